@@ -0,0 +1,99 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/danilopavk/battleshipper/auth"
+	"github.com/danilopavk/battleshipper/store"
+)
+
+// Loaded is returned once the dump is loaded, with the number of everything loaded from it
+type Loaded struct {
+	Waiting  int `json:"waiting"`
+	Games    int `json:"games"`
+	Archived int `json:"archived"`
+}
+
+// contentTypes are the content types of the dump formats
+var contentTypes = map[string]string{
+	store.DumpJSON:   "application/json",
+	store.DumpNDJSON: "application/x-ndjson",
+}
+
+func (server *Server) dump(writer http.ResponseWriter, request *http.Request) {
+	if err := server.authorizeAdmin(request); err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	format, err := dumpFormat(request)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+
+	dump, err := server.Store.Dump()
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	writer.Header().Set("Content-Type", contentTypes[format])
+	writer.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="battleshipper-%s.%s"`, dump.DumpedAt.Format("20060102-150405"), format))
+	if err := store.WriteDump(writer, dump, format); err != nil {
+		fmt.Printf("Cannot write dump, error: %v\n", err)
+	}
+}
+
+func (server *Server) load(writer http.ResponseWriter, request *http.Request) {
+	if err := server.authorizeAdmin(request); err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	format, err := dumpFormat(request)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+
+	dump, err := store.ReadDump(request.Body, format)
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := server.Store.Load(dump); err != nil {
+		if errors.Is(err, store.ErrExists) {
+			writeError(writer, http.StatusConflict, err.Error())
+			return
+		}
+		writeFailure(writer, err)
+		return
+	}
+	waiting, games, archived := dump.Counts()
+	writeJSON(writer, http.StatusOK, Loaded{Waiting: waiting, Games: games, Archived: archived})
+}
+
+// authorizeAdmin checks the admin token of the request, and fails if the admin endpoints are disabled
+func (server *Server) authorizeAdmin(request *http.Request) error {
+	if server.AdminToken == "" {
+		return withStatus(http.StatusForbidden, errors.New("Admin endpoints are disabled"))
+	}
+	token := request.Header.Get(auth.AdminHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(server.AdminToken)) != 1 {
+		return withStatus(http.StatusUnauthorized, errors.New("Admin token is missing or wrong"))
+	}
+	return nil
+}
+
+// dumpFormat returns the dump format from the format parameter, JSON by default
+func dumpFormat(request *http.Request) (string, error) {
+	format := request.URL.Query().Get("format")
+	if format == "" {
+		return store.DumpJSON, nil
+	}
+	if _, ok := contentTypes[format]; !ok {
+		return "", withStatus(http.StatusBadRequest, fmt.Errorf("Expected %v or %v format, but got %q", store.DumpJSON, store.DumpNDJSON, format))
+	}
+	return format, nil
+}
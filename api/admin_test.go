@@ -0,0 +1,102 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danilopavk/battleshipper/auth"
+	"github.com/danilopavk/battleshipper/ratings"
+	"github.com/danilopavk/battleshipper/stats"
+	"github.com/danilopavk/battleshipper/store"
+	"github.com/danilopavk/battleshipper/users"
+)
+
+const testAdminToken = "otataral"
+
+func Test_DumpAndLoad(t *testing.T) {
+	source, sourceStore := adminServer(testAdminToken)
+	karsa, _ := sourceStore.StartGame("Karsa Orlong", 0)
+	game, _ := sourceStore.JoinGame("Fiddler", 0, karsa.Id)
+	hedge, _ := sourceStore.StartGame("Hedge", 0)
+
+	for _, format := range []string{store.DumpJSON, store.DumpNDJSON} {
+		t.Run(format, func(t *testing.T) {
+			recorder := admin(source, testAdminToken, "GET", "/api/admin/dump?format="+format, nil)
+			if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != contentTypes[format] {
+				t.Fatalf("Expected the dump, but got %d %v", recorder.Code, recorder.Body)
+			}
+			dump := recorder.Body.Bytes()
+
+			target, targetStore := adminServer(testAdminToken)
+			recorder = admin(target, testAdminToken, "POST", "/api/admin/dump?format="+format, dump)
+			var loaded Loaded
+			_ = json.NewDecoder(recorder.Body).Decode(&loaded)
+			if recorder.Code != http.StatusOK || loaded != (Loaded{Waiting: 1, Games: 1}) {
+				t.Errorf("Expected one waiting player and one game to be loaded, but got %d %v", recorder.Code, loaded)
+			}
+			if _, err := targetStore.GetGame(game.Id); err != nil {
+				t.Errorf("Cannot find the loaded game: %v", err)
+			}
+			if _, waiting := targetStore.WaitingSince(hedge.Id); !waiting {
+				t.Error("Expected Hedge to be loaded waiting")
+			}
+
+			if recorder := admin(target, testAdminToken, "POST", "/api/admin/dump?format="+format, dump); recorder.Code != http.StatusConflict {
+				t.Errorf("Expected conflict loading the same dump again, but got %d", recorder.Code)
+			}
+		})
+	}
+}
+
+func Test_LoadInvalidDump(t *testing.T) {
+	handler, _ := adminServer(testAdminToken)
+
+	if recorder := admin(handler, testAdminToken, "POST", "/api/admin/dump", []byte(`{"version": 99}`)); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad request for the newer version, but got %d", recorder.Code)
+	}
+	if recorder := admin(handler, testAdminToken, "GET", "/api/admin/dump?format=xml", nil); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad request for the unknown format, but got %d", recorder.Code)
+	}
+}
+
+func Test_AdminToken(t *testing.T) {
+	handler, _ := adminServer(testAdminToken)
+	disabled, _ := adminServer("")
+
+	if recorder := admin(handler, "", "GET", "/api/admin/dump", nil); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected unauthorized without the token, but got %d", recorder.Code)
+	}
+	if recorder := admin(handler, "shadowthrone", "GET", "/api/admin/dump", nil); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected unauthorized with the wrong token, but got %d", recorder.Code)
+	}
+	if recorder := admin(disabled, "", "POST", "/api/admin/dump", nil); recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected forbidden while the admin endpoints are disabled, but got %d", recorder.Code)
+	}
+}
+
+// adminServer builds the server with the admin token, and returns the store behind it
+func adminServer(adminToken string) (http.Handler, *store.Store) {
+	gameStore := store.InitializeStore()
+	hub := store.InitializeHub()
+	watchers := store.InitializeWatchers()
+	registry := users.InitializeRegistry()
+	ratingService := ratings.InitializeService()
+	statsService := stats.InitializeService()
+	server := InitializeServer(&gameStore, &hub, &watchers, &registry, &ratingService, &statsService, nil, testSigner)
+	server.AdminToken = adminToken
+	return server.Handler(), &gameStore
+}
+
+// admin makes the request with the admin token, if there is one
+func admin(handler http.Handler, token string, method string, path string, body []byte) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, bytes.NewReader(body))
+	if token != "" {
+		request.Header.Set(auth.AdminHeader, token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
@@ -0,0 +1,511 @@
+// Package api exposes the game over a JSON HTTP API.
+//
+// All the endpoints live under /api. Successful responses contain the requested
+// object, and failed ones contain an object with the error message, like
+// {"error": "message"}. The endpoints are documented in the README.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/danilopavk/battleshipper/auth"
+	"github.com/danilopavk/battleshipper/bot"
+	"github.com/danilopavk/battleshipper/engine"
+	"github.com/danilopavk/battleshipper/ratings"
+	"github.com/danilopavk/battleshipper/stats"
+	"github.com/danilopavk/battleshipper/store"
+	"github.com/danilopavk/battleshipper/users"
+)
+
+// Server holds everything the endpoints need to operate on the games.
+//
+// Hub delivers the events about every move to the players and spectators,
+// Watchers counts the spectators of every game, Users holds the accounts
+// the players can sign in to, Ratings holds the ratings of the users, Stats
+// holds their statistics, Bots driver is used to play the bots' turns after
+// every move of a human player, and Signer issues and checks the session
+// tokens of the players and users. AdminToken guards the admin endpoints,
+// which are disabled while it's empty.
+type Server struct {
+	Store    store.GameRepository
+	Hub      *store.Hub
+	Watchers *store.Watchers
+	Users    *users.Registry
+	Ratings  *ratings.Service
+	Stats    *stats.Service
+	Bots     *bot.Driver
+	Signer   auth.Signer
+
+	AdminToken string
+}
+
+// InitializeServer builds the server on top of the store
+func InitializeServer(store store.GameRepository, hub *store.Hub, watchers *store.Watchers, users *users.Registry, ratings *ratings.Service, stats *stats.Service, bots *bot.Driver, signer auth.Signer) Server {
+	return Server{Store: store, Hub: hub, Watchers: watchers, Users: users, Ratings: ratings, Stats: stats, Bots: bots, Signer: signer}
+}
+
+// Handler returns the handler serving all the api endpoints
+func (server *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/games", server.createGame)
+	mux.HandleFunc("GET /api/games", server.openGames)
+	mux.HandleFunc("POST /api/games/{opponentId}/join", server.joinGame)
+	mux.HandleFunc("POST /api/invites/{code}/join", server.joinPrivateGame)
+	mux.HandleFunc("POST /api/matches", server.findMatch)
+	mux.HandleFunc("GET /api/games/{gameId}", server.spectate)
+	mux.HandleFunc("GET /api/games/{gameId}/events", server.spectatorEvents)
+	mux.HandleFunc("POST /api/bots", server.registerBot)
+	mux.HandleFunc("POST /api/users", server.registerUser)
+	mux.HandleFunc("GET /api/users/{userId}", server.profile)
+	mux.HandleFunc("PATCH /api/users/{userId}", server.rename)
+	mux.HandleFunc("GET /api/users/{userId}/ratings", server.ratingHistory)
+	mux.HandleFunc("GET /api/users/{userId}/stats", server.userStats)
+	mux.HandleFunc("GET /api/leaderboard", server.leaderboard)
+	mux.HandleFunc("GET /api/archive", server.archive)
+	mux.HandleFunc("GET /api/archive/{gameId}", server.replay)
+	mux.HandleFunc("GET /api/admin/dump", server.dump)
+	mux.HandleFunc("POST /api/admin/dump", server.load)
+	mux.HandleFunc("POST /api/sessions", server.signIn)
+	mux.HandleFunc("GET /api/players/{playerId}", server.view)
+	mux.HandleFunc("POST /api/players/{playerId}/ships", server.placeShip)
+	mux.HandleFunc("POST /api/players/{playerId}/ships/random", server.placeRandomly)
+	mux.HandleFunc("POST /api/players/{playerId}/shots", server.shoot)
+	mux.HandleFunc("POST /api/players/{playerId}/resign", server.resign)
+	mux.HandleFunc("DELETE /api/players/{playerId}/match", server.cancelMatch)
+	mux.HandleFunc("POST /api/players/{playerId}/spectating", server.openSpectating)
+	mux.HandleFunc("GET /api/players/{playerId}/history", server.history)
+	mux.HandleFunc("GET /api/players/{playerId}/hint", server.hint)
+	mux.HandleFunc("GET /api/players/{playerId}/events", server.events)
+	mux.HandleFunc("GET /api/players/{playerId}/socket", server.socket)
+	return mux
+}
+
+// NewPlayer is the request body for creating and joining games.
+//
+// Private games are left out of the open games, and can only be joined with the invite code.
+// Ranked games count for the ratings of the players, so only signed in users
+// can start or join them, and they can't be private.
+type NewPlayer struct {
+	Name    string `json:"name"`
+	Private bool   `json:"private,omitempty"`
+	Ranked  bool   `json:"ranked,omitempty"`
+}
+
+// CreatedPlayer is returned when the game is created or joined.
+//
+// Token must be sent in the Authorization header of every following request of the player.
+// InviteCode is only set for the private games.
+type CreatedPlayer struct {
+	PlayerId   int    `json:"playerId"`
+	GameId     int    `json:"gameId,omitempty"`
+	InviteCode string `json:"inviteCode,omitempty"`
+	Token      string `json:"token"`
+}
+
+// OpenGame is a game that waits for the opponent to join
+type OpenGame struct {
+	PlayerId int    `json:"playerId"`
+	Name     string `json:"name"`
+	Ranked   bool   `json:"ranked,omitempty"`
+}
+
+// NewBot is the request body for registering bots
+type NewBot struct {
+	Name        string `json:"name"`
+	CallbackURL string `json:"callbackUrl"`
+}
+
+// PlaceShip is the request body for placing a ship
+type PlaceShip struct {
+	Cells []engine.Cell `json:"cells"`
+}
+
+// ShotResult is returned after the shot is fired
+type ShotResult struct {
+	Hit  bool `json:"hit"`
+	Sank bool `json:"sank"`
+	Won  bool `json:"won"`
+}
+
+// Hint is returned when the player asks for the recommended shot
+type Hint struct {
+	Cell    engine.Cell    `json:"cell"`
+	Heatmap engine.Heatmap `json:"heatmap"`
+}
+
+// Error is the body of every failed response
+type Error struct {
+	Error string `json:"error"`
+}
+
+// errGuestRanked is returned when a guest tries to play a ranked game
+var errGuestRanked = errors.New("Only signed in users can play ranked games")
+
+// statusError is an error that knows which http status it should be reported with
+type statusError struct {
+	status int
+	err    error
+}
+
+func (err statusError) Error() string {
+	return err.err.Error()
+}
+
+func withStatus(status int, err error) error {
+	return statusError{status, err}
+}
+
+func (server *Server) createGame(writer http.ResponseWriter, request *http.Request) {
+	newPlayer, user, err := server.newPlayer(request)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+
+	if newPlayer.Ranked {
+		if err := rankedBy(user, newPlayer.Private); err != nil {
+			writeFailure(writer, err)
+			return
+		}
+		player, err := server.Store.StartGameWithRules(newPlayer.Name, user.Id, engine.RankedRules())
+		if err != nil {
+			writeFailure(writer, err)
+			return
+		}
+		server.writeCreated(writer, CreatedPlayer{PlayerId: player.Id})
+		return
+	}
+
+	if newPlayer.Private {
+		player, code, err := server.Store.StartPrivateGame(newPlayer.Name, user.Id)
+		if err != nil {
+			writeFailure(writer, err)
+			return
+		}
+		server.writeCreated(writer, CreatedPlayer{PlayerId: player.Id, InviteCode: store.FormatInviteCode(code)})
+		return
+	}
+
+	player, err := server.Store.StartGame(newPlayer.Name, user.Id)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	server.writeCreated(writer, CreatedPlayer{PlayerId: player.Id})
+}
+
+func (server *Server) openGames(writer http.ResponseWriter, request *http.Request) {
+	openGames := []OpenGame{}
+	for _, player := range server.Store.AllWaitingPlayers() {
+		rules, _ := server.Store.WaitingRules(player.Id)
+		openGames = append(openGames, OpenGame{PlayerId: player.Id, Name: player.Name, Ranked: rules.Ranked})
+	}
+	writeJSON(writer, http.StatusOK, openGames)
+}
+
+func (server *Server) joinGame(writer http.ResponseWriter, request *http.Request) {
+	opponentId, err := strconv.Atoi(request.PathValue("opponentId"))
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, "Expected numeric opponent id")
+		return
+	}
+	newPlayer, user, err := server.newPlayer(request)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+
+	game, err := server.Join(newPlayer.Name, opponentId, user)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	server.writeCreated(writer, CreatedPlayer{PlayerId: game.PlayerB.Id, GameId: game.Id})
+}
+
+func (server *Server) joinPrivateGame(writer http.ResponseWriter, request *http.Request) {
+	newPlayer, user, err := server.newPlayer(request)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+
+	game, err := server.JoinPrivate(newPlayer.Name, request.PathValue("code"), user)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	server.writeCreated(writer, CreatedPlayer{PlayerId: game.PlayerB.Id, GameId: game.Id})
+}
+
+func (server *Server) registerBot(writer http.ResponseWriter, request *http.Request) {
+	if server.Bots == nil {
+		writeError(writer, http.StatusNotFound, "Bots are not enabled on this server")
+		return
+	}
+	if err := server.authorizeBots(request); err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	var newBot NewBot
+	if err := decode(request, &newBot); err != nil || newBot.Name == "" || newBot.CallbackURL == "" {
+		writeError(writer, http.StatusBadRequest, "Expected bot name and callback url")
+		return
+	}
+	if err := bot.CheckCallback(request.Context(), newBot.CallbackURL); err != nil {
+		writeError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	player := engine.InitializePlayer(newBot.Name)
+	if err := player.PlaceRandomly(); err != nil {
+		writeError(writer, http.StatusInternalServerError, fmt.Sprintf("Cannot place bot ships: %v", err))
+		return
+	}
+	// The bot is registered before it's listed, so it plays from the moment anyone joins
+	if err := server.Bots.Registry.Register(bot.Bot{PlayerId: player.Id, Name: player.Name, CallbackURL: newBot.CallbackURL}); err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	if _, err := server.Store.StartGameAs(player); err != nil {
+		writeFailure(writer, errors.Join(err, server.Bots.Registry.Remove(player.Id)))
+		return
+	}
+
+	// The token is only returned in the body, so the cookie session of the registering user stays
+	token := server.Signer.Issue(player.Id)
+	writeJSON(writer, http.StatusCreated, CreatedPlayer{PlayerId: player.Id, Token: token})
+}
+
+func (server *Server) view(writer http.ResponseWriter, request *http.Request) {
+	player, game, err := server.playerAndGame(request)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+
+	if game.Id == 0 {
+		writeJSON(writer, http.StatusOK, player.View())
+		return
+	}
+	view, err := game.ViewFor(player.Id)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	writeJSON(writer, http.StatusOK, view)
+}
+
+func (server *Server) placeShip(writer http.ResponseWriter, request *http.Request) {
+	playerId, err := server.authorize(request)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	var placeShip PlaceShip
+	if err := decode(request, &placeShip); err != nil {
+		writeError(writer, http.StatusBadRequest, "Expected ship cells")
+		return
+	}
+
+	view, err := server.PlaceShip(playerId, placeShip.Cells)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	writeJSON(writer, http.StatusOK, view)
+}
+
+func (server *Server) placeRandomly(writer http.ResponseWriter, request *http.Request) {
+	playerId, err := server.authorize(request)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+
+	view, err := server.PlaceRandomly(playerId)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	writeJSON(writer, http.StatusOK, view)
+}
+
+func (server *Server) shoot(writer http.ResponseWriter, request *http.Request) {
+	playerId, err := server.authorize(request)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	var cell engine.Cell
+	if err := decode(request, &cell); err != nil {
+		writeError(writer, http.StatusBadRequest, "Expected cell to shoot at")
+		return
+	}
+
+	result, err := server.Shoot(playerId, cell)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	writeJSON(writer, http.StatusOK, result)
+}
+
+func (server *Server) resign(writer http.ResponseWriter, request *http.Request) {
+	playerId, err := server.authorize(request)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+
+	view, err := server.Resign(playerId)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	writeJSON(writer, http.StatusOK, view)
+}
+
+func (server *Server) history(writer http.ResponseWriter, request *http.Request) {
+	_, game, err := server.playerAndGame(request)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+
+	history := []engine.Shot{}
+	history = append(history, game.History...)
+	writeJSON(writer, http.StatusOK, history)
+}
+
+func (server *Server) hint(writer http.ResponseWriter, request *http.Request) {
+	player, game, err := server.playerAndGame(request)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	if game.Id == 0 {
+		writeError(writer, http.StatusConflict, "Nobody joined the game yet")
+		return
+	}
+
+	cell, heatmap, err := game.HintFor(player.Id)
+	if err != nil {
+		writeFailure(writer, moveFailure(fmt.Errorf("%w: %v", store.ErrIllegalMove, err)))
+		return
+	}
+	writeJSON(writer, http.StatusOK, Hint{Cell: cell, Heatmap: heatmap})
+}
+
+// newPlayer decodes the new player from the request, with the name of the signed in user if there's none
+func (server *Server) newPlayer(request *http.Request) (NewPlayer, users.User, error) {
+	var newPlayer NewPlayer
+	if err := decode(request, &newPlayer); err != nil {
+		return NewPlayer{}, users.User{}, withStatus(http.StatusBadRequest, errors.New("Expected player name"))
+	}
+	user, err := server.SignedInUser(request)
+	if err != nil {
+		return NewPlayer{}, users.User{}, err
+	}
+	newPlayer.Name = playerName(newPlayer.Name, user)
+	if newPlayer.Name == "" {
+		return NewPlayer{}, users.User{}, withStatus(http.StatusBadRequest, errors.New("Expected player name"))
+	}
+	return newPlayer, user, nil
+}
+
+// rankedBy checks that the user can start the ranked game
+func rankedBy(user users.User, private bool) error {
+	if private {
+		return withStatus(http.StatusBadRequest, errors.New("Private games can't be ranked"))
+	}
+	if user.Id == 0 {
+		return withStatus(http.StatusForbidden, errGuestRanked)
+	}
+	return nil
+}
+
+func (server *Server) playerAndGame(request *http.Request) (engine.Player, engine.Game, error) {
+	playerId, err := server.authorize(request)
+	if err != nil {
+		return engine.Player{}, engine.Game{}, err
+	}
+	return server.find(playerId)
+}
+
+// authorize checks that the request carries the token of the player from the path, and returns the player's id
+func (server *Server) authorize(request *http.Request) (int, error) {
+	playerId, err := strconv.Atoi(request.PathValue("playerId"))
+	if err != nil {
+		return 0, withStatus(http.StatusBadRequest, errors.New("Expected numeric player id"))
+	}
+
+	authorizedId, err := server.Signer.PlayerId(request)
+	if err != nil {
+		return 0, withStatus(http.StatusUnauthorized, err)
+	}
+	if authorizedId != playerId {
+		return 0, withStatus(http.StatusForbidden, fmt.Errorf("Not allowed to act for player %d", playerId))
+	}
+	return playerId, nil
+}
+
+// authorizeBots lets only the signed in users and the admins register bots
+func (server *Server) authorizeBots(request *http.Request) error {
+	if server.authorizeAdmin(request) == nil {
+		return nil
+	}
+	user, err := server.SignedInUser(request)
+	if err != nil {
+		return err
+	}
+	if user.Id == 0 {
+		return withStatus(http.StatusUnauthorized, errors.New("Sign in to register bots"))
+	}
+	return nil
+}
+
+func (server *Server) writeCreated(writer http.ResponseWriter, created CreatedPlayer) {
+	created.Token = server.Signer.Issue(created.PlayerId)
+	auth.SetCookie(writer, created.Token)
+	writeJSON(writer, http.StatusCreated, created)
+}
+
+func (server *Server) playBots(playerId int) {
+	if server.Bots == nil {
+		return
+	}
+	go func() {
+		if err := server.Bots.Play(context.Background(), playerId); err != nil {
+			fmt.Printf("Cannot play bot turn in game of player %d, error: %v\n", playerId, err)
+		}
+	}()
+}
+
+func decode(request *http.Request, object any) error {
+	return json.NewDecoder(request.Body).Decode(object)
+}
+
+func writeJSON(writer http.ResponseWriter, status int, object any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_ = json.NewEncoder(writer).Encode(object)
+}
+
+func writeError(writer http.ResponseWriter, status int, message string) {
+	writeJSON(writer, status, Error{Error: message})
+}
+
+func writeFailure(writer http.ResponseWriter, err error) {
+	var statusErr statusError
+	if errors.As(err, &statusErr) {
+		writeError(writer, statusErr.status, err.Error())
+		return
+	}
+	writeError(writer, http.StatusInternalServerError, err.Error())
+}
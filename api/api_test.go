@@ -0,0 +1,405 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danilopavk/battleshipper/auth"
+	"github.com/danilopavk/battleshipper/bot"
+	"github.com/danilopavk/battleshipper/engine"
+	"github.com/danilopavk/battleshipper/ratings"
+	"github.com/danilopavk/battleshipper/stats"
+	"github.com/danilopavk/battleshipper/store"
+	"github.com/danilopavk/battleshipper/users"
+)
+
+func Test_CreateAndListGames(t *testing.T) {
+	server := testServer()
+
+	var created CreatedPlayer
+	status := call(t, server, "POST", "/api/games", NewPlayer{Name: "Tavore"}, &created)
+	if status != http.StatusCreated {
+		t.Errorf("Unexpected status %d", status)
+	}
+	if created.PlayerId == 0 {
+		t.Error("Expected to get player id, but there was none")
+	}
+
+	var openGames []OpenGame
+	call(t, server, "GET", "/api/games", nil, &openGames)
+	if len(openGames) != 1 || openGames[0].PlayerId != created.PlayerId || openGames[0].Name != "Tavore" {
+		t.Errorf("Unexpected open games %v", openGames)
+	}
+}
+
+func Test_PrivateGame(t *testing.T) {
+	server := testServer()
+
+	var created CreatedPlayer
+	call(t, server, "POST", "/api/games", NewPlayer{Name: "Tavore", Private: true}, &created)
+	if created.InviteCode == "" {
+		t.Fatal("Expected to get the invite code, but there was none")
+	}
+
+	var openGames []OpenGame
+	call(t, server, "GET", "/api/games", nil, &openGames)
+	if len(openGames) != 0 {
+		t.Errorf("Expected the private game not to be listed, but got %v", openGames)
+	}
+	if status := call(t, server, "POST", fmt.Sprintf("/api/games/%d/join", created.PlayerId), NewPlayer{Name: "Felisin"}, nil); status != http.StatusNotFound {
+		t.Errorf("Expected not found status when joining without the code, but got %d", status)
+	}
+
+	var joined CreatedPlayer
+	status := call(t, server, "POST", fmt.Sprintf("/api/invites/%s/join", created.InviteCode), NewPlayer{Name: "Felisin"}, &joined)
+	if status != http.StatusCreated || joined.GameId == 0 {
+		t.Errorf("Expected to join with the invite code, but got status %d", status)
+	}
+}
+
+func Test_JoinUnknownGame(t *testing.T) {
+	server := testServer()
+
+	var failure Error
+	status := call(t, server, "POST", "/api/games/123/join", NewPlayer{Name: "Tavore"}, &failure)
+
+	if status != http.StatusNotFound {
+		t.Errorf("Unexpected status %d", status)
+	}
+	if failure.Error == "" {
+		t.Error("Expected error message, but there was none")
+	}
+}
+
+func Test_PlayCompleteGame(t *testing.T) {
+	server := testServer()
+
+	var playerA CreatedPlayer
+	call(t, server, "POST", "/api/games", NewPlayer{Name: "Tavore"}, &playerA)
+	var playerB CreatedPlayer
+	call(t, server, "POST", fmt.Sprintf("/api/games/%d/join", playerA.PlayerId), NewPlayer{Name: "Felisin"}, &playerB)
+
+	if status := call(t, server, "POST", fmt.Sprintf("/api/players/%d/ships/random", playerA.PlayerId), nil, nil); status != http.StatusOK {
+		t.Errorf("Unexpected status on random placement %d", status)
+	}
+	for i, length := range []int{5, 4, 4, 3, 3} {
+		var cells []engine.Cell
+		for j := 0; j < length; j++ {
+			cells = append(cells, engine.Cell{X: i * 2, Y: j})
+		}
+		if status := call(t, server, "POST", fmt.Sprintf("/api/players/%d/ships", playerB.PlayerId), PlaceShip{cells}, nil); status != http.StatusOK {
+			t.Errorf("Unexpected status on placement %d", status)
+		}
+	}
+
+	var view engine.View
+	call(t, server, "GET", fmt.Sprintf("/api/players/%d", playerA.PlayerId), nil, &view)
+	if len(view.Ships) != 5 {
+		t.Fatalf("Expected 5 ships in view, but there are %d", len(view.Ships))
+	}
+
+	var failure Error
+	if status := call(t, server, "POST", fmt.Sprintf("/api/players/%d/shots", playerB.PlayerId), engine.Cell{}, &failure); status != http.StatusConflict {
+		t.Errorf("Expected conflict on shot out of turn, but got %d", status)
+	}
+
+	var result ShotResult
+	for i := 0; !result.Won; i++ {
+		x := i % 10
+		y := i / 10
+		call(t, server, "POST", fmt.Sprintf("/api/players/%d/shots", playerA.PlayerId), engine.Cell{X: x, Y: y}, &result)
+		if !result.Won {
+			call(t, server, "POST", fmt.Sprintf("/api/players/%d/shots", playerB.PlayerId), engine.Cell{X: 9, Y: 9}, nil)
+		}
+		if i > 100 {
+			t.Fatal("Expected to win within 100 shots")
+		}
+	}
+
+	call(t, server, "GET", fmt.Sprintf("/api/players/%d", playerB.PlayerId), nil, &view)
+	if view.Winner != playerA.PlayerId {
+		t.Errorf("Expected player a to win, but the winner was %d", view.Winner)
+	}
+
+	var history []engine.Shot
+	call(t, server, "GET", fmt.Sprintf("/api/players/%d/history", playerA.PlayerId), nil, &history)
+	if len(history) == 0 || history[0].PlayerId != playerA.PlayerId {
+		t.Errorf("Unexpected history %v", history)
+	}
+}
+
+func Test_Resign(t *testing.T) {
+	server := testServer()
+
+	var playerA CreatedPlayer
+	call(t, server, "POST", "/api/games", NewPlayer{Name: "Tavore"}, &playerA)
+	var playerB CreatedPlayer
+	call(t, server, "POST", fmt.Sprintf("/api/games/%d/join", playerA.PlayerId), NewPlayer{Name: "Felisin"}, &playerB)
+
+	var view engine.View
+	status := call(t, server, "POST", fmt.Sprintf("/api/players/%d/resign", playerA.PlayerId), nil, &view)
+
+	if status != http.StatusOK {
+		t.Errorf("Unexpected status %d", status)
+	}
+	if view.Winner != playerB.PlayerId {
+		t.Errorf("Expected player b to win, but the winner was %d", view.Winner)
+	}
+}
+
+func Test_ActForAnotherPlayer(t *testing.T) {
+	server := testServer()
+
+	var playerA CreatedPlayer
+	call(t, server, "POST", "/api/games", NewPlayer{Name: "Tavore"}, &playerA)
+	var playerB CreatedPlayer
+	call(t, server, "POST", "/api/games", NewPlayer{Name: "Felisin"}, &playerB)
+
+	path := fmt.Sprintf("/api/players/%d/ships/random", playerA.PlayerId)
+	if status := callAs(t, server, playerB.Token, "POST", path, nil, nil); status != http.StatusForbidden {
+		t.Errorf("Expected forbidden status, but got %d", status)
+	}
+	if status := callAs(t, server, "", "POST", path, nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("Expected unauthorized status, but got %d", status)
+	}
+	if status := callAs(t, server, playerA.Token+"x", "POST", path, nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("Expected unauthorized status, but got %d", status)
+	}
+}
+
+func Test_RegisterBot(t *testing.T) {
+	gameStore := store.InitializeStore()
+	registry := users.InitializeRegistry()
+	bots := bot.InitializeRegistry()
+	driver := bot.Driver{Store: &gameStore, Registry: &bots, Client: bot.InitializeClient()}
+	server := InitializeServer(&gameStore, nil, nil, &registry, nil, nil, &driver, testSigner)
+	server.AdminToken = testAdminToken
+	handler := server.Handler()
+	var user SignedInUser
+	call(t, handler, "POST", "/api/users", NewUser{Username: "kruppe", Password: "eel of darujhistan"}, &user)
+	kruppe := NewBot{Name: "Kruppe", CallbackURL: "http://203.0.113.5/shot"}
+
+	if status := call(t, handler, "POST", "/api/bots", kruppe, nil); status != http.StatusUnauthorized {
+		t.Errorf("Expected unauthorized status for the guest, but got %d", status)
+	}
+	for _, callbackURL := range []string{"http://127.0.0.1:8080/shot", "http://localhost/shot", "http://10.0.0.7/shot", "http://169.254.169.254/latest", "http://[::1]/shot", "file:///etc/passwd"} {
+		private := NewBot{Name: "Kruppe", CallbackURL: callbackURL}
+		if status := callAsUser(t, handler, user.Token, "POST", "/api/bots", private, nil); status != http.StatusBadRequest {
+			t.Errorf("Expected bad request for the callback %v, but got %d", callbackURL, status)
+		}
+	}
+
+	var created CreatedPlayer
+	if status := callAsUser(t, handler, user.Token, "POST", "/api/bots", kruppe, &created); status != http.StatusCreated {
+		t.Fatalf("Expected the bot to be registered, but got %d", status)
+	}
+	if _, ok := bots.Get(created.PlayerId); !ok {
+		t.Error("Expected the bot to be registered")
+	}
+	if created.Token == "" {
+		t.Error("Expected the bot token in the response")
+	}
+	player, _, _ := gameStore.GetPlayerAndGame(created.PlayerId)
+	if waiting := gameStore.AllWaitingPlayers(); len(waiting) != 1 || len(*player.Ships) != 5 {
+		t.Errorf("Expected the bot to be listed with the ships placed, but got %v", player)
+	}
+	if status := callWithHeaders(t, handler, map[string]string{auth.AdminHeader: testAdminToken}, "POST", "/api/bots", kruppe, nil); status != http.StatusCreated {
+		t.Errorf("Expected the admin to register the bot, but got %d", status)
+	}
+}
+
+func Test_RegisterBotKeepsUserSession(t *testing.T) {
+	gameStore := store.InitializeStore()
+	registry := users.InitializeRegistry()
+	bots := bot.InitializeRegistry()
+	driver := bot.Driver{Store: &gameStore, Registry: &bots, Client: bot.InitializeClient()}
+	server := InitializeServer(&gameStore, nil, nil, &registry, nil, nil, &driver, testSigner)
+	handler := server.Handler()
+	var user SignedInUser
+	call(t, handler, "POST", "/api/users", NewUser{Username: "kruppe", Password: "eel of darujhistan"}, &user)
+
+	var body bytes.Buffer
+	_ = json.NewEncoder(&body).Encode(NewBot{Name: "Kruppe", CallbackURL: "http://203.0.113.5/shot"})
+	request := httptest.NewRequest("POST", "/api/bots", &body)
+	request.Header.Set(auth.UserHeader, user.Token)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected the bot to be registered, but got %d", recorder.Code)
+	}
+	if cookies := recorder.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("Expected no session cookie for the bot, but got %v", cookies)
+	}
+}
+
+func testServer() http.Handler {
+	gameStore := store.InitializeStore()
+	hub := store.InitializeHub()
+	watchers := store.InitializeWatchers()
+	registry := users.InitializeRegistry()
+	ratingService := ratings.InitializeService()
+	hub.OnGameOver(ratingService.Record)
+	statsService := stats.InitializeService()
+	hub.OnGameOver(statsService.Record)
+	server := InitializeServer(&gameStore, &hub, &watchers, &registry, &ratingService, &statsService, nil, testSigner)
+	return server.Handler()
+}
+
+var testSigner = auth.InitializeSigner([]byte("secret"))
+
+// call makes the request as the player from the path, if there is one
+func call(t *testing.T, handler http.Handler, method string, path string, body any, response any) int {
+	token := ""
+	var playerId int
+	if _, err := fmt.Sscanf(path, "/api/players/%d", &playerId); err == nil {
+		token = testSigner.Issue(playerId)
+	}
+	return callAs(t, handler, token, method, path, body, response)
+}
+
+func callAs(t *testing.T, handler http.Handler, token string, method string, path string, body any, response any) int {
+	headers := map[string]string{}
+	if token != "" {
+		headers["Authorization"] = "Bearer " + token
+	}
+	return callWithHeaders(t, handler, headers, method, path, body, response)
+}
+
+// callAsUser makes the request as the signed in user
+func callAsUser(t *testing.T, handler http.Handler, userToken string, method string, path string, body any, response any) int {
+	return callWithHeaders(t, handler, map[string]string{auth.UserHeader: userToken}, method, path, body, response)
+}
+
+func callWithHeaders(t *testing.T, handler http.Handler, headers map[string]string, method string, path string, body any, response any) int {
+	var requestBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&requestBody).Encode(body); err != nil {
+			t.Fatalf("Cannot encode request: %v", err)
+		}
+	}
+
+	request := httptest.NewRequest(method, path, &requestBody)
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if response != nil {
+		if err := json.NewDecoder(recorder.Body).Decode(response); err != nil {
+			t.Fatalf("Cannot decode response of %s %s: %v", method, path, err)
+		}
+	}
+	return recorder.Code
+}
+
+func Test_FindMatch(t *testing.T) {
+	server := testServer()
+
+	var tavore CreatedPlayer
+	call(t, server, "POST", "/api/matches", FindMatch{Name: "Tavore"}, &tavore)
+	if tavore.GameId != 0 {
+		t.Fatal("Expected Tavore to wait for the opponent")
+	}
+
+	var felisin CreatedPlayer
+	status := call(t, server, "POST", "/api/matches", FindMatch{Name: "Felisin"}, &felisin)
+	if status != http.StatusCreated || felisin.GameId == 0 {
+		t.Errorf("Expected Felisin to be matched with Tavore, but got status %d", status)
+	}
+
+	path := fmt.Sprintf("/api/players/%d/match", tavore.PlayerId)
+	if status := call(t, server, "DELETE", path, nil, nil); status != http.StatusConflict {
+		t.Errorf("Expected conflict status when cancelling a started match, but got %d", status)
+	}
+}
+
+func Test_FindMatchIgnoresGuestRating(t *testing.T) {
+	server := testServer()
+
+	var tavore CreatedPlayer
+	call(t, server, "POST", "/api/matches", map[string]any{"name": "Tavore", "rating": 3000}, &tavore)
+	var felisin CreatedPlayer
+	call(t, server, "POST", "/api/matches", map[string]any{"name": "Felisin", "rating": 1000}, &felisin)
+
+	if felisin.GameId == 0 {
+		t.Error("Expected the guests to be matched regardless of the ratings they sent")
+	}
+}
+
+func Test_Hint(t *testing.T) {
+	server := testServer()
+	for _, hints := range []bool{true, false} {
+		var tavore, felisin CreatedPlayer
+		call(t, server, "POST", "/api/matches", FindMatch{Name: "Tavore", Hints: &hints}, &tavore)
+		call(t, server, "POST", "/api/matches", FindMatch{Name: "Felisin", Hints: &hints}, &felisin)
+
+		want := http.StatusConflict
+		if hints {
+			want = http.StatusOK
+		}
+		if status := call(t, server, "GET", fmt.Sprintf("/api/players/%d/hint", tavore.PlayerId), nil, nil); status != want {
+			t.Errorf("Expected status %d for the hint when hints are %v, but got %d", want, hints, status)
+		}
+	}
+}
+
+func Test_CancelMatch(t *testing.T) {
+	server := testServer()
+
+	var tavore CreatedPlayer
+	call(t, server, "POST", "/api/matches", FindMatch{Name: "Tavore"}, &tavore)
+
+	path := fmt.Sprintf("/api/players/%d/match", tavore.PlayerId)
+	if status := call(t, server, "DELETE", path, nil, nil); status != http.StatusNoContent {
+		t.Errorf("Expected no content status, but got %d", status)
+	}
+}
+
+// failingStore can't save the new players, like a durable store whose disk is full
+type failingStore struct {
+	store.GameRepository
+}
+
+func (failingStore) StartGame(playerName string, userId int) (engine.Player, error) {
+	return engine.Player{}, errors.New("Disk is full")
+}
+
+func (failingStore) StartGameWithRules(playerName string, userId int, rules engine.Rules) (engine.Player, error) {
+	return engine.Player{}, errors.New("Disk is full")
+}
+
+func (failingStore) FindMatch(playerName string, ticket store.Ticket) (engine.Player, engine.Game, error) {
+	return engine.Player{}, engine.Game{}, errors.New("Disk is full")
+}
+
+func Test_UnsavedPlayers(t *testing.T) {
+	gameStore := store.InitializeStore()
+	hub := store.InitializeHub()
+	watchers := store.InitializeWatchers()
+	registry := users.InitializeRegistry()
+	ratingService := ratings.InitializeService()
+	statsService := stats.InitializeService()
+	server := InitializeServer(failingStore{&gameStore}, &hub, &watchers, &registry, &ratingService, &statsService, nil, testSigner)
+	handler := server.Handler()
+
+	var failure Error
+	if status := call(t, handler, "POST", "/api/games", NewPlayer{Name: "Tavore"}, &failure); status != http.StatusInternalServerError {
+		t.Errorf("Expected internal server error status when the game can't be saved, but got %d", status)
+	}
+	if failure.Error == "" {
+		t.Error("Expected error message, but there was none")
+	}
+	if status := call(t, handler, "POST", "/api/matches", FindMatch{Name: "Tavore"}, nil); status != http.StatusInternalServerError {
+		t.Errorf("Expected internal server error status when the match can't be saved, but got %d", status)
+	}
+	if len(gameStore.AllWaitingPlayers()) != 0 {
+		t.Error("Expected nobody to wait")
+	}
+}
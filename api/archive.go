@@ -0,0 +1,132 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danilopavk/battleshipper/archive"
+	"github.com/danilopavk/battleshipper/engine"
+)
+
+// ArchivePage is a single page of the finished games found in the archive.
+//
+// Next is the link to the following page, and is empty on the last one.
+type ArchivePage struct {
+	Games    []ArchivedGame `json:"games"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"pageSize"`
+	Total    int            `json:"total"`
+	Next     string         `json:"next,omitempty"`
+}
+
+// ArchivedGame is a finished game as listed in the archive, with the link to its replay
+type ArchivedGame struct {
+	GameId    int            `json:"gameId"`
+	PlayerA   ArchivedPlayer `json:"playerA"`
+	PlayerB   ArchivedPlayer `json:"playerB"`
+	Winner    int            `json:"winner"`
+	Ranked    bool           `json:"ranked"`
+	Shots     int            `json:"shots"`
+	StartedAt time.Time      `json:"startedAt"`
+	EndedAt   time.Time      `json:"endedAt"`
+	Replay    string         `json:"replay"`
+}
+
+// ArchivedPlayer is one of the players of the archived game, with the user they played for, if any
+type ArchivedPlayer struct {
+	Id     int    `json:"id"`
+	Name   string `json:"name"`
+	UserId int    `json:"userId,omitempty"`
+}
+
+// Replay is everything needed to replay the finished game: both fleets, and all the shots in order
+type Replay struct {
+	engine.SpectatorView
+	Ranked    bool          `json:"ranked"`
+	History   []engine.Shot `json:"history"`
+	StartedAt time.Time     `json:"startedAt"`
+	EndedAt   time.Time     `json:"endedAt"`
+}
+
+func (server *Server) archive(writer http.ResponseWriter, request *http.Request) {
+	query, err := archive.ParseQuery(request.URL.Query())
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := server.Archive(query)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+
+	archivePage := ArchivePage{Games: []ArchivedGame{}, Page: page.Page, PageSize: page.PageSize, Total: page.Total}
+	for _, game := range page.Games {
+		archivePage.Games = append(archivePage.Games, archivedGame(game))
+	}
+	if page.HasNext() {
+		query.Page = page.Page + 1
+		archivePage.Next = "/api/archive?" + query.Values().Encode()
+	}
+	writeJSON(writer, http.StatusOK, archivePage)
+}
+
+func (server *Server) replay(writer http.ResponseWriter, request *http.Request) {
+	gameId, err := strconv.Atoi(request.PathValue("gameId"))
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, "Expected numeric game id")
+		return
+	}
+
+	game, err := server.Replay(gameId)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	history := []engine.Shot{}
+	history = append(history, game.History...)
+	writeJSON(writer, http.StatusOK, Replay{game.SpectatorView(), game.Rules.Ranked, history, game.StartedAt, game.EndedAt})
+}
+
+// Archive finds the finished games matching the query, archived or not, the latest ended first
+func (server *Server) Archive(query archive.Query) (archive.Page, error) {
+	if err := query.Validate(); err != nil {
+		return archive.Page{}, withStatus(http.StatusBadRequest, err)
+	}
+	page, err := server.Store.SearchFinished(query)
+	if err != nil {
+		return archive.Page{}, err
+	}
+	return page, nil
+}
+
+// Replay returns the finished game by its id, archived or not
+func (server *Server) Replay(gameId int) (engine.Game, error) {
+	game, err := server.Store.FinishedGame(gameId)
+	if err != nil {
+		return engine.Game{}, withStatus(http.StatusNotFound, err)
+	}
+	return game, nil
+}
+
+// ReplayPath returns the path of the page replaying the finished game
+func ReplayPath(gameId int) string {
+	return fmt.Sprintf("/replay/%d", gameId)
+}
+
+func archivedGame(game engine.Game) ArchivedGame {
+	return ArchivedGame{
+		GameId:    game.Id,
+		PlayerA:   ArchivedPlayer{game.PlayerA.Id, game.PlayerA.Name, game.PlayerA.UserId},
+		PlayerB:   ArchivedPlayer{game.PlayerB.Id, game.PlayerB.Name, game.PlayerB.UserId},
+		Winner:    *game.Winner,
+		Ranked:    game.Rules.Ranked,
+		Shots:     len(game.History),
+		StartedAt: game.StartedAt,
+		EndedAt:   game.EndedAt,
+		Replay:    ReplayPath(game.Id),
+	}
+}
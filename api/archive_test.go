@@ -0,0 +1,70 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func Test_Archive(t *testing.T) {
+	handler := testServer()
+	var ganoes, tavore SignedInUser
+	call(t, handler, "POST", "/api/users", NewUser{Username: "ganoes", Password: "paran's sword", DisplayName: "Ganoes Paran"}, &ganoes)
+	call(t, handler, "POST", "/api/users", NewUser{Username: "tavore", Password: "otataral sword", DisplayName: "Tavore Paran"}, &tavore)
+
+	var ranked, casual CreatedPlayer
+	callAsUser(t, handler, ganoes.Token, "POST", "/api/games", NewPlayer{Ranked: true}, &ranked)
+	callAsUser(t, handler, tavore.Token, "POST", fmt.Sprintf("/api/games/%d/join", ranked.PlayerId), NewPlayer{}, nil)
+	call(t, handler, "POST", fmt.Sprintf("/api/players/%d/resign", ranked.PlayerId), nil, nil)
+	callAsUser(t, handler, ganoes.Token, "POST", "/api/games", NewPlayer{}, &casual)
+	call(t, handler, "POST", fmt.Sprintf("/api/games/%d/join", casual.PlayerId), NewPlayer{Name: "Felisin"}, nil)
+	call(t, handler, "POST", fmt.Sprintf("/api/players/%d/resign", casual.PlayerId), nil, nil)
+
+	var lost ArchivePage
+	call(t, handler, "GET", fmt.Sprintf("/api/archive?user=%d&result=lost&pageSize=1", ganoes.UserId), nil, &lost)
+	if lost.Total != 2 || len(lost.Games) != 1 || lost.Games[0].PlayerB.Name != "Felisin" {
+		t.Fatalf("Expected the latest of two lost games on the first page, but got %v", lost)
+	}
+	if lost.Games[0].Replay != fmt.Sprintf("/replay/%d", lost.Games[0].GameId) {
+		t.Errorf("Expected the link to the replay, but got %v", lost.Games[0].Replay)
+	}
+
+	var next ArchivePage
+	call(t, handler, "GET", lost.Next, nil, &next)
+	if len(next.Games) != 1 || next.Games[0].PlayerB.UserId != tavore.UserId || !next.Games[0].Ranked || next.Next != "" {
+		t.Errorf("Expected the ranked game against Tavore on the last page, but got %v", next)
+	}
+
+	var againstTavore ArchivePage
+	call(t, handler, "GET", fmt.Sprintf("/api/archive?user=%d&opponentId=%d&rules=ranked", ganoes.UserId, tavore.UserId), nil, &againstTavore)
+	if againstTavore.Total != 1 {
+		t.Errorf("Expected one game against Tavore, but got %v", againstTavore)
+	}
+
+	if status := call(t, handler, "GET", "/api/archive?result=won", nil, nil); status != http.StatusBadRequest {
+		t.Errorf("Expected bad request for the result without the user, but got %d", status)
+	}
+}
+
+func Test_Replay(t *testing.T) {
+	handler := testServer()
+	var host, joined CreatedPlayer
+	call(t, handler, "POST", "/api/games", NewPlayer{Name: "Tavore"}, &host)
+	call(t, handler, "POST", fmt.Sprintf("/api/games/%d/join", host.PlayerId), NewPlayer{Name: "Felisin"}, &joined)
+
+	if status := call(t, handler, "GET", fmt.Sprintf("/api/archive/%d", joined.GameId), nil, nil); status != http.StatusNotFound {
+		t.Errorf("Expected not found status for the running game, but got %d", status)
+	}
+	call(t, handler, "POST", fmt.Sprintf("/api/players/%d/resign", joined.PlayerId), nil, nil)
+
+	var replay Replay
+	if status := call(t, handler, "GET", fmt.Sprintf("/api/archive/%d", joined.GameId), nil, &replay); status != http.StatusOK {
+		t.Fatalf("Expected the replay of the finished game, but got %d", status)
+	}
+	if replay.GameId != joined.GameId || replay.Winner != host.PlayerId || replay.History == nil {
+		t.Errorf("Unexpected replay %v", replay)
+	}
+	if status := call(t, handler, "GET", "/api/archive/abc", nil, nil); status != http.StatusBadRequest {
+		t.Errorf("Expected bad request for the non-numeric game id, but got %d", status)
+	}
+}
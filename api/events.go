@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danilopavk/battleshipper/store"
+)
+
+// keepAliveInterval is how often a comment is sent to idle event streams, so proxies don't close them
+const keepAliveInterval = 15 * time.Second
+
+// events streams the events of the player's game as Server-Sent Events.
+//
+// Every event is sent with its type as the SSE event name, its id as the SSE id, and
+// the event object as the data. Reconnecting clients send Last-Event-ID header, and
+// get all the events they missed first.
+func (server *Server) events(writer http.ResponseWriter, request *http.Request) {
+	player, _, err := server.playerAndGame(request)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	if _, ok := writer.(http.Flusher); !ok {
+		writeError(writer, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+
+	events, unsubscribe := server.Hub.SubscribeFrom(player.Id, lastEventId(request))
+	defer unsubscribe()
+	stream(writer, request, events)
+}
+
+// lastEventId returns the id of the last event the reconnecting client received, or -1 if there's none
+func lastEventId(request *http.Request) int {
+	lastEventId, err := strconv.Atoi(request.Header.Get("Last-Event-ID"))
+	if err != nil {
+		return -1
+	}
+	return lastEventId
+}
+
+// stream writes the events as Server-Sent Events until the client disconnects
+func stream(writer http.ResponseWriter, request *http.Request, events <-chan store.Event) {
+	flusher := writer.(http.Flusher)
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("Connection", "keep-alive")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(writer, ": keep-alive\n\n")
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(writer, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data)
+		}
+		flusher.Flush()
+	}
+}
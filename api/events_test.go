@@ -0,0 +1,60 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_EventsStream(t *testing.T) {
+	server := httptest.NewServer(testServer())
+	defer server.Close()
+
+	var playerA CreatedPlayer
+	call(t, server.Config.Handler, "POST", "/api/games", NewPlayer{Name: "Tavore"}, &playerA)
+
+	request, _ := http.NewRequest("GET", fmt.Sprintf("%s/api/players/%d/events", server.URL, playerA.PlayerId), nil)
+	request.Header.Set("Authorization", "Bearer "+playerA.Token)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("Cannot connect to events: %v", err)
+	}
+	defer response.Body.Close()
+	if response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Unexpected content type %v", response.Header.Get("Content-Type"))
+	}
+
+	call(t, server.Config.Handler, "POST", fmt.Sprintf("/api/games/%d/join", playerA.PlayerId), NewPlayer{Name: "Felisin"}, nil)
+
+	reader := bufio.NewReader(response.Body)
+	line, _ := reader.ReadString('\n')
+	if !strings.HasPrefix(line, "id: ") {
+		t.Errorf("Expected event id, but got %v", line)
+	}
+	line, err = reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Cannot read event: %v", err)
+	}
+	if strings.TrimSpace(line) != "event: opponent-joined" {
+		t.Errorf("Expected opponent joined event, but got %v", line)
+	}
+	line, _ = reader.ReadString('\n')
+	if !strings.HasPrefix(line, "data: ") || !strings.Contains(line, `"type":"opponent-joined"`) {
+		t.Errorf("Unexpected event data %v", line)
+	}
+}
+
+func Test_EventsRequireToken(t *testing.T) {
+	server := testServer()
+
+	var playerA CreatedPlayer
+	call(t, server, "POST", "/api/games", NewPlayer{Name: "Tavore"}, &playerA)
+
+	status := callAs(t, server, "", "GET", fmt.Sprintf("/api/players/%d/events", playerA.PlayerId), nil, nil)
+	if status != http.StatusUnauthorized {
+		t.Errorf("Expected unauthorized status, but got %d", status)
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/danilopavk/battleshipper/stats"
+)
+
+// leaderboardSize is the number of the best users shown on the leaderboard
+const leaderboardSize = 100
+
+// LeaderboardEntry is a single user on the leaderboard
+type LeaderboardEntry struct {
+	Rank        int     `json:"rank"`
+	UserId      int     `json:"userId"`
+	DisplayName string  `json:"displayName"`
+	Rating      int     `json:"rating"`
+	GamesPlayed int     `json:"gamesPlayed"`
+	Wins        int     `json:"wins"`
+	WinRate     float64 `json:"winRate"`
+}
+
+func (server *Server) leaderboard(writer http.ResponseWriter, request *http.Request) {
+	writeJSON(writer, http.StatusOK, server.Leaderboard())
+}
+
+func (server *Server) userStats(writer http.ResponseWriter, request *http.Request) {
+	userId, err := strconv.Atoi(request.PathValue("userId"))
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, "Expected numeric user id")
+		return
+	}
+
+	userStats, err := server.UserStats(userId)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	writeJSON(writer, http.StatusOK, userStats)
+}
+
+// Leaderboard ranks the users who finished at least one game.
+//
+// Users are ranked by rating, then by the number of wins. Only the best
+// leaderboardSize users are returned.
+func (server *Server) Leaderboard() []LeaderboardEntry {
+	entries := []LeaderboardEntry{}
+	for userId, userStats := range server.Stats.All() {
+		user, err := server.Users.Get(userId)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, LeaderboardEntry{
+			UserId:      userId,
+			DisplayName: user.DisplayName,
+			Rating:      server.Ratings.Rating(userId),
+			GamesPlayed: userStats.GamesPlayed,
+			Wins:        userStats.Wins,
+			WinRate:     userStats.WinRate,
+		})
+	}
+
+	slices.SortFunc(entries, func(a, b LeaderboardEntry) int {
+		return cmp.Or(b.Rating-a.Rating, b.Wins-a.Wins, a.UserId-b.UserId)
+	})
+	if len(entries) > leaderboardSize {
+		entries = entries[:leaderboardSize]
+	}
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+	return entries
+}
+
+// UserStats returns the statistics of the user, from all the games they finished
+func (server *Server) UserStats(userId int) (stats.Stats, error) {
+	if _, err := server.Users.Get(userId); err != nil {
+		return stats.Stats{}, withStatus(http.StatusNotFound, err)
+	}
+	return server.Stats.ForUser(userId), nil
+}
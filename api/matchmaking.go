@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/danilopavk/battleshipper/engine"
+	"github.com/danilopavk/battleshipper/store"
+)
+
+// FindMatch is the request body for joining the matchmaking queue.
+//
+// Hints default to the rules of the casual games. Ranked matches are only
+// open to the signed in users, and are played without hints. Signed in users
+// are matched with the players of similar rating, taken from their ratings,
+// while guests have no rating and are matched with anyone.
+type FindMatch struct {
+	Name   string `json:"name"`
+	Hints  *bool  `json:"hints,omitempty"`
+	Ranked bool   `json:"ranked,omitempty"`
+}
+
+func (server *Server) findMatch(writer http.ResponseWriter, request *http.Request) {
+	var findMatch FindMatch
+	if err := decode(request, &findMatch); err != nil {
+		writeError(writer, http.StatusBadRequest, "Expected player name")
+		return
+	}
+	user, err := server.SignedInUser(request)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	findMatch.Name = playerName(findMatch.Name, user)
+	if findMatch.Name == "" {
+		writeError(writer, http.StatusBadRequest, "Expected player name")
+		return
+	}
+
+	ticket := store.Ticket{UserId: user.Id, Rules: engine.DefaultRules()}
+	switch {
+	case findMatch.Ranked && user.Id == 0:
+		writeError(writer, http.StatusForbidden, errGuestRanked.Error())
+		return
+	case findMatch.Ranked:
+		ticket.Rules = engine.RankedRules()
+	case findMatch.Hints != nil:
+		ticket.Rules.Hints = *findMatch.Hints
+	}
+	if user.Id != 0 && server.Ratings != nil {
+		ticket.Rating = server.Ratings.Rating(user.Id)
+		ticket.Rated = true
+	}
+
+	player, game, err := server.FindMatch(findMatch.Name, ticket)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	server.writeCreated(writer, CreatedPlayer{PlayerId: player.Id, GameId: game.Id})
+}
+
+func (server *Server) cancelMatch(writer http.ResponseWriter, request *http.Request) {
+	playerId, err := server.authorize(request)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+
+	if err := server.Store.CancelMatch(playerId); err != nil {
+		writeError(writer, http.StatusConflict, err.Error())
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// FindMatch puts the player in the matchmaking queue, and starts the game if the opponent is already waiting
+func (server *Server) FindMatch(playerName string, ticket store.Ticket) (engine.Player, engine.Game, error) {
+	player, game, err := server.Store.FindMatch(playerName, ticket)
+	if err != nil {
+		return engine.Player{}, engine.Game{}, err
+	}
+	if game.Id != 0 {
+		server.joined(game)
+	}
+	return player, game, nil
+}
+
+// Matchmake periodically matches the players waiting in the queue, until the context is done.
+//
+// Waiting players are matched once their rating windows widen enough, so it
+// should run for as long as the server does.
+func (server *Server) Matchmake(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, game := range server.Store.Matchmake(now) {
+				server.joined(game)
+			}
+		}
+	}
+}
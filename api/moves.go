@@ -0,0 +1,177 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/danilopavk/battleshipper/engine"
+	"github.com/danilopavk/battleshipper/store"
+	"github.com/danilopavk/battleshipper/users"
+)
+
+// Join starts the game between the new player and the waiting opponent.
+//
+// Moves are shared by all the transports: the JSON endpoints, the websocket
+// and the web UI. The caller is responsible for checking that the player is
+// allowed to make the move.
+//
+// The new player is linked to the user, and guests can't join ranked games.
+func (server *Server) Join(playerName string, opponentId int, user users.User) (engine.Game, error) {
+	if rules, _ := server.Store.WaitingRules(opponentId); rules.Ranked && user.Id == 0 {
+		return engine.Game{}, withStatus(http.StatusForbidden, errGuestRanked)
+	}
+	game, err := server.Store.JoinGame(playerName, user.Id, opponentId)
+	if errors.Is(err, store.ErrNotWaiting) {
+		return engine.Game{}, withStatus(http.StatusNotFound, fmt.Errorf("No open game for player %d", opponentId))
+	}
+	if err != nil {
+		return engine.Game{}, err
+	}
+	server.joined(game)
+	return game, nil
+}
+
+// JoinPrivate starts the private game with the invite code, with the new player linked to the user
+func (server *Server) JoinPrivate(playerName string, code string, user users.User) (engine.Game, error) {
+	game, err := server.Store.JoinPrivateGame(playerName, user.Id, code)
+	if errors.Is(err, store.ErrNotWaiting) {
+		return engine.Game{}, withStatus(http.StatusNotFound, fmt.Errorf("No open game with invite code %v", code))
+	}
+	if err != nil {
+		return engine.Game{}, err
+	}
+	server.joined(game)
+	return game, nil
+}
+
+// PlaceShip places the next ship on the player's board
+func (server *Server) PlaceShip(playerId int, cells []engine.Cell) (engine.View, error) {
+	ship := engine.Ship{Cells: map[engine.Cell]bool{}}
+	for _, cell := range cells {
+		ship.Cells[cell] = true
+	}
+
+	if _, _, err := server.find(playerId); err != nil {
+		return engine.View{}, err
+	}
+	player, game, err := server.Store.PlaceShip(playerId, ship)
+	if err != nil {
+		return engine.View{}, moveFailure(err)
+	}
+	return server.placed(player, game)
+}
+
+// PlaceRandomly places the rest of the player's ships randomly
+func (server *Server) PlaceRandomly(playerId int) (engine.View, error) {
+	player, game, err := server.update(playerId, func(player *engine.Player, game *engine.Game) error {
+		if err := player.PlaceRandomly(); err != nil {
+			return withStatus(http.StatusConflict, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return engine.View{}, err
+	}
+	return server.placed(player, game)
+}
+
+// Shoot fires the player's shot at the opponent's board
+func (server *Server) Shoot(playerId int, cell engine.Cell) (ShotResult, error) {
+	if _, _, err := server.find(playerId); err != nil {
+		return ShotResult{}, err
+	}
+	shot, game, err := server.Store.Shoot(playerId, cell)
+	if err != nil {
+		return ShotResult{}, moveFailure(err)
+	}
+	server.Hub.PublishShot(game, shot)
+	server.playBots(playerId)
+
+	return ShotResult{Hit: shot.Hit, Sank: shot.Sank, Won: game.Winner != nil}, nil
+}
+
+// Resign ends the player's game, with the opponent as the winner
+func (server *Server) Resign(playerId int) (engine.View, error) {
+	player, game, err := server.update(playerId, func(player *engine.Player, game *engine.Game) error {
+		if game.Id == 0 {
+			return withStatus(http.StatusConflict, errors.New("Nobody joined the game yet"))
+		}
+		if err := game.Forfeit(player.Id); err != nil {
+			return withStatus(http.StatusConflict, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return engine.View{}, err
+	}
+	server.Hub.PublishOver(game)
+
+	return game.ViewFor(player.Id)
+}
+
+// OpenSpectating reveals both fleets to the spectators, or hides them again
+func (server *Server) OpenSpectating(playerId int, open bool) (engine.View, error) {
+	player, game, err := server.update(playerId, func(player *engine.Player, game *engine.Game) error {
+		if game.Id == 0 {
+			return withStatus(http.StatusConflict, errors.New("Nobody joined the game yet"))
+		}
+		if err := game.OpenSpectating(player.Id, open); err != nil {
+			return withStatus(http.StatusForbidden, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return engine.View{}, err
+	}
+	server.Hub.PublishSpectating(game)
+
+	return game.ViewFor(player.Id)
+}
+
+func (server *Server) joined(game engine.Game) {
+	server.Hub.PublishJoined(game)
+	server.playBots(game.PlayerB.Id)
+}
+
+// placed publishes the placed ships, once the player is in the game
+func (server *Server) placed(player engine.Player, game engine.Game) (engine.View, error) {
+	if game.Id == 0 {
+		return player.View(), nil
+	}
+
+	server.Hub.PublishReady(game)
+	server.playBots(player.Id)
+	return game.ViewFor(player.Id)
+}
+
+// update makes the player's move on the fresh game, and saves it.
+//
+// If someone else saves the game in the meantime, the move is made again on
+// the game they saved, so neither of the changes is lost.
+func (server *Server) update(playerId int, move func(player *engine.Player, game *engine.Game) error) (engine.Player, engine.Game, error) {
+	if _, _, err := server.find(playerId); err != nil {
+		return engine.Player{}, engine.Game{}, err
+	}
+	player, game, err := store.UpdateWithRetry(server.Store, playerId, move)
+	if errors.Is(err, store.ErrConflict) {
+		return engine.Player{}, engine.Game{}, withStatus(http.StatusConflict, err)
+	}
+	return player, game, err
+}
+
+// moveFailure responds with conflict to the moves the game doesn't allow right now
+func moveFailure(err error) error {
+	if errors.Is(err, store.ErrIllegalMove) || errors.Is(err, store.ErrNotStarted) || errors.Is(err, store.ErrConflict) {
+		return withStatus(http.StatusConflict, err)
+	}
+	return err
+}
+
+func (server *Server) find(playerId int) (engine.Player, engine.Game, error) {
+	player, game, err := server.Store.GetPlayerAndGame(playerId)
+	if err != nil {
+		return engine.Player{}, engine.Game{}, withStatus(http.StatusNotFound, err)
+	}
+	return player, game, nil
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/danilopavk/battleshipper/engine"
+)
+
+// Spectating is returned to the spectators of the game
+type Spectating struct {
+	engine.SpectatorView
+	Spectators int `json:"spectators"`
+}
+
+// OpenSpectatingRequest is the request body for opening and closing spectating
+type OpenSpectatingRequest struct {
+	Open bool `json:"open"`
+}
+
+func (server *Server) spectate(writer http.ResponseWriter, request *http.Request) {
+	game, err := server.spectatedGame(request)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+
+	writeJSON(writer, http.StatusOK, Spectating{game.SpectatorView(), server.Watchers.Count(game.Id)})
+}
+
+// spectatorEvents streams the events of the game to a spectator.
+//
+// Spectators are counted while they are connected, and everyone following the
+// game is notified whenever the count changes.
+func (server *Server) spectatorEvents(writer http.ResponseWriter, request *http.Request) {
+	game, err := server.spectatedGame(request)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	if _, ok := writer.(http.Flusher); !ok {
+		writeError(writer, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+
+	events, unsubscribe := server.Hub.SubscribeFrom(game.Id, lastEventId(request))
+	defer unsubscribe()
+
+	spectators, leave := server.Watchers.Watch(game.Id)
+	server.Hub.PublishSpectators(game, spectators)
+	defer func() {
+		server.Hub.PublishSpectators(game, leave())
+	}()
+
+	stream(writer, request, events)
+}
+
+func (server *Server) openSpectating(writer http.ResponseWriter, request *http.Request) {
+	playerId, err := server.authorize(request)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	var openSpectating OpenSpectatingRequest
+	if err := decode(request, &openSpectating); err != nil {
+		writeError(writer, http.StatusBadRequest, "Expected open flag")
+		return
+	}
+
+	view, err := server.OpenSpectating(playerId, openSpectating.Open)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	writeJSON(writer, http.StatusOK, view)
+}
+
+func (server *Server) spectatedGame(request *http.Request) (engine.Game, error) {
+	gameId, err := strconv.Atoi(request.PathValue("gameId"))
+	if err != nil {
+		return engine.Game{}, withStatus(http.StatusBadRequest, errors.New("Expected numeric game id"))
+	}
+	game, err := server.Store.GetGame(gameId)
+	if err != nil {
+		return engine.Game{}, withStatus(http.StatusNotFound, err)
+	}
+	return game, nil
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_Spectate(t *testing.T) {
+	server := testServer()
+	playerA, playerB := startedGame(t, server)
+
+	var spectating Spectating
+	status := call(t, server, "GET", fmt.Sprintf("/api/games/%d", playerB.GameId), nil, &spectating)
+
+	if status != http.StatusOK {
+		t.Fatalf("Unexpected status %d", status)
+	}
+	if spectating.PlayerA.Id != playerA.PlayerId || spectating.PlayerB.Name != "Felisin" {
+		t.Errorf("Unexpected players %v and %v", spectating.PlayerA, spectating.PlayerB)
+	}
+	if spectating.PlayerA.Ships != nil {
+		t.Error("Expected the fleets to be hidden")
+	}
+
+	path := fmt.Sprintf("/api/players/%d/spectating", playerB.PlayerId)
+	if status := call(t, server, "POST", path, OpenSpectatingRequest{Open: true}, nil); status != http.StatusForbidden {
+		t.Errorf("Expected forbidden status for the guest, but got %d", status)
+	}
+	path = fmt.Sprintf("/api/players/%d/spectating", playerA.PlayerId)
+	if status := call(t, server, "POST", path, OpenSpectatingRequest{Open: true}, nil); status != http.StatusOK {
+		t.Errorf("Expected the host to open spectating, but got %d", status)
+	}
+
+	call(t, server, "GET", fmt.Sprintf("/api/games/%d", playerB.GameId), nil, &spectating)
+	if len(spectating.PlayerA.Ships) != 5 {
+		t.Error("Expected the fleets to be revealed")
+	}
+}
+
+func Test_SpectateUnknownGame(t *testing.T) {
+	server := testServer()
+
+	if status := call(t, server, "GET", "/api/games/42", nil, nil); status != http.StatusNotFound {
+		t.Errorf("Expected not found status, but got %d", status)
+	}
+}
+
+func Test_SpectatorEventsCountSpectators(t *testing.T) {
+	server := httptest.NewServer(testServer())
+	defer server.Close()
+	_, playerB := startedGame(t, server.Config.Handler)
+
+	response, err := http.Get(fmt.Sprintf("%s/api/games/%d/events", server.URL, playerB.GameId))
+	if err != nil {
+		t.Fatalf("Cannot connect to events: %v", err)
+	}
+	defer response.Body.Close()
+
+	reader := bufio.NewReader(response.Body)
+	_, _ = reader.ReadString('\n')
+	line, _ := reader.ReadString('\n')
+	if strings.TrimSpace(line) != "event: spectators-changed" {
+		t.Errorf("Expected spectators changed event, but got %v", line)
+	}
+	line, _ = reader.ReadString('\n')
+	if !strings.Contains(line, `"spectators":1`) {
+		t.Errorf("Expected one spectator, but got %v", line)
+	}
+
+	var spectating Spectating
+	call(t, server.Config.Handler, "GET", fmt.Sprintf("/api/games/%d", playerB.GameId), nil, &spectating)
+	if spectating.Spectators != 1 {
+		t.Errorf("Expected one spectator, but got %d", spectating.Spectators)
+	}
+}
+
+// startedGame creates the game between Tavore and Felisin, with both fleets placed
+func startedGame(t *testing.T, server http.Handler) (CreatedPlayer, CreatedPlayer) {
+	var playerA, playerB CreatedPlayer
+	call(t, server, "POST", "/api/games", NewPlayer{Name: "Tavore"}, &playerA)
+	call(t, server, "POST", fmt.Sprintf("/api/games/%d/join", playerA.PlayerId), NewPlayer{Name: "Felisin"}, &playerB)
+	call(t, server, "POST", fmt.Sprintf("/api/players/%d/ships/random", playerA.PlayerId), nil, nil)
+	call(t, server, "POST", fmt.Sprintf("/api/players/%d/ships/random", playerB.PlayerId), nil, nil)
+	return playerA, playerB
+}
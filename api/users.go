@@ -0,0 +1,183 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danilopavk/battleshipper/auth"
+	"github.com/danilopavk/battleshipper/users"
+)
+
+// NewUser is the request body for registering users
+type NewUser struct {
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+// Credentials is the request body for signing in
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// SignedInUser is returned when the user registers or signs in.
+//
+// Token must be sent in the X-User-Token header when starting or joining
+// games, so the games are linked to the user.
+type SignedInUser struct {
+	UserId int    `json:"userId"`
+	Token  string `json:"token"`
+}
+
+// Profile is the public data of the user, with the current rating
+type Profile struct {
+	UserId      int       `json:"userId"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"displayName"`
+	Rating      int       `json:"rating"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Rename is the request body for changing the display name
+type Rename struct {
+	DisplayName string `json:"displayName"`
+}
+
+func (server *Server) registerUser(writer http.ResponseWriter, request *http.Request) {
+	var newUser NewUser
+	if err := decode(request, &newUser); err != nil {
+		writeError(writer, http.StatusBadRequest, "Expected username and password")
+		return
+	}
+
+	user, err := server.Users.Register(newUser.Username, newUser.DisplayName, newUser.Password)
+	switch {
+	case errors.Is(err, users.ErrUsernameTaken):
+		writeError(writer, http.StatusConflict, err.Error())
+	case err != nil:
+		writeError(writer, http.StatusBadRequest, err.Error())
+	default:
+		server.writeSignedIn(writer, http.StatusCreated, user)
+	}
+}
+
+func (server *Server) signIn(writer http.ResponseWriter, request *http.Request) {
+	var credentials Credentials
+	if err := decode(request, &credentials); err != nil {
+		writeError(writer, http.StatusBadRequest, "Expected username and password")
+		return
+	}
+
+	user, err := server.Users.Authenticate(credentials.Username, credentials.Password)
+	if err != nil {
+		writeError(writer, http.StatusUnauthorized, err.Error())
+		return
+	}
+	server.writeSignedIn(writer, http.StatusOK, user)
+}
+
+func (server *Server) profile(writer http.ResponseWriter, request *http.Request) {
+	userId, err := strconv.Atoi(request.PathValue("userId"))
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, "Expected numeric user id")
+		return
+	}
+
+	profile, err := server.Profile(userId)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	writeJSON(writer, http.StatusOK, profile)
+}
+
+func (server *Server) ratingHistory(writer http.ResponseWriter, request *http.Request) {
+	userId, err := strconv.Atoi(request.PathValue("userId"))
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, "Expected numeric user id")
+		return
+	}
+	if _, err := server.Users.Get(userId); err != nil {
+		writeError(writer, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(writer, http.StatusOK, server.Ratings.History(userId))
+}
+
+func (server *Server) rename(writer http.ResponseWriter, request *http.Request) {
+	userId, err := strconv.Atoi(request.PathValue("userId"))
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, "Expected numeric user id")
+		return
+	}
+	user, err := server.SignedInUser(request)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	if user.Id != userId {
+		writeError(writer, http.StatusForbidden, "Not allowed to change another user's profile")
+		return
+	}
+	var rename Rename
+	if err := decode(request, &rename); err != nil {
+		writeError(writer, http.StatusBadRequest, "Expected display name")
+		return
+	}
+
+	user, err = server.Users.Rename(userId, rename.DisplayName)
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(writer, http.StatusOK, server.profileOf(user))
+}
+
+// SignedInUser returns the user signed in with the request.
+//
+// Guests get the zero user, with zero id. Requests with an invalid user token
+// fail, instead of silently playing as a guest.
+func (server *Server) SignedInUser(request *http.Request) (users.User, error) {
+	userId, err := server.Signer.UserId(request)
+	if errors.Is(err, auth.ErrNoToken) || server.Users == nil {
+		return users.User{}, nil
+	}
+	if err != nil {
+		return users.User{}, withStatus(http.StatusUnauthorized, err)
+	}
+	user, err := server.Users.Get(userId)
+	if err != nil {
+		return users.User{}, withStatus(http.StatusUnauthorized, err)
+	}
+	return user, nil
+}
+
+// Profile returns the public data of the user
+func (server *Server) Profile(userId int) (Profile, error) {
+	user, err := server.Users.Get(userId)
+	if err != nil {
+		return Profile{}, withStatus(http.StatusNotFound, err)
+	}
+	return server.profileOf(user), nil
+}
+
+// playerName returns the name the player asked for, or the display name of the signed in user
+func playerName(name string, user users.User) string {
+	if name == "" {
+		return user.DisplayName
+	}
+	return name
+}
+
+func (server *Server) writeSignedIn(writer http.ResponseWriter, status int, user users.User) {
+	token := server.Signer.IssueUser(user.Id)
+	auth.SetUserCookie(writer, token)
+	writeJSON(writer, status, SignedInUser{UserId: user.Id, Token: token})
+}
+
+func (server *Server) profileOf(user users.User) Profile {
+	return Profile{UserId: user.Id, Username: user.Username, DisplayName: user.DisplayName, Rating: server.Ratings.Rating(user.Id), CreatedAt: user.CreatedAt}
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/danilopavk/battleshipper/ratings"
+	"github.com/danilopavk/battleshipper/stats"
+)
+
+func Test_RegisterAndSignIn(t *testing.T) {
+	server := testServer()
+
+	var registered SignedInUser
+	status := call(t, server, "POST", "/api/users", NewUser{Username: "ganoes", Password: "paran's sword", DisplayName: "Ganoes Paran"}, &registered)
+	if status != http.StatusCreated || registered.UserId == 0 {
+		t.Fatalf("Expected the user to be registered, but got status %d", status)
+	}
+	if status := call(t, server, "POST", "/api/users", NewUser{Username: "Ganoes", Password: "another sword"}, nil); status != http.StatusConflict {
+		t.Errorf("Expected conflict status for the taken username, but got %d", status)
+	}
+
+	var signedIn SignedInUser
+	status = call(t, server, "POST", "/api/sessions", Credentials{Username: "ganoes", Password: "paran's sword"}, &signedIn)
+	if status != http.StatusOK || signedIn.UserId != registered.UserId {
+		t.Errorf("Expected to sign in, but got status %d", status)
+	}
+	if status := call(t, server, "POST", "/api/sessions", Credentials{Username: "ganoes", Password: "wrong"}, nil); status != http.StatusUnauthorized {
+		t.Errorf("Expected unauthorized status for the wrong password, but got %d", status)
+	}
+
+	var profile Profile
+	call(t, server, "GET", fmt.Sprintf("/api/users/%d", registered.UserId), nil, &profile)
+	if profile.Username != "ganoes" || profile.DisplayName != "Ganoes Paran" {
+		t.Errorf("Unexpected profile %v", profile)
+	}
+}
+
+func Test_StartGameAsUser(t *testing.T) {
+	handler := testServer()
+	var user SignedInUser
+	call(t, handler, "POST", "/api/users", NewUser{Username: "ganoes", Password: "paran's sword", DisplayName: "Ganoes Paran"}, &user)
+
+	if status := callAsUser(t, handler, user.Token, "POST", "/api/games", NewPlayer{}, nil); status != http.StatusCreated {
+		t.Fatalf("Unexpected status %d", status)
+	}
+
+	var openGames []OpenGame
+	call(t, handler, "GET", "/api/games", nil, &openGames)
+	if len(openGames) != 1 || openGames[0].Name != "Ganoes Paran" {
+		t.Errorf("Expected the game to be started with the display name, got %v", openGames)
+	}
+
+	if status := callAsUser(t, handler, user.Token+"x", "POST", "/api/games", NewPlayer{Name: "Tavore"}, nil); status != http.StatusUnauthorized {
+		t.Errorf("Expected unauthorized status for the invalid user token, but got %d", status)
+	}
+}
+
+func Test_RenameAnotherUser(t *testing.T) {
+	handler := testServer()
+	var ganoes, tavore SignedInUser
+	call(t, handler, "POST", "/api/users", NewUser{Username: "ganoes", Password: "paran's sword"}, &ganoes)
+	call(t, handler, "POST", "/api/users", NewUser{Username: "tavore", Password: "otataral sword"}, &tavore)
+
+	path := fmt.Sprintf("/api/users/%d", ganoes.UserId)
+	if status := callAsUser(t, handler, tavore.Token, "PATCH", path, Rename{DisplayName: "Adjunct"}, nil); status != http.StatusForbidden {
+		t.Errorf("Expected forbidden status, but got %d", status)
+	}
+	if status := callAsUser(t, handler, ganoes.Token, "PATCH", path, Rename{DisplayName: "Captain"}, nil); status != http.StatusOK {
+		t.Errorf("Expected the user to rename themselves, but got %d", status)
+	}
+}
+
+func Test_RankedGame(t *testing.T) {
+	handler := testServer()
+	var ganoes, tavore SignedInUser
+	call(t, handler, "POST", "/api/users", NewUser{Username: "ganoes", Password: "paran's sword"}, &ganoes)
+	call(t, handler, "POST", "/api/users", NewUser{Username: "tavore", Password: "otataral sword"}, &tavore)
+
+	if status := call(t, handler, "POST", "/api/games", NewPlayer{Name: "Felisin", Ranked: true}, nil); status != http.StatusForbidden {
+		t.Errorf("Expected forbidden status when a guest starts a ranked game, but got %d", status)
+	}
+	var host CreatedPlayer
+	callAsUser(t, handler, ganoes.Token, "POST", "/api/games", NewPlayer{Ranked: true}, &host)
+
+	var openGames []OpenGame
+	call(t, handler, "GET", "/api/games", nil, &openGames)
+	if len(openGames) != 1 || !openGames[0].Ranked {
+		t.Errorf("Expected the open game to be ranked, got %v", openGames)
+	}
+
+	joinPath := fmt.Sprintf("/api/games/%d/join", host.PlayerId)
+	if status := call(t, handler, "POST", joinPath, NewPlayer{Name: "Felisin"}, nil); status != http.StatusForbidden {
+		t.Errorf("Expected forbidden status when a guest joins a ranked game, but got %d", status)
+	}
+	if status := callAsUser(t, handler, tavore.Token, "POST", joinPath, NewPlayer{}, nil); status != http.StatusCreated {
+		t.Fatalf("Expected Tavore to join the ranked game, but got status %d", status)
+	}
+	call(t, handler, "POST", fmt.Sprintf("/api/players/%d/resign", host.PlayerId), nil, nil)
+
+	var history []ratings.Change
+	call(t, handler, "GET", fmt.Sprintf("/api/users/%d/ratings", ganoes.UserId), nil, &history)
+	if len(history) != 1 || history[0].Won || history[0].OpponentId != tavore.UserId {
+		t.Errorf("Expected the resignation to count as a loss, but the history is %v", history)
+	}
+	var profile Profile
+	call(t, handler, "GET", fmt.Sprintf("/api/users/%d", tavore.UserId), nil, &profile)
+	if profile.Rating <= ratings.DefaultRating {
+		t.Errorf("Expected Tavore's rating to grow, but it is %d", profile.Rating)
+	}
+}
+
+func Test_LeaderboardAndStats(t *testing.T) {
+	handler := testServer()
+	var ganoes, tavore SignedInUser
+	call(t, handler, "POST", "/api/users", NewUser{Username: "ganoes", Password: "paran's sword", DisplayName: "Ganoes Paran"}, &ganoes)
+	call(t, handler, "POST", "/api/users", NewUser{Username: "tavore", Password: "otataral sword", DisplayName: "Tavore Paran"}, &tavore)
+
+	var host CreatedPlayer
+	callAsUser(t, handler, ganoes.Token, "POST", "/api/games", NewPlayer{Ranked: true}, &host)
+	callAsUser(t, handler, tavore.Token, "POST", fmt.Sprintf("/api/games/%d/join", host.PlayerId), NewPlayer{}, nil)
+	call(t, handler, "POST", fmt.Sprintf("/api/players/%d/resign", host.PlayerId), nil, nil)
+
+	var leaderboard []LeaderboardEntry
+	call(t, handler, "GET", "/api/leaderboard", nil, &leaderboard)
+	if len(leaderboard) != 2 || leaderboard[0].UserId != tavore.UserId || leaderboard[0].Rank != 1 || leaderboard[1].DisplayName != "Ganoes Paran" {
+		t.Errorf("Expected Tavore to lead Ganoes, but got %v", leaderboard)
+	}
+
+	var userStats stats.Stats
+	call(t, handler, "GET", fmt.Sprintf("/api/users/%d/stats", tavore.UserId), nil, &userStats)
+	if userStats.GamesPlayed != 1 || userStats.WinRate != 1 || userStats.LongestWinStreak != 1 {
+		t.Errorf("Unexpected stats %v", userStats)
+	}
+	if status := call(t, handler, "GET", "/api/users/123/stats", nil, nil); status != http.StatusNotFound {
+		t.Errorf("Expected not found status for the unknown user, but got %d", status)
+	}
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/danilopavk/battleshipper/engine"
+	"github.com/gorilla/websocket"
+)
+
+// Message types sent over the websocket
+const (
+	// Sent by the client
+	PlaceMessage       = "place"
+	PlaceRandomMessage = "place-random"
+	ShootMessage       = "shoot"
+	PingMessage        = "ping"
+
+	// Sent by the server
+	EventMessage  = "event"
+	ResultMessage = "result"
+	ErrorMessage  = "error"
+	PongMessage   = "pong"
+)
+
+// heartbeatInterval is how often the server pings the client, and pongWait how long it waits for the answer
+const heartbeatInterval = 30 * time.Second
+const pongWait = 2 * heartbeatInterval
+
+// Message is the envelope of everything sent over the websocket, in both directions.
+//
+// Ref is chosen by the client when sending a command, and repeated in the result
+// or error for that command. EventId is set on event messages, and can be used
+// to resume the stream after reconnecting. Payload depends on the type: ship
+// cells for place, cell for shoot, view or shot result for result, event for
+// event, and error object for error.
+type Message struct {
+	Type    string          `json:"type"`
+	Ref     string          `json:"ref,omitempty"`
+	EventId int             `json:"eventId,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+var upgrader = websocket.Upgrader{}
+
+// session is a single websocket connection of a player
+type session struct {
+	server     *Server
+	playerId   int
+	connection *websocket.Conn
+	mutex      sync.Mutex
+}
+
+// socket upgrades the connection, and then serves the player's commands and game events over it.
+//
+// To resume after reconnecting, the client passes the id of the last event it received
+// in lastEventId query parameter, and gets all the events it missed first.
+func (server *Server) socket(writer http.ResponseWriter, request *http.Request) {
+	player, _, err := server.playerAndGame(request)
+	if err != nil {
+		writeFailure(writer, err)
+		return
+	}
+	lastEventId := -1
+	if value := request.URL.Query().Get("lastEventId"); value != "" {
+		if lastEventId, err = strconv.Atoi(value); err != nil {
+			writeError(writer, http.StatusBadRequest, "Expected numeric last event id")
+			return
+		}
+	}
+
+	connection, err := upgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		return
+	}
+	defer connection.Close()
+
+	events, unsubscribe := server.Hub.SubscribeFrom(player.Id, lastEventId)
+	defer unsubscribe()
+
+	session := &session{server: server, playerId: player.Id, connection: connection}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		session.readCommands()
+	}()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-heartbeat.C:
+			if err := session.ping(); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := session.send(Message{Type: EventMessage, EventId: event.Id}, event); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (session *session) readCommands() {
+	session.connection.SetReadDeadline(time.Now().Add(pongWait))
+	session.connection.SetPongHandler(func(string) error {
+		return session.connection.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		var message Message
+		if err := session.connection.ReadJSON(&message); err != nil {
+			return
+		}
+		session.connection.SetReadDeadline(time.Now().Add(pongWait))
+
+		result, err := session.execute(message)
+		reply := Message{Type: ResultMessage, Ref: message.Ref}
+		switch {
+		case err != nil:
+			reply.Type = ErrorMessage
+			result = Error{Error: err.Error()}
+		case message.Type == PingMessage:
+			reply.Type = PongMessage
+		}
+		if err := session.send(reply, result); err != nil {
+			return
+		}
+	}
+}
+
+func (session *session) execute(message Message) (any, error) {
+	if message.Type == PingMessage {
+		return nil, nil
+	}
+
+	switch message.Type {
+	case PlaceMessage:
+		var placeShip PlaceShip
+		if err := json.Unmarshal(message.Payload, &placeShip); err != nil {
+			return nil, errors.New("Expected ship cells")
+		}
+		return session.server.PlaceShip(session.playerId, placeShip.Cells)
+	case PlaceRandomMessage:
+		return session.server.PlaceRandomly(session.playerId)
+	case ShootMessage:
+		var cell engine.Cell
+		if err := json.Unmarshal(message.Payload, &cell); err != nil {
+			return nil, errors.New("Expected cell to shoot at")
+		}
+		return session.server.Shoot(session.playerId, cell)
+	default:
+		return nil, fmt.Errorf("Unknown message type %v", message.Type)
+	}
+}
+
+func (session *session) send(message Message, payload any) error {
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		message.Payload = data
+	}
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.connection.WriteJSON(message)
+}
+
+func (session *session) ping() error {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.connection.WriteControl(websocket.PingMessage, nil, time.Now().Add(heartbeatInterval))
+}
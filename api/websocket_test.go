@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danilopavk/battleshipper/engine"
+	"github.com/gorilla/websocket"
+)
+
+func Test_SocketCommandsAndEvents(t *testing.T) {
+	server := httptest.NewServer(testServer())
+	defer server.Close()
+
+	var playerA CreatedPlayer
+	call(t, server.Config.Handler, "POST", "/api/games", NewPlayer{Name: "Tavore"}, &playerA)
+	var playerB CreatedPlayer
+	call(t, server.Config.Handler, "POST", fmt.Sprintf("/api/games/%d/join", playerA.PlayerId), NewPlayer{Name: "Felisin"}, &playerB)
+	call(t, server.Config.Handler, "POST", fmt.Sprintf("/api/players/%d/ships/random", playerB.PlayerId), nil, nil)
+
+	connection := dial(t, server, playerA, "")
+	defer connection.Close()
+
+	send(t, connection, Message{Type: PingMessage, Ref: "1"})
+	if reply := receive(t, connection); reply.Type != PongMessage || reply.Ref != "1" {
+		t.Errorf("Expected pong, but got %v", reply)
+	}
+
+	send(t, connection, Message{Type: ShootMessage, Ref: "2", Payload: json.RawMessage(`{"x": 0, "y": 0}`)})
+	if reply := receive(t, connection); reply.Type != ErrorMessage || reply.Ref != "2" {
+		t.Errorf("Expected error on shot before placing ships, but got %v", reply)
+	}
+
+	send(t, connection, Message{Type: PlaceRandomMessage, Ref: "3"})
+	var event Message
+	var result Message
+	for _, reply := range []Message{receive(t, connection), receive(t, connection)} {
+		switch reply.Type {
+		case EventMessage:
+			event = reply
+		case ResultMessage:
+			result = reply
+		}
+	}
+	if result.Ref != "3" {
+		t.Errorf("Expected result of random placement, but got %v", result)
+	}
+	var view engine.View
+	_ = json.Unmarshal(result.Payload, &view)
+	if len(view.Ships) != 5 {
+		t.Errorf("Expected 5 ships after random placement, but there are %d", len(view.Ships))
+	}
+	if event.EventId == 0 || !strings.Contains(string(event.Payload), `"your-turn"`) {
+		t.Errorf("Expected your turn event, but got %v", event)
+	}
+}
+
+func Test_SocketResume(t *testing.T) {
+	server := httptest.NewServer(testServer())
+	defer server.Close()
+
+	var playerA CreatedPlayer
+	call(t, server.Config.Handler, "POST", "/api/games", NewPlayer{Name: "Tavore"}, &playerA)
+	call(t, server.Config.Handler, "POST", fmt.Sprintf("/api/games/%d/join", playerA.PlayerId), NewPlayer{Name: "Felisin"}, nil)
+
+	connection := dial(t, server, playerA, "0")
+	defer connection.Close()
+
+	if reply := receive(t, connection); reply.Type != EventMessage || !strings.Contains(string(reply.Payload), `"opponent-joined"`) {
+		t.Errorf("Expected missed opponent joined event, but got %v", reply)
+	}
+}
+
+func dial(t *testing.T, server *httptest.Server, player CreatedPlayer, lastEventId string) *websocket.Conn {
+	url := fmt.Sprintf("ws%s/api/players/%d/socket", strings.TrimPrefix(server.URL, "http"), player.PlayerId)
+	if lastEventId != "" {
+		url += "?lastEventId=" + lastEventId
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+player.Token)
+
+	connection, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("Cannot connect to socket: %v", err)
+	}
+	return connection
+}
+
+func send(t *testing.T, connection *websocket.Conn, message Message) {
+	if err := connection.WriteJSON(message); err != nil {
+		t.Fatalf("Cannot send message: %v", err)
+	}
+}
+
+func receive(t *testing.T, connection *websocket.Conn) Message {
+	_ = connection.SetReadDeadline(time.Now().Add(time.Second))
+	var message Message
+	if err := connection.ReadJSON(&message); err != nil {
+		t.Fatalf("Cannot receive message: %v", err)
+	}
+	return message
+}
@@ -0,0 +1,283 @@
+// Package archive searches the finished games, like "all my games against Ana last month".
+//
+// Search runs the query over the games it's given, which is how the stores
+// that keep their games in memory search them. The SQLite store runs the
+// same query in SQL instead, so its indexes find the archived games, and
+// uses Query.Matches and Query.PageOf for the games it still keeps in
+// memory. Queries travel in the URLs, both in the API and in the pages, so
+// ParseQuery and Query.Values convert between the two.
+package archive
+
+import (
+	"cmp"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danilopavk/battleshipper/engine"
+)
+
+// DefaultPageSize is the number of games on a page, unless the query asks for another
+const DefaultPageSize = 20
+
+// MaxPageSize is the most games a single page can have
+const MaxPageSize = 100
+
+// dateFormat is how the dates are written in the queries, like 2024-03-01
+const dateFormat = "2006-01-02"
+
+// Result of the game, from the point of view of the user the games are searched for
+type Result string
+
+const (
+	AnyResult Result = ""
+	Won       Result = "won"
+	Lost      Result = "lost"
+)
+
+// Rule sets the games can be searched by
+const (
+	anyRules    = ""
+	rankedRules = "ranked"
+	casualRules = "casual"
+)
+
+// Query tells which finished games to find, and which page of them to return.
+//
+// UserId limits the games to the ones the user played, and OpponentId and
+// Opponent to the ones played against that user, or against the player with
+// that name, in any case. Without the user, either player can be the
+// opponent. The games ended on From or later, and before To, and zero times
+// leave the range open. Result needs the user, since it's their result.
+// Ranked, if set, keeps only the ranked or only the casual games. Pages are
+// counted from 1.
+type Query struct {
+	UserId     int
+	OpponentId int
+	Opponent   string
+	From       time.Time
+	To         time.Time
+	Result     Result
+	Ranked     *bool
+	Page       int
+	PageSize   int
+}
+
+// Page is a single page of the games found, the latest ended first, and the number of all the games found
+type Page struct {
+	Games    []engine.Game
+	Page     int
+	PageSize int
+	Total    int
+}
+
+// Search finds the games matching the query, and returns the page the query asks for.
+//
+// Returns error if the query can't be answered, like the result without the user.
+func Search(games []engine.Game, query Query) (Page, error) {
+	if err := query.Validate(); err != nil {
+		return Page{}, err
+	}
+
+	var found []engine.Game
+	for _, game := range games {
+		if query.Matches(game) {
+			found = append(found, game)
+		}
+	}
+	return query.PageOf(found), nil
+}
+
+// PageOf sorts the games found, the latest ended first, and returns the page of them the query asks for
+func (query Query) PageOf(found []engine.Game) Page {
+	page := Page{Games: []engine.Game{}, Page: max(query.Page, 1), PageSize: query.PageSize}
+	if page.PageSize <= 0 {
+		page.PageSize = DefaultPageSize
+	}
+	slices.SortFunc(found, func(a, b engine.Game) int {
+		return cmp.Or(b.EndedAt.Compare(a.EndedAt), b.Id-a.Id)
+	})
+
+	page.Total = len(found)
+	// Pages past the last one are empty, checked first so the huge page numbers don't overflow
+	start := len(found)
+	if page.Page-1 <= len(found)/page.PageSize {
+		start = min((page.Page-1)*page.PageSize, len(found))
+	}
+	end := min(start+page.PageSize, len(found))
+	page.Games = append(page.Games, found[start:end]...)
+	return page
+}
+
+// Pages returns the number of pages all the games found fill
+func (page Page) Pages() int {
+	return (page.Total + page.PageSize - 1) / page.PageSize
+}
+
+// HasNext checks whether there are more games found after this page
+func (page Page) HasNext() bool {
+	return page.Page < page.Pages()
+}
+
+// ParseQuery reads the query from the URL parameters.
+//
+// Parameters are user, opponentId, opponent, from, to, result, rules, page
+// and pageSize. Dates are like 2024-03-01, and to includes the whole day.
+// Rules are either ranked or casual. Missing parameters match every game.
+func ParseQuery(values url.Values) (Query, error) {
+	query := Query{Opponent: strings.TrimSpace(values.Get("opponent")), Result: Result(values.Get("result"))}
+	numbers := []struct {
+		name  string
+		value *int
+	}{
+		{"user", &query.UserId},
+		{"opponentId", &query.OpponentId},
+		{"page", &query.Page},
+		{"pageSize", &query.PageSize},
+	}
+	for _, number := range numbers {
+		value := values.Get(number.name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return Query{}, fmt.Errorf("Expected positive number in %v, but got %q", number.name, value)
+		}
+		*number.value = parsed
+	}
+
+	var err error
+	if query.From, err = parseDate(values, "from"); err != nil {
+		return Query{}, err
+	}
+	if query.To, err = parseDate(values, "to"); err != nil {
+		return Query{}, err
+	}
+	if !query.To.IsZero() {
+		query.To = query.To.AddDate(0, 0, 1)
+	}
+
+	switch rules := values.Get("rules"); rules {
+	case anyRules:
+	case rankedRules, casualRules:
+		ranked := rules == rankedRules
+		query.Ranked = &ranked
+	default:
+		return Query{}, fmt.Errorf("Expected %v or %v rules, but got %q", rankedRules, casualRules, rules)
+	}
+	return query, query.Validate()
+}
+
+// Values writes the query as the URL parameters ParseQuery reads, leaving out the ones that match every game
+func (query Query) Values() url.Values {
+	values := url.Values{}
+	setNumber := func(name string, value int) {
+		if value != 0 {
+			values.Set(name, strconv.Itoa(value))
+		}
+	}
+	setNumber("user", query.UserId)
+	setNumber("opponentId", query.OpponentId)
+	setNumber("page", query.Page)
+	setNumber("pageSize", query.PageSize)
+	if query.Opponent != "" {
+		values.Set("opponent", query.Opponent)
+	}
+	if !query.From.IsZero() {
+		values.Set("from", query.From.Format(dateFormat))
+	}
+	if !query.To.IsZero() {
+		values.Set("to", query.To.AddDate(0, 0, -1).Format(dateFormat))
+	}
+	if query.Result != AnyResult {
+		values.Set("result", string(query.Result))
+	}
+	if query.Ranked != nil {
+		values.Set("rules", casualRules)
+		if *query.Ranked {
+			values.Set("rules", rankedRules)
+		}
+	}
+	return values
+}
+
+// Validate checks whether the query can be answered
+func (query Query) Validate() error {
+	switch query.Result {
+	case AnyResult:
+	case Won, Lost:
+		if query.UserId == 0 {
+			return fmt.Errorf("Expected user to search by the result")
+		}
+	default:
+		return fmt.Errorf("Expected %v or %v result, but got %q", Won, Lost, query.Result)
+	}
+	if query.PageSize > MaxPageSize {
+		return fmt.Errorf("Expected at most %d games on the page, but got %d", MaxPageSize, query.PageSize)
+	}
+	return nil
+}
+
+// Matches checks whether the finished game is one of the games the query looks for
+func (query Query) Matches(game engine.Game) bool {
+	if game.Winner == nil {
+		return false
+	}
+	if !query.From.IsZero() && game.EndedAt.Before(query.From) {
+		return false
+	}
+	if !query.To.IsZero() && !game.EndedAt.Before(query.To) {
+		return false
+	}
+	if query.Ranked != nil && game.Rules.Ranked != *query.Ranked {
+		return false
+	}
+
+	if query.UserId == 0 {
+		return query.isOpponent(game.PlayerA) || query.isOpponent(game.PlayerB)
+	}
+	for _, pair := range [][2]engine.Player{{game.PlayerA, game.PlayerB}, {game.PlayerB, game.PlayerA}} {
+		player, opponent := pair[0], pair[1]
+		if player.UserId == query.UserId && query.isOpponent(opponent) && query.hasResult(game, player) {
+			return true
+		}
+	}
+	return false
+}
+
+// isOpponent checks whether the player is the opponent the query looks for, if it looks for any
+func (query Query) isOpponent(player engine.Player) bool {
+	if query.OpponentId != 0 && player.UserId != query.OpponentId {
+		return false
+	}
+	return query.Opponent == "" || strings.EqualFold(player.Name, query.Opponent)
+}
+
+// hasResult checks whether the player finished the game with the result the query looks for
+func (query Query) hasResult(game engine.Game, player engine.Player) bool {
+	switch query.Result {
+	case Won:
+		return *game.Winner == player.Id
+	case Lost:
+		return *game.Winner != player.Id
+	default:
+		return true
+	}
+}
+
+// parseDate reads the date parameter, which is zero if it's missing
+func parseDate(values url.Values, name string) (time.Time, error) {
+	value := values.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	date, err := time.Parse(dateFormat, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Expected date like 2024-03-01 in %v, but got %q", name, value)
+	}
+	return date, nil
+}
@@ -0,0 +1,205 @@
+package archive
+
+import (
+	"math"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/danilopavk/battleshipper/engine"
+	"github.com/google/go-cmp/cmp"
+)
+
+// Users the games are played by
+const (
+	karsa   = 1
+	fiddler = 2
+	hedge   = 3
+)
+
+func Test_Search(t *testing.T) {
+	march := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	april := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	ranked, casual := true, false
+	games := []engine.Game{
+		finishedGame(1, karsa, "Karsa Orlong", fiddler, "Fiddler", karsa, march, true),
+		finishedGame(2, karsa, "Karsa Orlong", fiddler, "Fiddler", fiddler, march.AddDate(0, 0, 10), false),
+		finishedGame(3, hedge, "Hedge", karsa, "Karsa Orlong", karsa, april, false),
+		finishedGame(4, fiddler, "Fiddler", 0, "Quick Ben", 0, april, false),
+		unfinishedGame(5, karsa, fiddler),
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []int
+	}{
+		{"everything", Query{}, []int{4, 3, 2, 1}},
+		{"user", Query{UserId: karsa}, []int{3, 2, 1}},
+		{"opponent by user", Query{UserId: karsa, OpponentId: fiddler}, []int{2, 1}},
+		{"opponent by name", Query{UserId: fiddler, Opponent: "quick ben"}, []int{4}},
+		{"opponent without user", Query{Opponent: "Hedge"}, []int{3}},
+		{"date range", Query{UserId: karsa, From: march.AddDate(0, 0, 1), To: april}, []int{2}},
+		{"won", Query{UserId: karsa, Result: Won}, []int{3, 1}},
+		{"lost", Query{UserId: karsa, Result: Lost}, []int{2}},
+		{"ranked", Query{Ranked: &ranked}, []int{1}},
+		{"casual", Query{UserId: karsa, Ranked: &casual}, []int{3, 2}},
+		{"all my games against Fiddler last month", Query{UserId: karsa, OpponentId: fiddler, From: march, To: april}, []int{2, 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page, err := Search(games, test.query)
+			if err != nil {
+				t.Fatalf("Cannot search: %v", err)
+			}
+			if diff := cmp.Diff(test.want, gameIds(page.Games)); diff != "" {
+				t.Errorf("Unexpected games (-want +got):\n%s", diff)
+			}
+			if page.Total != len(test.want) {
+				t.Errorf("Expected %d games in total, but got %d", len(test.want), page.Total)
+			}
+		})
+	}
+}
+
+func Test_SearchPages(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	var games []engine.Game
+	for id := 1; id <= 5; id++ {
+		games = append(games, finishedGame(id, karsa, "Karsa Orlong", fiddler, "Fiddler", karsa, start.Add(time.Duration(id)*time.Hour), false))
+	}
+
+	first, _ := Search(games, Query{PageSize: 2})
+	last, _ := Search(games, Query{PageSize: 2, Page: 3})
+	beyond, _ := Search(games, Query{PageSize: 2, Page: 4})
+
+	if diff := cmp.Diff([]int{5, 4}, gameIds(first.Games)); diff != "" {
+		t.Errorf("Unexpected first page (-want +got):\n%s", diff)
+	}
+	if first.Pages() != 3 || !first.HasNext() {
+		t.Errorf("Expected the first of three pages, but got %v of %v", first.Page, first.Pages())
+	}
+	if diff := cmp.Diff([]int{1}, gameIds(last.Games)); diff != "" {
+		t.Errorf("Unexpected last page (-want +got):\n%s", diff)
+	}
+	if last.HasNext() {
+		t.Error("Expected no page after the last one")
+	}
+	if len(beyond.Games) != 0 || beyond.Total != 5 {
+		t.Errorf("Expected the page beyond the last one to be empty, but got %v", beyond)
+	}
+}
+
+func Test_SearchHugePage(t *testing.T) {
+	games := []engine.Game{finishedGame(1, karsa, "Karsa Orlong", fiddler, "Fiddler", karsa, time.Now(), false)}
+	query, err := ParseQuery(url.Values{"page": {strconv.Itoa(math.MaxInt)}, "pageSize": {"2"}})
+	if err != nil {
+		t.Fatalf("Cannot parse the query: %v", err)
+	}
+
+	page, err := Search(games, query)
+	if err != nil || len(page.Games) != 0 || page.Total != 1 {
+		t.Errorf("Expected the huge page to be empty, but got %v, error %v", page, err)
+	}
+}
+
+func Test_SearchInvalid(t *testing.T) {
+	queries := []Query{
+		{Result: Won},
+		{UserId: karsa, Result: "drawn"},
+		{PageSize: MaxPageSize + 1},
+	}
+	for _, query := range queries {
+		if _, err := Search(nil, query); err == nil {
+			t.Errorf("Expected the query %v to fail", query)
+		}
+	}
+}
+
+func Test_ParseQuery(t *testing.T) {
+	values := url.Values{
+		"user":       {"1"},
+		"opponentId": {"2"},
+		"opponent":   {" Fiddler "},
+		"from":       {"2024-03-01"},
+		"to":         {"2024-03-31"},
+		"result":     {"won"},
+		"rules":      {"ranked"},
+		"page":       {"2"},
+		"pageSize":   {"10"},
+	}
+
+	query, err := ParseQuery(values)
+
+	if err != nil {
+		t.Fatalf("Cannot parse the query: %v", err)
+	}
+	ranked := true
+	want := Query{
+		UserId:     karsa,
+		OpponentId: fiddler,
+		Opponent:   "Fiddler",
+		From:       time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		To:         time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		Result:     Won,
+		Ranked:     &ranked,
+		Page:       2,
+		PageSize:   10,
+	}
+	if diff := cmp.Diff(want, query); diff != "" {
+		t.Errorf("Unexpected query (-want +got):\n%s", diff)
+	}
+	values.Set("opponent", "Fiddler")
+	if diff := cmp.Diff(values, query.Values()); diff != "" {
+		t.Errorf("Unexpected values (-want +got):\n%s", diff)
+	}
+}
+
+func Test_ParseInvalidQuery(t *testing.T) {
+	invalid := []url.Values{
+		{"user": {"Karsa"}},
+		{"page": {"-1"}},
+		{"page": {"0"}},
+		{"pageSize": {"0"}},
+		{"from": {"March"}},
+		{"rules": {"hints"}},
+		{"result": {"won"}},
+	}
+	for _, values := range invalid {
+		if _, err := ParseQuery(values); err == nil {
+			t.Errorf("Expected %v to fail", values)
+		}
+	}
+}
+
+// finishedGame builds the game between the two players, won by the player of the winning user
+func finishedGame(id int, userA int, nameA string, userB int, nameB string, winnerUser int, endedAt time.Time, ranked bool) engine.Game {
+	game := unfinishedGame(id, userA, userB)
+	game.PlayerA.Name, game.PlayerB.Name = nameA, nameB
+	winner := game.PlayerB.Id
+	if winnerUser == userA {
+		winner = game.PlayerA.Id
+	}
+	game.Winner = &winner
+	game.Rules.Ranked = ranked
+	game.EndedAt = endedAt
+	return game
+}
+
+// unfinishedGame builds the game between the two users that nobody won yet
+func unfinishedGame(id int, userA int, userB int) engine.Game {
+	return engine.Game{
+		Id:      id,
+		PlayerA: engine.Player{Id: id * 10, UserId: userA},
+		PlayerB: engine.Player{Id: id*10 + 1, UserId: userB},
+	}
+}
+
+func gameIds(games []engine.Game) []int {
+	ids := []int{}
+	for _, game := range games {
+		ids = append(ids, game.Id)
+	}
+	return ids
+}
@@ -0,0 +1,176 @@
+// Package auth issues and checks the session tokens of the players and users.
+//
+// Player ids are plain random numbers, so knowing one shouldn't be enough to act
+// as that player. Every player gets a token signed by the server when they start
+// or join a game, and must present it with every action. The htmx UI keeps the
+// token in a cookie, and the API clients send it in the Authorization header.
+//
+// Users signing in to their accounts get a separate user token, which outlives
+// the games. It's kept in its own cookie, or sent in the X-User-Token header.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CookieName is the name of the cookie holding the session token
+const CookieName = "battleshipper_session"
+
+// UserCookieName is the name of the cookie holding the user token
+const UserCookieName = "battleshipper_user"
+
+// UserHeader is the name of the header API clients send the user token in
+const UserHeader = "X-User-Token"
+
+// AdminHeader is the name of the header the admin token is sent in
+const AdminHeader = "X-Admin-Token"
+
+// userPrefix starts the payload of every user token, so player tokens can't be used as user tokens and the other way around
+const userPrefix = "user."
+
+// ErrNoToken is returned when the request doesn't carry a session token
+var ErrNoToken = errors.New("Session token is missing")
+
+// Signer issues tokens signed with the server secret, and verifies them.
+type Signer struct {
+	secret []byte
+}
+
+// InitializeSigner builds the signer with the given secret.
+//
+// Tokens issued with one secret can only be verified with the same secret.
+func InitializeSigner(secret []byte) Signer {
+	return Signer{secret}
+}
+
+// RandomSecret generates a secret suitable for signing tokens.
+//
+// Tokens signed with the random secret become invalid once the server restarts.
+func RandomSecret() []byte {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+	return secret
+}
+
+// Issue creates a new token for the player.
+//
+// Token contains the player id and a random nonce, followed by the signature of both.
+func (signer Signer) Issue(playerId int) string {
+	return signer.issue("", playerId)
+}
+
+// Verify checks the signature of the token and returns the player id it was issued for
+func (signer Signer) Verify(token string) (int, error) {
+	separator := strings.LastIndex(token, ".")
+	if separator < 0 {
+		return 0, errors.New("Malformed session token")
+	}
+	payload, signature := token[:separator], token[separator+1:]
+
+	if !hmac.Equal([]byte(signature), []byte(signer.sign(payload))) {
+		return 0, errors.New("Invalid session token signature")
+	}
+
+	playerId, err := strconv.Atoi(strings.Split(payload, ".")[0])
+	if err != nil {
+		return 0, fmt.Errorf("Malformed player id in session token: %w", err)
+	}
+	return playerId, nil
+}
+
+// PlayerId returns the id of the player the request was made by.
+//
+// Token is read from the bearer Authorization header, or the session cookie if there's no header.
+func (signer Signer) PlayerId(request *http.Request) (int, error) {
+	if header := request.Header.Get("Authorization"); header != "" {
+		token, found := strings.CutPrefix(header, "Bearer ")
+		if !found {
+			return 0, errors.New("Expected bearer token in Authorization header")
+		}
+		return signer.Verify(token)
+	}
+
+	if cookie, err := request.Cookie(CookieName); err == nil {
+		return signer.Verify(cookie.Value)
+	}
+
+	return 0, ErrNoToken
+}
+
+// IssueUser creates a new token for the signed in user
+func (signer Signer) IssueUser(userId int) string {
+	return signer.issue(userPrefix, userId)
+}
+
+// VerifyUser checks the signature of the user token and returns the user id it was issued for
+func (signer Signer) VerifyUser(token string) (int, error) {
+	if !strings.HasPrefix(token, userPrefix) {
+		return 0, errors.New("Malformed user token")
+	}
+	separator := strings.LastIndex(token, ".")
+	if separator < 0 || !hmac.Equal([]byte(token[separator+1:]), []byte(signer.sign(token[:separator]))) {
+		return 0, errors.New("Invalid user token signature")
+	}
+
+	userId, err := strconv.Atoi(strings.Split(token, ".")[1])
+	if err != nil {
+		return 0, fmt.Errorf("Malformed user id in user token: %w", err)
+	}
+	return userId, nil
+}
+
+// UserId returns the id of the user signed in with the request.
+//
+// Token is read from the X-User-Token header, or the user cookie if there's no header.
+func (signer Signer) UserId(request *http.Request) (int, error) {
+	if token := request.Header.Get(UserHeader); token != "" {
+		return signer.VerifyUser(token)
+	}
+	if cookie, err := request.Cookie(UserCookieName); err == nil {
+		return signer.VerifyUser(cookie.Value)
+	}
+	return 0, ErrNoToken
+}
+
+// SetCookie stores the token in the session cookie
+func SetCookie(writer http.ResponseWriter, token string) {
+	setCookie(writer, CookieName, token)
+}
+
+// SetUserCookie stores the user token in the user cookie
+func SetUserCookie(writer http.ResponseWriter, token string) {
+	setCookie(writer, UserCookieName, token)
+}
+
+func setCookie(writer http.ResponseWriter, name string, token string) {
+	http.SetCookie(writer, &http.Cookie{
+		Name:     name,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// issue signs the payload with the id and a random nonce, after the prefix
+func (signer Signer) issue(prefix string, id int) string {
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+
+	payload := prefix + strconv.Itoa(id) + "." + base64.RawURLEncoding.EncodeToString(nonce)
+	return payload + "." + signer.sign(payload)
+}
+
+func (signer Signer) sign(payload string) string {
+	mac := hmac.New(sha256.New, signer.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_IssueAndVerify(t *testing.T) {
+	signer := InitializeSigner([]byte("secret"))
+
+	playerId, err := signer.Verify(signer.Issue(42))
+
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if playerId != 42 {
+		t.Errorf("Expected player 42, but was %d", playerId)
+	}
+}
+
+func Test_TokensAreUnique(t *testing.T) {
+	signer := InitializeSigner([]byte("secret"))
+
+	if signer.Issue(42) == signer.Issue(42) {
+		t.Error("Expected two tokens for the same player to differ")
+	}
+}
+
+func Test_VerifyForgedToken(t *testing.T) {
+	signer := InitializeSigner([]byte("secret"))
+	forger := InitializeSigner([]byte("guess"))
+
+	if _, err := signer.Verify(forger.Issue(42)); err == nil {
+		t.Error("Expected error on token signed with another secret")
+	}
+
+	token := signer.Issue(42)
+	if _, err := signer.Verify("43" + token[2:]); err == nil {
+		t.Error("Expected error on token with changed player id")
+	}
+}
+
+func Test_PlayerIdFromRequest(t *testing.T) {
+	signer := InitializeSigner([]byte("secret"))
+
+	request := httptest.NewRequest("GET", "/", nil)
+	if _, err := signer.PlayerId(request); err != ErrNoToken {
+		t.Errorf("Expected missing token error, but was %v", err)
+	}
+
+	request.Header.Set("Authorization", "Bearer "+signer.Issue(42))
+	if playerId, err := signer.PlayerId(request); err != nil || playerId != 42 {
+		t.Errorf("Expected player 42 from header, but was %d, error: %v", playerId, err)
+	}
+
+	recorder := httptest.NewRecorder()
+	SetCookie(recorder, signer.Issue(7))
+	request = httptest.NewRequest("GET", "/", nil)
+	request.AddCookie(recorder.Result().Cookies()[0])
+	if playerId, err := signer.PlayerId(request); err != nil || playerId != 7 {
+		t.Errorf("Expected player 7 from cookie, but was %d, error: %v", playerId, err)
+	}
+}
+
+func Test_UserTokens(t *testing.T) {
+	signer := InitializeSigner([]byte("secret"))
+
+	userId, err := signer.VerifyUser(signer.IssueUser(42))
+	if err != nil || userId != 42 {
+		t.Errorf("Expected user 42, but was %d with error %v", userId, err)
+	}
+	if _, err := signer.VerifyUser(signer.Issue(42)); err == nil {
+		t.Error("Expected error on player token used as user token")
+	}
+	if _, err := signer.Verify(signer.IssueUser(42)); err == nil {
+		t.Error("Expected error on user token used as player token")
+	}
+	userToken := signer.IssueUser(42)
+	if _, err := signer.Verify(userToken[len(userPrefix):]); err == nil {
+		t.Error("Expected error on user token without the prefix used as player token")
+	}
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set(UserHeader, userToken)
+	if userId, _ := signer.UserId(request); userId != 42 {
+		t.Errorf("Expected user 42 from the header, but was %d", userId)
+	}
+}
@@ -0,0 +1,274 @@
+// Package bot lets players hosted as external HTTP services take part in games.
+//
+// A bot registers a callback URL. Whenever it's the bot's turn, the server POSTs
+// the bot's view of the game to that URL and expects a shot back. If the bot
+// doesn't answer with a valid shot in time, even after retries, it forfeits the game.
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/danilopavk/battleshipper/engine"
+	"github.com/danilopavk/battleshipper/store"
+)
+
+// errNotBotsTurn stops the bot's forfeit once the game moved on while the bot was thinking
+var errNotBotsTurn = errors.New("It's not a bot's turn")
+
+// Bot is a player whose moves are decided by an external service.
+type Bot struct {
+	PlayerId    int
+	Name        string
+	CallbackURL string
+}
+
+// Registry keeps track of all the players that are bots.
+//
+// If save and remove are set, every registered bot is saved with save
+// before it's visible, and forgotten with remove, so the bots keep playing
+// their games after a restart.
+type Registry struct {
+	mutex  sync.RWMutex
+	bots   map[int]Bot
+	save   func(Bot) error
+	remove func(playerId int) error
+}
+
+// InitializeRegistry builds the empty registry
+func InitializeRegistry() Registry {
+	return Registry{bots: map[int]Bot{}}
+}
+
+// InitializePersistentRegistry builds the registry with the bots saved before, and saves the registrations with the save and remove functions
+func InitializePersistentRegistry(saved []Bot, save func(Bot) error, remove func(playerId int) error) Registry {
+	bots := map[int]Bot{}
+	for _, bot := range saved {
+		bots[bot.PlayerId] = bot
+	}
+	return Registry{bots: bots, save: save, remove: remove}
+}
+
+// Register marks the player from the bot object as a bot
+func (registry *Registry) Register(bot Bot) error {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if registry.save != nil {
+		if err := registry.save(bot); err != nil {
+			return fmt.Errorf("Cannot save bot %d: %w", bot.PlayerId, err)
+		}
+	}
+	registry.bots[bot.PlayerId] = bot
+	return nil
+}
+
+// Remove unmarks the player as a bot
+func (registry *Registry) Remove(playerId int) error {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if registry.remove != nil {
+		if err := registry.remove(playerId); err != nil {
+			return fmt.Errorf("Cannot remove bot %d: %w", playerId, err)
+		}
+	}
+	delete(registry.bots, playerId)
+	return nil
+}
+
+// All returns all the registered bots
+func (registry *Registry) All() []Bot {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	return slices.Collect(maps.Values(registry.bots))
+}
+
+// Get returns the bot for the player id, and false if the player isn't a bot
+func (registry *Registry) Get(playerId int) (Bot, bool) {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	bot, ok := registry.bots[playerId]
+	return bot, ok
+}
+
+// Client talks to the bots over HTTP.
+//
+// Timeout is the deadline for a single attempt, and Retries is the number of
+// additional attempts made after the first one fails.
+type Client struct {
+	HTTPClient *http.Client
+	Timeout    time.Duration
+	Retries    int
+	Backoff    time.Duration
+}
+
+// InitializeClient builds the client with the default deadlines, which only calls the public hosts
+func InitializeClient() Client {
+	return Client{
+		HTTPClient: &http.Client{Transport: publicTransport()},
+		Timeout:    5 * time.Second,
+		Retries:    2,
+		Backoff:    500 * time.Millisecond,
+	}
+}
+
+// RequestShot sends the view of the game to the bot and returns the cell it wants to shoot at.
+//
+// Returns error if none of the attempts returned a valid cell on time.
+func (client Client) RequestShot(ctx context.Context, bot Bot, view engine.View) (engine.Cell, error) {
+	body, err := json.Marshal(view)
+	if err != nil {
+		return engine.Cell{}, fmt.Errorf("Cannot encode view for bot %d: %w", bot.PlayerId, err)
+	}
+
+	var errs []error
+	for attempt := 0; attempt <= client.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return engine.Cell{}, errors.Join(append(errs, ctx.Err())...)
+			case <-time.After(client.Backoff):
+			}
+		}
+
+		cell, err := client.attempt(ctx, bot, body)
+		if err == nil {
+			return cell, nil
+		}
+		errs = append(errs, fmt.Errorf("Attempt %d failed: %w", attempt+1, err))
+	}
+
+	return engine.Cell{}, fmt.Errorf("Bot %d did not return a shot: %w", bot.PlayerId, errors.Join(errs...))
+}
+
+func (client Client) attempt(ctx context.Context, bot Bot, body []byte) (engine.Cell, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.Timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, bot.CallbackURL, bytes.NewReader(body))
+	if err != nil {
+		return engine.Cell{}, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := client.HTTPClient.Do(request)
+	if err != nil {
+		return engine.Cell{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return engine.Cell{}, fmt.Errorf("Unexpected status %d", response.StatusCode)
+	}
+
+	var cell engine.Cell
+	if err := json.NewDecoder(response.Body).Decode(&cell); err != nil {
+		return engine.Cell{}, fmt.Errorf("Cannot decode shot: %w", err)
+	}
+	if !cell.OnBoard() {
+		return engine.Cell{}, fmt.Errorf("Shot outside of the board: %d - %d", cell.X, cell.Y)
+	}
+	return cell, nil
+}
+
+// Driver plays the bots' turns in the games from the store.
+//
+// Every bot's move is published to the hub, so human players can follow it.
+type Driver struct {
+	Store    store.GameRepository
+	Hub      *store.Hub
+	Registry *Registry
+	Client   Client
+}
+
+// Play keeps playing the game of the given player for as long as it's one of the bots' turn.
+//
+// It should be called after every change of the game that might hand the turn to a bot.
+// Games in which not all ships are placed yet are skipped. The bot is asked
+// for its shot outside of any update of the store, and the shot is then
+// fired like any player's. If the bot can't come up with a legal shot, it
+// forfeits, unless the game changed while the bot was thinking, like when
+// the opponent resigns, in which case the fresh game is played instead.
+func (driver Driver) Play(ctx context.Context, playerId int) error {
+	for {
+		_, game, err := driver.Store.GetPlayerAndGame(playerId)
+		if err != nil {
+			return err
+		}
+		bot, ok := driver.botToMove(game)
+		if !ok {
+			return nil
+		}
+		view, err := game.ViewFor(bot.PlayerId)
+		if err != nil {
+			return err
+		}
+		shots := len(game.History)
+
+		cell, err := driver.Client.RequestShot(ctx, bot, view)
+		if err == nil {
+			shot, shotAt, err := driver.Store.Shoot(bot.PlayerId, cell)
+			if err == nil {
+				driver.Hub.PublishShot(shotAt, shot)
+				continue
+			}
+			if !errors.Is(err, store.ErrIllegalMove) {
+				return err
+			}
+		}
+
+		_, game, err = store.UpdateWithRetry(driver.Store, bot.PlayerId, func(_ *engine.Player, game *engine.Game) error {
+			if game.Winner != nil || len(game.History) != shots || *game.Turn != bot.PlayerId {
+				return errNotBotsTurn
+			}
+			return game.Forfeit(bot.PlayerId)
+		})
+		if errors.Is(err, errNotBotsTurn) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		driver.Hub.PublishOver(game)
+	}
+}
+
+// Resume picks up the bots' games after a restart, and forgets the bots whose players are gone.
+//
+// Players of the archived or removed games can't be found in the store
+// anymore, so their registrations are removed. Every other bot's game is
+// played in its own goroutine, in case it waits for the bot's shot.
+func (driver Driver) Resume(ctx context.Context) error {
+	var errs []error
+	for _, bot := range driver.Registry.All() {
+		if _, _, err := driver.Store.GetPlayerAndGame(bot.PlayerId); err != nil {
+			errs = append(errs, driver.Registry.Remove(bot.PlayerId))
+			continue
+		}
+		go func() {
+			if err := driver.Play(ctx, bot.PlayerId); err != nil {
+				fmt.Printf("Cannot play turn of bot %d, error: %v\n", bot.PlayerId, err)
+			}
+		}()
+	}
+	return errors.Join(errs...)
+}
+
+// botToMove returns the bot whose turn it is in the game, and false if the game doesn't wait for any bot's shot
+func (driver Driver) botToMove(game engine.Game) (Bot, bool) {
+	if game.Id == 0 || game.Winner != nil || len(*game.PlayerA.Ships) != engine.FleetSize || len(*game.PlayerB.Ships) != engine.FleetSize {
+		return Bot{}, false
+	}
+	return driver.Registry.Get(*game.Turn)
+}
@@ -0,0 +1,258 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danilopavk/battleshipper/engine"
+	"github.com/danilopavk/battleshipper/store"
+)
+
+func Test_RequestShot(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var view engine.View
+		if err := json.NewDecoder(request.Body).Decode(&view); err != nil {
+			t.Errorf("Cannot decode view: %v", err)
+		}
+		_ = json.NewEncoder(writer).Encode(engine.Cell{X: 3, Y: 4})
+	}))
+	defer server.Close()
+
+	cell, err := testClient().RequestShot(context.Background(), Bot{PlayerId: 1, CallbackURL: server.URL}, engine.View{})
+
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if cell != (engine.Cell{X: 3, Y: 4}) {
+		t.Errorf("Unexpected cell %v", cell)
+	}
+}
+
+func Test_RequestShotRetries(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if calls.Add(1) < 3 {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(writer).Encode(engine.Cell{X: 1, Y: 1})
+	}))
+	defer server.Close()
+
+	cell, err := testClient().RequestShot(context.Background(), Bot{PlayerId: 1, CallbackURL: server.URL}, engine.View{})
+
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if cell != (engine.Cell{X: 1, Y: 1}) {
+		t.Errorf("Unexpected cell %v", cell)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("Expected 3 calls, but there were %d", calls.Load())
+	}
+}
+
+func Test_RequestShotOutsideOfBoard(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_ = json.NewEncoder(writer).Encode(engine.Cell{X: 10, Y: 1})
+	}))
+	defer server.Close()
+
+	_, err := testClient().RequestShot(context.Background(), Bot{PlayerId: 1, CallbackURL: server.URL}, engine.View{})
+
+	if err == nil {
+		t.Error("Expected error on shot outside of the board, but there was none")
+	}
+}
+
+func Test_ClientRefusesPrivateHosts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_ = json.NewEncoder(writer).Encode(engine.Cell{X: 3, Y: 4})
+	}))
+	defer server.Close()
+	client := InitializeClient()
+	client.Retries = 0
+
+	_, err := client.RequestShot(context.Background(), Bot{PlayerId: 1, CallbackURL: server.URL}, engine.View{})
+
+	if !errors.Is(err, ErrPrivateHost) {
+		t.Errorf("Expected the loopback bot to be refused, but got %v", err)
+	}
+}
+
+func Test_DriverPlaysBotTurn(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_ = json.NewEncoder(writer).Encode(engine.Cell{X: 9, Y: 9})
+	}))
+	defer server.Close()
+
+	gameStore := store.InitializeStore()
+	botPlayer, _ := gameStore.StartGame("Icarium", 0)
+	_ = botPlayer.PlaceRandomly()
+	_ = gameStore.UpdatePlayer(botPlayer)
+	game, _ := gameStore.JoinGame("Mappo", 0, botPlayer.Id)
+	_ = game.PlayerB.PlaceRandomly()
+	_ = gameStore.UpdateGame(game)
+
+	registry := InitializeRegistry()
+	registry.Register(Bot{PlayerId: botPlayer.Id, Name: botPlayer.Name, CallbackURL: server.URL})
+	driver := Driver{Store: &gameStore, Registry: &registry, Client: testClient()}
+
+	if err := driver.Play(context.Background(), game.PlayerB.Id); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	_, updatedGame, _ := gameStore.GetPlayerAndGame(botPlayer.Id)
+	if *updatedGame.Turn != game.PlayerB.Id {
+		t.Error("Expected bot to play its turn, but it's still bot's turn")
+	}
+	if updatedGame.Winner != nil {
+		t.Error("Expected game to continue, but there is a winner")
+	}
+}
+
+func Test_DriverForfeitsFailingBot(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		calls.Add(1)
+		writer.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	gameStore := store.InitializeStore()
+	botPlayer, _ := gameStore.StartGame("Icarium", 0)
+	_ = botPlayer.PlaceRandomly()
+	_ = gameStore.UpdatePlayer(botPlayer)
+	game, _ := gameStore.JoinGame("Mappo", 0, botPlayer.Id)
+	_ = game.PlayerB.PlaceRandomly()
+	_ = gameStore.UpdateGame(game)
+
+	registry := InitializeRegistry()
+	registry.Register(Bot{PlayerId: botPlayer.Id, Name: botPlayer.Name, CallbackURL: server.URL})
+	client := testClient()
+	driver := Driver{Store: &gameStore, Registry: &registry, Client: client}
+
+	if err := driver.Play(context.Background(), game.PlayerB.Id); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	_, updatedGame, _ := gameStore.GetPlayerAndGame(botPlayer.Id)
+	if updatedGame.Winner == nil || *updatedGame.Winner != game.PlayerB.Id {
+		t.Error("Expected the bot to forfeit, but it did not")
+	}
+	if int(calls.Load()) != client.Retries+1 {
+		t.Errorf("Expected the bot to be called %d times, but it was called %d times", client.Retries+1, calls.Load())
+	}
+}
+
+func Test_DriverForfeitsTimedOutBot(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		select {
+		case <-request.Context().Done():
+		case <-time.After(200 * time.Millisecond):
+		}
+	}))
+	defer server.Close()
+
+	gameStore := store.InitializeStore()
+	botPlayer, _ := gameStore.StartGame("Icarium", 0)
+	_ = botPlayer.PlaceRandomly()
+	_ = gameStore.UpdatePlayer(botPlayer)
+	game, _ := gameStore.JoinGame("Mappo", 0, botPlayer.Id)
+	_ = game.PlayerB.PlaceRandomly()
+	_ = gameStore.UpdateGame(game)
+
+	registry := InitializeRegistry()
+	registry.Register(Bot{PlayerId: botPlayer.Id, Name: botPlayer.Name, CallbackURL: server.URL})
+	driver := Driver{Store: &gameStore, Registry: &registry, Client: testClient()}
+
+	if err := driver.Play(context.Background(), game.PlayerB.Id); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	_, updatedGame, _ := gameStore.GetPlayerAndGame(botPlayer.Id)
+	if updatedGame.Winner == nil || *updatedGame.Winner != game.PlayerB.Id {
+		t.Error("Expected the bot that didn't answer in time to forfeit, but it did not")
+	}
+}
+
+func Test_PersistentRegistry(t *testing.T) {
+	saved := map[int]Bot{}
+	save := func(bot Bot) error {
+		if bot.Name == "Kruppe" {
+			return errors.New("Disk is full")
+		}
+		saved[bot.PlayerId] = bot
+		return nil
+	}
+	remove := func(playerId int) error {
+		delete(saved, playerId)
+		return nil
+	}
+	registry := InitializePersistentRegistry([]Bot{{PlayerId: 1, Name: "Icarium"}}, save, remove)
+
+	if err := registry.Register(Bot{PlayerId: 2, Name: "Kruppe"}); err == nil {
+		t.Error("Expected error for the bot that can't be saved")
+	}
+	if _, ok := registry.Get(2); ok {
+		t.Error("Expected the unsaved bot not to be registered")
+	}
+	_ = registry.Register(Bot{PlayerId: 3, Name: "Baruk"})
+	_ = registry.Remove(1)
+
+	if _, ok := saved[3]; !ok || len(saved) != 1 {
+		t.Errorf("Expected only Baruk to be saved, but got %v", saved)
+	}
+	if _, ok := registry.Get(1); ok {
+		t.Error("Expected the removed bot to be forgotten")
+	}
+}
+
+func Test_DriverResume(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_ = json.NewEncoder(writer).Encode(engine.Cell{X: 9, Y: 9})
+	}))
+	defer server.Close()
+
+	gameStore := store.InitializeStore()
+	botPlayer, _ := gameStore.StartGame("Icarium", 0)
+	_ = botPlayer.PlaceRandomly()
+	_ = gameStore.UpdatePlayer(botPlayer)
+	game, _ := gameStore.JoinGame("Mappo", 0, botPlayer.Id)
+	_ = game.PlayerB.PlaceRandomly()
+	_ = gameStore.UpdateGame(game)
+
+	registry := InitializeRegistry()
+	registry.Register(Bot{PlayerId: botPlayer.Id, Name: botPlayer.Name, CallbackURL: server.URL})
+	registry.Register(Bot{PlayerId: 42, Name: "Kruppe", CallbackURL: server.URL})
+	driver := Driver{Store: &gameStore, Registry: &registry, Client: testClient()}
+
+	if err := driver.Resume(context.Background()); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if _, ok := registry.Get(42); ok {
+		t.Error("Expected the bot without the player to be forgotten")
+	}
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if _, updatedGame, _ := gameStore.GetPlayerAndGame(botPlayer.Id); *updatedGame.Turn == game.PlayerB.Id {
+			return
+		}
+	}
+	t.Error("Expected the resumed bot to play its turn")
+}
+
+func testClient() Client {
+	return Client{
+		HTTPClient: http.DefaultClient,
+		Timeout:    50 * time.Millisecond,
+		Retries:    2,
+		Backoff:    time.Millisecond,
+	}
+}
@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateHost is returned for the callback urls of the hosts inside the server's network
+var ErrPrivateHost = errors.New("Callback host is not public")
+
+// CheckCallback checks that the callback url is a http or https url of a public host.
+//
+// Hosts resolving to loopback, private or link-local addresses are refused,
+// so bots can't make the server call the services next to it.
+func CheckCallback(ctx context.Context, callbackURL string) error {
+	parsed, err := url.Parse(callbackURL)
+	if err != nil {
+		return fmt.Errorf("Cannot parse callback url: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("Expected http or https callback url, but got %q", parsed.Scheme)
+	}
+	if parsed.Hostname() == "" {
+		return errors.New("Expected callback url with the host")
+	}
+
+	addresses, err := net.DefaultResolver.LookupNetIP(ctx, "ip", parsed.Hostname())
+	if err != nil {
+		return fmt.Errorf("Cannot resolve callback host %v: %w", parsed.Hostname(), err)
+	}
+	for _, address := range addresses {
+		if !public(address) {
+			return fmt.Errorf("Cannot call %v at %v: %w", parsed.Hostname(), address, ErrPrivateHost)
+		}
+	}
+	return nil
+}
+
+// public checks whether the address is outside of the server's network
+func public(address netip.Addr) bool {
+	address = address.Unmap()
+	return address.IsGlobalUnicast() && !address.IsPrivate() && !address.IsLoopback() && !address.IsLinkLocalUnicast()
+}
+
+// publicTransport is the transport that only connects to the public addresses.
+//
+// Addresses are checked once resolved, right before connecting, so hosts that
+// change their address after the bot registered, or redirects, don't reach
+// inside the server's network either.
+func publicTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !public(addrPort.Addr()) {
+				return fmt.Errorf("Cannot connect to %v: %w", address, ErrPrivateHost)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
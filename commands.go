@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danilopavk/battleshipper/store"
+)
+
+// commands are the admin commands, run instead of the server when named as the first argument.
+//
+// They open the same store the server does, so they must be run while the
+// server is stopped; the running server is dumped and loaded through the
+// admin endpoints instead.
+var commands = map[string]func(args []string) error{
+	"export": export,
+	"import": load,
+}
+
+// runCommand runs the admin command named by the first argument
+func runCommand(args []string) error {
+	command, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown command %q, expected export or import", args[0])
+	}
+	return command(args[1:])
+}
+
+// export writes the whole store to the file, or to the standard output
+func export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "", "format of the dump, json or ndjson, by default from the file extension, or json")
+	output := flags.String("o", "", "file to write the dump to, instead of the standard output")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	gameStore, _, err := openStore()
+	if err != nil {
+		return fmt.Errorf("Cannot open the game store, cause: %w", err)
+	}
+	defer closeStore(gameStore)
+	dump, err := gameStore.Dump()
+	if err != nil {
+		return err
+	}
+
+	var writer io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("Cannot create %v: %w", *output, err)
+		}
+		defer file.Close()
+		writer = file
+	}
+	if err := store.WriteDump(writer, dump, dumpFormat(*format, *output)); err != nil {
+		return err
+	}
+	waiting, games, archived := dump.Counts()
+	fmt.Fprintf(os.Stderr, "Exported %d waiting players, %d games and %d archived games\n", waiting, games, archived)
+	return nil
+}
+
+// load reads the dump from the file, or from the standard input, and adds it to the store
+func load(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "", "format of the dump, json or ndjson, by default from the file extension, or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return errors.New("Expected at most one file to import")
+	}
+	input := flags.Arg(0)
+
+	var reader io.Reader = os.Stdin
+	if input != "" {
+		file, err := os.Open(input)
+		if err != nil {
+			return fmt.Errorf("Cannot open %v: %w", input, err)
+		}
+		defer file.Close()
+		reader = file
+	}
+	dump, err := store.ReadDump(reader, dumpFormat(*format, input))
+	if err != nil {
+		return err
+	}
+
+	gameStore, _, err := openStore()
+	if err != nil {
+		return fmt.Errorf("Cannot open the game store, cause: %w", err)
+	}
+	defer closeStore(gameStore)
+	if err := gameStore.Load(dump); err != nil {
+		return err
+	}
+	waiting, games, archived := dump.Counts()
+	fmt.Fprintf(os.Stderr, "Imported %d waiting players, %d games and %d archived games\n", waiting, games, archived)
+	return nil
+}
+
+// dumpFormat returns the format set with the flag, or the one the file name ends with, JSON by default
+func dumpFormat(format string, path string) string {
+	if format != "" {
+		return format
+	}
+	if strings.EqualFold(filepath.Ext(path), "."+store.DumpNDJSON) {
+		return store.DumpNDJSON
+	}
+	return store.DumpJSON
+}
+
+// closeStore closes the store, if it has anything to close
+func closeStore(gameStore store.GameRepository) {
+	if closer, ok := gameStore.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot close the game store, cause: %v\n", err)
+		}
+	}
+}
@@ -8,21 +8,68 @@ import (
 	"fmt"
 	"maps"
 	"math/rand/v2"
+	"slices"
+	"time"
 )
 
 const width = 10
 const height = 10
 
+// fleet holds the lengths of the ships every player places, in the order of placing
+var fleet = [...]int{5, 4, 4, 3, 3}
+
+// FleetSize is the number of ships every player places, and has to sink to win
+const FleetSize = len(fleet)
+
 // Game is an object holding the whole data related to a single game.
 //
 // Two objects representing two players, Turn int representing an id
-// of the player whose turn it is, and Winner int representing an id
-// of the winning player.
+// of the player whose turn it is, Winner int representing an id
+// of the winning player, Rules that the game is played by, History
+// with all the shots fired so far, and the times when the game started
+// and ended. EndedAt is zero until there's a winner. Version counts how
+// many times the game was saved, so saving the game that changed since it
+// was read can be detected.
 type Game struct {
 	Id               int
 	PlayerA, PlayerB Player
 	Turn             *int
 	Winner           *int
+	Rules            Rules
+	History          []Shot
+	StartedAt        time.Time
+	EndedAt          time.Time
+	Version          int
+}
+
+// Shot is a record of a single shot fired in the game
+type Shot struct {
+	PlayerId int  `json:"playerId"`
+	Cell     Cell `json:"cell"`
+	Hit      bool `json:"hit"`
+	Sank     bool `json:"sank"`
+}
+
+// Rules type holds the settings that can differ from game to game.
+//
+// Hints flag allows players to ask for the recommended next shot,
+// OpenSpectating flag reveals both fleets to the spectators while the game
+// is still in progress, and Ranked flag makes the result count for the
+// ratings of the players. Hints are never allowed in ranked games.
+type Rules struct {
+	Hints          bool
+	OpenSpectating bool
+	Ranked         bool
+}
+
+// DefaultRules returns the rules used for casual games
+func DefaultRules() Rules {
+	return Rules{Hints: true}
+}
+
+// RankedRules returns the rules used for ranked games
+func RankedRules() Rules {
+	return Rules{Ranked: true}
 }
 
 // Player type holds the data on one contestant of the game.
@@ -30,12 +77,16 @@ type Game struct {
 // Ships pointer represents ships that belong to the player. Target pointer
 // represents all the data that this player has on the opposing player's
 // board. They are mutable represntations of the player's and opposing
-// player's boards
+// player's boards. UserId is the id of the account the player plays for,
+// or zero for guests. Version counts how many times the waiting player was
+// saved, and once the game starts, it's the version of the game.
 type Player struct {
-	Id     int
-	Name   string
-	Ships  *[]Ship
-	Target *Target
+	Id      int
+	Name    string
+	Ships   *[]Ship
+	Target  *Target
+	UserId  int
+	Version int
 }
 
 // Target type holds the data that one player has on the opposing player's board.
@@ -56,14 +107,29 @@ type Ship struct {
 
 // Cell is one item in a grid
 type Cell struct {
-	X, Y int
+	X int `json:"x"`
+	Y int `json:"y"`
 }
 
 // InitializeGame sets up the game between 2 players.
 //
 // To create the player, call InitializePlayer method
 func InitializeGame(playerA, playerB Player, turn int) Game {
-	return Game{rand.Int(), playerA, playerB, &turn, nil}
+	return Game{rand.Int(), playerA, playerB, &turn, nil, DefaultRules(), []Shot{}, time.Now(), time.Time{}, 0}
+}
+
+// Clone returns the deep copy of the game, which shares nothing with the original.
+//
+// Players keep their boards behind pointers and maps, so a plain copy of the
+// game changes along with the original, for example when either is shot at.
+func (game Game) Clone() Game {
+	clone := game
+	clone.PlayerA = game.PlayerA.Clone()
+	clone.PlayerB = game.PlayerB.Clone()
+	clone.Turn = cloneId(game.Turn)
+	clone.Winner = cloneId(game.Winner)
+	clone.History = slices.Clone(game.History)
+	return clone
 }
 
 // NextShipLength method retrieves a desired lenght of the next ship to be added.
@@ -74,9 +140,9 @@ func InitializeGame(playerA, playerB Player, turn int) Game {
 func (game Game) NextShipLength(playerId int) (int, error) {
 	switch playerId {
 	case game.PlayerA.Id:
-		return game.PlayerA.nextShipLength()
+		return game.PlayerA.NextShipLength()
 	case game.PlayerB.Id:
-		return game.PlayerB.nextShipLength()
+		return game.PlayerB.NextShipLength()
 	default:
 		return -1, fmt.Errorf("Player with id %d not found", playerId)
 	}
@@ -90,15 +156,21 @@ func (game Game) NextShipLength(playerId int) (int, error) {
 // Error thrown if the shot is illegal. The shot is illegal if 1) The shooting phase of the game didn't
 // start yet 2) It's not player's turn 3) It's not even player's game
 func (game *Game) Shoot(playerId int, cell Cell) (hit bool, sank bool, won bool, err error) {
-	if len(*game.PlayerA.Ships) != 5 {
+	if len(*game.PlayerA.Ships) != FleetSize {
 		return false, false, false, fmt.Errorf("Shot attempted, but player %d does not have his board full yet", game.PlayerA.Id)
 	}
-	if len(*game.PlayerB.Ships) != 5 {
+	if len(*game.PlayerB.Ships) != FleetSize {
 		return false, false, false, fmt.Errorf("Shot attempted, but player %d does not have his board full yet", game.PlayerB.Id)
 	}
+	if game.Winner != nil {
+		return false, false, false, fmt.Errorf("Game %d is already over", game.Id)
+	}
 	if *game.Turn != playerId {
 		return false, false, false, fmt.Errorf("Player %d tried to shoot, but it's not their turn", playerId)
 	}
+	if !cell.OnBoard() {
+		return false, false, false, fmt.Errorf("Player %d tried to shoot outside of the board: %d - %d", playerId, cell.X, cell.Y)
+	}
 
 	var me Player
 	var opponent Player
@@ -113,14 +185,17 @@ func (game *Game) Shoot(playerId int, cell Cell) (hit bool, sank bool, won bool,
 	case game.PlayerB.Id:
 		{
 			me = game.PlayerB
-			opponent = game.PlayerB
+			opponent = game.PlayerA
 			*game.Turn = opponent.Id
-
 		}
 	default:
 		return false, false, false, fmt.Errorf("Player %d not in game %d", playerId, game.Id)
 	}
 
+	defer func() {
+		game.History = append(game.History, Shot{playerId, cell, hit, sank})
+	}()
+
 	hit = opponent.shoot(cell)
 	if !hit {
 		me.Target.Misses[cell] = true
@@ -134,17 +209,84 @@ func (game *Game) Shoot(playerId int, cell Cell) (hit bool, sank bool, won bool,
 	}
 	me.markAsSank(ship)
 
-	if len(*me.Target.SankShips) != 5 {
+	if len(*me.Target.SankShips) != FleetSize {
 		return hit, true, false, nil
 	}
 	game.Winner = &me.Id
+	game.EndedAt = time.Now()
 	return hit, true, true, nil
 }
 
+// Forfeit ends the game in favor of the opponent of the given player.
+//
+// It's used when a player gives up, or can't continue playing, for example
+// when a bot doesn't respond in time.
+func (game *Game) Forfeit(playerId int) error {
+	if game.Winner != nil {
+		return fmt.Errorf("Game %d is already over", game.Id)
+	}
+
+	switch playerId {
+	case game.PlayerA.Id:
+		game.Winner = &game.PlayerB.Id
+	case game.PlayerB.Id:
+		game.Winner = &game.PlayerA.Id
+	default:
+		return fmt.Errorf("Player %d not in game %d", playerId, game.Id)
+	}
+	game.EndedAt = time.Now()
+	return nil
+}
+
+// OpenSpectating lets the spectators see both fleets before the game is over.
+//
+// Only the host, the player who started the game, can change it.
+func (game *Game) OpenSpectating(playerId int, open bool) error {
+	if playerId != game.PlayerA.Id {
+		return fmt.Errorf("Only the host of game %d can open spectating", game.Id)
+	}
+	game.Rules.OpenSpectating = open
+	return nil
+}
+
 // Initializes the contestant with the given name and return the player object
 func InitializePlayer(name string) Player {
 	target := Target{&[]Ship{}, map[Cell]bool{}, map[Cell]bool{}}
-	return Player{rand.Int(), name, &[]Ship{}, &target}
+	return Player{rand.Int(), name, &[]Ship{}, &target, 0, 0}
+}
+
+// Clone returns the deep copy of the player, which shares no ships or targets with the original
+func (player Player) Clone() Player {
+	clone := player
+	if player.Ships != nil {
+		ships := cloneShips(*player.Ships)
+		clone.Ships = &ships
+	}
+	if player.Target != nil {
+		target := Target{Hits: maps.Clone(player.Target.Hits), Misses: maps.Clone(player.Target.Misses)}
+		if player.Target.SankShips != nil {
+			sankShips := cloneShips(*player.Target.SankShips)
+			target.SankShips = &sankShips
+		}
+		clone.Target = &target
+	}
+	return clone
+}
+
+func cloneShips(ships []Ship) []Ship {
+	clones := make([]Ship, len(ships))
+	for i, ship := range ships {
+		clones[i] = Ship{Cells: maps.Clone(ship.Cells)}
+	}
+	return clones
+}
+
+func cloneId(id *int) *int {
+	if id == nil {
+		return nil
+	}
+	clone := *id
+	return &clone
 }
 
 // AvailableCells method gives a utility method that can be used to draw the board for the player.
@@ -174,13 +316,16 @@ func (player Player) AvailableCells() map[int]map[int]bool {
 // Returns error if the provided ship isn't of the correct length.
 // It's used as a utility method in the first phase of the game
 func (player Player) AddShip(ship Ship) error {
-	nextShipLength, err := player.nextShipLength()
+	nextShipLength, err := player.NextShipLength()
 	if err != nil {
 		return fmt.Errorf("Cannot add ship to player %v because of error: %w", player.Id, err)
 	}
 	if len(ship.Cells) != nextShipLength {
 		return fmt.Errorf("Cannot add ship, expected length %d, was %d", nextShipLength, len(ship.Cells))
 	}
+	if !ship.straight() {
+		return errors.New("Cannot add ship, cells must form a single horizontal or vertical line")
+	}
 
 	for cell := range ship.Cells {
 		if !player.AvailableCells()[cell.X][cell.Y] {
@@ -193,6 +338,70 @@ func (player Player) AddShip(ship Ship) error {
 	return nil
 }
 
+// PlaceRandomly fills the rest of the player's board with randomly placed ships.
+//
+// Ships that are already placed are kept. It's used for bots and for players
+// that don't want to place their ships by hand.
+func (player Player) PlaceRandomly() error {
+	for {
+		nextShipLength, err := player.NextShipLength()
+		if err != nil {
+			return nil
+		}
+
+		candidates := player.shipCandidates(nextShipLength)
+		if len(candidates) == 0 {
+			return fmt.Errorf("Cannot place ship of length %d for player %d, no space left", nextShipLength, player.Id)
+		}
+		if err := player.AddShip(candidates[rand.IntN(len(candidates))]); err != nil {
+			return err
+		}
+	}
+}
+
+func (player Player) shipCandidates(length int) []Ship {
+	available := player.AvailableCells()
+	var candidates []Ship
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			horizontal := map[Cell]bool{}
+			vertical := map[Cell]bool{}
+			for i := 0; i < length; i++ {
+				if x+i < width && available[x+i][y] {
+					horizontal[Cell{x + i, y}] = true
+				}
+				if y+i < height && available[x][y+i] {
+					vertical[Cell{x, y + i}] = true
+				}
+			}
+			if len(horizontal) == length {
+				candidates = append(candidates, Ship{horizontal})
+			}
+			if length > 1 && len(vertical) == length {
+				candidates = append(candidates, Ship{vertical})
+			}
+		}
+	}
+	return candidates
+}
+
+func (ship Ship) straight() bool {
+	cells := sortedCells(ship.Cells)
+	for i := 1; i < len(cells); i++ {
+		horizontal := cells[i].X == cells[i-1].X+1 && cells[i].Y == cells[0].Y
+		vertical := cells[i].Y == cells[i-1].Y+1 && cells[i].X == cells[0].X
+		if !horizontal && !vertical {
+			return false
+		}
+	}
+	return true
+}
+
+// OnBoard checks weather the cell is inside the board
+func (cell Cell) OnBoard() bool {
+	return cell.X >= 0 && cell.X < width && cell.Y >= 0 && cell.Y < height
+}
+
 func (player Player) markAsSank(ship Ship) {
 	*player.Target.SankShips = append(*player.Target.SankShips, ship)
 	notHits := func(cell Cell) bool {
@@ -220,17 +429,14 @@ func (player Player) shoot(shootAtCell Cell) bool {
 	return false
 }
 
-func (player Player) nextShipLength() (int, error) {
-	switch len(*player.Ships) {
-	case 0:
-		return 5, nil
-	case 1, 2:
-		return 4, nil
-	case 3, 4:
-		return 3, nil
-	default:
+// NextShipLength method retrieves a desired length of the player's next ship.
+//
+// Unlike the game's method, it can be used before the opponent joins the game.
+func (player Player) NextShipLength() (int, error) {
+	if len(*player.Ships) >= FleetSize {
 		return -1, errors.New("Player board is full, cannot create new ship!")
 	}
+	return fleet[len(*player.Ships)], nil
 }
 
 func neighborCells(originCell Cell, includeDiagonal bool, filter func(Cell) bool) []Cell {
@@ -285,3 +285,175 @@ func initialize() (Player, Player, Game) {
 	game := InitializeGame(playerA, playerB, playerA.Id)
 	return playerA, playerB, game
 }
+
+func Test_ShootAsPlayerB(t *testing.T) {
+	playerA, playerB, game := initializeAndStart()
+	_, _, _, _ = game.Shoot(playerA.Id, Cell{9, 9})
+
+	hit, _, _, err := game.Shoot(playerB.Id, Cell{0, 0})
+
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !hit {
+		t.Error("Expected player b to hit player a's ship, but it didn't")
+	}
+	if *game.Turn != playerA.Id {
+		t.Error("Expected turn to go back to player a")
+	}
+}
+
+func Test_ShootOutsideOfBoard(t *testing.T) {
+	player, _, game := initializeAndStart()
+	_, _, _, err := game.Shoot(player.Id, Cell{10, 0})
+
+	if err == nil {
+		t.Error("Shot outside of the board, but there was no error")
+	}
+}
+
+func Test_Forfeit(t *testing.T) {
+	playerA, playerB, game := initializeAndStart()
+
+	if err := game.Forfeit(playerA.Id); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if *game.Winner != playerB.Id {
+		t.Error("Expected player b to win after player a forfeited")
+	}
+
+	_, _, _, err := game.Shoot(playerA.Id, Cell{0, 0})
+	if err == nil {
+		t.Error("Shot in finished game, but there was no error")
+	}
+}
+
+func Test_PlaceRandomly(t *testing.T) {
+	player, _, game := initialize()
+
+	if err := player.PlaceRandomly(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(*player.Ships) != 5 {
+		t.Errorf("Expected 5 ships, but there are %d", len(*player.Ships))
+	}
+	if _, err := game.NextShipLength(player.Id); err == nil {
+		t.Error("Expected the board to be full")
+	}
+}
+
+func Test_Clone(t *testing.T) {
+	playerA, playerB, game := initializeAndStart()
+	_, _, _, _ = game.Shoot(playerA.Id, Cell{0, 0})
+
+	clone := game.Clone()
+	if diff := cmp.Diff(game, clone); diff != "" {
+		t.Errorf("Unexpected clone (-want +got):\n%s", diff)
+	}
+
+	_, _, _, _ = clone.Shoot(playerB.Id, Cell{9, 9})
+	_ = clone.Forfeit(playerB.Id)
+	if *game.Turn != playerB.Id || game.Winner != nil || len(game.History) != 1 {
+		t.Error("Expected the game not to change with its clone")
+	}
+	if len(game.PlayerB.Target.Misses) != 0 {
+		t.Errorf("Expected no misses of player b in the original game, but got %v", game.PlayerB.Target.Misses)
+	}
+	(*clone.PlayerA.Ships)[0].Cells[Cell{9, 0}] = true
+	if (*game.PlayerA.Ships)[0].Cells[Cell{9, 0}] {
+		t.Error("Expected the ships of the original game not to change with the clone")
+	}
+}
+
+func Test_ViewFor(t *testing.T) {
+	playerA, playerB, game := initializeAndStart()
+	_, _, _, _ = game.Shoot(playerA.Id, Cell{0, 0})
+
+	view, err := game.ViewFor(playerA.Id)
+
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if view.OpponentId != playerB.Id {
+		t.Errorf("Unexpected opponent %d", view.OpponentId)
+	}
+	if diff := cmp.Diff([]Cell{{0, 0}}, view.Hits); diff != "" {
+		t.Errorf("Unexpected diff %v", diff)
+	}
+	if len(view.Ships) != 5 {
+		t.Errorf("Expected 5 ships in view, but there are %d", len(view.Ships))
+	}
+
+	if _, err := game.ViewFor(0); err == nil {
+		t.Error("Expected error on view for unknown player")
+	}
+}
+
+func Test_SpectatorView(t *testing.T) {
+	playerA, playerB, game := initializeAndStart()
+	_, _, _, _ = game.Shoot(playerA.Id, Cell{0, 0})
+
+	view := game.SpectatorView()
+
+	if view.PlayerA.Ships != nil || view.PlayerB.Ships != nil {
+		t.Error("Expected the fleets to be hidden while the game is in progress")
+	}
+	if diff := cmp.Diff([]Cell{{0, 0}}, view.PlayerA.Hits); diff != "" {
+		t.Errorf("Unexpected diff %v", diff)
+	}
+
+	if err := game.OpenSpectating(playerB.Id, true); err == nil {
+		t.Error("Expected error when the guest opens spectating")
+	}
+	if err := game.OpenSpectating(playerA.Id, true); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(game.SpectatorView().PlayerB.Ships) != 5 {
+		t.Error("Expected the fleets to be revealed once spectating is open")
+	}
+}
+
+func Test_HintFollowsHit(t *testing.T) {
+	player, _, _ := initializeAndStart()
+	player.Target.Hits[Cell{5, 5}] = true
+	player.Target.Misses[Cell{5, 4}] = true
+	player.Target.Misses[Cell{4, 5}] = true
+	player.Target.Misses[Cell{6, 5}] = true
+
+	cell, heatmap := Hint(*player.Target)
+
+	if cell != (Cell{5, 6}) {
+		t.Errorf("Expected hint to follow the hit to 5 - 6, but it was %v", cell)
+	}
+	if heatmap[5][4] != 0 || heatmap[5][5] != 0 {
+		t.Error("Expected known cells to have zero probability")
+	}
+
+	sum := 0.0
+	for x := range heatmap {
+		for y := range heatmap[x] {
+			sum += heatmap[x][y]
+		}
+	}
+	if sum < 0.999 || sum > 1.001 {
+		t.Errorf("Expected heatmap to add up to 1, but it was %f", sum)
+	}
+}
+
+func Test_HintDisabledByRules(t *testing.T) {
+	player, _, game := initializeAndStart()
+
+	if _, _, err := game.HintFor(player.Id); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	game.Rules.Hints = false
+	if _, _, err := game.HintFor(player.Id); err == nil {
+		t.Error("Expected error on hint when hints are disabled")
+	}
+
+	game.Rules = Rules{Hints: true, Ranked: true}
+	if _, _, err := game.HintFor(player.Id); err == nil {
+		t.Error("Expected error on hint in ranked game")
+	}
+}
@@ -0,0 +1,144 @@
+package engine
+
+import "fmt"
+
+// hitWeight is how much more likely a ship placement becomes for every unsunk hit it covers
+const hitWeight = 100
+
+// Heatmap holds the likelihood of hitting a ship for every cell of the board.
+//
+// It's indexed by X and then by Y, and the values of all the cells add up to 1.
+type Heatmap [width][height]float64
+
+// Hint recommends the next cell to shoot at, based on what the player knows about the opponent's board.
+//
+// For every ship that isn't sunk yet, it counts all the ways the ship could be
+// placed on the cells that aren't known to be empty. If there are unsunk hits,
+// only the placements that cover them are counted, and the ones covering more
+// hits weigh more. The recommended cell is the unknown cell covered by the most
+// placements. If there's nothing left to shoot at, the recommended cell is
+// outside of the board.
+func Hint(target Target) (Cell, Heatmap) {
+	var heatmap Heatmap
+	total := 0.0
+
+	blocked := map[Cell]bool{}
+	for cell, miss := range target.Misses {
+		blocked[cell] = miss
+	}
+	for _, ship := range *target.SankShips {
+		for cell := range ship.Cells {
+			blocked[cell] = true
+		}
+	}
+
+	hunting := true
+	for _, hit := range target.Hits {
+		if hit {
+			hunting = false
+		}
+	}
+
+	for _, length := range remainingShips(target) {
+		for _, placement := range placements(length) {
+			weight := 1.0
+			possible := true
+			for _, cell := range placement {
+				if blocked[cell] {
+					possible = false
+					break
+				}
+				if target.Hits[cell] {
+					weight *= hitWeight
+				}
+			}
+			if !possible || (!hunting && weight == 1) {
+				continue
+			}
+			for _, cell := range placement {
+				if !target.Hits[cell] {
+					heatmap[cell.X][cell.Y] += weight
+					total += weight
+				}
+			}
+		}
+	}
+
+	best := Cell{-1, -1}
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			cell := Cell{x, y}
+			if total > 0 {
+				heatmap[x][y] /= total
+			}
+			if blocked[cell] || target.Hits[cell] {
+				continue
+			}
+			if !best.OnBoard() || heatmap[x][y] > heatmap[best.X][best.Y] {
+				best = cell
+			}
+		}
+	}
+
+	return best, heatmap
+}
+
+// HintFor returns the hint for the player with the given id.
+//
+// Returns error if the rules of the game don't allow hints.
+func (game Game) HintFor(playerId int) (Cell, Heatmap, error) {
+	if !game.Rules.Hints || game.Rules.Ranked {
+		return Cell{}, Heatmap{}, fmt.Errorf("Hints are disabled in game %d", game.Id)
+	}
+
+	switch playerId {
+	case game.PlayerA.Id:
+		cell, heatmap := Hint(*game.PlayerA.Target)
+		return cell, heatmap, nil
+	case game.PlayerB.Id:
+		cell, heatmap := Hint(*game.PlayerB.Target)
+		return cell, heatmap, nil
+	default:
+		return Cell{}, Heatmap{}, fmt.Errorf("Player %d not in game %d", playerId, game.Id)
+	}
+}
+
+func remainingShips(target Target) []int {
+	sank := map[int]int{}
+	for _, ship := range *target.SankShips {
+		sank[len(ship.Cells)]++
+	}
+
+	var remaining []int
+	for _, length := range fleet {
+		if sank[length] > 0 {
+			sank[length]--
+			continue
+		}
+		remaining = append(remaining, length)
+	}
+	return remaining
+}
+
+func placements(length int) [][]Cell {
+	var all [][]Cell
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			if x+length <= width {
+				var horizontal []Cell
+				for i := 0; i < length; i++ {
+					horizontal = append(horizontal, Cell{x + i, y})
+				}
+				all = append(all, horizontal)
+			}
+			if y+length <= height {
+				var vertical []Cell
+				for i := 0; i < length; i++ {
+					vertical = append(vertical, Cell{x, y + i})
+				}
+				all = append(all, vertical)
+			}
+		}
+	}
+	return all
+}
@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+)
+
+// View is a snapshot of the game as seen by one of the players.
+//
+// It contains the player's own fleet and everything the player knows about
+// the opponent's board, but never the positions of the opponent's ships.
+// Unlike Game, it can be serialized to JSON and sent to the clients.
+type View struct {
+	GameId       int      `json:"gameId,omitempty"`
+	PlayerId     int      `json:"playerId"`
+	OpponentId   int      `json:"opponentId,omitempty"`
+	OpponentName string   `json:"opponentName,omitempty"`
+	Ships        [][]Cell `json:"ships"`
+	Hits         []Cell   `json:"hits"`
+	Misses       []Cell   `json:"misses"`
+	SankShips    [][]Cell `json:"sankShips"`
+	Turn         int      `json:"turn,omitempty"`
+	Winner       int      `json:"winner,omitempty"`
+}
+
+// ViewFor builds the view of the game for the player with the given id.
+func (game Game) ViewFor(playerId int) (View, error) {
+	var me Player
+	var opponent Player
+
+	switch playerId {
+	case game.PlayerA.Id:
+		me = game.PlayerA
+		opponent = game.PlayerB
+	case game.PlayerB.Id:
+		me = game.PlayerB
+		opponent = game.PlayerA
+	default:
+		return View{}, fmt.Errorf("Player %d not in game %d", playerId, game.Id)
+	}
+
+	view := me.View()
+	view.GameId = game.Id
+	view.OpponentId = opponent.Id
+	view.OpponentName = opponent.Name
+	view.Turn = *game.Turn
+	if game.Winner != nil {
+		view.Winner = *game.Winner
+	}
+
+	return view, nil
+}
+
+// View builds the view of a player that is not in a game yet.
+//
+// It only contains the player's own fleet, since there is no opponent to shoot at.
+func (player Player) View() View {
+	view := View{
+		PlayerId:  player.Id,
+		Ships:     [][]Cell{},
+		Hits:      sortedCells(player.Target.Hits),
+		Misses:    sortedCells(player.Target.Misses),
+		SankShips: [][]Cell{},
+	}
+	for _, ship := range *player.Ships {
+		view.Ships = append(view.Ships, sortedCells(ship.Cells))
+	}
+	for _, ship := range *player.Target.SankShips {
+		view.SankShips = append(view.SankShips, sortedCells(ship.Cells))
+	}
+	return view
+}
+
+func sortedCells(cells map[Cell]bool) []Cell {
+	sorted := []Cell{}
+	for cell, present := range cells {
+		if present {
+			sorted = append(sorted, cell)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].X != sorted[j].X {
+			return sorted[i].X < sorted[j].X
+		}
+		return sorted[i].Y < sorted[j].Y
+	})
+	return sorted
+}
+
+// SpectatorView is a snapshot of the game as seen by someone who only watches it.
+//
+// It contains the shots both players fired at each other. The fleets are only
+// revealed once the game is over, or when the host opened spectating, so the
+// spectators can't tip off the players.
+type SpectatorView struct {
+	GameId         int             `json:"gameId"`
+	PlayerA        SpectatedPlayer `json:"playerA"`
+	PlayerB        SpectatedPlayer `json:"playerB"`
+	Turn           int             `json:"turn"`
+	Winner         int             `json:"winner,omitempty"`
+	OpenSpectating bool            `json:"openSpectating"`
+}
+
+// SpectatedPlayer is one of the players as seen by the spectators.
+//
+// Hits, Misses and SankShips are the player's shots at the opponent's board,
+// and Ships are only set while the fleets are revealed.
+type SpectatedPlayer struct {
+	Id        int      `json:"id"`
+	Name      string   `json:"name"`
+	Ships     [][]Cell `json:"ships,omitempty"`
+	Hits      []Cell   `json:"hits"`
+	Misses    []Cell   `json:"misses"`
+	SankShips [][]Cell `json:"sankShips"`
+}
+
+// SpectatorView builds the view of the game for the spectators
+func (game Game) SpectatorView() SpectatorView {
+	view := SpectatorView{
+		GameId:         game.Id,
+		PlayerA:        spectated(game.PlayerA, game.FleetsRevealed()),
+		PlayerB:        spectated(game.PlayerB, game.FleetsRevealed()),
+		Turn:           *game.Turn,
+		OpenSpectating: game.Rules.OpenSpectating,
+	}
+	if game.Winner != nil {
+		view.Winner = *game.Winner
+	}
+	return view
+}
+
+// FleetsRevealed checks whether the spectators can see the ships of both players
+func (game Game) FleetsRevealed() bool {
+	return game.Winner != nil || game.Rules.OpenSpectating
+}
+
+func spectated(player Player, revealed bool) SpectatedPlayer {
+	view := player.View()
+	spectated := SpectatedPlayer{
+		Id:        player.Id,
+		Name:      player.Name,
+		Hits:      view.Hits,
+		Misses:    view.Misses,
+		SankShips: view.SankShips,
+	}
+	if revealed {
+		spectated.Ships = view.Ships
+	}
+	return spectated
+}
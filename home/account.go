@@ -0,0 +1,58 @@
+package home
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/danilopavk/battleshipper/auth"
+	"github.com/danilopavk/battleshipper/users"
+)
+
+func (server *Server) signIn(writer http.ResponseWriter, request *http.Request) {
+	user, err := server.Moves.Users.Authenticate(request.FormValue("username"), request.FormValue("password"))
+	if err != nil {
+		server.render(writer, request, Account(users.User{}, err.Error()))
+		return
+	}
+	auth.SetUserCookie(writer, server.Signer.IssueUser(user.Id))
+	server.render(writer, request, Account(user, ""))
+}
+
+func (server *Server) register(writer http.ResponseWriter, request *http.Request) {
+	user, err := server.Moves.Users.Register(request.FormValue("username"), request.FormValue("displayName"), request.FormValue("password"))
+	if err != nil {
+		server.render(writer, request, Account(users.User{}, err.Error()))
+		return
+	}
+	auth.SetUserCookie(writer, server.Signer.IssueUser(user.Id))
+	server.render(writer, request, Account(user, ""))
+}
+
+// signedIn returns the user signed in with the user cookie, or the zero user for guests
+func (server *Server) signedIn(request *http.Request) users.User {
+	user, err := server.Moves.SignedInUser(request)
+	if err != nil {
+		return users.User{}
+	}
+	return user
+}
+
+// startingPlayer decodes the start form, and returns the name of the player and the signed in user
+func (server *Server) startingPlayer(request *http.Request) (string, users.User, bool) {
+	var startPlayer StartPlayer
+	if err := json.NewDecoder(request.Body).Decode(&startPlayer); err != nil {
+		fmt.Printf("Cannot decode player name, error: %v", err)
+		return "", users.User{}, false
+	}
+	user := server.signedIn(request)
+	return playerName(startPlayer.Name, user), user, true
+}
+
+// playerName returns the name the player typed in, or the display name of the signed in user
+func playerName(name string, user users.User) string {
+	if name == "" {
+		return user.DisplayName
+	}
+	return name
+}
@@ -0,0 +1,105 @@
+package home
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/a-h/templ"
+	"github.com/danilopavk/battleshipper/api"
+	"github.com/danilopavk/battleshipper/archive"
+	"github.com/danilopavk/battleshipper/engine"
+)
+
+// replayStep is the finished game as it was after the first shots of its history
+type replayStep struct {
+	Game engine.Game
+	Shot int
+	Last int
+}
+
+func (server *Server) archive(writer http.ResponseWriter, request *http.Request) {
+	query, err := archive.ParseQuery(request.URL.Query())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !request.URL.Query().Has("user") {
+		query.UserId = server.signedIn(request).Id
+	}
+
+	page, err := server.Moves.Archive(query)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	server.render(writer, request, Archive(query, page))
+}
+
+func (server *Server) replay(writer http.ResponseWriter, request *http.Request) {
+	gameId, err := strconv.Atoi(request.PathValue("gameId"))
+	if err != nil {
+		http.Error(writer, "Expected numeric game id", http.StatusBadRequest)
+		return
+	}
+	game, err := server.Moves.Replay(gameId)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	step := replayStep{Game: game, Last: len(game.History)}
+	if value := request.URL.Query().Get("shot"); value != "" {
+		if step.Shot, err = strconv.Atoi(value); err != nil {
+			http.Error(writer, "Expected numeric shot", http.StatusBadRequest)
+			return
+		}
+	}
+	step.Shot = min(max(step.Shot, 0), step.Last)
+	step.Game.History = game.History[:step.Shot]
+	server.render(writer, request, Replay(step))
+}
+
+// archiveLink returns the link to the page of the archive the query asks for
+func archiveLink(query archive.Query, page int) templ.SafeURL {
+	query.Page = page
+	return templ.SafeURL("/archive?" + query.Values().Encode())
+}
+
+// userArchiveLink returns the link to the archive of the user's games
+func userArchiveLink(userId int) templ.SafeURL {
+	return archiveLink(archive.Query{UserId: userId}, 0)
+}
+
+// replayLink returns the link to the replay of the game, after the first shots
+func replayLink(gameId int, shot int) templ.SafeURL {
+	return templ.SafeURL(fmt.Sprintf("%s?shot=%d", api.ReplayPath(gameId), shot))
+}
+
+// rulesName names the rule set the game was played by
+func rulesName(rules engine.Rules) string {
+	if rules.Ranked {
+		return "ranked"
+	}
+	return "casual"
+}
+
+// lastShot describes the last shot of the replayed step, like "Tavore fired at E5 and hit"
+func lastShot(step replayStep) string {
+	if step.Shot == 0 {
+		return "Nobody fired yet."
+	}
+	shot := step.Game.History[step.Shot-1]
+	shooter := step.Game.PlayerB
+	if shot.PlayerId == step.Game.PlayerA.Id {
+		shooter = step.Game.PlayerA
+	}
+	switch {
+	case shot.Sank:
+		return fmt.Sprintf("%s fired at %s and sank a ship.", shooter.Name, cellName(shot.Cell))
+	case shot.Hit:
+		return fmt.Sprintf("%s fired at %s and hit.", shooter.Name, cellName(shot.Cell))
+	default:
+		return fmt.Sprintf("%s fired at %s and missed.", shooter.Name, cellName(shot.Cell))
+	}
+}
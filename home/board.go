@@ -0,0 +1,238 @@
+package home
+
+import (
+	"fmt"
+
+	"github.com/danilopavk/battleshipper/engine"
+)
+
+// boardSize is the number of cells in every row and column of the board
+const boardSize = 10
+
+// Orientations of the ship being placed
+const (
+	horizontal = "horizontal"
+	vertical   = "vertical"
+)
+
+// cellState tells how a cell of the player's own board is drawn
+type cellState int
+
+const (
+	availableCell cellState = iota
+	blockedCell
+	shipCell
+)
+
+// fleetRows returns the states of the cells of the player's board, row by row
+func fleetRows(player engine.Player) [][]cellState {
+	available := player.AvailableCells()
+	ships := map[engine.Cell]bool{}
+	for _, ship := range *player.Ships {
+		for cell := range ship.Cells {
+			ships[cell] = true
+		}
+	}
+
+	rows := make([][]cellState, len(available[0]))
+	for y := range rows {
+		rows[y] = make([]cellState, len(available))
+		for x := range rows[y] {
+			switch {
+			case ships[engine.Cell{X: x, Y: y}]:
+				rows[y][x] = shipCell
+			case available[x][y]:
+				rows[y][x] = availableCell
+			default:
+				rows[y][x] = blockedCell
+			}
+		}
+	}
+	return rows
+}
+
+// placing checks whether any of the players is still placing ships
+func placing(game engine.Game) bool {
+	return len(*game.PlayerA.Ships) != engine.FleetSize || len(*game.PlayerB.Ships) != engine.FleetSize
+}
+
+// nextShipLength returns the length of the player's next ship, whether the opponent joined or not
+func nextShipLength(player engine.Player, game engine.Game) (int, error) {
+	if game.Id == 0 {
+		return player.NextShipLength()
+	}
+	return game.NextShipLength(player.Id)
+}
+
+// shipCells returns the cells of the ship of the given length, starting at the cell
+func shipCells(start engine.Cell, length int, orientation string) []engine.Cell {
+	var cells []engine.Cell
+	for i := 0; i < length; i++ {
+		if orientation == vertical {
+			cells = append(cells, engine.Cell{X: start.X, Y: start.Y + i})
+		} else {
+			cells = append(cells, engine.Cell{X: start.X + i, Y: start.Y})
+		}
+	}
+	return cells
+}
+
+func cellValues(x, y int) string {
+	return fmt.Sprintf(`{"x": %d, "y": %d}`, x, y)
+}
+
+// shotState tells how a cell is drawn once the shooting starts
+type shotState int
+
+const (
+	unknownCell shotState = iota
+	emptyCell
+	missCell
+	floatingCell
+	hitCell
+	sunkCell
+)
+
+// targetRows returns what the player knows about the opponent's board, row by row
+func targetRows(player engine.Player) [][]shotState {
+	target := player.Target
+	sunk := map[engine.Cell]bool{}
+	for _, ship := range *target.SankShips {
+		for cell := range ship.Cells {
+			sunk[cell] = true
+		}
+	}
+
+	return rows(func(cell engine.Cell) shotState {
+		switch {
+		case sunk[cell]:
+			return sunkCell
+		case target.Hits[cell]:
+			return hitCell
+		case target.Misses[cell]:
+			return missCell
+		default:
+			return unknownCell
+		}
+	})
+}
+
+// incomingRows returns the owner's ships, and the shots the other player fired at them, row by row
+func incomingRows(owner engine.Player, game engine.Game) [][]shotState {
+	ships := map[engine.Cell]bool{}
+	for _, ship := range *owner.Ships {
+		for cell := range ship.Cells {
+			ships[cell] = true
+		}
+	}
+	shots := map[engine.Cell]bool{}
+	for _, shot := range game.History {
+		if shot.PlayerId != owner.Id {
+			shots[shot.Cell] = true
+		}
+	}
+
+	return rows(func(cell engine.Cell) shotState {
+		switch {
+		case ships[cell] && shots[cell]:
+			return hitCell
+		case ships[cell]:
+			return floatingCell
+		case shots[cell]:
+			return missCell
+		default:
+			return emptyCell
+		}
+	})
+}
+
+// changedCells returns the cells whose state differs between the two boards
+func changedCells(before, after [][]shotState) []engine.Cell {
+	var cells []engine.Cell
+	for y := range after {
+		for x := range after[y] {
+			if before[y][x] != after[y][x] {
+				cells = append(cells, engine.Cell{X: x, Y: y})
+			}
+		}
+	}
+	return cells
+}
+
+// opponent returns the other player in the game
+func opponent(player engine.Player, game engine.Game) engine.Player {
+	if game.PlayerA.Id == player.Id {
+		return game.PlayerB
+	}
+	return game.PlayerA
+}
+
+func rows(state func(engine.Cell) shotState) [][]shotState {
+	rows := make([][]shotState, boardSize)
+	for y := range rows {
+		rows[y] = make([]shotState, boardSize)
+		for x := range rows[y] {
+			rows[y][x] = state(engine.Cell{X: x, Y: y})
+		}
+	}
+	return rows
+}
+
+func targetCellId(x, y int) string {
+	return fmt.Sprintf("target-%d-%d", x, y)
+}
+
+func shotClass(state shotState) string {
+	switch state {
+	case missCell:
+		return "bg-sky-200"
+	case floatingCell:
+		return "bg-sky-700"
+	case hitCell:
+		return "bg-red-400"
+	case sunkCell:
+		return "bg-red-800"
+	default:
+		return "bg-white"
+	}
+}
+
+// spectatedRows returns the owner's board as the spectators see it.
+//
+// Once the fleets are revealed it's the same as the owner's own board,
+// otherwise it only shows what the other player knows about it.
+func spectatedRows(owner engine.Player, game engine.Game) [][]shotState {
+	if game.FleetsRevealed() {
+		return incomingRows(owner, game)
+	}
+	return targetRows(opponent(owner, game))
+}
+
+// turn returns the player whose turn it is
+func turn(game engine.Game) engine.Player {
+	if *game.Turn == game.PlayerA.Id {
+		return game.PlayerA
+	}
+	return game.PlayerB
+}
+
+// myTurn checks whether the player can shoot, which they can't before the game starts or after it ends
+func myTurn(player engine.Player, game engine.Game) bool {
+	return game.Winner == nil && game.Turn != nil && *game.Turn == player.Id
+}
+
+// winner returns the player who won the game
+func winner(game engine.Game) engine.Player {
+	if *game.Winner == game.PlayerA.Id {
+		return game.PlayerA
+	}
+	return game.PlayerB
+}
+
+// watching tells how many spectators are watching the game
+func watching(spectators int) string {
+	if spectators == 1 {
+		return "1 spectator watching"
+	}
+	return fmt.Sprintf("%d spectators watching", spectators)
+}
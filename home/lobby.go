@@ -0,0 +1,74 @@
+package home
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/danilopavk/battleshipper/engine"
+	"github.com/danilopavk/battleshipper/store"
+)
+
+// lobbyRefresh is how often the page reloads the list of waiting players
+const lobbyRefresh = "every 5s"
+
+// lobbyEntry is a waiting player shown in the lobby, together with how long they have been waiting
+type lobbyEntry struct {
+	Player  engine.Player
+	Waiting time.Duration
+}
+
+// waiting tells how the player without the opponent is waiting.
+//
+// InviteCode is set for the private games, and Queued for the players in the matchmaking queue.
+type waiting struct {
+	InviteCode string
+	Queued     bool
+}
+
+// waitingFor returns how the player is waiting for the opponent
+func waitingFor(gameStore store.GameRepository, playerId int) waiting {
+	code, _ := gameStore.InviteCode(playerId)
+	if code != "" {
+		code = store.FormatInviteCode(code)
+	}
+	return waiting{InviteCode: code, Queued: gameStore.Queued(playerId)}
+}
+
+// lobbyEntries lists the waiting players, the ones waiting the longest first
+func lobbyEntries(gameStore store.GameRepository, now time.Time) []lobbyEntry {
+	entries := []lobbyEntry{}
+	for _, player := range gameStore.AllWaitingPlayers() {
+		since, ok := gameStore.WaitingSince(player.Id)
+		if !ok {
+			continue
+		}
+		entries = append(entries, lobbyEntry{Player: player, Waiting: now.Sub(since)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Waiting != entries[j].Waiting {
+			return entries[i].Waiting > entries[j].Waiting
+		}
+		return entries[i].Player.Id < entries[j].Player.Id
+	})
+	return entries
+}
+
+// waitingTime describes when the player started waiting, like "3 minutes ago"
+func waitingTime(waiting time.Duration) string {
+	switch {
+	case waiting < time.Minute:
+		return "just now"
+	case waiting < time.Hour:
+		return plural(int(waiting/time.Minute), "minute") + " ago"
+	default:
+		return plural(int(waiting/time.Hour), "hour") + " ago"
+	}
+}
+
+func plural(count int, unit string) string {
+	if count == 1 {
+		return fmt.Sprintf("1 %s", unit)
+	}
+	return fmt.Sprintf("%d %ss", count, unit)
+}
@@ -0,0 +1,388 @@
+package home
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/danilopavk/battleshipper/api"
+	"github.com/danilopavk/battleshipper/auth"
+	"github.com/danilopavk/battleshipper/engine"
+	"github.com/danilopavk/battleshipper/store"
+)
+
+// Server serves the home page, and the htmx fragments the page is built from.
+//
+// Moves are made through the api server, so the players using the page and
+// the ones using the API play by the same rules and get the same events.
+type Server struct {
+	Store  store.GameRepository
+	Moves  *api.Server
+	Signer auth.Signer
+}
+
+// InitializeServer builds the server for the web UI
+func InitializeServer(store store.GameRepository, moves *api.Server, signer auth.Signer) Server {
+	return Server{Store: store, Moves: moves, Signer: signer}
+}
+
+// Handler returns the handler serving the page and all its fragments
+func (server *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", server.page)
+	mux.HandleFunc("GET /invite/{code}", server.page)
+	mux.HandleFunc("POST /start", server.start)
+	mux.HandleFunc("POST /start/private", server.startPrivate)
+	mux.HandleFunc("POST /invite", server.joinPrivate)
+	mux.HandleFunc("POST /match", server.findMatch)
+	mux.HandleFunc("POST /match/cancel", server.cancelMatch)
+	mux.HandleFunc("POST /account/signin", server.signIn)
+	mux.HandleFunc("POST /account/register", server.register)
+	mux.HandleFunc("GET /lobby", server.lobby)
+	mux.HandleFunc("POST /join/{opponentId}", server.join)
+	mux.HandleFunc("GET /game", server.game)
+	mux.HandleFunc("POST /place", server.place)
+	mux.HandleFunc("POST /place/random", server.placeRandomly)
+	mux.HandleFunc("POST /shoot", server.shoot)
+	mux.HandleFunc("GET /spectating", server.spectating)
+	mux.HandleFunc("POST /spectating", server.openSpectating)
+	mux.HandleFunc("GET /watch/{gameId}", server.watch)
+	mux.HandleFunc("GET /watch/{gameId}/boards", server.watchBoards)
+	mux.HandleFunc("GET /leaderboard", server.leaderboard)
+	mux.HandleFunc("GET /users/{userId}", server.userProfile)
+	mux.HandleFunc("GET /archive", server.archive)
+	mux.HandleFunc("GET /replay/{gameId}", server.replay)
+	return mux
+}
+
+// StartPlayer is the body of the start form
+type StartPlayer struct {
+	Name string `json:"name"`
+}
+
+// page renders the whole page, with the invite code from the invite link filled in, if there is one
+func (server *Server) page(writer http.ResponseWriter, request *http.Request) {
+	server.render(writer, request, Page(server.Store, request.PathValue("code"), server.signedIn(request)))
+}
+
+func (server *Server) start(writer http.ResponseWriter, request *http.Request) {
+	name, user, ok := server.startingPlayer(request)
+	if !ok {
+		return
+	}
+
+	player, err := server.Store.StartGame(name, user.Id)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auth.SetCookie(writer, server.Signer.Issue(player.Id))
+	server.render(writer, request, Game(player, engine.Game{}, waiting{}))
+}
+
+func (server *Server) startPrivate(writer http.ResponseWriter, request *http.Request) {
+	name, user, ok := server.startingPlayer(request)
+	if !ok {
+		return
+	}
+
+	player, _, err := server.Store.StartPrivateGame(name, user.Id)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auth.SetCookie(writer, server.Signer.Issue(player.Id))
+	server.render(writer, request, Game(player, engine.Game{}, waitingFor(server.Store, player.Id)))
+}
+
+// findMatch puts the player in the matchmaking queue, with the rules of the casual games
+func (server *Server) findMatch(writer http.ResponseWriter, request *http.Request) {
+	name, user, ok := server.startingPlayer(request)
+	if !ok {
+		return
+	}
+
+	player, game, err := server.Moves.FindMatch(name, store.Ticket{UserId: user.Id, Rules: engine.DefaultRules()})
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auth.SetCookie(writer, server.Signer.Issue(player.Id))
+	server.render(writer, request, Game(player, game, waitingFor(server.Store, player.Id)))
+}
+
+// cancelMatch takes the player out of the matchmaking queue, and goes back to the start
+func (server *Server) cancelMatch(writer http.ResponseWriter, request *http.Request) {
+	player, game, ok := server.playerAndGame(writer, request)
+	if !ok {
+		return
+	}
+	if err := server.Store.CancelMatch(player.Id); err != nil {
+		server.render(writer, request, Game(player, game, waiting{}))
+		return
+	}
+	server.render(writer, request, Welcome(server.Store, ""))
+}
+
+// joinPrivate starts the private game with the invite code, or renders the invite form again with the error
+func (server *Server) joinPrivate(writer http.ResponseWriter, request *http.Request) {
+	code := request.FormValue("code")
+	user := server.signedIn(request)
+	name := playerName(request.FormValue("name"), user)
+
+	message := ""
+	if name == "" {
+		message = "Tell us your name before joining"
+	} else {
+		game, err := server.Moves.JoinPrivate(name, code, user)
+		if err == nil {
+			auth.SetCookie(writer, server.Signer.Issue(game.PlayerB.Id))
+			server.render(writer, request, Game(game.PlayerB, game, waiting{}))
+			return
+		}
+		message = fmt.Sprintf("Cannot join the game: %v", err)
+	}
+
+	writer.Header().Set("HX-Retarget", "#invite")
+	writer.Header().Set("HX-Reswap", "outerHTML")
+	server.render(writer, request, InviteForm(code, message))
+}
+
+func (server *Server) lobby(writer http.ResponseWriter, request *http.Request) {
+	server.render(writer, request, Lobby(lobbyEntries(server.Store, time.Now()), ""))
+}
+
+// join starts the game with the waiting player.
+//
+// If the player is gone, for example because someone else joined first, the
+// lobby is rendered again with the error instead of the game.
+func (server *Server) join(writer http.ResponseWriter, request *http.Request) {
+	opponentId, err := strconv.Atoi(request.PathValue("opponentId"))
+	user := server.signedIn(request)
+	name := playerName(request.FormValue("name"), user)
+
+	message := ""
+	switch {
+	case err != nil:
+		message = "Choose the player to join"
+	case name == "":
+		message = "Tell us your name before joining"
+	default:
+		game, err := server.Moves.Join(name, opponentId, user)
+		if err == nil {
+			auth.SetCookie(writer, server.Signer.Issue(game.PlayerB.Id))
+			server.render(writer, request, Game(game.PlayerB, game, waiting{}))
+			return
+		}
+		message = fmt.Sprintf("Cannot join the game: %v", err)
+	}
+
+	writer.Header().Set("HX-Retarget", "#lobby")
+	writer.Header().Set("HX-Reswap", "outerHTML")
+	server.render(writer, request, Lobby(lobbyEntries(server.Store, time.Now()), message))
+}
+
+func (server *Server) game(writer http.ResponseWriter, request *http.Request) {
+	player, game, ok := server.playerAndGame(writer, request)
+	if !ok {
+		return
+	}
+	server.render(writer, request, Status(player, game, waitingFor(server.Store, player.Id)))
+}
+
+func (server *Server) place(writer http.ResponseWriter, request *http.Request) {
+	player, game, ok := server.playerAndGame(writer, request)
+	if !ok {
+		return
+	}
+
+	orientation := request.FormValue("orientation")
+	x, errX := strconv.Atoi(request.FormValue("x"))
+	y, errY := strconv.Atoi(request.FormValue("y"))
+	length, err := nextShipLength(player, game)
+
+	message := ""
+	switch {
+	case errX != nil || errY != nil:
+		message = "Choose the cell where the ship starts"
+	case err != nil:
+		message = err.Error()
+	default:
+		_, err = server.Moves.PlaceShip(player.Id, shipCells(engine.Cell{X: x, Y: y}, length, orientation))
+		if err != nil {
+			message = err.Error()
+		}
+	}
+
+	server.renderPlacement(writer, request, player.Id, orientation, message)
+}
+
+func (server *Server) placeRandomly(writer http.ResponseWriter, request *http.Request) {
+	player, _, ok := server.playerAndGame(writer, request)
+	if !ok {
+		return
+	}
+
+	message := ""
+	if _, err := server.Moves.PlaceRandomly(player.Id); err != nil {
+		message = err.Error()
+	}
+
+	server.renderPlacement(writer, request, player.Id, request.FormValue("orientation"), message)
+}
+
+// shoot fires the shot, and swaps only the target cells that changed.
+//
+// The shot cell replaces the clicked one, and all other changes, like the cells
+// around the sunk ship, the turn banner and the tally, are swapped out of band.
+// Players still waiting for the opponent get the error instead.
+func (server *Server) shoot(writer http.ResponseWriter, request *http.Request) {
+	player, game, ok := server.playerAndGame(writer, request)
+	if !ok {
+		return
+	}
+	if game.Id == 0 || game.Turn == nil {
+		http.Error(writer, store.ErrNotStarted.Error(), http.StatusConflict)
+		return
+	}
+	before := targetRows(player)
+
+	x, errX := strconv.Atoi(request.FormValue("x"))
+	y, errY := strconv.Atoi(request.FormValue("y"))
+	message := ""
+	if errX != nil || errY != nil {
+		message = "Choose the cell to shoot at"
+	} else if _, err := server.Moves.Shoot(player.Id, engine.Cell{X: x, Y: y}); err != nil {
+		message = err.Error()
+	}
+
+	player, game, err := server.Store.GetPlayerAndGame(player.Id)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	}
+	after := targetRows(player)
+	canShoot := myTurn(player, game)
+
+	if errX == nil && errY == nil && y >= 0 && y < len(after) && x >= 0 && x < len(after[y]) {
+		server.render(writer, request, TargetCell(x, y, after[y][x], canShoot, false))
+	}
+	for _, cell := range changedCells(before, after) {
+		if cell != (engine.Cell{X: x, Y: y}) {
+			server.render(writer, request, TargetCell(cell.X, cell.Y, after[cell.Y][cell.X], canShoot, true))
+		}
+	}
+	server.render(writer, request, TurnBanner(player, game, message, true))
+	server.render(writer, request, Tally(player, game, true))
+}
+
+func (server *Server) spectating(writer http.ResponseWriter, request *http.Request) {
+	player, game, ok := server.playerAndGame(writer, request)
+	if !ok {
+		return
+	}
+	server.render(writer, request, SpectatingControls(player, game, server.Moves.Watchers.Count(game.Id)))
+}
+
+func (server *Server) openSpectating(writer http.ResponseWriter, request *http.Request) {
+	player, _, ok := server.playerAndGame(writer, request)
+	if !ok {
+		return
+	}
+	open, err := strconv.ParseBool(request.FormValue("open"))
+	if err != nil {
+		http.Error(writer, "Expected open flag", http.StatusBadRequest)
+		return
+	}
+	if _, err := server.Moves.OpenSpectating(player.Id, open); err != nil {
+		http.Error(writer, err.Error(), http.StatusConflict)
+		return
+	}
+	server.spectating(writer, request)
+}
+
+func (server *Server) watch(writer http.ResponseWriter, request *http.Request) {
+	game, ok := server.watchedGame(writer, request)
+	if !ok {
+		return
+	}
+	server.render(writer, request, Watch(game, server.Moves.Watchers.Count(game.Id)))
+}
+
+func (server *Server) watchBoards(writer http.ResponseWriter, request *http.Request) {
+	game, ok := server.watchedGame(writer, request)
+	if !ok {
+		return
+	}
+	server.render(writer, request, Spectate(game, server.Moves.Watchers.Count(game.Id)))
+}
+
+func (server *Server) leaderboard(writer http.ResponseWriter, request *http.Request) {
+	server.render(writer, request, Leaderboard(server.Moves.Leaderboard()))
+}
+
+func (server *Server) userProfile(writer http.ResponseWriter, request *http.Request) {
+	userId, err := strconv.Atoi(request.PathValue("userId"))
+	if err != nil {
+		http.Error(writer, "Expected numeric user id", http.StatusBadRequest)
+		return
+	}
+	profile, err := server.Moves.Profile(userId)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	}
+	userStats, err := server.Moves.UserStats(userId)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	}
+	server.render(writer, request, UserProfile(profile, userStats))
+}
+
+// watchedGame finds the game from the path, and writes the error if there isn't one
+func (server *Server) watchedGame(writer http.ResponseWriter, request *http.Request) (engine.Game, bool) {
+	gameId, err := strconv.Atoi(request.PathValue("gameId"))
+	if err != nil {
+		http.Error(writer, "Expected numeric game id", http.StatusBadRequest)
+		return engine.Game{}, false
+	}
+	game, err := server.Store.GetGame(gameId)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return engine.Game{}, false
+	}
+	return game, true
+}
+
+func (server *Server) renderPlacement(writer http.ResponseWriter, request *http.Request, playerId int, orientation string, message string) {
+	player, game, err := server.Store.GetPlayerAndGame(playerId)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	}
+	server.render(writer, request, Placement(player, game, orientation, message))
+}
+
+// playerAndGame finds the player from the session cookie, and writes the error if there isn't one
+func (server *Server) playerAndGame(writer http.ResponseWriter, request *http.Request) (engine.Player, engine.Game, bool) {
+	playerId, err := server.Signer.PlayerId(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusUnauthorized)
+		return engine.Player{}, engine.Game{}, false
+	}
+	player, game, err := server.Store.GetPlayerAndGame(playerId)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return engine.Player{}, engine.Game{}, false
+	}
+	return player, game, true
+}
+
+func (server *Server) render(writer http.ResponseWriter, request *http.Request, component templ.Component) {
+	if err := component.Render(request.Context(), writer); err != nil {
+		fmt.Printf("Cannot render component, error: %v", err)
+	}
+}
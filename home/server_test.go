@@ -0,0 +1,433 @@
+package home
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danilopavk/battleshipper/api"
+	"github.com/danilopavk/battleshipper/auth"
+	"github.com/danilopavk/battleshipper/engine"
+	"github.com/danilopavk/battleshipper/ratings"
+	"github.com/danilopavk/battleshipper/stats"
+	"github.com/danilopavk/battleshipper/store"
+	"github.com/danilopavk/battleshipper/users"
+)
+
+func Test_PlaceShip(t *testing.T) {
+	server, gameStore := testServer()
+	player, _ := gameStore.StartGame("Quick Ben", 0)
+
+	body := post(t, server, player, "/place", url.Values{"x": {"0"}, "y": {"0"}, "orientation": {vertical}})
+
+	player, _, _ = gameStore.GetPlayerAndGame(player.Id)
+	if len(*player.Ships) != 1 {
+		t.Fatalf("Expected one ship to be placed, but there are %d", len(*player.Ships))
+	}
+	if !(*player.Ships)[0].Cells[engine.Cell{X: 0, Y: 4}] {
+		t.Error("Expected the ship to be placed vertically")
+	}
+	if !strings.Contains(body, "Place your ship of length 4") {
+		t.Error("Expected to ask for the next ship of length 4")
+	}
+}
+
+func Test_PlaceShipShowsError(t *testing.T) {
+	server, gameStore := testServer()
+	player, _ := gameStore.StartGame("Quick Ben", 0)
+
+	body := post(t, server, player, "/place", url.Values{"x": {"8"}, "y": {"0"}, "orientation": {horizontal}})
+
+	if !strings.Contains(body, "text-red-700") {
+		t.Error("Expected to show the error inline")
+	}
+	player, _, _ = gameStore.GetPlayerAndGame(player.Id)
+	if len(*player.Ships) != 0 {
+		t.Error("Expected no ships to be placed")
+	}
+}
+
+func Test_PlaceRandomly(t *testing.T) {
+	server, gameStore := testServer()
+	player, _ := gameStore.StartGame("Quick Ben", 0)
+
+	body := post(t, server, player, "/place/random", url.Values{})
+
+	if !strings.Contains(body, "All your ships are placed") {
+		t.Error("Expected all ships to be placed")
+	}
+}
+
+func Test_PlaceWithoutSession(t *testing.T) {
+	server, _ := testServer()
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("POST", "/place", nil))
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected unauthorized status, but got %d", recorder.Code)
+	}
+}
+
+func Test_ShipCells(t *testing.T) {
+	cells := shipCells(engine.Cell{X: 2, Y: 3}, 3, horizontal)
+
+	if len(cells) != 3 || cells[2] != (engine.Cell{X: 4, Y: 3}) {
+		t.Errorf("Unexpected cells %v", cells)
+	}
+}
+
+var testSigner = auth.InitializeSigner([]byte("secret"))
+
+func testServer() (http.Handler, *store.Store) {
+	server, gameStore, _ := testServerWithHub()
+	return server, gameStore
+}
+
+// testServerWithHub also returns the hub, to publish the end of the games finished in the store directly
+func testServerWithHub() (http.Handler, *store.Store, *store.Hub) {
+	gameStore := store.InitializeStore()
+	hub := store.InitializeHub()
+	watchers := store.InitializeWatchers()
+	registry := users.InitializeRegistry()
+	ratingService := ratings.InitializeService()
+	hub.OnGameOver(ratingService.Record)
+	statsService := stats.InitializeService()
+	hub.OnGameOver(statsService.Record)
+	moves := api.InitializeServer(&gameStore, &hub, &watchers, &registry, &ratingService, &statsService, nil, testSigner)
+	server := InitializeServer(&gameStore, &moves, testSigner)
+	return server.Handler(), &gameStore, &hub
+}
+
+func post(t *testing.T, server http.Handler, player engine.Player, path string, form url.Values) string {
+	request := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.AddCookie(&http.Cookie{Name: auth.CookieName, Value: testSigner.Issue(player.Id)})
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d", recorder.Code)
+	}
+	return recorder.Body.String()
+}
+
+func Test_Shoot(t *testing.T) {
+	server, gameStore := testServer()
+	player, _ := gameStore.StartGame("Quick Ben", 0)
+	_ = player.PlaceRandomly()
+	_ = gameStore.UpdatePlayer(player)
+	game, _ := gameStore.JoinGame("Kalam", 0, player.Id)
+	_ = game.PlayerB.PlaceRandomly()
+	_ = gameStore.UpdateGame(game)
+
+	body := post(t, server, player, "/shoot", url.Values{"x": {"3"}, "y": {"4"}})
+
+	if !strings.HasPrefix(body, `<div id="target-3-4"`) {
+		t.Errorf("Expected the shot cell first, but the response was %v", body)
+	}
+	if !strings.Contains(body, `id="turn" class="mb-2 font-medium" hx-swap-oob="true"`) {
+		t.Errorf("Expected the turn banner to be swapped out of band, but the response was %v", body)
+	}
+	if !strings.Contains(body, "Kalam's turn") {
+		t.Errorf("Expected the turn to pass to the opponent, but the response was %v", body)
+	}
+
+	_, game, _ = gameStore.GetPlayerAndGame(player.Id)
+	if len(game.History) != 1 {
+		t.Errorf("Expected one shot in the history, but there are %d", len(game.History))
+	}
+}
+
+func Test_ShootWhileWaiting(t *testing.T) {
+	server, gameStore := testServer()
+	player, _ := gameStore.StartGame("Quick Ben", 0)
+
+	request := httptest.NewRequest("POST", "/shoot", strings.NewReader(url.Values{"x": {"3"}, "y": {"4"}}.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.AddCookie(&http.Cookie{Name: auth.CookieName, Value: testSigner.Issue(player.Id)})
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusConflict {
+		t.Errorf("Expected conflict status, but got %d", recorder.Code)
+	}
+	if !strings.Contains(recorder.Body.String(), store.ErrNotStarted.Error()) {
+		t.Errorf("Expected the game not started error, but the response was %v", recorder.Body.String())
+	}
+}
+
+func Test_GameOverRevealsFleet(t *testing.T) {
+	server, gameStore := testServer()
+	player, _ := gameStore.StartGame("Quick Ben", 0)
+	_ = player.PlaceRandomly()
+	_ = gameStore.UpdatePlayer(player)
+	game, _ := gameStore.JoinGame("Kalam", 0, player.Id)
+	_ = game.PlayerB.PlaceRandomly()
+	_ = game.Forfeit(game.PlayerB.Id)
+	_ = gameStore.UpdateGame(game)
+
+	request := httptest.NewRequest("GET", "/game", nil)
+	request.AddCookie(&http.Cookie{Name: auth.CookieName, Value: testSigner.Issue(player.Id)})
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+
+	body := recorder.Body.String()
+	if !strings.Contains(body, "You won!") {
+		t.Error("Expected to show the winner")
+	}
+	if !strings.Contains(body, "Kalam's fleet") {
+		t.Error("Expected to reveal the opponent's fleet")
+	}
+}
+
+func Test_Join(t *testing.T) {
+	server, gameStore := testServer()
+	player, _ := gameStore.StartGame("Quick Ben", 0)
+
+	recorder := join(server, player.Id, "Kalam")
+
+	_, game, err := gameStore.GetPlayerAndGame(player.Id)
+	if err != nil || game.PlayerB.Name != "Kalam" {
+		t.Fatalf("Expected Kalam to join the game, error: %v", err)
+	}
+	if len(recorder.Result().Cookies()) != 1 {
+		t.Error("Expected the session cookie for Kalam")
+	}
+	if !strings.Contains(recorder.Body.String(), `<div id="game"`) {
+		t.Errorf("Expected the game to be rendered, but the response was %v", recorder.Body.String())
+	}
+}
+
+func Test_JoinTakenGame(t *testing.T) {
+	server, gameStore := testServer()
+	player, _ := gameStore.StartGame("Quick Ben", 0)
+	_, _ = gameStore.JoinGame("Kalam", 0, player.Id)
+
+	recorder := join(server, player.Id, "Fiddler")
+
+	if recorder.Header().Get("HX-Retarget") != "#lobby" {
+		t.Error("Expected the lobby to be rendered again")
+	}
+	if !strings.Contains(recorder.Body.String(), "text-red-700") {
+		t.Errorf("Expected to show the error inline, but the response was %v", recorder.Body.String())
+	}
+	if len(gameStore.Games()) != 1 {
+		t.Error("Expected Fiddler not to start another game")
+	}
+}
+
+func Test_WaitingTime(t *testing.T) {
+	for waiting, expected := range map[time.Duration]string{
+		10 * time.Second: "just now",
+		time.Minute:      "1 minute ago",
+		42 * time.Minute: "42 minutes ago",
+		3 * time.Hour:    "3 hours ago",
+	} {
+		if actual := waitingTime(waiting); actual != expected {
+			t.Errorf("Expected %v for %v, got %v", expected, waiting, actual)
+		}
+	}
+}
+
+func join(server http.Handler, opponentId int, name string) *httptest.ResponseRecorder {
+	form := url.Values{"name": {name}}
+	request := httptest.NewRequest("POST", fmt.Sprintf("/join/%d", opponentId), strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func Test_WatchHidesFleets(t *testing.T) {
+	server, gameStore := testServer()
+	player, _ := gameStore.StartGame("Quick Ben", 0)
+	_ = player.PlaceRandomly()
+	_ = gameStore.UpdatePlayer(player)
+	game, _ := gameStore.JoinGame("Kalam", 0, player.Id)
+	_ = game.PlayerB.PlaceRandomly()
+	_ = gameStore.UpdateGame(game)
+
+	body := get(t, server, fmt.Sprintf("/watch/%d", game.Id))
+	if !strings.Contains(body, "Quick Ben's turn") {
+		t.Errorf("Expected to show whose turn it is, but the response was %v", body)
+	}
+	if strings.Contains(body, "bg-sky-700") {
+		t.Error("Expected the fleets to be hidden from the spectators")
+	}
+
+	post(t, server, player, "/spectating", url.Values{"open": {"true"}})
+
+	body = get(t, server, fmt.Sprintf("/watch/%d/boards", game.Id))
+	if !strings.Contains(body, "bg-sky-700") {
+		t.Error("Expected the fleets to be revealed once the host opened spectating")
+	}
+}
+
+func get(t *testing.T, server http.Handler, path string) string {
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d", recorder.Code)
+	}
+	return recorder.Body.String()
+}
+
+func Test_JoinPrivateGame(t *testing.T) {
+	server, gameStore := testServer()
+	player, code, _ := gameStore.StartPrivateGame("Quick Ben", 0)
+
+	body := get(t, server, "/invite/"+store.FormatInviteCode(code))
+	if !strings.Contains(body, store.FormatInviteCode(code)) {
+		t.Error("Expected the invite link to fill in the code")
+	}
+	if strings.Contains(body, "Join Quick Ben") {
+		t.Error("Expected the private game not to be listed in the lobby")
+	}
+
+	form := url.Values{"name": {"Kalam"}, "code": {code}}
+	request := httptest.NewRequest("POST", "/invite", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+
+	_, game, _ := gameStore.GetPlayerAndGame(player.Id)
+	if game.PlayerB.Name != "Kalam" {
+		t.Errorf("Expected Kalam to join with the invite code, but the response was %v", recorder.Body.String())
+	}
+}
+
+func Test_FindMatch(t *testing.T) {
+	server, gameStore := testServer()
+
+	body := postJSON(t, server, "/match", `{"name": "Quick Ben"}`)
+	if !strings.Contains(body, "looking for an opponent") {
+		t.Errorf("Expected Quick Ben to wait in the queue, but the response was %v", body)
+	}
+
+	body = postJSON(t, server, "/match", `{"name": "Kalam"}`)
+	if !strings.Contains(body, "Quick Ben vs Kalam") {
+		t.Errorf("Expected Kalam to be matched with Quick Ben, but the response was %v", body)
+	}
+	if len(gameStore.Games()) != 1 {
+		t.Error("Expected the game to start")
+	}
+}
+
+func Test_CancelMatch(t *testing.T) {
+	server, gameStore := testServer()
+	player, _, _ := gameStore.FindMatch("Quick Ben", store.Ticket{Rules: engine.DefaultRules()})
+
+	body := post(t, server, player, "/match/cancel", url.Values{})
+
+	if !strings.Contains(body, "Start new game") {
+		t.Errorf("Expected to go back to the start, but the response was %v", body)
+	}
+	if gameStore.Queued(player.Id) {
+		t.Error("Expected Quick Ben to leave the queue")
+	}
+}
+
+func postJSON(t *testing.T, server http.Handler, path string, body string) string {
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("POST", path, strings.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d", recorder.Code)
+	}
+	return recorder.Body.String()
+}
+
+func Test_StartAsSignedInUser(t *testing.T) {
+	server, gameStore := testServer()
+
+	form := url.Values{"username": {"tool"}, "password": {"imass flint sword"}, "displayName": {"Onos T'oolan"}}
+	request := httptest.NewRequest("POST", "/account/register", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	if !strings.Contains(recorder.Body.String(), "Signed in as") {
+		t.Fatalf("Expected to be signed in, but the response was %v", recorder.Body.String())
+	}
+
+	request = httptest.NewRequest("POST", "/start", strings.NewReader(`{"name": ""}`))
+	for _, cookie := range recorder.Result().Cookies() {
+		request.AddCookie(cookie)
+	}
+	server.ServeHTTP(httptest.NewRecorder(), request)
+
+	players := gameStore.AllWaitingPlayers()
+	if len(players) != 1 || players[0].UserId == 0 || players[0].Name != "Onos T'oolan" {
+		t.Errorf("Expected the game to be started for the signed in user, got %v", players)
+	}
+}
+
+func Test_LeaderboardAndProfile(t *testing.T) {
+	server, gameStore, hub := testServerWithHub()
+
+	form := url.Values{"username": {"tool"}, "password": {"imass flint sword"}, "displayName": {"Onos Toolan"}}
+	request := httptest.NewRequest("POST", "/account/register", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+
+	request = httptest.NewRequest("POST", "/start", strings.NewReader(`{"name": ""}`))
+	for _, cookie := range recorder.Result().Cookies() {
+		request.AddCookie(cookie)
+	}
+	server.ServeHTTP(httptest.NewRecorder(), request)
+	tool := gameStore.AllWaitingPlayers()[0]
+	game, _ := gameStore.JoinGame("Icarium", 0, tool.Id)
+	game.Forfeit(game.PlayerB.Id)
+	gameStore.UpdateGame(game)
+	hub.PublishOver(game)
+
+	body := get(t, server, "/leaderboard")
+	if !strings.Contains(body, "Onos Toolan") || !strings.Contains(body, fmt.Sprintf("/users/%d", tool.UserId)) {
+		t.Errorf("Expected the leaderboard to link to Onos Toolan's profile, but got %v", body)
+	}
+	if strings.Contains(body, "Icarium") {
+		t.Error("Expected the guests to be left out of the leaderboard")
+	}
+
+	body = get(t, server, fmt.Sprintf("/users/%d", tool.UserId))
+	if !strings.Contains(body, "Onos Toolan") || !strings.Contains(body, "100%") {
+		t.Errorf("Expected the profile to show the win rate, but got %v", body)
+	}
+}
+
+func Test_ArchiveAndReplay(t *testing.T) {
+	server, gameStore := testServer()
+	player, _ := gameStore.StartGame("Quick Ben", 0)
+	_ = player.PlaceRandomly()
+	_ = gameStore.UpdatePlayer(player)
+	game, _ := gameStore.JoinGame("Kalam", 0, player.Id)
+	_ = game.PlayerB.PlaceRandomly()
+	_ = gameStore.UpdateGame(game)
+	_, game, _ = gameStore.Shoot(player.Id, engine.Cell{X: 4, Y: 4})
+	_ = game.Forfeit(game.PlayerB.Id)
+	_ = gameStore.UpdateGame(game)
+	gameStore.ArchiveFinished(time.Now().Add(time.Minute))
+
+	body := get(t, server, "/archive?opponent=kalam")
+	if !strings.Contains(body, "Quick Ben vs Kalam") || !strings.Contains(body, fmt.Sprintf("/replay/%d?shot=0", game.Id)) {
+		t.Errorf("Expected the archive to link to the replay of the game, but got %v", body)
+	}
+	body = get(t, server, "/archive?opponent=Felisin")
+	if !strings.Contains(body, "No finished games found") {
+		t.Errorf("Expected no games against Felisin, but got %v", body)
+	}
+
+	body = get(t, server, fmt.Sprintf("/replay/%d", game.Id))
+	if !strings.Contains(body, "Nobody fired yet") || !strings.Contains(body, "Shot 0 of 1") {
+		t.Errorf("Expected the replay to start before the first shot, but got %v", body)
+	}
+	body = get(t, server, fmt.Sprintf("/replay/%d?shot=1", game.Id))
+	if !strings.Contains(body, "Quick Ben fired at E5") || !strings.Contains(body, "Quick Ben won!") {
+		t.Errorf("Expected the last step to show the shot and the winner, but got %v", body)
+	}
+}
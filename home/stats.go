@@ -0,0 +1,32 @@
+package home
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/a-h/templ"
+	"github.com/danilopavk/battleshipper/engine"
+)
+
+// percent formats the share, like 0.5, as the rounded percentage, like "50%"
+func percent(share float64) string {
+	return fmt.Sprintf("%d%%", int(math.Round(share*100)))
+}
+
+// average formats the average with one decimal, or a dash if there's nothing to average
+func average(value float64) string {
+	if value == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f", value)
+}
+
+// cellName names the cell like on the paper boards, with the column letter and the row number, like "E5"
+func cellName(cell engine.Cell) string {
+	return fmt.Sprintf("%c%d", 'A'+cell.X, cell.Y+1)
+}
+
+// profileLink returns the link to the profile page of the user
+func profileLink(userId int) templ.SafeURL {
+	return templ.SafeURL(fmt.Sprintf("/users/%d", userId))
+}
@@ -1,39 +1,202 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
-	"github.com/a-h/templ"
+	"github.com/danilopavk/battleshipper/api"
+	"github.com/danilopavk/battleshipper/auth"
+	"github.com/danilopavk/battleshipper/bot"
 	"github.com/danilopavk/battleshipper/home"
+	"github.com/danilopavk/battleshipper/ratings"
+	"github.com/danilopavk/battleshipper/stats"
 	"github.com/danilopavk/battleshipper/store"
+	"github.com/danilopavk/battleshipper/users"
 )
 
+// matchmakingInterval is how often the players waiting in the matchmaking queue are matched again
+const matchmakingInterval = time.Second
+
+// janitorInterval is how often the janitor cleans up the abandoned players and the old games
+const janitorInterval = time.Minute
+
+// shutdownTimeout is how long the server waits for the requests in progress when it's stopped
+const shutdownTimeout = 10 * time.Second
+
+// defaultDataDir is the directory the games are saved in, unless BATTLESHIPPER_DATA environment variable says otherwise
+const defaultDataDir = "data"
+
+// databaseName is the name of the SQLite database file in the data directory
+const databaseName = "battleshipper.db"
+
 func main() {
-	gameStore := store.InitializeStore()
-	homePage := home.Page(&gameStore)
-	http.Handle("/", templ.Handler(homePage))
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
-	http.HandleFunc("/start", func(writer http.ResponseWriter, request *http.Request) {
-		if request.Method == "POST" {
-			var startPlayer StartPlayer
-			err := json.NewDecoder(request.Body).Decode(&startPlayer)
-			if err != nil {
-				fmt.Printf("Cannot decode player name, error: %v", err)
-				return
-			}
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-			gameStore.StartGame(startPlayer.Name)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
+	gameStore, accounts, err := openStore()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot open the game store, cause: %v", err))
+	}
+	hub := store.InitializeHub()
+	watchers := store.InitializeWatchers()
+	finished := gameStore.FinishedGames()
+	ratingService := ratings.InitializeService()
+	ratingService.Rebuild(finished)
+	hub.OnGameOver(ratingService.Record)
+	statsService := stats.InitializeService()
+	statsService.Rebuild(finished)
+	hub.OnGameOver(statsService.Record)
+	bots, err := openBots(gameStore)
+	if err != nil {
+		panic(fmt.Sprintf("Cannot read the bots, cause: %v", err))
+	}
+	botDriver := bot.Driver{Store: gameStore, Hub: &hub, Registry: &bots, Client: bot.InitializeClient()}
+	go func() {
+		if err := botDriver.Resume(ctx); err != nil {
+			fmt.Printf("Cannot resume the bots, error: %v\n", err)
 		}
-	})
+	}()
+	signer := auth.InitializeSigner(secret())
+	apiServer := api.InitializeServer(gameStore, &hub, &watchers, &accounts, &ratingService, &statsService, &botDriver, signer)
+	apiServer.AdminToken = os.Getenv("BATTLESHIPPER_ADMIN_TOKEN")
+	go apiServer.Matchmake(ctx, matchmakingInterval)
+	janitor := store.InitializeJanitor(gameStore, &hub, ttls(), store.SystemClock{})
+	janitor.Keep = func(playerId int) bool {
+		_, ok := bots.Get(playerId)
+		return ok
+	}
+	stopJanitor := janitor.Start(janitorInterval)
+	homeServer := home.InitializeServer(gameStore, &apiServer, signer)
+	http.Handle("/", homeServer.Handler())
+	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	http.Handle("/api/", apiServer.Handler())
 
-	if err := http.ListenAndServe(":3002", nil); err != nil {
+	server := &http.Server{Addr: ":3002"}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		panic(fmt.Sprintf("Cannot start server, cause: %v", err))
 	}
+
+	stopJanitor()
+	closeStore(gameStore)
+}
+
+// ttls returns how long the waiting players and the games are kept around.
+//
+// Every TTL can be set with its environment variable, as a duration like
+// "30m": BATTLESHIPPER_WAITING_TTL, BATTLESHIPPER_IDLE_TTL and
+// BATTLESHIPPER_FINISHED_TTL. Zero turns that part of the cleanup off.
+func ttls() store.TTLs {
+	ttls := store.DefaultTTLs()
+	for name, ttl := range map[string]*time.Duration{
+		"BATTLESHIPPER_WAITING_TTL":  &ttls.Waiting,
+		"BATTLESHIPPER_IDLE_TTL":     &ttls.Idle,
+		"BATTLESHIPPER_FINISHED_TTL": &ttls.Finished,
+	} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			panic(fmt.Sprintf("Cannot parse %s, cause: %v", name, err))
+		}
+		*ttl = duration
+	}
+	return ttls
+}
+
+// secret returns the secret for signing session tokens.
+//
+// Secret can be set with BATTLESHIPPER_SECRET environment variable, so sessions
+// survive restarts. Otherwise, a random one is generated.
+func secret() []byte {
+	if secret := os.Getenv("BATTLESHIPPER_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	return auth.RandomSecret()
+}
+
+// openStore opens the store the games are saved in, and the registry of the users.
+//
+// Games and users are saved in the SQLite database, unless BATTLESHIPPER_STORE
+// environment variable is set to "file", in which case both are saved in the
+// file store.
+func openStore() (store.GameRepository, users.Registry, error) {
+	if os.Getenv("BATTLESHIPPER_STORE") == "file" {
+		fileStore, err := store.InitializeFileStore(dataDir(), store.FileOptions{})
+		if err != nil {
+			return nil, users.Registry{}, err
+		}
+		saved, err := fileStore.Users()
+		if err != nil {
+			return nil, users.Registry{}, err
+		}
+		return fileStore, users.InitializePersistentRegistry(saved, fileStore.SaveUser), nil
+	}
+
+	sqliteStore, err := store.InitializeSQLiteStore(filepath.Join(dataDir(), databaseName))
+	if err != nil {
+		return nil, users.Registry{}, err
+	}
+	saved, err := sqliteStore.Users()
+	if err != nil {
+		return nil, users.Registry{}, err
+	}
+	return sqliteStore, users.InitializePersistentRegistry(saved, sqliteStore.SaveUser), nil
 }
 
-type StartPlayer struct {
-	Name string `json:"name"`
+// botSaver is the store that also saves the bot registrations, like the SQLite and the file stores
+type botSaver interface {
+	SaveBot(bot store.BotRecord) error
+	RemoveBot(playerId int) error
+	Bots() ([]store.BotRecord, error)
+}
+
+// openBots builds the registry of the bots, with the ones saved before, if the store saves them
+func openBots(gameStore store.GameRepository) (bot.Registry, error) {
+	saver, ok := gameStore.(botSaver)
+	if !ok {
+		return bot.InitializeRegistry(), nil
+	}
+	records, err := saver.Bots()
+	if err != nil {
+		return bot.Registry{}, err
+	}
+	saved := make([]bot.Bot, len(records))
+	for i, record := range records {
+		saved[i] = bot.Bot(record)
+	}
+	save := func(registered bot.Bot) error {
+		return saver.SaveBot(store.BotRecord(registered))
+	}
+	return bot.InitializePersistentRegistry(saved, save, saver.RemoveBot), nil
+}
+
+// dataDir returns the directory the games are saved in, so they survive restarts
+func dataDir() string {
+	if dir := os.Getenv("BATTLESHIPPER_DATA"); dir != "" {
+		return dir
+	}
+	return defaultDataDir
 }
@@ -0,0 +1,139 @@
+// Package ratings keeps the Elo ratings of the users playing ranked games.
+//
+// Every user starts with DefaultRating. Once a ranked game between two
+// signed in users ends, the winner takes rating points from the loser, more
+// of them the less likely the win was. It doesn't matter how the game ended:
+// a resignation or a forfeit on timeout counts as a loss like any other.
+package ratings
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/danilopavk/battleshipper/engine"
+)
+
+// DefaultRating is the rating of the users who haven't finished any ranked game yet
+const DefaultRating = 1500
+
+// kFactor is the largest number of points a single game can move the rating by
+const kFactor = 32
+
+// Change is a single entry in the rating history of the user
+type Change struct {
+	GameId     int       `json:"gameId"`
+	OpponentId int       `json:"opponentId"`
+	Before     int       `json:"before"`
+	After      int       `json:"after"`
+	Won        bool      `json:"won"`
+	At         time.Time `json:"at"`
+}
+
+// Service type holds the current ratings and the rating history of every user.
+//
+// Ratings are indexed by the user id, since the players only live as long as
+// their game. Guests, with zero user id, are never rated.
+type Service struct {
+	mutex           sync.RWMutex
+	ratingsByUserId map[int]int
+	historyByUserId map[int][]Change
+	recordedGameIds map[int]bool
+	now             func() time.Time
+}
+
+// InitializeService builds the service without any rated users
+func InitializeService() Service {
+	return Service{
+		ratingsByUserId: map[int]int{},
+		historyByUserId: map[int][]Change{},
+		recordedGameIds: map[int]bool{},
+		now:             time.Now,
+	}
+}
+
+// Rating returns the current rating of the user
+func (service *Service) Rating(userId int) int {
+	service.mutex.RLock()
+	defer service.mutex.RUnlock()
+
+	return service.rating(userId)
+}
+
+// History returns the rating changes of the user, the oldest first
+func (service *Service) History(userId int) []Change {
+	service.mutex.RLock()
+	defer service.mutex.RUnlock()
+
+	return append([]Change{}, service.historyByUserId[userId]...)
+}
+
+// Record updates the ratings of both players once the ranked game is over.
+//
+// Casual games, unfinished games, games with a guest and games a user played
+// against themselves are ignored. Every game is only counted once, so it's
+// safe to record the same game again.
+func (service *Service) Record(game engine.Game) {
+	if !game.Rules.Ranked || game.Winner == nil {
+		return
+	}
+	winner, loser := game.PlayerA, game.PlayerB
+	if *game.Winner == game.PlayerB.Id {
+		winner, loser = game.PlayerB, game.PlayerA
+	}
+	if winner.UserId == 0 || loser.UserId == 0 || winner.UserId == loser.UserId {
+		return
+	}
+
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
+	if service.recordedGameIds[game.Id] {
+		return
+	}
+	service.recordedGameIds[game.Id] = true
+
+	winnerBefore, loserBefore := service.rating(winner.UserId), service.rating(loser.UserId)
+	points := int(math.Round(kFactor * (1 - expected(winnerBefore, loserBefore))))
+	at := game.EndedAt
+	if at.IsZero() {
+		at = service.now()
+	}
+
+	service.change(winner.UserId, Change{GameId: game.Id, OpponentId: loser.UserId, Before: winnerBefore, After: winnerBefore + points, Won: true, At: at})
+	service.change(loser.UserId, Change{GameId: game.Id, OpponentId: winner.UserId, Before: loserBefore, After: loserBefore - points, At: at})
+}
+
+// Rebuild records the finished games, like the ones kept in the store, in the order they ended.
+//
+// Ratings aren't saved anywhere, so they're rebuilt from the ranked games on
+// every start. Games already recorded are skipped, like in Record.
+func (service *Service) Rebuild(games []engine.Game) {
+	games = slices.Clone(games)
+	slices.SortStableFunc(games, func(a, b engine.Game) int {
+		return a.EndedAt.Compare(b.EndedAt)
+	})
+	for _, game := range games {
+		service.Record(game)
+	}
+}
+
+// rating returns the rating of the user, and must be called with the lock held
+func (service *Service) rating(userId int) int {
+	if rating, ok := service.ratingsByUserId[userId]; ok {
+		return rating
+	}
+	return DefaultRating
+}
+
+// change applies the change to the user's rating, and must be called with the lock held
+func (service *Service) change(userId int, change Change) {
+	service.ratingsByUserId[userId] = change.After
+	service.historyByUserId[userId] = append(service.historyByUserId[userId], change)
+}
+
+// expected returns the probability of the player with the rating beating the opponent
+func expected(rating int, opponent int) float64 {
+	return 1 / (1 + math.Pow(10, float64(opponent-rating)/400))
+}
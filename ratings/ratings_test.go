@@ -0,0 +1,108 @@
+package ratings
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danilopavk/battleshipper/engine"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_Record(t *testing.T) {
+	service := InitializeService()
+	game := rankedGame(1, 2)
+
+	game.Forfeit(game.PlayerA.Id)
+	service.Record(game)
+
+	if rating := service.Rating(2); rating != DefaultRating+16 {
+		t.Errorf("Expected the winner to gain 16 points, but the rating is %d", rating)
+	}
+	if rating := service.Rating(1); rating != DefaultRating-16 {
+		t.Errorf("Expected the resigned player to lose 16 points, but the rating is %d", rating)
+	}
+
+	service.Record(game)
+	if history := service.History(1); len(history) != 1 || history[0].Won || history[0].OpponentId != 2 {
+		t.Errorf("Expected the game to be recorded once as a loss, but the history is %v", history)
+	}
+}
+
+func Test_RecordUpset(t *testing.T) {
+	service := InitializeService()
+	for i := 0; i < 3; i++ {
+		game := rankedGame(1, 2)
+		game.Forfeit(game.PlayerB.Id)
+		service.Record(game)
+	}
+	favourite, underdog := service.Rating(1), service.Rating(2)
+
+	game := rankedGame(1, 2)
+	game.Forfeit(game.PlayerA.Id)
+	service.Record(game)
+
+	if gained := service.Rating(2) - underdog; gained <= 16 {
+		t.Errorf("Expected the underdog to gain more than 16 points, but gained %d", gained)
+	}
+	if lost := favourite - service.Rating(1); lost != service.Rating(2)-underdog {
+		t.Errorf("Expected the favourite to lose as many points as the underdog gained, but lost %d", lost)
+	}
+}
+
+func Test_Rebuild(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	var games []engine.Game
+	for i, loser := range []int{2, 2, 1} {
+		game := rankedGame(1, 2)
+		loserId := game.PlayerA.Id
+		if loser == 2 {
+			loserId = game.PlayerB.Id
+		}
+		game.Forfeit(loserId)
+		game.EndedAt = start.Add(time.Duration(i) * time.Hour)
+		games = append(games, game)
+	}
+	live := InitializeService()
+	for _, game := range games {
+		live.Record(game)
+	}
+
+	rebuilt := InitializeService()
+	rebuilt.Rebuild([]engine.Game{games[2], games[0], games[1]})
+
+	for _, userId := range []int{1, 2} {
+		if diff := cmp.Diff(live.History(userId), rebuilt.History(userId)); diff != "" {
+			t.Errorf("Unexpected history of user %d (-want +got):\n%s", userId, diff)
+		}
+	}
+}
+
+func Test_RecordIgnoresUnrankedGames(t *testing.T) {
+	service := InitializeService()
+
+	casual := rankedGame(1, 2)
+	casual.Rules = engine.DefaultRules()
+	casual.Forfeit(casual.PlayerA.Id)
+	service.Record(casual)
+
+	guest := rankedGame(1, 0)
+	guest.Forfeit(guest.PlayerA.Id)
+	service.Record(guest)
+
+	unfinished := rankedGame(1, 2)
+	service.Record(unfinished)
+
+	if history := service.History(1); len(history) != 0 {
+		t.Errorf("Expected no rating changes, but got %v", history)
+	}
+}
+
+func rankedGame(userA int, userB int) engine.Game {
+	anomander := engine.InitializePlayer("Anomander Rake")
+	anomander.UserId = userA
+	draconus := engine.InitializePlayer("Draconus")
+	draconus.UserId = userB
+	game := engine.InitializeGame(anomander, draconus, anomander.Id)
+	game.Rules = engine.RankedRules()
+	return game
+}
@@ -0,0 +1,213 @@
+// Package stats aggregates the statistics of the users from their finished games.
+//
+// Like the ratings, the statistics aren't saved anywhere: the Service adds up
+// the shots in the history of every game once it ends, and is rebuilt from the
+// finished games on every start, so they can be recomputed whenever their
+// definitions change. Only the players linked to a user are counted.
+package stats
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+
+	"github.com/danilopavk/battleshipper/engine"
+)
+
+// favouriteOpenings is the number of opening cells kept in the statistics
+const favouriteOpenings = 3
+
+// Stats holds the statistics of a single user.
+//
+// WinRate is the share of the games played that were won, and Accuracy the
+// share of the shots that hit. AverageShotsToWin only counts the games won by
+// sinking the whole fleet, not the ones the opponent resigned or forfeited.
+// FavouriteOpenings are the cells the user shot at first most often.
+type Stats struct {
+	UserId            int       `json:"userId"`
+	GamesPlayed       int       `json:"gamesPlayed"`
+	Wins              int       `json:"wins"`
+	WinRate           float64   `json:"winRate"`
+	AverageShotsToWin float64   `json:"averageShotsToWin"`
+	Accuracy          float64   `json:"accuracy"`
+	LongestWinStreak  int       `json:"longestWinStreak"`
+	FavouriteOpenings []Opening `json:"favouriteOpenings"`
+}
+
+// Opening is a cell the user opened the games with, and the number of games opened with it
+type Opening struct {
+	Cell  engine.Cell `json:"cell"`
+	Games int         `json:"games"`
+}
+
+// tally accumulates the counts the statistics of one user are computed from
+type tally struct {
+	gamesPlayed    int
+	wins           int
+	shots          int
+	hits           int
+	sinkingWins    int
+	shotsToWin     int
+	streak         int
+	longestStreak  int
+	openingsByCell map[engine.Cell]int
+}
+
+// Service keeps the statistics of every user up to date as their games end.
+//
+// Statistics are indexed by the user id, like the ratings, and the requests
+// read them without going through the finished games again.
+type Service struct {
+	mutex           sync.RWMutex
+	talliesByUserId map[int]*tally
+	recordedGameIds map[int]bool
+}
+
+// InitializeService builds the service without any statistics
+func InitializeService() Service {
+	return Service{talliesByUserId: map[int]*tally{}, recordedGameIds: map[int]bool{}}
+}
+
+// Record counts the game once it's over.
+//
+// Games without a winner, and games a user played against themselves, are
+// ignored. Every game is only counted once, so it's safe to record the same
+// game again.
+func (service *Service) Record(game engine.Game) {
+	if game.Winner == nil || game.PlayerA.UserId == game.PlayerB.UserId {
+		return
+	}
+
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
+	if service.recordedGameIds[game.Id] {
+		return
+	}
+	service.recordedGameIds[game.Id] = true
+	for _, player := range []engine.Player{game.PlayerA, game.PlayerB} {
+		if player.UserId == 0 {
+			continue
+		}
+		if service.talliesByUserId[player.UserId] == nil {
+			service.talliesByUserId[player.UserId] = &tally{openingsByCell: map[engine.Cell]int{}}
+		}
+		service.talliesByUserId[player.UserId].add(game, player.Id)
+	}
+}
+
+// Rebuild records the finished games, like the ones kept in the store, in the order they ended
+func (service *Service) Rebuild(games []engine.Game) {
+	games = slices.Clone(games)
+	slices.SortStableFunc(games, func(a, b engine.Game) int {
+		return a.EndedAt.Compare(b.EndedAt)
+	})
+	for _, game := range games {
+		service.Record(game)
+	}
+}
+
+// All returns the statistics of every user who finished at least one game, by user id
+func (service *Service) All() map[int]Stats {
+	service.mutex.RLock()
+	defer service.mutex.RUnlock()
+
+	statsByUserId := map[int]Stats{}
+	for userId, tally := range service.talliesByUserId {
+		statsByUserId[userId] = tally.stats(userId)
+	}
+	return statsByUserId
+}
+
+// ForUser returns the statistics of the user, which are empty if the user didn't finish any game
+func (service *Service) ForUser(userId int) Stats {
+	service.mutex.RLock()
+	defer service.mutex.RUnlock()
+
+	if tally, ok := service.talliesByUserId[userId]; ok {
+		return tally.stats(userId)
+	}
+	return Stats{UserId: userId, FavouriteOpenings: []Opening{}}
+}
+
+// Compute returns the statistics of every user who finished at least one game, by user id.
+//
+// Games without a winner, and games a user played against themselves, are left out.
+func Compute(games []engine.Game) map[int]Stats {
+	service := InitializeService()
+	service.Rebuild(games)
+	return service.All()
+}
+
+// ForUser returns the statistics of the user, which are empty if the user didn't finish any game
+func ForUser(games []engine.Game, userId int) Stats {
+	service := InitializeService()
+	service.Rebuild(games)
+	return service.ForUser(userId)
+}
+
+// add counts the finished game of the player
+func (tally *tally) add(game engine.Game, playerId int) {
+	tally.gamesPlayed++
+
+	shots, sank := 0, 0
+	for _, shot := range game.History {
+		if shot.PlayerId != playerId {
+			continue
+		}
+		if shots == 0 {
+			tally.openingsByCell[shot.Cell]++
+		}
+		shots++
+		if shot.Hit {
+			tally.hits++
+		}
+		if shot.Sank {
+			sank++
+		}
+	}
+	tally.shots += shots
+
+	if *game.Winner != playerId {
+		tally.streak = 0
+		return
+	}
+	tally.wins++
+	tally.streak++
+	tally.longestStreak = max(tally.longestStreak, tally.streak)
+	if sank == engine.FleetSize {
+		tally.sinkingWins++
+		tally.shotsToWin += shots
+	}
+}
+
+func (tally *tally) stats(userId int) Stats {
+	stats := Stats{
+		UserId:            userId,
+		GamesPlayed:       tally.gamesPlayed,
+		Wins:              tally.wins,
+		WinRate:           ratio(tally.wins, tally.gamesPlayed),
+		AverageShotsToWin: ratio(tally.shotsToWin, tally.sinkingWins),
+		Accuracy:          ratio(tally.hits, tally.shots),
+		LongestWinStreak:  tally.longestStreak,
+		FavouriteOpenings: []Opening{},
+	}
+
+	for cell, games := range tally.openingsByCell {
+		stats.FavouriteOpenings = append(stats.FavouriteOpenings, Opening{cell, games})
+	}
+	slices.SortFunc(stats.FavouriteOpenings, func(a, b Opening) int {
+		return cmp.Or(b.Games-a.Games, a.Cell.Y-b.Cell.Y, a.Cell.X-b.Cell.X)
+	})
+	if len(stats.FavouriteOpenings) > favouriteOpenings {
+		stats.FavouriteOpenings = stats.FavouriteOpenings[:favouriteOpenings]
+	}
+	return stats
+}
+
+func ratio(part int, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) / float64(whole)
+}
@@ -0,0 +1,109 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danilopavk/battleshipper/engine"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_Compute(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	games := []engine.Game{
+		// won by sinking the fleet: 5 sinking shots out of 6
+		finishedGame(1, 2, true, start.Add(2*time.Hour), sinkingShots(engine.Cell{X: 4, Y: 4}, 1)),
+		// lost, opened in the corner
+		finishedGame(1, 2, false, start, []engine.Shot{{Cell: engine.Cell{X: 0, Y: 0}}}),
+		// won by resignation
+		finishedGame(1, 3, true, start.Add(3*time.Hour), []engine.Shot{{Cell: engine.Cell{X: 4, Y: 4}, Hit: true}}),
+		// guest game, only counted for the user
+		finishedGame(1, 0, false, start.Add(4*time.Hour), []engine.Shot{{Cell: engine.Cell{X: 9, Y: 9}}}),
+	}
+
+	statsByUserId := Compute(games)
+
+	want := Stats{
+		UserId:            1,
+		GamesPlayed:       4,
+		Wins:              2,
+		WinRate:           0.5,
+		AverageShotsToWin: 6,
+		Accuracy:          6.0 / 9.0,
+		LongestWinStreak:  2,
+		FavouriteOpenings: []Opening{
+			{engine.Cell{X: 4, Y: 4}, 2},
+			{engine.Cell{X: 0, Y: 0}, 1},
+			{engine.Cell{X: 9, Y: 9}, 1},
+		},
+	}
+	if diff := cmp.Diff(want, statsByUserId[1]); diff != "" {
+		t.Errorf("Unexpected stats (-want +got):\n%s", diff)
+	}
+	if _, ok := statsByUserId[0]; ok {
+		t.Error("Expected no stats for the guests")
+	}
+	if stats := statsByUserId[2]; stats.GamesPlayed != 2 || stats.Wins != 1 {
+		t.Errorf("Expected the opponent to win one of two games, but got %v", stats)
+	}
+}
+
+func Test_ForUserWithoutGames(t *testing.T) {
+	stats := ForUser(nil, 7)
+
+	if diff := cmp.Diff(Stats{UserId: 7, FavouriteOpenings: []Opening{}}, stats); diff != "" {
+		t.Errorf("Unexpected stats (-want +got):\n%s", diff)
+	}
+}
+
+func Test_ServiceRecord(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	won := finishedGame(1, 2, true, start, sinkingShots(engine.Cell{X: 4, Y: 4}, 1))
+	lost := finishedGame(1, 2, false, start.Add(time.Hour), []engine.Shot{{Cell: engine.Cell{X: 0, Y: 0}}})
+	service := InitializeService()
+
+	service.Rebuild([]engine.Game{won})
+	service.Record(lost)
+	service.Record(won)
+
+	if diff := cmp.Diff(Compute([]engine.Game{won, lost}), service.All()); diff != "" {
+		t.Errorf("Expected the recorded games to add up like the computed ones (-want +got):\n%s", diff)
+	}
+	if stats := service.ForUser(1); stats.GamesPlayed != 2 || stats.LongestWinStreak != 1 {
+		t.Errorf("Expected the game recorded again to be counted once, but got %v", stats)
+	}
+}
+
+// finishedGame builds the game between the two users, with the given shots of the first one
+func finishedGame(userA int, userB int, won bool, endedAt time.Time, shots []engine.Shot) engine.Game {
+	whiskeyjack := engine.InitializePlayer("Whiskeyjack")
+	whiskeyjack.UserId = userA
+	dujek := engine.InitializePlayer("Dujek Onearm")
+	dujek.UserId = userB
+
+	game := engine.InitializeGame(whiskeyjack, dujek, whiskeyjack.Id)
+	for _, shot := range shots {
+		shot.PlayerId = whiskeyjack.Id
+		game.History = append(game.History, shot)
+	}
+	game.History = append(game.History, engine.Shot{PlayerId: dujek.Id, Cell: engine.Cell{X: 5, Y: 5}, Hit: true})
+	if won {
+		game.Winner = &whiskeyjack.Id
+	} else {
+		game.Winner = &dujek.Id
+	}
+	game.EndedAt = endedAt
+	return game
+}
+
+// sinkingShots opens with the cell, then misses, followed by a sinking shot for every ship of the fleet
+func sinkingShots(opening engine.Cell, misses int) []engine.Shot {
+	shots := []engine.Shot{{Cell: opening}}
+	for i := 1; i < misses; i++ {
+		shots = append(shots, engine.Shot{Cell: engine.Cell{X: i, Y: 9}})
+	}
+	for i := 0; i < engine.FleetSize; i++ {
+		shots = append(shots, engine.Shot{Cell: engine.Cell{X: i, Y: 0}, Hit: true, Sank: true})
+	}
+	return shots
+}
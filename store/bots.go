@@ -0,0 +1,11 @@
+package store
+
+// BotRecord is the registration of the bot, as the SQLite and the file stores save it.
+//
+// The bot package depends on the store, so the store keeps its own copy of
+// the fields, and the registrations convert between the two.
+type BotRecord struct {
+	PlayerId    int    `json:"playerId"`
+	Name        string `json:"name"`
+	CallbackURL string `json:"callbackUrl"`
+}
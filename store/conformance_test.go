@@ -0,0 +1,76 @@
+package store_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/danilopavk/battleshipper/store"
+	"github.com/danilopavk/battleshipper/store/storetest"
+)
+
+func Test_Conformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.GameRepository {
+		gameStore := store.InitializeStore()
+		return &gameStore
+	})
+}
+
+func Test_FileStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.GameRepository {
+		fileStore, err := store.InitializeFileStore(t.TempDir(), store.FileOptions{Sync: store.SyncNever, SnapshotEvery: 3})
+		if err != nil {
+			t.Fatalf("Cannot open file store: %v", err)
+		}
+		t.Cleanup(func() { fileStore.Close() })
+		return fileStore
+	})
+}
+
+func Test_SQLiteStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.GameRepository {
+		sqliteStore, err := store.InitializeSQLiteStore(filepath.Join(t.TempDir(), "battleshipper.db"))
+		if err != nil {
+			t.Fatalf("Cannot open SQLite store: %v", err)
+		}
+		t.Cleanup(func() { sqliteStore.Close() })
+		return sqliteStore
+	})
+}
+
+func Test_FileStoreRestart(t *testing.T) {
+	storetest.RunRestart(t, func(t *testing.T) (store.GameRepository, func() store.GameRepository) {
+		dir := t.TempDir()
+		open := func() *store.FileStore {
+			fileStore, err := store.InitializeFileStore(dir, store.FileOptions{Sync: store.SyncNever})
+			if err != nil {
+				t.Fatalf("Cannot open file store: %v", err)
+			}
+			t.Cleanup(func() { fileStore.Close() })
+			return fileStore
+		}
+		fileStore := open()
+		return fileStore, func() store.GameRepository {
+			fileStore.Close()
+			return open()
+		}
+	})
+}
+
+func Test_SQLiteStoreRestart(t *testing.T) {
+	storetest.RunRestart(t, func(t *testing.T) (store.GameRepository, func() store.GameRepository) {
+		path := filepath.Join(t.TempDir(), "battleshipper.db")
+		open := func() *store.SQLiteStore {
+			sqliteStore, err := store.InitializeSQLiteStore(path)
+			if err != nil {
+				t.Fatalf("Cannot open SQLite store: %v", err)
+			}
+			t.Cleanup(func() { sqliteStore.Close() })
+			return sqliteStore
+		}
+		sqliteStore := open()
+		return sqliteStore, func() store.GameRepository {
+			sqliteStore.Close()
+			return open()
+		}
+	})
+}
@@ -0,0 +1,353 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/danilopavk/battleshipper/engine"
+)
+
+// dumpVersion is the version of the dump format, increased whenever the format changes
+const dumpVersion = 1
+
+// Formats the dump can be written in
+const (
+	// DumpJSON writes the whole dump as a single JSON object
+	DumpJSON = "json"
+	// DumpNDJSON writes the header, and then every player and game on its own line, so the dump can be streamed
+	DumpNDJSON = "ndjson"
+)
+
+// maxDumpLine is the longest line of the NDJSON dump, way longer than any game
+const maxDumpLine = 16 << 20
+
+// ErrExists is returned when loading the dump with the player or the game that's already in the store
+var ErrExists = errors.New("Already in the store")
+
+// Dump is the whole state of the store: the waiting players, the started games and the archived games.
+//
+// Dumps are written and read with WriteDump and ReadDump, and move the state
+// between the stores, whatever the storage behind them. Version is the
+// version of the format, so the newer dumps aren't misread by the older
+// servers.
+type Dump struct {
+	Version  int             `json:"version"`
+	DumpedAt time.Time       `json:"dumpedAt"`
+	Waiting  []waitingRecord `json:"waiting"`
+	Games    []gameRecord    `json:"games"`
+	Archived []gameRecord    `json:"archived"`
+}
+
+// dumpHeader is the first line of the NDJSON dump
+type dumpHeader struct {
+	Version  int       `json:"version"`
+	DumpedAt time.Time `json:"dumpedAt"`
+}
+
+// dumpEntry is every following line of the NDJSON dump, with exactly one of the fields set
+type dumpEntry struct {
+	Waiting  *waitingRecord `json:"waiting,omitempty"`
+	Game     *gameRecord    `json:"game,omitempty"`
+	Archived *gameRecord    `json:"archived,omitempty"`
+}
+
+// Counts returns the number of the waiting players, the started games and the archived games in the dump
+func (dump Dump) Counts() (waiting int, games int, archived int) {
+	return len(dump.Waiting), len(dump.Games), len(dump.Archived)
+}
+
+// Dump returns the whole state of the store at once
+func (store *Store) Dump() (Dump, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	dump := Dump{Version: dumpVersion, DumpedAt: time.Now(), Waiting: store.waitingRecords(), Games: store.gameRecords(), Archived: []gameRecord{}}
+	for _, game := range store.archive {
+		dump.Archived = append(dump.Archived, recordOfGame(game))
+	}
+	return dump, nil
+}
+
+// Load adds everything from the dump to the store.
+//
+// Returns ErrExists, and loads nothing, if any of the players or the games
+// is already in the store, so loading never overwrites anything. Like when
+// archiving, only the last archiveLimit archived games are kept.
+func (store *Store) Load(dump Dump) error {
+	if err := dump.validate(); err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if err := store.checkAbsent(dump); err != nil {
+		return err
+	}
+	store.restore(change{Waiting: dump.Waiting, Games: dump.Games})
+	for _, record := range dump.Archived {
+		store.keepArchived(record.game())
+	}
+	return nil
+}
+
+// checkAbsent checks that none of the players and games from the dump are in the store, and must be called with the lock held
+func (store *Store) checkAbsent(dump Dump) error {
+	for _, record := range dump.Waiting {
+		if err := store.checkPlayerAbsent(record.Player.Id); err != nil {
+			return err
+		}
+		if _, ok := store.playerIdByInviteCode[record.InviteCode]; ok {
+			return fmt.Errorf("Cannot load invite code %v: %w", record.InviteCode, ErrExists)
+		}
+	}
+	for _, record := range slices.Concat(dump.Games, dump.Archived) {
+		if _, ok := store.game(record.Id); ok || store.archived(record.Id) {
+			return fmt.Errorf("Cannot load game %d: %w", record.Id, ErrExists)
+		}
+		for _, playerId := range []int{record.PlayerA.Id, record.PlayerB.Id} {
+			if err := store.checkPlayerAbsent(playerId); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkPlayerAbsent checks that the player is neither waiting nor in the started game, and must be called with the lock held
+func (store *Store) checkPlayerAbsent(playerId int) error {
+	_, waiting := store.waitingPlayers[playerId]
+	_, playing := store.gameIdOf(playerId)
+	if waiting || playing {
+		return fmt.Errorf("Cannot load player %d: %w", playerId, ErrExists)
+	}
+	return nil
+}
+
+// archived checks whether the game is archived, and must be called with the lock held
+func (store *Store) archived(gameId int) bool {
+	for _, game := range store.archive {
+		if game.Id == gameId {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks that the dump can be loaded: it's of a known version, every id and invite code is in it only once, and every record can be played on
+func (dump Dump) validate() error {
+	if dump.Version < 1 || dump.Version > dumpVersion {
+		return fmt.Errorf("Dump version %d is not supported, expected version up to %d", dump.Version, dumpVersion)
+	}
+
+	playerIds := map[int]bool{}
+	addPlayer := func(player playerRecord) error {
+		if player.Id == 0 || playerIds[player.Id] {
+			return fmt.Errorf("Cannot load dump with player %d more than once, or without id", player.Id)
+		}
+		playerIds[player.Id] = true
+		return nil
+	}
+	inviteCodes := map[string]bool{}
+	for _, record := range dump.Waiting {
+		if err := addPlayer(record.Player); err != nil {
+			return err
+		}
+		if err := record.validate(); err != nil {
+			return err
+		}
+		if record.InviteCode != "" && inviteCodes[record.InviteCode] {
+			return fmt.Errorf("Cannot load dump with invite code %v more than once", record.InviteCode)
+		}
+		inviteCodes[record.InviteCode] = true
+	}
+
+	gameIds := map[int]bool{}
+	for _, record := range slices.Concat(dump.Games, dump.Archived) {
+		if record.Id == 0 || gameIds[record.Id] {
+			return fmt.Errorf("Cannot load dump with game %d more than once, or without id", record.Id)
+		}
+		gameIds[record.Id] = true
+		for _, player := range []playerRecord{record.PlayerA, record.PlayerB} {
+			if err := addPlayer(player); err != nil {
+				return err
+			}
+		}
+		if err := record.validate(); err != nil {
+			return err
+		}
+	}
+	for _, record := range dump.Archived {
+		if record.Winner == nil {
+			return fmt.Errorf("Cannot archive game %d without a winner", record.Id)
+		}
+	}
+	return nil
+}
+
+// validate checks that the player can wait with everything they are waiting with
+func (record waitingRecord) validate() error {
+	playerId := record.Player.Id
+	if err := record.Player.validate(); err != nil {
+		return err
+	}
+	if record.Ticket != nil && record.Ticket.PlayerId != playerId {
+		return fmt.Errorf("Cannot load player %d waiting with the ticket of player %d", playerId, record.Ticket.PlayerId)
+	}
+	if record.Ticket != nil && record.InviteCode != "" {
+		return fmt.Errorf("Cannot load player %d waiting both in the queue and in the private game", playerId)
+	}
+	if record.InviteCode != "" && NormalizeInviteCode(record.InviteCode) != record.InviteCode {
+		return fmt.Errorf("Cannot load player %d with the invalid invite code %q", playerId, record.InviteCode)
+	}
+	return nil
+}
+
+// validate checks that the game is either won by one of its players, or it's one of its players' turn, and that both players and all the shots are on the board
+func (record gameRecord) validate() error {
+	playerIds := []int{record.PlayerA.Id, record.PlayerB.Id}
+	if record.Winner != nil && !slices.Contains(playerIds, *record.Winner) {
+		return fmt.Errorf("Cannot load game %d won by player %d, who doesn't play in it", record.Id, *record.Winner)
+	}
+	if record.Winner == nil && (record.Turn == nil || !slices.Contains(playerIds, *record.Turn)) {
+		return fmt.Errorf("Cannot load game %d that is neither won nor any of its players' turn", record.Id)
+	}
+	for _, player := range []playerRecord{record.PlayerA, record.PlayerB} {
+		if err := player.validate(); err != nil {
+			return err
+		}
+	}
+	for _, shot := range record.History {
+		if !slices.Contains(playerIds, shot.PlayerId) || !shot.Cell.OnBoard() {
+			return fmt.Errorf("Cannot load game %d with the shot %v", record.Id, shot)
+		}
+	}
+	return nil
+}
+
+// validate checks that the player has at most the whole fleet, and that all their cells are on the board
+func (record playerRecord) validate() error {
+	if len(record.Ships) > engine.FleetSize || len(record.SankShips) > engine.FleetSize {
+		return fmt.Errorf("Cannot load player %d with more than %d ships", record.Id, engine.FleetSize)
+	}
+	for _, cells := range slices.Concat(record.Ships, record.SankShips, [][]engine.Cell{record.Hits, record.Misses}) {
+		for _, cell := range cells {
+			if !cell.OnBoard() {
+				return fmt.Errorf("Cannot load player %d with the cell %d - %d outside of the board", record.Id, cell.X, cell.Y)
+			}
+		}
+	}
+	return nil
+}
+
+// ids returns the ids of all the players and all the games in the dump
+func (dump Dump) ids() (playerIds map[int]bool, gameIds map[int]bool) {
+	playerIds, gameIds = map[int]bool{}, map[int]bool{}
+	for _, record := range dump.Waiting {
+		playerIds[record.Player.Id] = true
+	}
+	for _, record := range slices.Concat(dump.Games, dump.Archived) {
+		gameIds[record.Id] = true
+		playerIds[record.PlayerA.Id] = true
+		playerIds[record.PlayerB.Id] = true
+	}
+	return playerIds, gameIds
+}
+
+// WriteDump writes the dump in the format, either DumpJSON or DumpNDJSON
+func WriteDump(writer io.Writer, dump Dump, format string) error {
+	encoder := json.NewEncoder(writer)
+	switch format {
+	case DumpJSON:
+		if err := encoder.Encode(dump); err != nil {
+			return fmt.Errorf("Cannot write dump: %w", err)
+		}
+		return nil
+	case DumpNDJSON:
+	default:
+		return fmt.Errorf("Expected %v or %v dump format, but got %q", DumpJSON, DumpNDJSON, format)
+	}
+
+	if err := encoder.Encode(dumpHeader{Version: dump.Version, DumpedAt: dump.DumpedAt}); err != nil {
+		return fmt.Errorf("Cannot write dump: %w", err)
+	}
+	var entries []dumpEntry
+	for i := range dump.Waiting {
+		entries = append(entries, dumpEntry{Waiting: &dump.Waiting[i]})
+	}
+	for i := range dump.Games {
+		entries = append(entries, dumpEntry{Game: &dump.Games[i]})
+	}
+	for i := range dump.Archived {
+		entries = append(entries, dumpEntry{Archived: &dump.Archived[i]})
+	}
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return fmt.Errorf("Cannot write dump: %w", err)
+		}
+	}
+	return nil
+}
+
+// ReadDump reads the dump written by WriteDump in the format.
+//
+// Returns error if the dump is corrupted anywhere, or of the newer version
+// than this server understands.
+func ReadDump(reader io.Reader, format string) (Dump, error) {
+	switch format {
+	case DumpJSON:
+		var dump Dump
+		if err := json.NewDecoder(reader).Decode(&dump); err != nil {
+			return Dump{}, fmt.Errorf("Cannot read dump: %w", err)
+		}
+		return dump, dump.validate()
+	case DumpNDJSON:
+	default:
+		return Dump{}, fmt.Errorf("Expected %v or %v dump format, but got %q", DumpJSON, DumpNDJSON, format)
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, maxDumpLine)
+	if !scanner.Scan() {
+		return Dump{}, fmt.Errorf("Cannot read dump header: %w", orEOF(scanner.Err()))
+	}
+	var header dumpHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return Dump{}, fmt.Errorf("Cannot read dump header: %w", err)
+	}
+
+	dump := Dump{Version: header.Version, DumpedAt: header.DumpedAt, Waiting: []waitingRecord{}, Games: []gameRecord{}, Archived: []gameRecord{}}
+	for line := 2; scanner.Scan(); line++ {
+		var entry dumpEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return Dump{}, fmt.Errorf("Cannot read dump line %d: %w", line, err)
+		}
+		switch {
+		case entry.Waiting != nil:
+			dump.Waiting = append(dump.Waiting, *entry.Waiting)
+		case entry.Game != nil:
+			dump.Games = append(dump.Games, *entry.Game)
+		case entry.Archived != nil:
+			dump.Archived = append(dump.Archived, *entry.Archived)
+		default:
+			return Dump{}, fmt.Errorf("Cannot read dump line %d: expected waiting player or game", line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Dump{}, fmt.Errorf("Cannot read dump: %w", err)
+	}
+	return dump, dump.validate()
+}
+
+// orEOF returns the error of the scanner, or io.EOF if the scanner just ran out of lines
+func orEOF(err error) error {
+	if err == nil {
+		return io.EOF
+	}
+	return err
+}
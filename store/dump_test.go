@@ -0,0 +1,191 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danilopavk/battleshipper/engine"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func Test_WriteAndReadDump(t *testing.T) {
+	dump := sampleDump(t)
+
+	for _, format := range []string{DumpJSON, DumpNDJSON} {
+		t.Run(format, func(t *testing.T) {
+			var buffer bytes.Buffer
+			if err := WriteDump(&buffer, dump, format); err != nil {
+				t.Fatalf("Cannot write the dump: %v", err)
+			}
+			read, err := ReadDump(&buffer, format)
+			if err != nil {
+				t.Fatalf("Cannot read the dump: %v", err)
+			}
+			if diff := cmp.Diff(dump, read, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Unexpected dump (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_WriteDumpAsLines(t *testing.T) {
+	var buffer bytes.Buffer
+	_ = WriteDump(&buffer, sampleDump(t), DumpNDJSON)
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected the header, the waiting player and two games on their own lines, but got %v", lines)
+	}
+	if !strings.HasPrefix(lines[0], `{"version":1,`) || !strings.HasPrefix(lines[1], `{"waiting":`) ||
+		!strings.HasPrefix(lines[2], `{"game":`) || !strings.HasPrefix(lines[3], `{"archived":`) {
+		t.Errorf("Unexpected lines %v", lines)
+	}
+}
+
+func Test_ReadDumpRejectsInvalid(t *testing.T) {
+	var buffer bytes.Buffer
+	_ = WriteDump(&buffer, sampleDump(t), DumpNDJSON)
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+
+	invalid := map[string]string{
+		"newer version":   strings.Replace(buffer.String(), `{"version":1,`, `{"version":2,`, 1),
+		"corrupted line":  buffer.String() + "{\"game\": {\n",
+		"unknown entry":   buffer.String() + `{"user": {"id": 5}}` + "\n",
+		"duplicated game": buffer.String() + lines[2] + "\n",
+		"empty":           "",
+	}
+	for name, content := range invalid {
+		if _, err := ReadDump(strings.NewReader(content), DumpNDJSON); err == nil {
+			t.Errorf("Expected the dump with %v to fail", name)
+		}
+	}
+	if _, err := ReadDump(strings.NewReader(`{"version": 0}`), DumpJSON); err == nil {
+		t.Error("Expected the dump without the version to fail")
+	}
+	if _, err := ReadDump(strings.NewReader(""), "xml"); err == nil {
+		t.Error("Expected the unknown format to fail")
+	}
+}
+
+func Test_LoadRejectsInvalidRecords(t *testing.T) {
+	otherId := 42
+	invalid := map[string]func(dump *Dump){
+		"ticket of another player": func(dump *Dump) {
+			dump.Waiting[0].Ticket = &Ticket{PlayerId: otherId}
+		},
+		"queued in the private game": func(dump *Dump) {
+			dump.Waiting[0].Ticket = &Ticket{PlayerId: dump.Waiting[0].Player.Id}
+			dump.Waiting[0].InviteCode = "ABCDEF"
+		},
+		"invalid invite code": func(dump *Dump) {
+			dump.Waiting[0].InviteCode = "abc-def"
+		},
+		"nobody's turn": func(dump *Dump) {
+			dump.Games[0].Turn = nil
+		},
+		"turn of another player": func(dump *Dump) {
+			dump.Games[0].Turn = &otherId
+		},
+		"won by another player": func(dump *Dump) {
+			dump.Archived[0].Winner = &otherId
+		},
+		"shot outside of the board": func(dump *Dump) {
+			dump.Games[0].History[0].Cell = engine.Cell{X: 10, Y: -1}
+		},
+		"ship outside of the board": func(dump *Dump) {
+			dump.Games[0].PlayerB.Ships[0][0] = engine.Cell{X: 12, Y: 0}
+		},
+		"too many ships": func(dump *Dump) {
+			dump.Games[0].PlayerA.Ships = append(dump.Games[0].PlayerA.Ships, dump.Games[0].PlayerA.Ships[0])
+		},
+	}
+
+	for name, corrupt := range invalid {
+		t.Run(name, func(t *testing.T) {
+			dump := sampleDump(t)
+			corrupt(&dump)
+			store := InitializeStore()
+
+			if err := store.Load(dump); err == nil {
+				t.Fatal("Expected the dump to be rejected")
+			}
+			if loaded, _ := store.Dump(); len(loaded.Waiting)+len(loaded.Games)+len(loaded.Archived) != 0 {
+				t.Errorf("Expected nothing to be loaded, but got %v", loaded)
+			}
+		})
+	}
+}
+
+func Test_LoadExistingInviteCode(t *testing.T) {
+	store := InitializeStore()
+	_, code, _ := store.StartPrivateGame("Karsa Orlong", 0)
+	dump := sampleDump(t)
+	dump.Waiting[0].InviteCode = code
+
+	if err := store.Load(dump); !errors.Is(err, ErrExists) {
+		t.Errorf("Expected the taken invite code to exist, but got %v", err)
+	}
+}
+
+func Test_LoadSurvivesRestart(t *testing.T) {
+	dump := sampleDump(t)
+	dir := t.TempDir()
+	stores := map[string]func() (GameRepository, func() error){
+		"file": func() (GameRepository, func() error) {
+			fileStore, err := InitializeFileStore(dir, FileOptions{})
+			if err != nil {
+				t.Fatalf("Cannot open file store: %v", err)
+			}
+			return fileStore, fileStore.Close
+		},
+		"sqlite": func() (GameRepository, func() error) {
+			sqliteStore := openSQLiteStore(t, filepath.Join(dir, "battleshipper.db"))
+			return sqliteStore, sqliteStore.Close
+		},
+	}
+
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			repository, closeStore := open()
+			if err := repository.Load(dump); err != nil {
+				t.Fatalf("Cannot load the dump: %v", err)
+			}
+			closeStore()
+
+			restored, closeRestored := open()
+			defer closeRestored()
+			if _, waiting := restored.WaitingSince(dump.Waiting[0].Player.Id); !waiting {
+				t.Error("Expected the loaded waiting player to wait after restart")
+			}
+			if _, err := restored.GetGame(dump.Games[0].Id); err != nil {
+				t.Errorf("Cannot find the loaded game after restart: %v", err)
+			}
+			if _, err := restored.FinishedGame(dump.Archived[0].Id); err != nil {
+				t.Errorf("Cannot find the loaded archived game after restart: %v", err)
+			}
+			if _, err := restored.GetGame(dump.Archived[0].Id); err == nil {
+				t.Error("Expected the loaded archived game to stay archived after restart")
+			}
+		})
+	}
+}
+
+// sampleDump dumps the store with a waiting player, a running game and an archived game
+func sampleDump(t *testing.T) Dump {
+	store := InitializeStore()
+	store.StartGameWithRules("Karsa Orlong", 0, engine.RankedRules())
+	running := placedGame(t, &store)
+	_, _, _ = store.Shoot(running.PlayerA.Id, engine.Cell{X: 0, Y: 0})
+	finished := placedGame(t, &store)
+	_ = finished.Forfeit(finished.PlayerB.Id)
+	_ = store.UpdateGame(finished)
+	store.ArchiveFinished(time.Now().Add(time.Minute))
+
+	dump, _ := store.Dump()
+	return dump
+}
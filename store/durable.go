@@ -0,0 +1,636 @@
+package store
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/danilopavk/battleshipper/archive"
+	"github.com/danilopavk/battleshipper/engine"
+)
+
+// change is a single change of the state of the store, as it is saved.
+//
+// Gone players stopped waiting, and the Waiting players and Games are saved
+// with their new state. Archived games left the started games, and are saved
+// with their final state in the archive. Every change is saved as a whole,
+// so it's either restored completely or not at all.
+type change struct {
+	Gone     []int           `json:"gone,omitempty"`
+	Waiting  []waitingRecord `json:"waiting,omitempty"`
+	Games    []gameRecord    `json:"games,omitempty"`
+	Archived []gameRecord    `json:"archived,omitempty"`
+}
+
+// saver saves the changes of the store somewhere they outlive the process
+type saver interface {
+	// save saves the change, and is called with either the whole durable
+	// store or just the saved game locked, so the changes of different games
+	// can be saved at the same time
+	save(change change) error
+	// archived reads the archived games back, since only the started ones are kept in memory
+	archived() ([]gameRecord, error)
+	// archivedGame reads the archived game back by its id, and false if there's no such archived game
+	archivedGame(gameId int) (gameRecord, bool, error)
+	// searchArchived finds the games matching the query among the finished games still in memory and the archived ones
+	searchArchived(query archive.Query, finished []engine.Game) (archive.Page, error)
+}
+
+// durableStore keeps the games in memory, like the Store, and saves every change with the saver.
+//
+// Reads are served from the memory, the saved state is only read back when
+// the store opens. The archived games are the exception, they are only kept
+// in the storage, and read from it when they're asked for. The file store
+// and the SQLite store differ only in how they save the changes.
+//
+// Changes of the waiting players lock the whole store, while the changes of
+// a started game only lock that game, so the moves in different games are
+// made and saved without waiting for each other. Either way, the changes of
+// a game are saved in the order they were made. A change that can't be saved
+// is undone in memory too, so the memory never holds what a restart would lose.
+type durableStore struct {
+	mutex  sync.RWMutex
+	games  [shardCount]sync.Mutex
+	memory *Store
+	saver  saver
+	name   string
+}
+
+// StartGame starts a new game, and saves the waiting player
+func (durable *durableStore) StartGame(playerName string, userId int) (engine.Player, error) {
+	return durable.StartGameWithRules(playerName, userId, engine.DefaultRules())
+}
+
+// StartGameWithRules starts a new game played by the rules, and saves the waiting player
+func (durable *durableStore) StartGameWithRules(playerName string, userId int, rules engine.Rules) (engine.Player, error) {
+	durable.mutex.Lock()
+	defer durable.mutex.Unlock()
+
+	player, _ := durable.memory.StartGameWithRules(playerName, userId, rules)
+	if err := durable.saveWaiting(change{}, player.Id); err != nil {
+		return engine.Player{}, err
+	}
+	return player, nil
+}
+
+// StartGameAs starts a new game with the player already set up, and saves the waiting player
+func (durable *durableStore) StartGameAs(player engine.Player) (engine.Player, error) {
+	durable.mutex.Lock()
+	defer durable.mutex.Unlock()
+
+	started, err := durable.memory.StartGameAs(player)
+	if err != nil {
+		return engine.Player{}, err
+	}
+	if err := durable.saveWaiting(change{}, started.Id); err != nil {
+		return engine.Player{}, err
+	}
+	return started, nil
+}
+
+// StartPrivateGame starts a new private game, and saves the waiting player
+func (durable *durableStore) StartPrivateGame(playerName string, userId int) (engine.Player, string, error) {
+	durable.mutex.Lock()
+	defer durable.mutex.Unlock()
+
+	player, code, err := durable.memory.StartPrivateGame(playerName, userId)
+	if err != nil {
+		return engine.Player{}, "", err
+	}
+	if err := durable.saveWaiting(change{}, player.Id); err != nil {
+		return engine.Player{}, "", err
+	}
+	return player, code, nil
+}
+
+// JoinGame joins the game of the waiting player, and saves the started game
+func (durable *durableStore) JoinGame(playerName string, userId int, opponentId int) (engine.Game, error) {
+	durable.mutex.Lock()
+	defer durable.mutex.Unlock()
+
+	before := durable.before(opponentId)
+	game, err := durable.memory.JoinGame(playerName, userId, opponentId)
+	if err != nil {
+		return engine.Game{}, err
+	}
+	if err := durable.saveStarted(before, game); err != nil {
+		return engine.Game{}, err
+	}
+	return game, nil
+}
+
+// JoinPrivateGame joins the private game with the invite code, and saves the started game
+func (durable *durableStore) JoinPrivateGame(playerName string, userId int, code string) (engine.Game, error) {
+	durable.mutex.Lock()
+	defer durable.mutex.Unlock()
+
+	before := durable.allWaiting()
+	game, err := durable.memory.JoinPrivateGame(playerName, userId, code)
+	if err != nil {
+		return engine.Game{}, err
+	}
+	if err := durable.saveStarted(before, game); err != nil {
+		return engine.Game{}, err
+	}
+	return game, nil
+}
+
+// FindMatch puts the player in the matchmaking queue, and saves either the queued player or the started game
+func (durable *durableStore) FindMatch(playerName string, ticket Ticket) (engine.Player, engine.Game, error) {
+	durable.mutex.Lock()
+	defer durable.mutex.Unlock()
+
+	before := durable.allWaiting()
+	player, game, _ := durable.memory.FindMatch(playerName, ticket)
+	var err error
+	if game.Id != 0 {
+		err = durable.saveStarted(before, game)
+	} else {
+		err = durable.saveWaiting(before, player.Id)
+	}
+	if err != nil {
+		return engine.Player{}, engine.Game{}, err
+	}
+	return player, game, nil
+}
+
+// Matchmake matches the queued players, and saves the started games
+func (durable *durableStore) Matchmake(now time.Time) []engine.Game {
+	durable.mutex.Lock()
+	defer durable.mutex.Unlock()
+
+	before := durable.allWaiting()
+	games := durable.memory.Matchmake(now)
+	if len(games) == 0 {
+		return nil
+	}
+	if err := durable.saveStarted(before, games...); err != nil {
+		durable.logFailure(err)
+		return nil
+	}
+	return games
+}
+
+// CancelMatch takes the player out of the matchmaking queue, and saves that the player is gone
+func (durable *durableStore) CancelMatch(playerId int) error {
+	durable.mutex.Lock()
+	defer durable.mutex.Unlock()
+
+	before := durable.before(playerId)
+	if err := durable.memory.CancelMatch(playerId); err != nil {
+		return err
+	}
+	return durable.commit(before, change{Gone: []int{playerId}})
+}
+
+// UpdatePlayer updates the player, and saves either the waiting player or the player's game
+func (durable *durableStore) UpdatePlayer(player engine.Player) error {
+	defer durable.lockPlayer(player.Id)()
+
+	before := durable.before(player.Id)
+	if err := durable.memory.UpdatePlayer(player); err != nil {
+		return err
+	}
+	return durable.savePlayer(before, player.Id)
+}
+
+// AssignUser links the player to the user, and saves either the waiting player or the player's game
+func (durable *durableStore) AssignUser(playerId int, userId int) error {
+	defer durable.lockPlayer(playerId)()
+
+	before := durable.before(playerId)
+	if err := durable.memory.AssignUser(playerId, userId); err != nil {
+		return err
+	}
+	return durable.savePlayer(before, playerId)
+}
+
+// UpdateGame updates the game, and saves it
+func (durable *durableStore) UpdateGame(game engine.Game) error {
+	defer durable.lockGame(game.Id)()
+
+	before := durable.beforeGame(game.Id)
+	if err := durable.memory.UpdateGame(game); err != nil {
+		return err
+	}
+	return durable.saveGame(before, game.Id)
+}
+
+// Shoot fires the player's shot, and saves the game
+func (durable *durableStore) Shoot(playerId int, cell engine.Cell) (engine.Shot, engine.Game, error) {
+	defer durable.lockPlayer(playerId)()
+
+	before := durable.before(playerId)
+	shot, game, err := durable.memory.Shoot(playerId, cell)
+	if err != nil {
+		return engine.Shot{}, engine.Game{}, err
+	}
+	if err := durable.saveGame(before, game.Id); err != nil {
+		return engine.Shot{}, engine.Game{}, err
+	}
+	return shot, game, nil
+}
+
+// PlaceShip places the player's next ship, and saves either the waiting player or the player's game
+func (durable *durableStore) PlaceShip(playerId int, ship engine.Ship) (engine.Player, engine.Game, error) {
+	defer durable.lockPlayer(playerId)()
+
+	before := durable.before(playerId)
+	player, game, err := durable.memory.PlaceShip(playerId, ship)
+	if err != nil {
+		return engine.Player{}, engine.Game{}, err
+	}
+	if err := durable.savePlayer(before, playerId); err != nil {
+		return engine.Player{}, engine.Game{}, err
+	}
+	return player, game, nil
+}
+
+// AllWaitingPlayers returns the waiting players anyone can join
+func (durable *durableStore) AllWaitingPlayers() []engine.Player {
+	return durable.memory.AllWaitingPlayers()
+}
+
+// WaitingSince returns when the player started waiting
+func (durable *durableStore) WaitingSince(playerId int) (time.Time, bool) {
+	return durable.memory.WaitingSince(playerId)
+}
+
+// WaitingRules returns the rules of the game the player waits in
+func (durable *durableStore) WaitingRules(playerId int) (engine.Rules, bool) {
+	return durable.memory.WaitingRules(playerId)
+}
+
+// InviteCode returns the invite code of the player waiting in a private game
+func (durable *durableStore) InviteCode(playerId int) (string, bool) {
+	return durable.memory.InviteCode(playerId)
+}
+
+// Queued checks whether the player waits in the matchmaking queue
+func (durable *durableStore) Queued(playerId int) bool {
+	return durable.memory.Queued(playerId)
+}
+
+// GetPlayerAndGame returns the player, and the game if the player isn't waiting anymore
+func (durable *durableStore) GetPlayerAndGame(playerId int) (engine.Player, engine.Game, error) {
+	return durable.memory.GetPlayerAndGame(playerId)
+}
+
+// GetGame returns the game by its id
+func (durable *durableStore) GetGame(gameId int) (engine.Game, error) {
+	return durable.memory.GetGame(gameId)
+}
+
+// Games returns all the started games
+func (durable *durableStore) Games() []engine.Game {
+	return durable.memory.Games()
+}
+
+// FinishedGames returns the games that have a winner, with the archived ones read from the storage
+func (durable *durableStore) FinishedGames() []engine.Game {
+	games := durable.memory.FinishedGames()
+	archived, err := durable.saver.archived()
+	durable.logFailure(err)
+	for _, record := range archived {
+		games = append(games, record.game())
+	}
+	sortByEnd(games)
+	return games
+}
+
+// FinishedGame returns the finished game by its id, reading it from the storage if it's archived
+func (durable *durableStore) FinishedGame(gameId int) (engine.Game, error) {
+	game, err := durable.memory.FinishedGame(gameId)
+	if err == nil {
+		return game, nil
+	}
+	record, archived, readErr := durable.saver.archivedGame(gameId)
+	if readErr != nil {
+		return engine.Game{}, readErr
+	}
+	if !archived {
+		return engine.Game{}, err
+	}
+	return record.game(), nil
+}
+
+// SearchFinished finds the finished games matching the query, with the archived ones searched in the storage.
+//
+// The store is read locked, so no game is archived in the middle of the
+// search, to be found twice or not at all.
+func (durable *durableStore) SearchFinished(query archive.Query) (archive.Page, error) {
+	durable.mutex.RLock()
+	defer durable.mutex.RUnlock()
+
+	return durable.saver.searchArchived(query, durable.memory.activeFinishedGames())
+}
+
+// RemoveWaiting removes the players waiting since before the time, and saves that they are gone
+func (durable *durableStore) RemoveWaiting(before time.Time, keep func(playerId int) bool) []engine.Player {
+	durable.mutex.Lock()
+	defer durable.mutex.Unlock()
+
+	waiting := durable.allWaiting()
+	removed := durable.memory.RemoveWaiting(before, keep)
+	if len(removed) == 0 {
+		return nil
+	}
+	var gone change
+	for _, player := range removed {
+		gone.Gone = append(gone.Gone, player.Id)
+	}
+	if err := durable.commit(waiting, gone); err != nil {
+		durable.logFailure(err)
+		return nil
+	}
+	return removed
+}
+
+// ArchiveFinished moves the games that ended before the time from the memory to the archive in the storage.
+//
+// If the archive can't be saved, the games are put back, to be archived by
+// the next call.
+func (durable *durableStore) ArchiveFinished(before time.Time) []engine.Game {
+	durable.mutex.Lock()
+	defer durable.mutex.Unlock()
+
+	games := durable.memory.removeFinished(before)
+	if len(games) == 0 {
+		return nil
+	}
+	var archived change
+	for _, game := range games {
+		archived.Archived = append(archived.Archived, recordOfGame(game))
+	}
+	if err := durable.saver.save(archived); err != nil {
+		durable.logFailure(err)
+		for _, game := range games {
+			durable.memory.putGame(game)
+		}
+		return nil
+	}
+	for i, game := range games {
+		games[i] = game.Clone()
+	}
+	return games
+}
+
+// Dump returns the whole state of the store at once, with the archived games read from the storage
+func (durable *durableStore) Dump() (Dump, error) {
+	durable.mutex.Lock()
+	defer durable.mutex.Unlock()
+
+	dump, err := durable.memory.Dump()
+	if err != nil {
+		return Dump{}, err
+	}
+	archived, err := durable.saver.archived()
+	if err != nil {
+		return Dump{}, err
+	}
+	dump.Archived = append(dump.Archived, archived...)
+	return dump, nil
+}
+
+// Load adds everything from the dump to the store, and saves it as a single change.
+//
+// Nothing is loaded if any of the players or the games is already in the
+// store, archived or not, or if the change can't be saved.
+func (durable *durableStore) Load(dump Dump) error {
+	if err := dump.validate(); err != nil {
+		return err
+	}
+
+	durable.mutex.Lock()
+	defer durable.mutex.Unlock()
+
+	if err := durable.memory.checkAbsent(dump); err != nil {
+		return err
+	}
+	archived, err := durable.saver.archived()
+	if err != nil {
+		return err
+	}
+	playerIds, gameIds := dump.ids()
+	for _, record := range archived {
+		if gameIds[record.Id] || playerIds[record.PlayerA.Id] || playerIds[record.PlayerB.Id] {
+			return fmt.Errorf("Cannot load dump, archived game %d clashes with it: %w", record.Id, ErrExists)
+		}
+	}
+
+	if err := durable.saver.save(change{Waiting: dump.Waiting, Games: dump.Games, Archived: dump.Archived}); err != nil {
+		return err
+	}
+	durable.memory.apply(change{Waiting: dump.Waiting, Games: dump.Games})
+	return nil
+}
+
+// lockPlayer locks just the player's game, or the whole store while the player is waiting, and returns the unlock
+func (durable *durableStore) lockPlayer(playerId int) func() {
+	if gameId, ok := durable.memory.gameIdOf(playerId); ok {
+		return durable.lockGame(gameId)
+	}
+	durable.mutex.Lock()
+	return durable.mutex.Unlock
+}
+
+// lockGame locks just the game, so only the whole store and the same game wait for it, and returns the unlock
+func (durable *durableStore) lockGame(gameId int) func() {
+	durable.mutex.RLock()
+	game := &durable.games[uint(gameId)%shardCount]
+	game.Lock()
+	return func() {
+		game.Unlock()
+		durable.mutex.RUnlock()
+	}
+}
+
+// before returns the player, either waiting or in the game, as they are before the change, and must be called with the lock held
+func (durable *durableStore) before(playerId int) change {
+	if record, ok := durable.memory.waitingRecord(playerId); ok {
+		return change{Waiting: []waitingRecord{record}}
+	}
+	if gameId, ok := durable.memory.gameIdOf(playerId); ok {
+		return durable.beforeGame(gameId)
+	}
+	return change{}
+}
+
+// beforeGame returns the game as it is before the change, and must be called with the lock held
+func (durable *durableStore) beforeGame(gameId int) change {
+	if game, ok := durable.memory.game(gameId); ok {
+		return change{Games: []gameRecord{recordOfGame(game)}}
+	}
+	return change{}
+}
+
+// allWaiting returns all the waiting players as they are before the change, and must be called with the whole store locked
+func (durable *durableStore) allWaiting() change {
+	return change{Waiting: durable.memory.allWaitingRecords()}
+}
+
+// commit saves the change already made in memory, and undoes it there if it can't be saved.
+//
+// Before holds the waiting players and the games the change touched, as they
+// were before it. Must be called with the lock held.
+func (durable *durableStore) commit(before change, made change) error {
+	if err := durable.saver.save(made); err != nil {
+		durable.memory.undo(before, made)
+		return err
+	}
+	return nil
+}
+
+// saveWaiting saves the waiting player, and must be called with the lock held
+func (durable *durableStore) saveWaiting(before change, playerId int) error {
+	record, ok := durable.memory.waitingRecord(playerId)
+	if !ok {
+		return fmt.Errorf("Cannot save player %d, they are not waiting", playerId)
+	}
+	return durable.commit(before, change{Waiting: []waitingRecord{record}})
+}
+
+// saveStarted saves the started games, whose players aren't waiting anymore, and must be called with the lock held
+func (durable *durableStore) saveStarted(before change, games ...engine.Game) error {
+	var started change
+	for _, game := range games {
+		started.Gone = append(started.Gone, game.PlayerA.Id, game.PlayerB.Id)
+		started.Games = append(started.Games, recordOfGame(game))
+	}
+	return durable.commit(before, started)
+}
+
+// savePlayer saves the player, either waiting or in the game, and must be called with the lock held
+func (durable *durableStore) savePlayer(before change, playerId int) error {
+	if _, waiting := durable.memory.WaitingSince(playerId); waiting {
+		return durable.saveWaiting(before, playerId)
+	}
+	_, game, err := durable.memory.GetPlayerAndGame(playerId)
+	if err != nil {
+		return err
+	}
+	return durable.saveGame(before, game.Id)
+}
+
+// saveGame saves the game as it's stored, with its new version, and must be called with the lock held
+func (durable *durableStore) saveGame(before change, gameId int) error {
+	game, err := durable.memory.GetGame(gameId)
+	if err != nil {
+		return err
+	}
+	return durable.commit(before, change{Games: []gameRecord{recordOfGame(game)}})
+}
+
+// logFailure reports the failure to save the change from the methods that can't return it
+func (durable *durableStore) logFailure(err error) {
+	if err != nil {
+		fmt.Printf("Cannot save the change to %v, error: %v\n", durable.name, err)
+	}
+}
+
+// waitingRecord returns the waiting player, with everything they are waiting with
+func (store *Store) waitingRecord(playerId int) (waitingRecord, bool) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return store.waitingRecordOf(playerId)
+}
+
+// waitingRecordOf returns the waiting player, and must be called with the lock held
+func (store *Store) waitingRecordOf(playerId int) (waitingRecord, bool) {
+	player, ok := store.waitingPlayers[playerId]
+	if !ok {
+		return waitingRecord{}, false
+	}
+
+	record := waitingRecord{
+		Player:     recordOfPlayer(player),
+		Since:      store.waitingSinceByPlayerId[playerId],
+		InviteCode: store.inviteCodeByPlayerId[playerId],
+	}
+	if rules, ok := store.rulesByPlayerId[playerId]; ok {
+		record.Rules = &rules
+	}
+	if index := store.queued(playerId); index >= 0 {
+		ticket := store.queue[index]
+		record.Ticket = &ticket
+	}
+	return record, true
+}
+
+// allWaitingRecords returns all the waiting players, in the order they started waiting
+func (store *Store) allWaitingRecords() []waitingRecord {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return store.waitingRecords()
+}
+
+// undo reverts the change made in memory that couldn't be saved, putting back what was there before it.
+//
+// Waiting players and games the change made, but that weren't there before,
+// are removed. The queue is kept in the order the players started waiting.
+func (store *Store) undo(before change, made change) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	existed := map[int]bool{}
+	for _, record := range before.Games {
+		existed[record.Id] = true
+	}
+	for _, record := range made.Games {
+		if !existed[record.Id] {
+			store.removeGame(record.Id)
+		}
+	}
+	for _, record := range made.Waiting {
+		store.forget(record.Player.Id)
+	}
+	store.restore(before)
+	slices.SortStableFunc(store.queue, func(a, b Ticket) int {
+		return a.Since.Compare(b.Since)
+	})
+}
+
+// apply restores the state from the saved change
+func (store *Store) apply(saved change) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.restore(saved)
+}
+
+// restore restores the state from the saved change, and must be called with the lock held
+func (store *Store) restore(saved change) {
+	for _, playerId := range saved.Gone {
+		store.forget(playerId)
+	}
+	for _, record := range saved.Waiting {
+		player := record.Player.player()
+		store.waitingPlayers[player.Id] = player
+		store.waitingSinceByPlayerId[player.Id] = record.Since
+		delete(store.rulesByPlayerId, player.Id)
+		if record.Rules != nil {
+			store.rulesByPlayerId[player.Id] = *record.Rules
+		}
+		if record.InviteCode != "" {
+			store.inviteCodeByPlayerId[player.Id] = record.InviteCode
+			store.playerIdByInviteCode[record.InviteCode] = player.Id
+		}
+		switch index := store.queued(player.Id); {
+		case index >= 0 && record.Ticket == nil:
+			store.queue = append(store.queue[:index:index], store.queue[index+1:]...)
+		case index >= 0:
+			store.queue[index] = *record.Ticket
+		case record.Ticket != nil:
+			store.queue = append(store.queue, *record.Ticket)
+		}
+	}
+	for _, record := range saved.Games {
+		store.putGame(record.game())
+	}
+	for _, record := range saved.Archived {
+		store.removeGame(record.Id)
+	}
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/danilopavk/battleshipper/archive"
+	"github.com/danilopavk/battleshipper/engine"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// failingSaver fails to save the changes while failing is set
+type failingSaver struct {
+	failing bool
+}
+
+func (saver *failingSaver) save(change change) error {
+	if saver.failing {
+		return errors.New("Disk is full")
+	}
+	return nil
+}
+
+func (saver *failingSaver) archived() ([]gameRecord, error) {
+	return nil, nil
+}
+
+func (saver *failingSaver) archivedGame(gameId int) (gameRecord, bool, error) {
+	return gameRecord{}, false, nil
+}
+
+func (saver *failingSaver) searchArchived(query archive.Query, finished []engine.Game) (archive.Page, error) {
+	return archive.Search(finished, query)
+}
+
+func Test_DurableStoreUndoesUnsavedChanges(t *testing.T) {
+	memory := InitializeStore()
+	saver := &failingSaver{}
+	durable := &durableStore{memory: &memory, saver: saver, name: "failing"}
+
+	karsa, _ := durable.StartGame("Karsa Orlong", 0)
+	game, _ := durable.JoinGame("Fiddler", 0, karsa.Id)
+	for _, player := range []engine.Player{game.PlayerA, game.PlayerB} {
+		if _, _, err := UpdateWithRetry(durable, player.Id, func(player *engine.Player, game *engine.Game) error {
+			return player.PlaceRandomly()
+		}); err != nil {
+			t.Fatalf("Cannot place ships: %v", err)
+		}
+	}
+	hedge, _ := durable.StartGame("Hedge", 0)
+	quickBen, _, _ := durable.FindMatch("Quick Ben", Ticket{Rules: engine.DefaultRules()})
+	before, _ := durable.Dump()
+
+	saver.failing = true
+	if _, _, err := durable.Shoot(karsa.Id, engine.Cell{X: 3, Y: 4}); err == nil {
+		t.Error("Expected the unsaved shot to fail")
+	}
+	hedge.Name = "Kalam"
+	if err := durable.UpdatePlayer(hedge); err == nil {
+		t.Error("Expected the unsaved update to fail")
+	}
+	if _, err := durable.JoinGame("Kalam", 0, hedge.Id); err == nil {
+		t.Error("Expected the unsaved join to fail")
+	}
+	if err := durable.CancelMatch(quickBen.Id); err == nil {
+		t.Error("Expected the unsaved cancel to fail")
+	}
+	if _, err := durable.StartGame("Tavore", 0); err == nil {
+		t.Error("Expected the unsaved start to fail")
+	}
+	if _, _, err := durable.FindMatch("Tavore", Ticket{Rules: engine.DefaultRules()}); err == nil {
+		t.Error("Expected the unsaved match to fail")
+	}
+	if _, _, err := durable.FindMatch("Tavore", Ticket{Rules: engine.RankedRules()}); err == nil {
+		t.Error("Expected the unsaved queueing to fail")
+	}
+	if games := durable.Matchmake(time.Now().Add(time.Hour)); len(games) != 0 {
+		t.Errorf("Expected no unsaved games, but got %v", games)
+	}
+	if removed := durable.RemoveWaiting(time.Now().Add(time.Hour), func(int) bool { return false }); len(removed) != 0 {
+		t.Errorf("Expected no unsaved removals, but got %v", removed)
+	}
+
+	after, _ := durable.Dump()
+	if diff := cmp.Diff(before, after, cmpopts.IgnoreFields(Dump{}, "DumpedAt"), cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Expected the unsaved changes to be undone (-want +got):\n%s", diff)
+	}
+
+	saver.failing = false
+	if _, _, err := durable.Shoot(karsa.Id, engine.Cell{X: 3, Y: 4}); err != nil {
+		t.Errorf("Cannot shoot once the store saves again: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"time"
+
+	"github.com/danilopavk/battleshipper/engine"
+)
+
+// RemoveWaiting removes the players who started waiting before the time, and returns them.
+//
+// Players in private games and in the matchmaking queue are removed too, as
+// if they never started waiting. Players keep returns true for are left
+// waiting no matter how long they wait, and keep can be nil.
+func (store *Store) RemoveWaiting(before time.Time, keep func(playerId int) bool) []engine.Player {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var removed []engine.Player
+	for playerId, since := range store.waitingSinceByPlayerId {
+		if !since.Before(before) || (keep != nil && keep(playerId)) {
+			continue
+		}
+		removed = append(removed, store.waitingPlayers[playerId].Clone())
+		store.forget(playerId)
+	}
+	return removed
+}
+
+// ArchiveFinished moves the games that ended before the time out of the started games, and returns them.
+//
+// Archived games are still among FinishedGames, but neither the games nor
+// their players can be found by their ids anymore. Memory is all the store
+// has, so only the last archiveLimit archived games are kept, and the older
+// ones are dropped for good.
+func (store *Store) ArchiveFinished(before time.Time) []engine.Game {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	games := store.removeFinished(before)
+
+	sortByEnd(games)
+	store.keepArchived(games...)
+
+	archived := make([]engine.Game, len(games))
+	for i, game := range games {
+		archived[i] = game.Clone()
+	}
+	return archived
+}
+
+// archiveLimit is how many archived games the in-memory store keeps
+const archiveLimit = 1000
+
+// keepArchived adds the games to the archive, dropping the earliest archived ones over the limit, and must be called with the lock held
+func (store *Store) keepArchived(games ...engine.Game) {
+	store.archive = append(store.archive, games...)
+	if excess := len(store.archive) - archiveLimit; excess > 0 {
+		clear(store.archive[:excess])
+		store.archive = store.archive[excess:]
+	}
+}
+
+// removeFinished removes the games that ended before the time from the started games, and returns them
+func (store *Store) removeFinished(before time.Time) []engine.Game {
+	var removed []engine.Game
+	for index := range store.shards {
+		shard := &store.shards[index]
+		shard.mutex.Lock()
+		for gameId, game := range shard.gamesByGameId {
+			if game.Winner != nil && game.EndedAt.Before(before) {
+				delete(shard.gamesByGameId, gameId)
+				removed = append(removed, game)
+			}
+		}
+		shard.mutex.Unlock()
+	}
+
+	for _, game := range removed {
+		store.unlinkPlayers(game)
+	}
+	return removed
+}
+
+// removeGame removes the started game by its id, and does nothing if there's no such game
+func (store *Store) removeGame(gameId int) {
+	shard := store.shardOf(gameId)
+	shard.mutex.Lock()
+	game, ok := shard.gamesByGameId[gameId]
+	delete(shard.gamesByGameId, gameId)
+	shard.mutex.Unlock()
+
+	if ok {
+		store.unlinkPlayers(game)
+	}
+}
+
+// unlinkPlayers forgets which game the players of the removed game played
+func (store *Store) unlinkPlayers(game engine.Game) {
+	for _, playerId := range []int{game.PlayerA.Id, game.PlayerB.Id} {
+		shard := store.shardOf(playerId)
+		shard.mutex.Lock()
+		delete(shard.gameIdByPlayerId, playerId)
+		shard.mutex.Unlock()
+	}
+}
@@ -0,0 +1,531 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/danilopavk/battleshipper/archive"
+	"github.com/danilopavk/battleshipper/engine"
+	"github.com/danilopavk/battleshipper/users"
+)
+
+// Files the file store keeps in its directory
+const (
+	journalName  = "journal.log"
+	snapshotName = "snapshot.json"
+	archiveName  = "archive.log"
+	botsName     = "bots.log"
+	usersName    = "users.log"
+)
+
+// snapshotVersion is the version of the snapshot format, increased whenever the format changes
+const snapshotVersion = 1
+
+// defaultSnapshotEvery is the number of journal records after which the snapshot is written, unless configured otherwise
+const defaultSnapshotEvery = 1000
+
+// SyncMode tells when the file store forces the written data to the disk
+type SyncMode int
+
+const (
+	// SyncEveryRecord syncs the journal after every record, so nothing that was saved is lost in a crash
+	SyncEveryRecord SyncMode = iota
+	// SyncOnSnapshot only syncs the snapshots, and leaves flushing the journal in between to the operating system
+	SyncOnSnapshot
+	// SyncNever never syncs, and is only meant for the tests
+	SyncNever
+)
+
+// FileOptions configure the file store.
+//
+// Sync defaults to SyncEveryRecord, and SnapshotEvery to defaultSnapshotEvery records.
+type FileOptions struct {
+	Sync          SyncMode
+	SnapshotEvery int
+}
+
+// FileStore keeps the games in memory, like the Store, and persists them in a directory on the local disk.
+//
+// Every change is appended to the journal as a single record with the new
+// state of the games and the waiting players it touched. Every SnapshotEvery
+// records, the whole state is written to the snapshot, and the journal starts
+// over. On startup, the snapshot is loaded and the journal replayed on top of
+// it. A torn final record, left by a crash in the middle of the write, is
+// dropped, since the change it describes was never acknowledged. Archived
+// games are appended to the archive, one per line, and aren't loaded on
+// startup, only cut back to the last complete line. Bot registrations are
+// appended to their own file the same way, removals included, and so are the
+// new and the changed users.
+type FileStore struct {
+	durableStore
+	dir     string
+	options FileOptions
+	saving  sync.Mutex
+	journal *os.File
+	archive *os.File
+	bots    *os.File
+	users   *os.File
+	records int
+}
+
+// botLine is a single line of the bots file, either the saved registration or the removal of the bot
+type botLine struct {
+	BotRecord
+	Removed bool `json:"removed,omitempty"`
+}
+
+// snapshot is the whole state of the store, with the waiting players in the order they started waiting
+type snapshot struct {
+	Version int             `json:"version"`
+	Waiting []waitingRecord `json:"waiting"`
+	Games   []gameRecord    `json:"games"`
+}
+
+var _ GameRepository = (*FileStore)(nil)
+
+// InitializeFileStore opens the file store in the directory, creating the directory if it doesn't exist.
+//
+// Games and waiting players saved in the directory before are restored.
+// Returns error if the snapshot or the journal is corrupted anywhere other
+// than in the final record.
+func InitializeFileStore(dir string, options FileOptions) (*FileStore, error) {
+	if options.SnapshotEvery <= 0 {
+		options.SnapshotEvery = defaultSnapshotEvery
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("Cannot create store directory %v: %w", dir, err)
+	}
+
+	memory := InitializeStore()
+	fileStore := &FileStore{dir: dir, options: options}
+	fileStore.memory = &memory
+	fileStore.saver = fileStore
+	fileStore.name = dir
+	if err := fileStore.loadSnapshot(); err != nil {
+		return nil, err
+	}
+
+	journal, err := os.OpenFile(filepath.Join(dir, journalName), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open journal: %w", err)
+	}
+	fileStore.journal = journal
+	if err := fileStore.replay(); err != nil {
+		journal.Close()
+		return nil, err
+	}
+
+	if fileStore.archive, err = openLog(dir, archiveName, "archive"); err != nil {
+		journal.Close()
+		return nil, err
+	}
+	if fileStore.bots, err = openLog(dir, botsName, "bots"); err != nil {
+		journal.Close()
+		fileStore.archive.Close()
+		return nil, err
+	}
+	if fileStore.users, err = openLog(dir, usersName, "users"); err != nil {
+		journal.Close()
+		fileStore.archive.Close()
+		fileStore.bots.Close()
+		return nil, err
+	}
+	return fileStore, nil
+}
+
+// openLog opens the file the lines are appended to, and cuts off its torn final line
+func openLog(dir string, fileName string, name string) (*os.File, error) {
+	file, err := os.OpenFile(filepath.Join(dir, fileName), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open %v: %w", name, err)
+	}
+	if err := repairLog(file, name); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Close writes the snapshot, so the next start doesn't have to replay the journal, and closes the journal
+func (fileStore *FileStore) Close() error {
+	fileStore.mutex.Lock()
+	defer fileStore.mutex.Unlock()
+
+	defer fileStore.archive.Close()
+	defer fileStore.bots.Close()
+	defer fileStore.users.Close()
+	if err := fileStore.snapshot(); err != nil {
+		fileStore.journal.Close()
+		return err
+	}
+	return fileStore.journal.Close()
+}
+
+// SaveBot appends the registration of the bot to the bots file
+func (fileStore *FileStore) SaveBot(bot BotRecord) error {
+	fileStore.saving.Lock()
+	defer fileStore.saving.Unlock()
+
+	if err := fileStore.append(fileStore.bots, botLine{BotRecord: bot}); err != nil {
+		return fmt.Errorf("Cannot save bot %d: %w", bot.PlayerId, err)
+	}
+	return nil
+}
+
+// RemoveBot appends the removal of the bot to the bots file
+func (fileStore *FileStore) RemoveBot(playerId int) error {
+	fileStore.saving.Lock()
+	defer fileStore.saving.Unlock()
+
+	if err := fileStore.append(fileStore.bots, botLine{BotRecord: BotRecord{PlayerId: playerId}, Removed: true}); err != nil {
+		return fmt.Errorf("Cannot remove bot %d: %w", playerId, err)
+	}
+	return nil
+}
+
+// Bots returns the registrations of all the saved bots, replaying the bots file from the start
+func (fileStore *FileStore) Bots() ([]BotRecord, error) {
+	fileStore.saving.Lock()
+	defer fileStore.saving.Unlock()
+
+	data, err := os.ReadFile(filepath.Join(fileStore.dir, botsName))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read bots: %w", err)
+	}
+	var saved []BotRecord
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var bot botLine
+		if !decodeLine(line, &bot) {
+			continue
+		}
+		saved = slices.DeleteFunc(saved, func(other BotRecord) bool {
+			return other.PlayerId == bot.PlayerId
+		})
+		if !bot.Removed {
+			saved = append(saved, bot.BotRecord)
+		}
+	}
+	return saved, nil
+}
+
+// SaveUser appends the new or the changed user to the users file
+func (fileStore *FileStore) SaveUser(user users.User) error {
+	fileStore.saving.Lock()
+	defer fileStore.saving.Unlock()
+
+	if err := fileStore.append(fileStore.users, user); err != nil {
+		return fmt.Errorf("Cannot save user %d: %w", user.Id, err)
+	}
+	return nil
+}
+
+// Users returns all the saved users, in the order they registered, replaying the users file from the start
+func (fileStore *FileStore) Users() ([]users.User, error) {
+	fileStore.saving.Lock()
+	defer fileStore.saving.Unlock()
+
+	data, err := os.ReadFile(filepath.Join(fileStore.dir, usersName))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read users: %w", err)
+	}
+	var saved []users.User
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var user users.User
+		if !decodeLine(line, &user) {
+			continue
+		}
+		index := slices.IndexFunc(saved, func(other users.User) bool {
+			return other.Id == user.Id
+		})
+		if index < 0 {
+			saved = append(saved, user)
+		} else {
+			saved[index] = user
+		}
+	}
+	return saved, nil
+}
+
+// save appends the change to the journal, and writes the snapshot once the journal is long enough.
+//
+// Archived games are appended to the archive first, so a crash can only
+// leave them both archived and started, to be archived again.
+func (fileStore *FileStore) save(change change) error {
+	fileStore.saving.Lock()
+	defer fileStore.saving.Unlock()
+
+	for _, record := range change.Archived {
+		if err := fileStore.append(fileStore.archive, record); err != nil {
+			return fmt.Errorf("Cannot write archived game %d: %w", record.Id, err)
+		}
+	}
+	if err := fileStore.append(fileStore.journal, change); err != nil {
+		return fmt.Errorf("Cannot write journal record: %w", err)
+	}
+
+	fileStore.records++
+	if fileStore.records >= fileStore.options.SnapshotEvery {
+		return fileStore.snapshot()
+	}
+	return nil
+}
+
+// archived reads the archived games, skipping the torn lines, and the duplicates left by a crash
+func (fileStore *FileStore) archived() ([]gameRecord, error) {
+	data, err := os.ReadFile(filepath.Join(fileStore.dir, archiveName))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read archive: %w", err)
+	}
+
+	var records []gameRecord
+	indexByGameId := map[int]int{}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var record gameRecord
+		if !decodeLine(line, &record) {
+			continue
+		}
+		if index, ok := indexByGameId[record.Id]; ok {
+			records[index] = record
+			continue
+		}
+		indexByGameId[record.Id] = len(records)
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// archivedGame reads the archived game by its id, reading the whole archive, which has no index
+func (fileStore *FileStore) archivedGame(gameId int) (gameRecord, bool, error) {
+	records, err := fileStore.archived()
+	if err != nil {
+		return gameRecord{}, false, err
+	}
+	for _, record := range records {
+		if record.Id == gameId {
+			return record, true, nil
+		}
+	}
+	return gameRecord{}, false, nil
+}
+
+// searchArchived finds the games matching the query among the finished games and the whole archive, which has no index
+func (fileStore *FileStore) searchArchived(query archive.Query, finished []engine.Game) (archive.Page, error) {
+	records, err := fileStore.archived()
+	if err != nil {
+		return archive.Page{}, err
+	}
+	for _, record := range records {
+		finished = append(finished, record.game())
+	}
+	return archive.Search(finished, query)
+}
+
+// repairLog cuts off everything after the last complete line of the file, like the torn final line.
+//
+// Otherwise the next line would be appended to the torn one, and be skipped
+// together with it when read.
+func repairLog(file *os.File, name string) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("Cannot read %v: %w", name, err)
+	}
+	reader := bufio.NewReader(file)
+
+	offset, end := int64(0), int64(0)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Cannot read %v: %w", name, err)
+		}
+		offset += int64(len(line))
+		var value json.RawMessage
+		if decodeLine(line, &value) {
+			end = offset
+		}
+	}
+	if err := file.Truncate(end); err != nil {
+		return fmt.Errorf("Cannot drop torn line of %v: %w", name, err)
+	}
+	return nil
+}
+
+// append writes the value to the file as a single line, with its checksum
+func (fileStore *FileStore) append(file *os.File, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(fmt.Appendf(nil, "%08x %s\n", crc32.ChecksumIEEE(data), data)); err != nil {
+		return err
+	}
+	if fileStore.options.Sync == SyncEveryRecord {
+		return file.Sync()
+	}
+	return nil
+}
+
+// snapshot writes the whole state to the snapshot file, and empties the journal.
+//
+// The snapshot replaces the previous one only once it's completely written,
+// so a crash leaves either the old or the new snapshot in place. Must be
+// called while nothing else is saved.
+func (fileStore *FileStore) snapshot() error {
+	data, err := json.Marshal(fileStore.memory.snapshot())
+	if err != nil {
+		return fmt.Errorf("Cannot encode snapshot: %w", err)
+	}
+
+	path := filepath.Join(fileStore.dir, snapshotName)
+	if err := fileStore.writeFile(path+".tmp", data); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("Cannot replace snapshot: %w", err)
+	}
+	if fileStore.options.Sync != SyncNever {
+		if err := syncDir(fileStore.dir); err != nil {
+			return err
+		}
+	}
+
+	if err := fileStore.journal.Truncate(0); err != nil {
+		return fmt.Errorf("Cannot empty journal: %w", err)
+	}
+	fileStore.records = 0
+	return nil
+}
+
+func (fileStore *FileStore) writeFile(path string, data []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("Cannot create %v: %w", path, err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return fmt.Errorf("Cannot write %v: %w", path, err)
+	}
+	if fileStore.options.Sync != SyncNever {
+		if err := file.Sync(); err != nil {
+			return fmt.Errorf("Cannot sync %v: %w", path, err)
+		}
+	}
+	return file.Close()
+}
+
+// loadSnapshot restores the state from the snapshot, if there is one
+func (fileStore *FileStore) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(fileStore.dir, snapshotName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot read snapshot: %w", err)
+	}
+
+	var loaded snapshot
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("Cannot decode snapshot: %w", err)
+	}
+	if loaded.Version > snapshotVersion {
+		return fmt.Errorf("Snapshot version %d is newer than the supported version %d", loaded.Version, snapshotVersion)
+	}
+	fileStore.memory.apply(change{Waiting: loaded.Waiting, Games: loaded.Games})
+	return nil
+}
+
+// replay applies the records from the journal, and cuts off the torn final record if there is one
+func (fileStore *FileStore) replay() error {
+	if _, err := fileStore.journal.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("Cannot read journal: %w", err)
+	}
+	reader := bufio.NewReader(fileStore.journal)
+
+	offset := int64(0)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) == 0 {
+				return nil
+			}
+			// the final record was only partially written
+			return fileStore.cut(offset)
+		}
+		if err != nil {
+			return fmt.Errorf("Cannot read journal: %w", err)
+		}
+
+		var saved change
+		if !decodeLine(line, &saved) {
+			if _, err := reader.Peek(1); errors.Is(err, io.EOF) {
+				return fileStore.cut(offset)
+			}
+			return fmt.Errorf("Journal is corrupted at record %d", fileStore.records+1)
+		}
+		fileStore.memory.apply(saved)
+		fileStore.records++
+		offset += int64(len(line))
+	}
+}
+
+// cut drops everything from the journal after the offset
+func (fileStore *FileStore) cut(offset int64) error {
+	if err := fileStore.journal.Truncate(offset); err != nil {
+		return fmt.Errorf("Cannot drop torn journal record: %w", err)
+	}
+	return nil
+}
+
+// decodeLine checks the checksum of the line written by append, and decodes the value from it
+func decodeLine(line []byte, value any) bool {
+	checksum, data, found := bytes.Cut(bytes.TrimSuffix(line, []byte("\n")), []byte(" "))
+	if !found || string(checksum) != fmt.Sprintf("%08x", crc32.ChecksumIEEE(data)) {
+		return false
+	}
+	return json.Unmarshal(data, value) == nil
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("Cannot open %v: %w", dir, err)
+	}
+	defer file.Close()
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("Cannot sync %v: %w", dir, err)
+	}
+	return nil
+}
+
+// snapshot returns the whole state of the store
+func (store *Store) snapshot() snapshot {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return snapshot{Version: snapshotVersion, Waiting: store.waitingRecords(), Games: store.gameRecords()}
+}
+
+// waitingRecords returns all the waiting players in the order they started waiting, and must be called with the lock held
+func (store *Store) waitingRecords() []waitingRecord {
+	records := []waitingRecord{}
+	for playerId := range store.waitingPlayers {
+		record, _ := store.waitingRecordOf(playerId)
+		records = append(records, record)
+	}
+	slices.SortFunc(records, func(a, b waitingRecord) int {
+		return a.Since.Compare(b.Since)
+	})
+	return records
+}
@@ -0,0 +1,237 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danilopavk/battleshipper/engine"
+	"github.com/danilopavk/battleshipper/users"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func Test_FileStoreRestoresAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	fileStore := openFileStore(t, dir, FileOptions{})
+
+	karsa, _ := fileStore.StartGameWithRules("Karsa Orlong", 0, engine.RankedRules())
+	game, _ := fileStore.JoinGame("Fiddler", 0, karsa.Id)
+	_ = game.PlayerA.PlaceRandomly()
+	_ = game.PlayerB.PlaceRandomly()
+	_, _, _, _ = game.Shoot(karsa.Id, engine.Cell{X: 3, Y: 4})
+	_ = fileStore.UpdateGame(game)
+	game, _ = fileStore.GetGame(game.Id)
+	hedge, code, _ := fileStore.StartPrivateGame("Hedge", 0)
+	quickBen, _, _ := fileStore.FindMatch("Quick Ben", Ticket{Rules: engine.DefaultRules()})
+
+	// the first store is never closed, like when the process crashes
+	restored := openFileStore(t, dir, FileOptions{})
+
+	restoredGame, err := restored.GetGame(game.Id)
+	if err != nil {
+		t.Fatalf("Cannot find the game after restart: %v", err)
+	}
+	if diff := cmp.Diff(game, restoredGame, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected game after restart (-want +got):\n%s", diff)
+	}
+	if restoredCode, ok := restored.InviteCode(hedge.Id); !ok || restoredCode != code {
+		t.Errorf("Expected Hedge to wait with invite code %v, but got %v", code, restoredCode)
+	}
+	if !restored.Queued(quickBen.Id) {
+		t.Error("Expected Quick Ben to wait in the matchmaking queue")
+	}
+	if len(restored.AllWaitingPlayers()) != 0 {
+		t.Error("Expected no listed waiting players")
+	}
+}
+
+func Test_FileStoreSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	fileStore := openFileStore(t, dir, FileOptions{Sync: SyncOnSnapshot, SnapshotEvery: 2})
+
+	karsa, _ := fileStore.StartGame("Karsa Orlong", 0)
+	game, _ := fileStore.JoinGame("Fiddler", 0, karsa.Id)
+	hedge, _ := fileStore.StartGame("Hedge", 0)
+
+	if _, err := os.Stat(filepath.Join(dir, snapshotName)); err != nil {
+		t.Errorf("Expected the snapshot to be written: %v", err)
+	}
+	if journal, _ := os.ReadFile(filepath.Join(dir, journalName)); len(journal) == 0 {
+		t.Error("Expected the change after the snapshot to be in the journal")
+	}
+
+	restored := openFileStore(t, dir, FileOptions{})
+	if _, err := restored.GetGame(game.Id); err != nil {
+		t.Errorf("Cannot find the game from the snapshot: %v", err)
+	}
+	if _, waiting := restored.WaitingSince(hedge.Id); !waiting {
+		t.Error("Expected Hedge from the journal to be waiting")
+	}
+	if _, waiting := restored.WaitingSince(karsa.Id); waiting {
+		t.Error("Expected Karsa to stop waiting")
+	}
+}
+
+func Test_FileStoreDropsTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	fileStore := openFileStore(t, dir, FileOptions{})
+	karsa, _ := fileStore.StartGame("Karsa Orlong", 0)
+	appendToJournal(t, dir, `1a2b3c4d {"waiting":[{"player":{"id":12`)
+
+	restored := openFileStore(t, dir, FileOptions{})
+	if _, waiting := restored.WaitingSince(karsa.Id); !waiting {
+		t.Error("Expected Karsa to be restored")
+	}
+
+	fiddler, _ := restored.StartGame("Fiddler", 0)
+	restoredAgain := openFileStore(t, dir, FileOptions{})
+	if len(restoredAgain.AllWaitingPlayers()) != 2 {
+		t.Error("Expected the records written after the torn one to be restored")
+	}
+	if _, waiting := restoredAgain.WaitingSince(fiddler.Id); !waiting {
+		t.Error("Expected Fiddler to be restored")
+	}
+}
+
+func Test_FileStoreRejectsCorruptedRecord(t *testing.T) {
+	dir := t.TempDir()
+	fileStore := openFileStore(t, dir, FileOptions{})
+	fileStore.StartGame("Karsa Orlong", 0)
+	appendToJournal(t, dir, "00000000 {}\n")
+	fileStore.StartGame("Fiddler", 0)
+
+	if _, err := InitializeFileStore(dir, FileOptions{}); err == nil {
+		t.Error("Expected error for the corrupted record in the middle of the journal")
+	}
+}
+
+func Test_FileStoreArchive(t *testing.T) {
+	dir := t.TempDir()
+	fileStore := openFileStore(t, dir, FileOptions{})
+
+	karsa, _ := fileStore.StartGame("Karsa Orlong", 0)
+	game, _ := fileStore.JoinGame("Fiddler", 0, karsa.Id)
+	_ = game.Forfeit(karsa.Id)
+	_ = fileStore.UpdateGame(game)
+	archived := fileStore.ArchiveFinished(time.Now().Add(time.Minute))
+	// a crash right after writing the archive leaves the same game there twice
+	_ = fileStore.append(fileStore.archive, recordOfGame(archived[0]))
+	appendToLog(t, dir, archiveName, "0badc0de {\"id\": 1")
+
+	restored := openFileStore(t, dir, FileOptions{})
+	if _, err := restored.GetGame(game.Id); err == nil {
+		t.Error("Expected the archived game to stay out of the started games after restart")
+	}
+	finished := restored.FinishedGames()
+	if len(finished) != 1 {
+		t.Fatalf("Expected the archived game to be read once, but got %v", finished)
+	}
+	if diff := cmp.Diff(archived[0], finished[0], cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected archived game (-want +got):\n%s", diff)
+	}
+}
+
+func Test_FileStoreArchiveAfterTornLine(t *testing.T) {
+	dir := t.TempDir()
+	fileStore := openFileStore(t, dir, FileOptions{})
+	first := finishedGame(fileStore, "Karsa Orlong", "Fiddler")
+	fileStore.ArchiveFinished(time.Now().Add(time.Minute))
+	appendToLog(t, dir, archiveName, "0badc0de {\"id\": 1")
+
+	restored := openFileStore(t, dir, FileOptions{})
+	second := finishedGame(restored, "Hedge", "Quick Ben")
+	restored.ArchiveFinished(time.Now().Add(time.Minute))
+
+	restoredAgain := openFileStore(t, dir, FileOptions{})
+	for _, game := range []engine.Game{first, second} {
+		if _, err := restoredAgain.FinishedGame(game.Id); err != nil {
+			t.Errorf("Expected the archived game %d to be read after restart, but got %v", game.Id, err)
+		}
+	}
+}
+
+func Test_FileStoreBots(t *testing.T) {
+	dir := t.TempDir()
+	fileStore := openFileStore(t, dir, FileOptions{})
+	kruppe := BotRecord{PlayerId: 1, Name: "Kruppe", CallbackURL: "https://kruppe.example.com/shot"}
+	baruk := BotRecord{PlayerId: 2, Name: "Baruk", CallbackURL: "https://baruk.example.com/shot"}
+	_ = fileStore.SaveBot(kruppe)
+	_ = fileStore.SaveBot(baruk)
+	_ = fileStore.RemoveBot(baruk.PlayerId)
+	fileStore.Close()
+	appendToLog(t, dir, botsName, "0badc0de {\"playerId\": 3")
+
+	restored := openFileStore(t, dir, FileOptions{})
+	kruppe.CallbackURL = "https://kruppe.example.com/move"
+	_ = restored.SaveBot(kruppe)
+	saved, err := restored.Bots()
+	if err != nil {
+		t.Fatalf("Cannot read bots: %v", err)
+	}
+	if diff := cmp.Diff([]BotRecord{kruppe}, saved); diff != "" {
+		t.Errorf("Expected only the latest registration of Kruppe after the torn line (-want +got):\n%s", diff)
+	}
+}
+
+func Test_FileStoreUsers(t *testing.T) {
+	dir := t.TempDir()
+	fileStore := openFileStore(t, dir, FileOptions{})
+	ganoes := users.User{Id: 1, Username: "ganoes", DisplayName: "Ganoes Paran", PasswordHash: []byte("hash"), CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	tavore := users.User{Id: 2, Username: "tavore", DisplayName: "Tavore", PasswordHash: []byte("hash"), CreatedAt: time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)}
+	_ = fileStore.SaveUser(ganoes)
+	_ = fileStore.SaveUser(tavore)
+	fileStore.Close()
+	appendToLog(t, dir, usersName, "0badc0de {\"Id\": 3")
+
+	restored := openFileStore(t, dir, FileOptions{})
+	ganoes.DisplayName = "Captain Paran"
+	_ = restored.SaveUser(ganoes)
+	saved, err := restored.Users()
+	if err != nil {
+		t.Fatalf("Cannot read users: %v", err)
+	}
+	if diff := cmp.Diff([]users.User{ganoes, tavore}, saved); diff != "" {
+		t.Errorf("Expected the latest version of every user after the torn line (-want +got):\n%s", diff)
+	}
+}
+
+func openFileStore(t *testing.T, dir string, options FileOptions) *FileStore {
+	fileStore, err := InitializeFileStore(dir, options)
+	if err != nil {
+		t.Fatalf("Cannot open file store: %v", err)
+	}
+	return fileStore
+}
+
+// finishedGame starts the game between the two players, and forfeits it for the first one
+func finishedGame(repository GameRepository, nameA string, nameB string) engine.Game {
+	host, _ := repository.StartGame(nameA, 0)
+	game, _ := repository.JoinGame(nameB, 0, host.Id)
+	_ = game.Forfeit(host.Id)
+	_ = repository.UpdateGame(game)
+	return game
+}
+
+func appendToJournal(t *testing.T, dir string, data string) {
+	journal, err := os.OpenFile(filepath.Join(dir, journalName), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("Cannot open journal: %v", err)
+	}
+	defer journal.Close()
+	if _, err := journal.WriteString(data); err != nil {
+		t.Fatalf("Cannot write journal: %v", err)
+	}
+}
+
+func appendToLog(t *testing.T, dir string, name string, data string) {
+	log, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("Cannot open %v: %v", name, err)
+	}
+	defer log.Close()
+	if _, err := log.WriteString(data); err != nil {
+		t.Fatalf("Cannot write %v: %v", name, err)
+	}
+}
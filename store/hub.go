@@ -0,0 +1,234 @@
+package store
+
+import (
+	"sync"
+
+	"github.com/danilopavk/battleshipper/engine"
+)
+
+// EventType tells what happened in the game
+type EventType string
+
+const (
+	OpponentJoined EventType = "opponent-joined"
+	ShotFired      EventType = "shot-fired"
+	YourTurn       EventType = "your-turn"
+	GameOver       EventType = "game-over"
+
+	SpectatorsChanged EventType = "spectators-changed"
+	SpectatingChanged EventType = "spectating-changed"
+)
+
+// replayed checks whether the events of the type are kept for the subscribers resuming later.
+//
+// Spectator events only tell the current state, which the clients read again
+// anyway, so they're left out, and the spectators coming and going can't push
+// the moves the players need out of the history.
+func (eventType EventType) replayed() bool {
+	return eventType != SpectatorsChanged && eventType != SpectatingChanged
+}
+
+// subscriberBuffer is the number of events a slow subscriber can fall behind before the events get dropped
+const subscriberBuffer = 16
+
+// historySize is the number of the latest events kept per topic, so the subscribers can resume after reconnecting
+const historySize = 64
+
+// Event is a notification about a change in the game.
+//
+// Id grows with every published event. PlayerId is the player the event is about:
+// the one who joined, shot, whose turn it is, or who won. Shot is only set for
+// ShotFired events, and Spectators for SpectatorsChanged events.
+type Event struct {
+	Id         int          `json:"id"`
+	Type       EventType    `json:"type"`
+	GameId     int          `json:"gameId"`
+	PlayerId   int          `json:"playerId"`
+	Shot       *engine.Shot `json:"shot,omitempty"`
+	Spectators *int         `json:"spectators,omitempty"`
+}
+
+// Hub type delivers events about the games to everyone who subscribed to them.
+//
+// Events are published on topics, and every game is published both on its own
+// id, and on the ids of both players, so players can follow their game before
+// it even starts. The latest events of every topic are kept, so subscribers
+// that lost the connection can continue where they left off. Besides the
+// subscribers, the hub calls the listeners registered with OnGameOver for
+// every finished game.
+type Hub struct {
+	mutex         sync.Mutex
+	lastId        int
+	subscribers   map[int]map[chan Event]bool
+	history       map[int][]Event
+	overListeners []func(engine.Game)
+}
+
+// InitializeHub builds the hub without any subscribers
+func InitializeHub() Hub {
+	return Hub{subscribers: map[int]map[chan Event]bool{}, history: map[int][]Event{}}
+}
+
+// Subscribe starts listening to the events on the topic.
+//
+// Returns the channel with the events, and the function that must be called
+// once the subscriber isn't interested in events anymore.
+func (hub *Hub) Subscribe(topic int) (<-chan Event, func()) {
+	return hub.SubscribeFrom(topic, -1)
+}
+
+// SubscribeFrom starts listening to the events on the topic, starting with the events after lastId.
+//
+// Events published before the subscription are only replayed if they are
+// still kept in the history of the topic. Negative lastId skips the replay.
+func (hub *Hub) SubscribeFrom(topic int, lastId int) (<-chan Event, func()) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	var missed []Event
+	if lastId >= 0 {
+		for _, event := range hub.history[topic] {
+			if event.Id > lastId {
+				missed = append(missed, event)
+			}
+		}
+	}
+
+	events := make(chan Event, subscriberBuffer+len(missed))
+	for _, event := range missed {
+		events <- event
+	}
+	if hub.subscribers[topic] == nil {
+		hub.subscribers[topic] = map[chan Event]bool{}
+	}
+	hub.subscribers[topic][events] = true
+
+	unsubscribe := func() {
+		hub.mutex.Lock()
+		defer hub.mutex.Unlock()
+
+		if _, ok := hub.subscribers[topic][events]; !ok {
+			return
+		}
+		delete(hub.subscribers[topic], events)
+		if len(hub.subscribers[topic]) == 0 {
+			delete(hub.subscribers, topic)
+		}
+		close(events)
+	}
+	return events, unsubscribe
+}
+
+// Publish sends the event to all subscribers of the topic.
+//
+// It never blocks: subscribers that don't keep up miss the events.
+func (hub *Hub) Publish(topic int, event Event) {
+	hub.publish([]int{topic}, event)
+}
+
+// Forget drops the events kept for the topics, like the ones of the archived games.
+//
+// Current subscribers keep their subscriptions, only the replay is gone.
+func (hub *Hub) Forget(topics ...int) {
+	if hub == nil {
+		return
+	}
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	for _, topic := range topics {
+		delete(hub.history, topic)
+	}
+}
+
+// PublishJoined notifies that the second player joined the game
+func (hub *Hub) PublishJoined(game engine.Game) {
+	hub.publishGame(game, Event{Type: OpponentJoined, GameId: game.Id, PlayerId: game.PlayerB.Id})
+}
+
+// PublishReady notifies the player whose turn it is that shooting can start.
+//
+// Nothing is published while any of the players is still placing ships.
+func (hub *Hub) PublishReady(game engine.Game) {
+	if len(*game.PlayerA.Ships) != engine.FleetSize || len(*game.PlayerB.Ships) != engine.FleetSize || len(game.History) != 0 {
+		return
+	}
+	hub.publishGame(game, Event{Type: YourTurn, GameId: game.Id, PlayerId: *game.Turn})
+}
+
+// PublishShot notifies about the shot, followed by either the turn change or the end of the game
+func (hub *Hub) PublishShot(game engine.Game, shot engine.Shot) {
+	hub.publishGame(game, Event{Type: ShotFired, GameId: game.Id, PlayerId: shot.PlayerId, Shot: &shot})
+	if game.Winner != nil {
+		hub.PublishOver(game)
+		return
+	}
+	hub.publishGame(game, Event{Type: YourTurn, GameId: game.Id, PlayerId: *game.Turn})
+}
+
+// OnGameOver registers the listener called with every game that ends.
+//
+// Listener is called in the goroutine that published the end of the game,
+// no matter if the game was won, resigned or forfeited.
+func (hub *Hub) OnGameOver(listener func(engine.Game)) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	hub.overListeners = append(hub.overListeners, listener)
+}
+
+// PublishOver notifies that the game ended, with the winner in the event's player id
+func (hub *Hub) PublishOver(game engine.Game) {
+	if game.Winner == nil || hub == nil {
+		return
+	}
+	hub.publishGame(game, Event{Type: GameOver, GameId: game.Id, PlayerId: *game.Winner})
+
+	hub.mutex.Lock()
+	listeners := append([]func(engine.Game){}, hub.overListeners...)
+	hub.mutex.Unlock()
+	for _, listener := range listeners {
+		listener(game)
+	}
+}
+
+// PublishSpectators notifies about the new number of spectators watching the game
+func (hub *Hub) PublishSpectators(game engine.Game, spectators int) {
+	hub.publishGame(game, Event{Type: SpectatorsChanged, GameId: game.Id, Spectators: &spectators})
+}
+
+// PublishSpectating notifies that the host opened or closed spectating, with the host in the event's player id
+func (hub *Hub) PublishSpectating(game engine.Game) {
+	hub.publishGame(game, Event{Type: SpectatingChanged, GameId: game.Id, PlayerId: game.PlayerA.Id})
+}
+
+func (hub *Hub) publishGame(game engine.Game, event Event) {
+	hub.publish([]int{game.Id, game.PlayerA.Id, game.PlayerB.Id}, event)
+}
+
+func (hub *Hub) publish(topics []int, event Event) {
+	if hub == nil {
+		return
+	}
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	hub.lastId++
+	event.Id = hub.lastId
+
+	for _, topic := range topics {
+		if event.Type.replayed() {
+			hub.history[topic] = append(hub.history[topic], event)
+			if len(hub.history[topic]) > historySize {
+				hub.history[topic] = hub.history[topic][1:]
+			}
+		}
+
+		for events := range hub.subscribers[topic] {
+			select {
+			case events <- event:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/danilopavk/battleshipper/engine"
+)
+
+func Test_PublishAndSubscribe(t *testing.T) {
+	hub := InitializeHub()
+	events, unsubscribe := hub.Subscribe(1)
+
+	hub.Publish(1, Event{Type: ShotFired, GameId: 7})
+	hub.Publish(2, Event{Type: GameOver, GameId: 8})
+
+	event := <-events
+	if event.Type != ShotFired || event.GameId != 7 {
+		t.Errorf("Unexpected event %v", event)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("Expected no more events, but got %v", event)
+	default:
+	}
+
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Error("Expected events to be closed after unsubscribe")
+	}
+}
+
+func Test_PublishDoesNotBlock(t *testing.T) {
+	hub := InitializeHub()
+	_, unsubscribe := hub.Subscribe(1)
+	defer unsubscribe()
+
+	for i := 0; i < subscriberBuffer*2; i++ {
+		hub.Publish(1, Event{Type: ShotFired})
+	}
+}
+
+func Test_PublishShotReachesBothPlayers(t *testing.T) {
+	hub := InitializeHub()
+	store := InitializeStore()
+	karsa, _ := store.StartGame("Karsa Orlong", 0)
+	game, _ := store.JoinGame("Fiddler", 0, karsa.Id)
+
+	karsaEvents, unsubscribeKarsa := hub.Subscribe(karsa.Id)
+	defer unsubscribeKarsa()
+	fiddlerEvents, unsubscribeFiddler := hub.Subscribe(game.PlayerB.Id)
+	defer unsubscribeFiddler()
+
+	*game.Turn = game.PlayerB.Id
+	hub.PublishShot(game, engine.Shot{PlayerId: karsa.Id, Cell: engine.Cell{X: 1, Y: 1}})
+
+	for _, events := range []<-chan Event{karsaEvents, fiddlerEvents} {
+		if event := <-events; event.Type != ShotFired || event.Shot.Cell != (engine.Cell{X: 1, Y: 1}) {
+			t.Errorf("Expected shot fired event, but got %v", event)
+		}
+		if event := <-events; event.Type != YourTurn || event.PlayerId != game.PlayerB.Id {
+			t.Errorf("Expected fiddler's turn event, but got %v", event)
+		}
+	}
+}
+
+func Test_SubscribeFromReplaysMissedEvents(t *testing.T) {
+	hub := InitializeHub()
+	hub.Publish(1, Event{Type: OpponentJoined})
+	hub.Publish(1, Event{Type: YourTurn})
+	hub.Publish(2, Event{Type: GameOver})
+
+	events, unsubscribe := hub.SubscribeFrom(1, 1)
+	defer unsubscribe()
+
+	if event := <-events; event.Type != YourTurn || event.Id != 2 {
+		t.Errorf("Expected to replay your turn event, but got %v", event)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("Expected no more events, but got %v", event)
+	default:
+	}
+}
+
+func Test_SpectatorEventsAreNotReplayed(t *testing.T) {
+	hub := InitializeHub()
+	store := InitializeStore()
+	karsa, _ := store.StartGame("Karsa Orlong", 0)
+	game, _ := store.JoinGame("Fiddler", 0, karsa.Id)
+	hub.PublishShot(game, engine.Shot{PlayerId: karsa.Id, Cell: engine.Cell{X: 1, Y: 1}})
+	for spectators := range historySize * 2 {
+		hub.PublishSpectators(game, spectators)
+	}
+	hub.PublishSpectating(game)
+
+	events, unsubscribe := hub.SubscribeFrom(game.PlayerB.Id, 0)
+	defer unsubscribe()
+
+	if event := <-events; event.Type != ShotFired {
+		t.Errorf("Expected to replay the shot fired event, but got %v", event)
+	}
+	if event := <-events; event.Type != YourTurn {
+		t.Errorf("Expected to replay the your turn event, but got %v", event)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("Expected no spectator events replayed, but got %v", event)
+	default:
+	}
+}
+
+func Test_Forget(t *testing.T) {
+	hub := InitializeHub()
+	hub.Publish(1, Event{Type: GameOver})
+	hub.Publish(2, Event{Type: GameOver})
+
+	hub.Forget(1)
+
+	forgotten, unsubscribe := hub.SubscribeFrom(1, 0)
+	defer unsubscribe()
+	select {
+	case event := <-forgotten:
+		t.Errorf("Expected no events of the forgotten topic, but got %v", event)
+	default:
+	}
+	kept, unsubscribeKept := hub.SubscribeFrom(2, 0)
+	defer unsubscribeKept()
+	if event := <-kept; event.Type != GameOver {
+		t.Errorf("Expected to replay the event of the other topic, but got %v", event)
+	}
+}
+
+func Test_OnGameOver(t *testing.T) {
+	hub := InitializeHub()
+	var ended []int
+	hub.OnGameOver(func(game engine.Game) { ended = append(ended, game.Id) })
+
+	game := engine.InitializeGame(engine.InitializePlayer("Kalam"), engine.InitializePlayer("Quick Ben"), 0)
+	hub.PublishOver(game)
+	if len(ended) != 0 {
+		t.Errorf("Expected no listener calls before the game is over, but got %v", ended)
+	}
+
+	game.Forfeit(game.PlayerA.Id)
+	hub.PublishOver(game)
+	if len(ended) != 1 || ended[0] != game.Id {
+		t.Errorf("Expected the listener to be called with game %d, but got %v", game.Id, ended)
+	}
+}
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// inviteAlphabet leaves out the characters that are easy to mix up, like 0 and O, or 1 and I
+const inviteAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// inviteLength is the number of characters in the invite code
+const inviteLength = 6
+
+// inviteAttempts is how many times a new code is generated before giving up, if the codes collide
+const inviteAttempts = 10
+
+// NormalizeInviteCode turns the code as typed by the player into the stored form
+func NormalizeInviteCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// FormatInviteCode splits the code in two halves, like ABC-DEF, so it's easier to read out
+func FormatInviteCode(code string) string {
+	if len(code) != inviteLength {
+		return code
+	}
+	return code[:inviteLength/2] + "-" + code[inviteLength/2:]
+}
+
+// newInviteCode generates the code that isn't used by any waiting game, and must be called with the lock held
+func (store *Store) newInviteCode() (string, error) {
+	for i := 0; i < inviteAttempts; i++ {
+		var code strings.Builder
+		for j := 0; j < inviteLength; j++ {
+			index, err := rand.Int(rand.Reader, big.NewInt(int64(len(inviteAlphabet))))
+			if err != nil {
+				return "", fmt.Errorf("Cannot generate invite code: %w", err)
+			}
+			code.WriteByte(inviteAlphabet[index.Int64()])
+		}
+		if _, used := store.playerIdByInviteCode[code.String()]; !used {
+			return code.String(), nil
+		}
+	}
+	return "", fmt.Errorf("Cannot generate unique invite code after %d attempts", inviteAttempts)
+}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/danilopavk/battleshipper/engine"
+)
+
+// TTLs tell how long the waiting players and the games are kept around.
+//
+// Players waiting for the opponent longer than Waiting are removed. Games
+// nobody moved in for longer than Idle are forfeited by the player holding
+// them up, and the finished games are archived once they ended longer than
+// Finished ago. Zero turns that part of the cleanup off.
+type TTLs struct {
+	Waiting  time.Duration
+	Idle     time.Duration
+	Finished time.Duration
+}
+
+// DefaultTTLs returns the TTLs the server runs with, unless configured otherwise
+func DefaultTTLs() TTLs {
+	return TTLs{Waiting: 30 * time.Minute, Idle: 15 * time.Minute, Finished: time.Hour}
+}
+
+// Clock tells the time, so the tests can move it forward instead of waiting
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the clock of the machine the server runs on
+type SystemClock struct{}
+
+// Now returns the current time
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Cleanup is what a single sweep of the janitor cleaned up
+type Cleanup struct {
+	Removed   []engine.Player
+	Forfeited []engine.Game
+	Archived  []engine.Game
+}
+
+// Janitor cleans up after the players who left, so the store doesn't grow without bound.
+//
+// Every sweep removes the abandoned waiting players, forfeits the idle games
+// and archives the finished ones, by the TTLs. A game is idle while its
+// version doesn't change, as seen by the janitor's sweeps, so the idle time
+// starts over when the server restarts. Forfeited games are published to the
+// hub like any other finished game, and the events of the archived games are
+// dropped from it. Keep, if set, spares the waiting players it returns true
+// for, like the bots waiting for the opponents.
+type Janitor struct {
+	Store GameRepository
+	Hub   *Hub
+	TTLs  TTLs
+	Clock Clock
+	Keep  func(playerId int) bool
+
+	mutex sync.Mutex
+	seen  map[int]seenGame
+}
+
+// seenGame is the version of the game the janitor saw, and when it first saw the game at that version
+type seenGame struct {
+	version int
+	since   time.Time
+}
+
+// InitializeJanitor builds the janitor of the store, with the hub to publish the forfeited games to
+func InitializeJanitor(repository GameRepository, hub *Hub, ttls TTLs, clock Clock) Janitor {
+	return Janitor{Store: repository, Hub: hub, TTLs: ttls, Clock: clock, seen: map[int]seenGame{}}
+}
+
+// Start sweeps every interval in the background, until the returned stop is called.
+//
+// Stop waits for the sweep in progress to finish, so the store can be closed
+// right after it. Calling stop more than once is fine.
+func (janitor *Janitor) Start(interval time.Duration) (stop func()) {
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				janitor.Sweep()
+			}
+		}
+	}()
+
+	return sync.OnceFunc(func() {
+		close(quit)
+		<-done
+	})
+}
+
+// Sweep cleans up the store once, at the time of the janitor's clock
+func (janitor *Janitor) Sweep() Cleanup {
+	janitor.mutex.Lock()
+	defer janitor.mutex.Unlock()
+
+	now := janitor.Clock.Now()
+	var cleanup Cleanup
+	if janitor.TTLs.Waiting > 0 {
+		cleanup.Removed = janitor.Store.RemoveWaiting(now.Add(-janitor.TTLs.Waiting), janitor.Keep)
+	}
+	if janitor.TTLs.Idle > 0 {
+		cleanup.Forfeited = janitor.forfeitIdle(now)
+	}
+	if janitor.TTLs.Finished > 0 {
+		cleanup.Archived = janitor.Store.ArchiveFinished(now.Add(-janitor.TTLs.Finished))
+		for _, game := range cleanup.Archived {
+			janitor.Hub.Forget(game.Id, game.PlayerA.Id, game.PlayerB.Id)
+		}
+	}
+	return cleanup
+}
+
+// forfeitIdle forfeits the games that stayed at the same version for longer than the idle TTL
+func (janitor *Janitor) forfeitIdle(now time.Time) []engine.Game {
+	var forfeited []engine.Game
+	seen := map[int]seenGame{}
+	for _, game := range janitor.Store.Games() {
+		if game.Winner != nil {
+			continue
+		}
+		last, ok := janitor.seen[game.Id]
+		if !ok || last.version != game.Version {
+			seen[game.Id] = seenGame{version: game.Version, since: now}
+			continue
+		}
+		if now.Sub(last.since) < janitor.TTLs.Idle {
+			seen[game.Id] = last
+			continue
+		}
+
+		if err := game.Forfeit(idlePlayer(game)); err != nil {
+			continue
+		}
+		// saved only if nobody moved since the game was read, otherwise it isn't idle anymore
+		if err := janitor.Store.UpdateGame(game); err != nil {
+			continue
+		}
+		game = versioned(game, game.Version+1)
+		janitor.Hub.PublishOver(game)
+		forfeited = append(forfeited, game)
+	}
+	janitor.seen = seen
+	return forfeited
+}
+
+// idlePlayer returns the player holding up the game: the one still placing ships, or the one whose turn it is
+func idlePlayer(game engine.Game) int {
+	for _, player := range []engine.Player{game.PlayerA, game.PlayerB} {
+		if _, err := game.NextShipLength(player.Id); err == nil {
+			return player.Id
+		}
+	}
+	return *game.Turn
+}
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/danilopavk/battleshipper/engine"
+)
+
+// fakeClock is the clock the tests move forward by hand
+type fakeClock struct {
+	now time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return clock.now
+}
+
+func Test_JanitorRemovesAbandonedWaiting(t *testing.T) {
+	store := InitializeStore()
+	clock := &fakeClock{now: time.Now()}
+	janitor := InitializeJanitor(&store, nil, TTLs{Waiting: time.Minute}, clock)
+	karsa, _ := store.StartGame("Karsa Orlong", 0)
+	icarium, _ := store.StartGame("Icarium", 0)
+	janitor.Keep = func(playerId int) bool {
+		return playerId == icarium.Id
+	}
+
+	if cleanup := janitor.Sweep(); len(cleanup.Removed) != 0 {
+		t.Errorf("Expected nobody to be removed yet, but got %v", cleanup.Removed)
+	}
+	clock.now = clock.now.Add(2 * time.Minute)
+	cleanup := janitor.Sweep()
+
+	if len(cleanup.Removed) != 1 || cleanup.Removed[0].Id != karsa.Id {
+		t.Errorf("Expected Karsa to be removed, but got %v", cleanup.Removed)
+	}
+	if _, waiting := store.WaitingSince(icarium.Id); !waiting {
+		t.Error("Expected the kept Icarium to keep waiting")
+	}
+}
+
+func Test_JanitorForfeitsIdleGames(t *testing.T) {
+	store := InitializeStore()
+	hub := InitializeHub()
+	var over []engine.Game
+	hub.OnGameOver(func(game engine.Game) {
+		over = append(over, game)
+	})
+	clock := &fakeClock{now: time.Now()}
+	janitor := InitializeJanitor(&store, &hub, TTLs{Idle: time.Minute}, clock)
+
+	idle := placedGame(t, &store)
+	active := placedGame(t, &store)
+	janitor.Sweep()
+	clock.now = clock.now.Add(30 * time.Second)
+	_, _, _ = store.Shoot(active.PlayerA.Id, engine.Cell{X: 0, Y: 1})
+	janitor.Sweep()
+	clock.now = clock.now.Add(45 * time.Second)
+	cleanup := janitor.Sweep()
+
+	if len(cleanup.Forfeited) != 1 || cleanup.Forfeited[0].Id != idle.Id {
+		t.Fatalf("Expected only the idle game to be forfeited, but got %v", cleanup.Forfeited)
+	}
+	if game, _ := store.GetGame(idle.Id); game.Winner == nil || *game.Winner != idle.PlayerB.Id {
+		t.Errorf("Expected Karsa, whose turn it was, to forfeit, but got %v", game.Winner)
+	}
+	if len(over) != 1 || over[0].Id != idle.Id {
+		t.Errorf("Expected the forfeited game to be published, but got %v", over)
+	}
+	if game, _ := store.GetGame(active.Id); game.Winner != nil {
+		t.Error("Expected the game with the recent shot to continue")
+	}
+}
+
+func Test_JanitorForfeitsPlayerPlacingShips(t *testing.T) {
+	store := InitializeStore()
+	clock := &fakeClock{now: time.Now()}
+	janitor := InitializeJanitor(&store, nil, TTLs{Idle: time.Minute}, clock)
+	karsa, _ := store.StartGame("Karsa Orlong", 0)
+	_ = karsa.PlaceRandomly()
+	_ = store.UpdatePlayer(karsa)
+	game, _ := store.JoinGame("Fiddler", 0, karsa.Id)
+
+	janitor.Sweep()
+	clock.now = clock.now.Add(2 * time.Minute)
+	janitor.Sweep()
+
+	if game, _ := store.GetGame(game.Id); game.Winner == nil || *game.Winner != karsa.Id {
+		t.Error("Expected Fiddler, who didn't place the ships, to forfeit")
+	}
+}
+
+func Test_JanitorArchivesFinishedGames(t *testing.T) {
+	store := InitializeStore()
+	hub := InitializeHub()
+	clock := &fakeClock{now: time.Now()}
+	janitor := InitializeJanitor(&store, &hub, TTLs{Finished: time.Hour}, clock)
+	game := placedGame(t, &store)
+	_ = game.Forfeit(game.PlayerA.Id)
+	_ = store.UpdateGame(game)
+	hub.PublishOver(game)
+
+	janitor.Sweep()
+	if _, err := store.GetGame(game.Id); err != nil {
+		t.Errorf("Expected the game to stay until it ended long enough ago: %v", err)
+	}
+	clock.now = clock.now.Add(2 * time.Hour)
+	cleanup := janitor.Sweep()
+
+	if len(cleanup.Archived) != 1 {
+		t.Fatalf("Expected the game to be archived, but got %v", cleanup.Archived)
+	}
+	if _, err := store.GetGame(game.Id); err == nil {
+		t.Error("Expected the archived game to leave the started games")
+	}
+	if len(store.FinishedGames()) != 1 {
+		t.Error("Expected the archived game to stay among the finished games")
+	}
+	events, unsubscribe := hub.SubscribeFrom(game.Id, 0)
+	defer unsubscribe()
+	select {
+	case event := <-events:
+		t.Errorf("Expected the events of the archived game to be forgotten, but got %v", event)
+	default:
+	}
+}
+
+func Test_JanitorArchiveStaysFlat(t *testing.T) {
+	store := InitializeStore()
+	clock := &fakeClock{now: time.Now()}
+	janitor := InitializeJanitor(&store, nil, TTLs{Finished: time.Hour}, clock)
+	sweep := func() {
+		for range 100 {
+			game := placedGame(t, &store)
+			_ = game.Forfeit(game.PlayerA.Id)
+			_ = store.UpdateGame(game)
+		}
+		clock.now = clock.now.Add(2 * time.Hour)
+		janitor.Sweep()
+	}
+	heap := func() uint64 {
+		var stats runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		return stats.HeapAlloc
+	}
+
+	for range 2 * archiveLimit / 100 {
+		sweep()
+	}
+	before := heap()
+	warmedUp := map[int]bool{}
+	for _, game := range store.archive {
+		warmedUp[game.Id] = true
+	}
+	for range 3 * archiveLimit / 100 {
+		sweep()
+	}
+	after := heap()
+
+	if len(store.archive) != archiveLimit {
+		t.Errorf("Expected the last %d archived games to be kept, but got %d", archiveLimit, len(store.archive))
+	}
+	if after > before*3/2 {
+		t.Errorf("Expected the memory to stay flat across the sweeps, but it grew from %d to %d bytes", before, after)
+	}
+	for _, game := range store.archive {
+		if warmedUp[game.Id] {
+			t.Fatalf("Expected only the latest archived games to be kept, but got game %d", game.Id)
+		}
+	}
+}
+
+func Test_JanitorStop(t *testing.T) {
+	store := InitializeStore()
+	janitor := InitializeJanitor(&store, nil, TTLs{Waiting: time.Nanosecond}, SystemClock{})
+	karsa, _ := store.StartGame("Karsa Orlong", 0)
+
+	stop := janitor.Start(time.Millisecond)
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if _, waiting := store.WaitingSince(karsa.Id); !waiting {
+			break
+		}
+	}
+	stop()
+	stop()
+
+	if _, waiting := store.WaitingSince(karsa.Id); waiting {
+		t.Error("Expected the running janitor to remove Karsa")
+	}
+	hedge, _ := store.StartGame("Hedge", 0)
+	time.Sleep(10 * time.Millisecond)
+	if _, waiting := store.WaitingSince(hedge.Id); !waiting {
+		t.Error("Expected the stopped janitor not to sweep anymore")
+	}
+}
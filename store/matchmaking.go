@@ -0,0 +1,145 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/danilopavk/battleshipper/engine"
+)
+
+// Rating window, in rating points, within which the rated players are matched.
+//
+// It starts at baseRatingWindow, and grows by ratingWindowGrowth for every
+// ratingWindowStep the player waits, so nobody waits forever.
+const (
+	baseRatingWindow   = 100
+	ratingWindowGrowth = 50
+	ratingWindowStep   = 10 * time.Second
+)
+
+// ErrNotQueued is returned when cancelling the match of a player that isn't in the matchmaking queue
+var ErrNotQueued = errors.New("Player is not in the matchmaking queue")
+
+// Ticket is a player's place in the matchmaking queue.
+//
+// Players are only matched with the ones playing by the same Rules. When
+// both players are Rated, they are also matched by Rating, within the window
+// that widens the longer they wait.
+type Ticket struct {
+	PlayerId int
+	UserId   int
+	Rules    engine.Rules
+	Rating   int
+	Rated    bool
+	Since    time.Time
+}
+
+// window returns the rating difference the player accepts after waiting until now
+func (ticket Ticket) window(now time.Time) int {
+	return baseRatingWindow + ratingWindowGrowth*int(now.Sub(ticket.Since)/ratingWindowStep)
+}
+
+// matches checks whether the two players can play against each other
+func (ticket Ticket) matches(other Ticket, now time.Time) bool {
+	if ticket.Rules != other.Rules {
+		return false
+	}
+	if !ticket.Rated || !other.Rated {
+		return true
+	}
+	difference := ticket.Rating - other.Rating
+	if difference < 0 {
+		difference = -difference
+	}
+	return difference <= ticket.window(now) && difference <= other.window(now)
+}
+
+// FindMatch puts the new player in the matchmaking queue, and starts the game
+// right away if a compatible player is already waiting.
+//
+// The player plays for the ticket's UserId, or is a guest when it's 0.
+// Rules, Rating and Rated are taken from the ticket. The player who waited the
+// longest is matched first, and becomes the host of the game. Returns the new
+// player, and the game if it started. Otherwise the player waits, like any
+// other waiting player but without being listed, until matched by another
+// FindMatch or Matchmake, or until CancelMatch. Never fails in memory, the
+// error is for the stores that save the player or the game.
+func (store *Store) FindMatch(playerName string, ticket Ticket) (engine.Player, engine.Game, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	player := initializePlayer(playerName, ticket.UserId)
+	ticket.PlayerId = player.Id
+	ticket.Since = time.Now()
+
+	for i, waiting := range store.queue {
+		if waiting.matches(ticket, ticket.Since) {
+			store.queue = append(store.queue[:i:i], store.queue[i+1:]...)
+			game := store.startBetween(store.waitingPlayers[waiting.PlayerId], player, ticket.Rules)
+			return game.PlayerB, game, nil
+		}
+	}
+
+	store.waitingPlayers[player.Id] = player
+	store.waitingSinceByPlayerId[player.Id] = ticket.Since
+	store.queue = append(store.queue, ticket)
+	return player.Clone(), engine.Game{}, nil
+}
+
+// Matchmake matches the players waiting in the queue whose rating windows widened enough by now.
+//
+// Returns the games that started.
+func (store *Store) Matchmake(now time.Time) []engine.Game {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var games []engine.Game
+	for i := 0; i < len(store.queue); i++ {
+		for j := i + 1; j < len(store.queue); j++ {
+			host, guest := store.queue[i], store.queue[j]
+			if !host.matches(guest, now) {
+				continue
+			}
+			store.queue = append(store.queue[:j:j], store.queue[j+1:]...)
+			store.queue = append(store.queue[:i:i], store.queue[i+1:]...)
+			games = append(games, store.startBetween(store.waitingPlayers[host.PlayerId], store.waitingPlayers[guest.PlayerId], host.Rules))
+			i--
+			break
+		}
+	}
+	return games
+}
+
+// CancelMatch takes the player out of the matchmaking queue.
+//
+// Returns ErrNotQueued if the player isn't in the queue, for example because
+// they were already matched.
+func (store *Store) CancelMatch(playerId int) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.queued(playerId) < 0 {
+		return fmt.Errorf("Cannot cancel match of player %d: %w", playerId, ErrNotQueued)
+	}
+	store.forget(playerId)
+	return nil
+}
+
+// Queued checks whether the player is waiting in the matchmaking queue
+func (store *Store) Queued(playerId int) bool {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return store.queued(playerId) >= 0
+}
+
+// queued returns the position of the player in the queue, or -1, and must be called with the lock held
+func (store *Store) queued(playerId int) int {
+	for i, ticket := range store.queue {
+		if ticket.PlayerId == playerId {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danilopavk/battleshipper/engine"
+)
+
+func Test_FindMatch(t *testing.T) {
+	store := InitializeStore()
+
+	karsa, game, _ := store.FindMatch("Karsa Orlong", Ticket{Rules: engine.DefaultRules()})
+	if game.Id != 0 {
+		t.Fatal("Expected Karsa to wait for the opponent")
+	}
+	if !store.Queued(karsa.Id) || len(store.AllWaitingPlayers()) != 0 {
+		t.Error("Expected Karsa to wait in the queue, without being listed")
+	}
+
+	fiddler, game, _ := store.FindMatch("Fiddler", Ticket{Rules: engine.DefaultRules()})
+	if game.Id == 0 {
+		t.Fatal("Expected Fiddler to be matched with Karsa")
+	}
+	if game.PlayerA.Id != karsa.Id || game.PlayerB.Id != fiddler.Id {
+		t.Errorf("Expected Karsa to host the game against Fiddler, got %v and %v", game.PlayerA.Name, game.PlayerB.Name)
+	}
+	if store.Queued(karsa.Id) {
+		t.Error("Expected Karsa to leave the queue")
+	}
+}
+
+func Test_FindMatchByRules(t *testing.T) {
+	store := InitializeStore()
+
+	store.FindMatch("Karsa Orlong", Ticket{Rules: engine.Rules{Hints: true}})
+	_, game, _ := store.FindMatch("Fiddler", Ticket{Rules: engine.Rules{Hints: false}})
+
+	if game.Id != 0 {
+		t.Error("Expected players with different rules not to be matched")
+	}
+}
+
+func Test_MatchmakeWidensRatingWindow(t *testing.T) {
+	store := InitializeStore()
+
+	store.FindMatch("Karsa Orlong", Ticket{Rules: engine.DefaultRules(), Rating: 1500, Rated: true})
+	_, game, _ := store.FindMatch("Fiddler", Ticket{Rules: engine.DefaultRules(), Rating: 1700, Rated: true})
+	if game.Id != 0 {
+		t.Fatal("Expected the ratings to be too far apart at first")
+	}
+
+	if games := store.Matchmake(time.Now()); len(games) != 0 {
+		t.Errorf("Expected no games before the window widens, got %d", len(games))
+	}
+	games := store.Matchmake(time.Now().Add(30 * time.Second))
+	if len(games) != 1 || games[0].PlayerA.Name != "Karsa Orlong" {
+		t.Errorf("Expected Karsa and Fiddler to be matched once the window widened, got %v", games)
+	}
+	if len(store.queue) != 0 {
+		t.Errorf("Expected the queue to be empty, but there are %d players", len(store.queue))
+	}
+}
+
+func Test_CancelMatch(t *testing.T) {
+	store := InitializeStore()
+	karsa, _, _ := store.FindMatch("Karsa Orlong", Ticket{Rules: engine.DefaultRules()})
+
+	if err := store.CancelMatch(karsa.Id); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, _, err := store.GetPlayerAndGame(karsa.Id); err == nil {
+		t.Error("Expected Karsa to be gone")
+	}
+	if err := store.CancelMatch(karsa.Id); !errors.Is(err, ErrNotQueued) {
+		t.Errorf("Expected not queued error, got %v", err)
+	}
+}
+
+func Test_FindMatchConcurrently(t *testing.T) {
+	store := InitializeStore()
+
+	var wait sync.WaitGroup
+	for _, name := range []string{"Fiddler", "Hedge", "Kalam", "Quick Ben", "Trotts", "Mallet"} {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			store.FindMatch(name, Ticket{Rules: engine.DefaultRules()})
+		}()
+	}
+	wait.Wait()
+
+	if len(store.allGames()) != 3 || len(store.queue) != 0 {
+		t.Errorf("Expected everyone to be matched, got %d games and %d queued", len(store.allGames()), len(store.queue))
+	}
+}
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/danilopavk/battleshipper/engine"
+)
+
+// ErrNotStarted is returned when shooting in the game nobody joined yet
+var ErrNotStarted = errors.New("Nobody joined the game yet")
+
+// ErrIllegalMove is matched by the errors of the moves the rules of the game don't allow
+var ErrIllegalMove = errors.New("Move is not allowed")
+
+// illegalMove is the error of the engine for the move the rules don't allow, which keeps its message
+type illegalMove struct {
+	err error
+}
+
+func (move illegalMove) Error() string {
+	return move.err.Error()
+}
+
+func (move illegalMove) Unwrap() []error {
+	return []error{move.err, ErrIllegalMove}
+}
+
+// Shoot fires the player's shot at the opponent's board, and saves the game.
+//
+// The game is read, shot at and saved under a single lock, so concurrent
+// shots can't undo each other. Returns the shot, and the game after it.
+// Returns ErrNotStarted if the player is still waiting, and the error
+// matching ErrIllegalMove if the rules don't allow the shot.
+func (store *Store) Shoot(playerId int, cell engine.Cell) (engine.Shot, engine.Game, error) {
+	_, game, err := store.changePlayer(playerId, func(player *engine.Player, game *engine.Game) error {
+		if game == nil {
+			return fmt.Errorf("Cannot shoot as player %d: %w", playerId, ErrNotStarted)
+		}
+		if _, _, _, err := game.Shoot(playerId, cell); err != nil {
+			return illegalMove{err}
+		}
+		return nil
+	})
+	if err != nil {
+		return engine.Shot{}, engine.Game{}, err
+	}
+	return game.History[len(game.History)-1], game, nil
+}
+
+// PlaceShip places the next ship on the player's board, and saves the player.
+//
+// Works both for the waiting players and for the ones in the game, under a
+// single lock. Returns the player, and the game if the player isn't waiting
+// anymore. Returns the error matching ErrIllegalMove if the ship doesn't fit.
+func (store *Store) PlaceShip(playerId int, ship engine.Ship) (engine.Player, engine.Game, error) {
+	return store.changePlayer(playerId, func(player *engine.Player, game *engine.Game) error {
+		if err := player.AddShip(ship); err != nil {
+			return illegalMove{err}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/danilopavk/battleshipper/engine"
+)
+
+// gameRecord is the game in the form that can be written as JSON.
+//
+// Engine keeps the cells in maps keyed by the cell, which JSON can't encode,
+// so the records keep them as lists, sorted row by row.
+type gameRecord struct {
+	Id        int           `json:"id"`
+	PlayerA   playerRecord  `json:"playerA"`
+	PlayerB   playerRecord  `json:"playerB"`
+	Turn      *int          `json:"turn,omitempty"`
+	Winner    *int          `json:"winner,omitempty"`
+	Rules     engine.Rules  `json:"rules"`
+	History   []engine.Shot `json:"history"`
+	StartedAt time.Time     `json:"startedAt"`
+	EndedAt   time.Time     `json:"endedAt"`
+	Version   int           `json:"version"`
+}
+
+// playerRecord is the player in the form that can be written as JSON
+type playerRecord struct {
+	Id        int             `json:"id"`
+	Name      string          `json:"name"`
+	UserId    int             `json:"userId,omitempty"`
+	Ships     [][]engine.Cell `json:"ships"`
+	SankShips [][]engine.Cell `json:"sankShips"`
+	Hits      []engine.Cell   `json:"hits"`
+	Misses    []engine.Cell   `json:"misses"`
+	Version   int             `json:"version"`
+}
+
+// waitingRecord is the player waiting for the opponent, with everything they are waiting with.
+//
+// Rules are only set if they differ from the default ones, and Ticket only for the players in the matchmaking queue.
+type waitingRecord struct {
+	Player     playerRecord  `json:"player"`
+	Since      time.Time     `json:"since"`
+	Rules      *engine.Rules `json:"rules,omitempty"`
+	InviteCode string        `json:"inviteCode,omitempty"`
+	Ticket     *Ticket       `json:"ticket,omitempty"`
+}
+
+func recordOfGame(game engine.Game) gameRecord {
+	return gameRecord{
+		Id:        game.Id,
+		PlayerA:   recordOfPlayer(game.PlayerA),
+		PlayerB:   recordOfPlayer(game.PlayerB),
+		Turn:      copyOf(game.Turn),
+		Winner:    copyOf(game.Winner),
+		Rules:     game.Rules,
+		History:   slices.Clone(game.History),
+		StartedAt: game.StartedAt,
+		EndedAt:   game.EndedAt,
+		Version:   game.Version,
+	}
+}
+
+func (record gameRecord) game() engine.Game {
+	history := append([]engine.Shot{}, record.History...)
+	return engine.Game{
+		Id:        record.Id,
+		PlayerA:   record.PlayerA.player(),
+		PlayerB:   record.PlayerB.player(),
+		Turn:      copyOf(record.Turn),
+		Winner:    copyOf(record.Winner),
+		Rules:     record.Rules,
+		History:   history,
+		StartedAt: record.StartedAt,
+		EndedAt:   record.EndedAt,
+		Version:   record.Version,
+	}
+}
+
+func recordOfPlayer(player engine.Player) playerRecord {
+	record := playerRecord{
+		Id:        player.Id,
+		Name:      player.Name,
+		UserId:    player.UserId,
+		Ships:     cellsOfShips(*player.Ships),
+		SankShips: cellsOfShips(*player.Target.SankShips),
+		Hits:      sortedCells(player.Target.Hits),
+		Misses:    sortedCells(player.Target.Misses),
+		Version:   player.Version,
+	}
+	return record
+}
+
+func (record playerRecord) player() engine.Player {
+	ships := shipsOfCells(record.Ships)
+	sankShips := shipsOfCells(record.SankShips)
+	target := engine.Target{SankShips: &sankShips, Hits: cellSet(record.Hits), Misses: cellSet(record.Misses)}
+	return engine.Player{Id: record.Id, Name: record.Name, Ships: &ships, Target: &target, UserId: record.UserId, Version: record.Version}
+}
+
+// copyOf returns the pointer to the copy of the value, so the copy doesn't change with the original
+func copyOf(value *int) *int {
+	if value == nil {
+		return nil
+	}
+	copied := *value
+	return &copied
+}
+
+func cellsOfShips(ships []engine.Ship) [][]engine.Cell {
+	cells := [][]engine.Cell{}
+	for _, ship := range ships {
+		cells = append(cells, sortedCells(ship.Cells))
+	}
+	return cells
+}
+
+func shipsOfCells(cells [][]engine.Cell) []engine.Ship {
+	ships := []engine.Ship{}
+	for _, shipCells := range cells {
+		ships = append(ships, engine.Ship{Cells: cellSet(shipCells)})
+	}
+	return ships
+}
+
+// sortedCells returns the cells set in the map, sorted row by row.
+//
+// Cells of the sank ships stay in the hits as false, and are left out, so
+// they aren't restored as the live hits.
+func sortedCells(cells map[engine.Cell]bool) []engine.Cell {
+	sorted := []engine.Cell{}
+	for cell, present := range cells {
+		if present {
+			sorted = append(sorted, cell)
+		}
+	}
+	slices.SortFunc(sorted, func(a, b engine.Cell) int {
+		return cmp.Or(a.Y-b.Y, a.X-b.X)
+	})
+	return sorted
+}
+
+func cellSet(cells []engine.Cell) map[engine.Cell]bool {
+	set := map[engine.Cell]bool{}
+	for _, cell := range cells {
+		set[cell] = true
+	}
+	return set
+}
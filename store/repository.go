@@ -0,0 +1,92 @@
+package store
+
+import (
+	"time"
+
+	"github.com/danilopavk/battleshipper/archive"
+	"github.com/danilopavk/battleshipper/engine"
+)
+
+// GameRepository keeps the waiting players and the games, whatever the storage behind it.
+//
+// Everything outside of this package works with the repository, never with
+// the storage directly, so the storage can be swapped without touching the
+// callers. Every method is safe for concurrent use. Games and waiting
+// players are versioned, so saving the one that someone else saved since it
+// was read fails with ErrConflict instead of silently undoing their change.
+// UpdateWithRetry makes the change again on the fresh game in that case.
+// Players and games are snapshots: the ones returned are deep copies the
+// caller can change freely, and the ones saved are copied, so changing them
+// afterwards doesn't change the stored state.
+//
+// Store is the in-memory implementation, and the reference for the behaviour
+// every other implementation must match, checked by the storetest package.
+type GameRepository interface {
+	// StartGame adds the new player of the user, or the guest when it's 0, waiting for someone to join their game, played by the default rules
+	StartGame(playerName string, userId int) (engine.Player, error)
+	// StartGameWithRules adds the new player of the user waiting for someone to join their game, played by the rules
+	StartGameWithRules(playerName string, userId int, rules engine.Rules) (engine.Player, error)
+	// StartGameAs adds the player already set up, like a bot with its ships placed, waiting in the game played by the default rules, or returns ErrExists
+	StartGameAs(player engine.Player) (engine.Player, error)
+	// StartPrivateGame adds the new player of the user waiting in the game that can only be joined with the returned invite code
+	StartPrivateGame(playerName string, userId int) (engine.Player, string, error)
+	// JoinGame starts the game of the new player of the user with the listed waiting player, or returns ErrNotWaiting
+	JoinGame(playerName string, userId int, opponentId int) (engine.Game, error)
+	// JoinPrivateGame starts the game of the new player of the user with the player waiting with the invite code, or returns ErrNotWaiting
+	JoinPrivateGame(playerName string, userId int, code string) (engine.Game, error)
+
+	// AllWaitingPlayers returns the waiting players anyone can join
+	AllWaitingPlayers() []engine.Player
+	// WaitingSince returns when the player started waiting, and false if they aren't waiting
+	WaitingSince(playerId int) (time.Time, bool)
+	// WaitingRules returns the rules of the game the player waits in, and false if they aren't waiting
+	WaitingRules(playerId int) (engine.Rules, bool)
+	// InviteCode returns the invite code of the player waiting in a private game, and false if there's none
+	InviteCode(playerId int) (string, bool)
+
+	// FindMatch puts the new player of the ticket's user in the matchmaking queue, and starts the game if the match is already waiting
+	FindMatch(playerName string, ticket Ticket) (engine.Player, engine.Game, error)
+	// Matchmake starts the games between the queued players who match by now
+	Matchmake(now time.Time) []engine.Game
+	// CancelMatch takes the player out of the matchmaking queue, or returns ErrNotQueued
+	CancelMatch(playerId int) error
+	// Queued checks whether the player waits in the matchmaking queue
+	Queued(playerId int) bool
+
+	// GetPlayerAndGame returns the player, and the game if the player isn't waiting anymore
+	GetPlayerAndGame(playerId int) (engine.Player, engine.Game, error)
+	// GetGame returns the game by its id
+	GetGame(gameId int) (engine.Game, error)
+	// Games returns all the started games, the earliest started first
+	Games() []engine.Game
+	// FinishedGames returns the games that have a winner, including the archived ones, the earliest ended first
+	FinishedGames() []engine.Game
+	// FinishedGame returns the game that has a winner by its id, including the archived ones
+	FinishedGame(gameId int) (engine.Game, error)
+	// SearchFinished finds the games that have a winner matching the query, including the archived ones, the latest ended first
+	SearchFinished(query archive.Query) (archive.Page, error)
+
+	// UpdatePlayer saves the player, either waiting or in the game, at the next version, or returns ErrConflict if the version is stale
+	UpdatePlayer(player engine.Player) error
+	// AssignUser links the player, either waiting or in the game, to the user, and moves the player or the game to the next version
+	AssignUser(playerId int, userId int) error
+	// UpdateGame saves the game at the next version, or returns ErrConflict if the version is stale
+	UpdateGame(game engine.Game) error
+
+	// Shoot fires the player's shot and saves the game at once, and returns the shot and the game after it
+	Shoot(playerId int, cell engine.Cell) (engine.Shot, engine.Game, error)
+	// PlaceShip places the player's next ship and saves the player at once, and returns the player, and the game if it started
+	PlaceShip(playerId int, ship engine.Ship) (engine.Player, engine.Game, error)
+
+	// RemoveWaiting removes the players waiting since before the time, except the ones keep returns true for, and returns them
+	RemoveWaiting(before time.Time, keep func(playerId int) bool) []engine.Player
+	// ArchiveFinished moves the games that ended before the time out of the started games, and returns them
+	ArchiveFinished(before time.Time) []engine.Game
+
+	// Dump returns the whole state of the store at once: the waiting players, the started games and the archived games
+	Dump() (Dump, error)
+	// Load adds everything from the dump to the store, or returns ErrExists and loads nothing if any of it is already there
+	Load(dump Dump) error
+}
+
+var _ GameRepository = (*Store)(nil)
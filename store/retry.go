@@ -0,0 +1,57 @@
+package store
+
+import (
+	"errors"
+
+	"github.com/danilopavk/battleshipper/engine"
+)
+
+// maxAttempts is how many times UpdateWithRetry makes the change before giving up on the conflicts
+const maxAttempts = 5
+
+// UpdateWithRetry reads the player and their game, changes them and saves them, and starts over when the save conflicts.
+//
+// The player given to the update is the one in the game, if the game started,
+// and the waiting one otherwise. Update gets a fresh copy on every attempt, so
+// it must only depend on what it gets, and can't have any other effect. When
+// update returns error, nothing is saved, and the error is returned as it is.
+// Returns the saved player and game, and ErrConflict if the game kept
+// changing during all the attempts.
+func UpdateWithRetry(repository GameRepository, playerId int, update func(player *engine.Player, game *engine.Game) error) (engine.Player, engine.Game, error) {
+	var player engine.Player
+	var game engine.Game
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		player, game, err = repository.GetPlayerAndGame(playerId)
+		if err != nil {
+			return engine.Player{}, engine.Game{}, err
+		}
+
+		if game.Id == 0 {
+			if err := update(&player, &game); err != nil {
+				return engine.Player{}, engine.Game{}, err
+			}
+			err = repository.UpdatePlayer(player)
+			player.Version++
+		} else {
+			target := &game.PlayerA
+			if game.PlayerB.Id == playerId {
+				target = &game.PlayerB
+			}
+			if err := update(target, &game); err != nil {
+				return engine.Player{}, engine.Game{}, err
+			}
+			err = repository.UpdateGame(game)
+			game = versioned(game, game.Version+1)
+			player = *target
+		}
+
+		if !errors.Is(err, ErrConflict) {
+			break
+		}
+	}
+	if err != nil {
+		return engine.Player{}, engine.Game{}, err
+	}
+	return player, game, nil
+}
@@ -0,0 +1,795 @@
+package store
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/danilopavk/battleshipper/archive"
+	"github.com/danilopavk/battleshipper/engine"
+	"github.com/danilopavk/battleshipper/users"
+	"modernc.org/sqlite"
+)
+
+// timeFormat is how the times are written in the database, in UTC and with the fixed width, so they sort as text
+const timeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// Boards the ships are on, the player's own fleet, or the opponent's ships the player sank
+const (
+	fleetBoard  = "fleet"
+	targetBoard = "target"
+)
+
+// migrations change the database schema, one version at a time.
+//
+// The database keeps the number of the applied migrations in its
+// user_version, so only the new ones run when the store opens. Released
+// migrations are never changed, the new ones are appended instead.
+var migrations = []string{
+	`CREATE TABLE users (
+		id INTEGER PRIMARY KEY,
+		username TEXT NOT NULL UNIQUE,
+		display_name TEXT NOT NULL,
+		password_hash BLOB NOT NULL,
+		created_at TEXT NOT NULL
+	);
+
+	CREATE TABLE games (
+		id INTEGER PRIMARY KEY,
+		player_a_id INTEGER NOT NULL,
+		player_b_id INTEGER NOT NULL,
+		turn_player_id INTEGER,
+		winner_player_id INTEGER,
+		hints INTEGER NOT NULL,
+		open_spectating INTEGER NOT NULL,
+		ranked INTEGER NOT NULL,
+		started_at TEXT NOT NULL,
+		ended_at TEXT
+	);
+
+	CREATE TABLE players (
+		id INTEGER PRIMARY KEY,
+		game_id INTEGER REFERENCES games (id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		user_id INTEGER,
+		hits TEXT NOT NULL,
+		misses TEXT NOT NULL
+	);
+	CREATE INDEX players_game_id ON players (game_id);
+	CREATE INDEX players_user_id ON players (user_id);
+
+	CREATE TABLE ships (
+		player_id INTEGER NOT NULL REFERENCES players (id) ON DELETE CASCADE,
+		board TEXT NOT NULL CHECK (board IN ('fleet', 'target')),
+		position INTEGER NOT NULL,
+		cells TEXT NOT NULL,
+		PRIMARY KEY (player_id, board, position)
+	);
+
+	CREATE TABLE shots (
+		game_id INTEGER NOT NULL REFERENCES games (id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		player_id INTEGER NOT NULL,
+		x INTEGER NOT NULL,
+		y INTEGER NOT NULL,
+		hit INTEGER NOT NULL,
+		sank INTEGER NOT NULL,
+		PRIMARY KEY (game_id, position)
+	);
+
+	CREATE TABLE waiting (
+		player_id INTEGER PRIMARY KEY REFERENCES players (id) ON DELETE CASCADE,
+		since TEXT NOT NULL,
+		hints INTEGER,
+		open_spectating INTEGER,
+		ranked INTEGER,
+		invite_code TEXT UNIQUE
+	);
+
+	CREATE TABLE queue (
+		id INTEGER PRIMARY KEY,
+		player_id INTEGER NOT NULL UNIQUE REFERENCES waiting (player_id) ON DELETE CASCADE,
+		hints INTEGER NOT NULL,
+		open_spectating INTEGER NOT NULL,
+		ranked INTEGER NOT NULL,
+		rating INTEGER NOT NULL,
+		rated INTEGER NOT NULL,
+		since TEXT NOT NULL
+	);`,
+	`ALTER TABLE games ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE players ADD COLUMN version INTEGER NOT NULL DEFAULT 0;`,
+	`ALTER TABLE games ADD COLUMN archived INTEGER NOT NULL DEFAULT 0;
+	CREATE INDEX games_archived ON games (archived);`,
+	`DROP INDEX games_archived;
+	CREATE INDEX games_archived ON games (archived, ended_at);`,
+	`CREATE TABLE bots (
+		player_id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		callback_url TEXT NOT NULL
+	);`,
+}
+
+func init() {
+	// equal_fold compares the names the way strings.EqualFold does, since SQLite's own lower only folds ASCII
+	sqlite.MustRegisterDeterministicScalarFunction("equal_fold", 2, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		a, _ := args[0].(string)
+		b, _ := args[1].(string)
+		return strings.EqualFold(a, b), nil
+	})
+}
+
+// SQLiteStore keeps the games in memory, like the Store, and persists them in the SQLite database file.
+//
+// Every change is saved in a single transaction, so a move is either saved
+// completely or not at all. The tables follow the games, so the database can
+// be queried directly, and backed up by copying the single file. Users and
+// bots are saved in the same database, through SaveUser and SaveBot.
+// Archived games stay in the same tables, marked as archived, and are only
+// loaded when asked for.
+type SQLiteStore struct {
+	durableStore
+	db *sql.DB
+}
+
+var _ GameRepository = (*SQLiteStore)(nil)
+
+// InitializeSQLiteStore opens the SQLite database in the file, creating the file and its directory if they don't exist.
+//
+// The schema is migrated to the latest version, and the games and waiting
+// players saved before are restored.
+func InitializeSQLiteStore(path string) (*SQLiteStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("Cannot create database directory: %w", err)
+	}
+	db, err := sql.Open("sqlite", path+"?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open database %v: %w", path, err)
+	}
+	// all the writes are serialized by the store anyway
+	db.SetMaxOpenConns(1)
+
+	memory := InitializeStore()
+	sqliteStore := &SQLiteStore{db: db}
+	sqliteStore.memory = &memory
+	sqliteStore.saver = sqliteStore
+	sqliteStore.name = path
+
+	if err := sqliteStore.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := sqliteStore.load(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return sqliteStore, nil
+}
+
+// Close closes the database
+func (sqliteStore *SQLiteStore) Close() error {
+	sqliteStore.mutex.Lock()
+	defer sqliteStore.mutex.Unlock()
+
+	return sqliteStore.db.Close()
+}
+
+// SaveUser saves the new or the changed user
+func (sqliteStore *SQLiteStore) SaveUser(user users.User) error {
+	_, err := sqliteStore.db.Exec(`INSERT INTO users (id, username, display_name, password_hash, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET username = excluded.username, display_name = excluded.display_name, password_hash = excluded.password_hash`,
+		user.Id, user.Username, user.DisplayName, user.PasswordHash, formatTime(user.CreatedAt))
+	if err != nil {
+		return fmt.Errorf("Cannot save user %d: %w", user.Id, err)
+	}
+	return nil
+}
+
+// Users returns all the saved users
+func (sqliteStore *SQLiteStore) Users() ([]users.User, error) {
+	rows, err := sqliteStore.db.Query(`SELECT id, username, display_name, password_hash, created_at FROM users ORDER BY created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read users: %w", err)
+	}
+	defer rows.Close()
+
+	var saved []users.User
+	for rows.Next() {
+		var user users.User
+		var createdAt string
+		if err := rows.Scan(&user.Id, &user.Username, &user.DisplayName, &user.PasswordHash, &createdAt); err != nil {
+			return nil, fmt.Errorf("Cannot read user: %w", err)
+		}
+		if user.CreatedAt, err = parseTime(createdAt); err != nil {
+			return nil, err
+		}
+		saved = append(saved, user)
+	}
+	return saved, rows.Err()
+}
+
+// SaveBot saves the registration of the bot
+func (sqliteStore *SQLiteStore) SaveBot(bot BotRecord) error {
+	_, err := sqliteStore.db.Exec(`INSERT INTO bots (player_id, name, callback_url) VALUES (?, ?, ?)
+		ON CONFLICT (player_id) DO UPDATE SET name = excluded.name, callback_url = excluded.callback_url`,
+		bot.PlayerId, bot.Name, bot.CallbackURL)
+	if err != nil {
+		return fmt.Errorf("Cannot save bot %d: %w", bot.PlayerId, err)
+	}
+	return nil
+}
+
+// RemoveBot deletes the registration of the bot, and does nothing if there's none
+func (sqliteStore *SQLiteStore) RemoveBot(playerId int) error {
+	if _, err := sqliteStore.db.Exec(`DELETE FROM bots WHERE player_id = ?`, playerId); err != nil {
+		return fmt.Errorf("Cannot remove bot %d: %w", playerId, err)
+	}
+	return nil
+}
+
+// Bots returns the registrations of all the saved bots
+func (sqliteStore *SQLiteStore) Bots() ([]BotRecord, error) {
+	rows, err := sqliteStore.db.Query(`SELECT player_id, name, callback_url FROM bots ORDER BY player_id`)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read bots: %w", err)
+	}
+	defer rows.Close()
+
+	var saved []BotRecord
+	for rows.Next() {
+		var bot BotRecord
+		if err := rows.Scan(&bot.PlayerId, &bot.Name, &bot.CallbackURL); err != nil {
+			return nil, fmt.Errorf("Cannot read bot: %w", err)
+		}
+		saved = append(saved, bot)
+	}
+	return saved, rows.Err()
+}
+
+// save saves the change in a single transaction
+func (sqliteStore *SQLiteStore) save(change change) error {
+	tx, err := sqliteStore.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, playerId := range change.Gone {
+		if _, err := tx.Exec(`DELETE FROM players WHERE id = ? AND game_id IS NULL`, playerId); err != nil {
+			return fmt.Errorf("Cannot delete waiting player %d: %w", playerId, err)
+		}
+	}
+	for _, record := range change.Waiting {
+		if err := saveWaiting(tx, record); err != nil {
+			return err
+		}
+	}
+	for _, record := range change.Games {
+		if err := saveGame(tx, record); err != nil {
+			return err
+		}
+	}
+	for _, record := range change.Archived {
+		if err := saveGame(tx, record); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE games SET archived = 1 WHERE id = ?`, record.Id); err != nil {
+			return fmt.Errorf("Cannot archive game %d: %w", record.Id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Cannot commit transaction: %w", err)
+	}
+	return nil
+}
+
+// migrate applies the migrations the database doesn't have yet, each in its own transaction
+func (sqliteStore *SQLiteStore) migrate() error {
+	var version int
+	if err := sqliteStore.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("Cannot read database schema version: %w", err)
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("Database schema version %d is newer than the supported version %d", version, len(migrations))
+	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := sqliteStore.db.Begin()
+		if err != nil {
+			return fmt.Errorf("Cannot begin migration %d: %w", version+1, err)
+		}
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Cannot apply migration %d: %w", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Cannot apply migration %d: %w", version+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("Cannot commit migration %d: %w", version+1, err)
+		}
+	}
+	return nil
+}
+
+// load restores the saved games and waiting players into the memory
+func (sqliteStore *SQLiteStore) load() error {
+	players, err := sqliteStore.loadPlayers(`players.game_id IS NULL OR players.game_id IN (SELECT id FROM games WHERE archived = 0)`)
+	if err != nil {
+		return err
+	}
+	waiting, err := sqliteStore.loadWaiting(players)
+	if err != nil {
+		return err
+	}
+	games, err := sqliteStore.loadGames(players, `games.archived = 0`)
+	if err != nil {
+		return err
+	}
+	sqliteStore.memory.apply(change{Waiting: waiting, Games: games})
+	return nil
+}
+
+// archived reads the archived games
+func (sqliteStore *SQLiteStore) archived() ([]gameRecord, error) {
+	return sqliteStore.loadArchived(`games.archived = 1`)
+}
+
+// archivedGame reads the archived game by its id, and false if there's no such archived game
+func (sqliteStore *SQLiteStore) archivedGame(gameId int) (gameRecord, bool, error) {
+	games, err := sqliteStore.loadArchived(`games.archived = 1 AND games.id = ?`, gameId)
+	if err != nil || len(games) == 0 {
+		return gameRecord{}, false, err
+	}
+	return games[0], true, nil
+}
+
+// searchArchived finds the games matching the query among the finished games and the archived ones.
+//
+// The archived games are filtered, counted and sorted in SQL, so the indexes
+// find them. The page can't reach further into the archived games than its
+// end, so only the ids and the end times up to there are read to merge them
+// with the finished games, and only the games on the page are read whole.
+func (sqliteStore *SQLiteStore) searchArchived(query archive.Query, finished []engine.Game) (archive.Page, error) {
+	if err := query.Validate(); err != nil {
+		return archive.Page{}, err
+	}
+	var found []engine.Game
+	for _, game := range finished {
+		if query.Matches(game) {
+			found = append(found, game)
+		}
+	}
+	matched := len(found)
+
+	from, args := archivedSearch(query)
+	var total int
+	if err := sqliteStore.db.QueryRow(`SELECT COUNT(DISTINCT games.id) `+from, args...).Scan(&total); err != nil {
+		return archive.Page{}, fmt.Errorf("Cannot count archived games: %w", err)
+	}
+	// checked by the division, so the huge page numbers don't overflow
+	bounds := query.PageOf(nil)
+	limit := total
+	if bounds.Page <= total/bounds.PageSize {
+		limit = bounds.Page * bounds.PageSize
+	}
+	rows, err := sqliteStore.db.Query(`SELECT DISTINCT games.id, games.ended_at `+from+`
+		ORDER BY games.ended_at DESC, games.id DESC LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return archive.Page{}, fmt.Errorf("Cannot search archived games: %w", err)
+	}
+	defer rows.Close()
+
+	archivedIds := map[int]bool{}
+	for rows.Next() {
+		var game engine.Game
+		var endedAt string
+		if err := rows.Scan(&game.Id, &endedAt); err != nil {
+			return archive.Page{}, fmt.Errorf("Cannot search archived games: %w", err)
+		}
+		if game.EndedAt, err = parseTime(endedAt); err != nil {
+			return archive.Page{}, err
+		}
+		archivedIds[game.Id] = true
+		found = append(found, game)
+	}
+	if err := rows.Err(); err != nil {
+		return archive.Page{}, fmt.Errorf("Cannot search archived games: %w", err)
+	}
+
+	page := query.PageOf(found)
+	page.Total = matched + total
+	var conditions []string
+	var ids []any
+	for _, game := range page.Games {
+		if archivedIds[game.Id] {
+			conditions = append(conditions, "?")
+			ids = append(ids, game.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return page, nil
+	}
+	records, err := sqliteStore.loadArchived(`games.archived = 1 AND games.id IN (`+strings.Join(conditions, ", ")+`)`, ids...)
+	if err != nil {
+		return archive.Page{}, err
+	}
+	gameById := map[int]engine.Game{}
+	for _, record := range records {
+		gameById[record.Id] = record.game()
+	}
+	for i, game := range page.Games {
+		if archivedIds[game.Id] {
+			page.Games[i] = gameById[game.Id]
+		}
+	}
+	return page, nil
+}
+
+// archivedSearch returns the FROM and WHERE clauses finding the archived games matching the query, and their arguments.
+//
+// Every game is joined with its players in both orders, as the player the
+// games are searched for and as the opponent, so the conditions match the
+// ones of archive.Query.Matches.
+func archivedSearch(query archive.Query) (string, []any) {
+	conditions := []string{`games.archived = 1`, `games.winner_player_id IS NOT NULL`}
+	var args []any
+	if !query.From.IsZero() {
+		conditions = append(conditions, `games.ended_at >= ?`)
+		args = append(args, formatTime(query.From))
+	}
+	if !query.To.IsZero() {
+		conditions = append(conditions, `games.ended_at < ?`)
+		args = append(args, formatTime(query.To))
+	}
+	if query.Ranked != nil {
+		conditions = append(conditions, `games.ranked = ?`)
+		args = append(args, *query.Ranked)
+	}
+	if query.UserId != 0 {
+		conditions = append(conditions, `player.user_id = ?`)
+		args = append(args, query.UserId)
+	}
+	if query.OpponentId != 0 {
+		conditions = append(conditions, `opponent.user_id = ?`)
+		args = append(args, query.OpponentId)
+	}
+	if query.Opponent != "" {
+		conditions = append(conditions, `equal_fold(opponent.name, ?)`)
+		args = append(args, query.Opponent)
+	}
+	switch query.Result {
+	case archive.Won:
+		conditions = append(conditions, `games.winner_player_id = player.id`)
+	case archive.Lost:
+		conditions = append(conditions, `games.winner_player_id = opponent.id`)
+	}
+	return `FROM games
+		JOIN players AS player ON player.game_id = games.id
+		JOIN players AS opponent ON opponent.game_id = games.id AND opponent.id != player.id
+		WHERE ` + strings.Join(conditions, " AND "), args
+}
+
+// loadArchived reads the archived games the condition on the games table holds for, with their players
+func (sqliteStore *SQLiteStore) loadArchived(condition string, args ...any) ([]gameRecord, error) {
+	players, err := sqliteStore.loadPlayers(`players.game_id IN (SELECT id FROM games WHERE `+condition+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return sqliteStore.loadGames(players, condition, args...)
+}
+
+// loadPlayers reads the players the condition on the players table holds for, with their ships, by their ids
+func (sqliteStore *SQLiteStore) loadPlayers(condition string, args ...any) (map[int]playerRecord, error) {
+	rows, err := sqliteStore.db.Query(`SELECT id, name, user_id, hits, misses, version FROM players WHERE `+condition, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read players: %w", err)
+	}
+	defer rows.Close()
+
+	players := map[int]playerRecord{}
+	for rows.Next() {
+		var record playerRecord
+		var userId sql.NullInt64
+		var hits, misses string
+		if err := rows.Scan(&record.Id, &record.Name, &userId, &hits, &misses, &record.Version); err != nil {
+			return nil, fmt.Errorf("Cannot read player: %w", err)
+		}
+		record.UserId = int(userId.Int64)
+		if err := json.Unmarshal([]byte(hits), &record.Hits); err != nil {
+			return nil, fmt.Errorf("Cannot decode hits of player %d: %w", record.Id, err)
+		}
+		if err := json.Unmarshal([]byte(misses), &record.Misses); err != nil {
+			return nil, fmt.Errorf("Cannot decode misses of player %d: %w", record.Id, err)
+		}
+		record.Ships = [][]engine.Cell{}
+		record.SankShips = [][]engine.Cell{}
+		players[record.Id] = record
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Cannot read players: %w", err)
+	}
+
+	ships, err := sqliteStore.db.Query(`SELECT ships.player_id, ships.board, ships.cells
+		FROM ships JOIN players ON players.id = ships.player_id
+		WHERE `+condition+`
+		ORDER BY ships.player_id, ships.board, ships.position`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read ships: %w", err)
+	}
+	defer ships.Close()
+
+	for ships.Next() {
+		var playerId int
+		var board, encoded string
+		if err := ships.Scan(&playerId, &board, &encoded); err != nil {
+			return nil, fmt.Errorf("Cannot read ship: %w", err)
+		}
+		var cells []engine.Cell
+		if err := json.Unmarshal([]byte(encoded), &cells); err != nil {
+			return nil, fmt.Errorf("Cannot decode ship of player %d: %w", playerId, err)
+		}
+		record := players[playerId]
+		if board == fleetBoard {
+			record.Ships = append(record.Ships, cells)
+		} else {
+			record.SankShips = append(record.SankShips, cells)
+		}
+		players[playerId] = record
+	}
+	return players, ships.Err()
+}
+
+// loadWaiting reads the waiting players, with the queued ones in the order they were queued
+func (sqliteStore *SQLiteStore) loadWaiting(players map[int]playerRecord) ([]waitingRecord, error) {
+	rows, err := sqliteStore.db.Query(`SELECT waiting.player_id, waiting.since, waiting.hints, waiting.open_spectating, waiting.ranked, waiting.invite_code,
+			queue.hints, queue.open_spectating, queue.ranked, queue.rating, queue.rated, queue.since
+		FROM waiting LEFT JOIN queue ON queue.player_id = waiting.player_id
+		ORDER BY queue.id`)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read waiting players: %w", err)
+	}
+	defer rows.Close()
+
+	waiting := []waitingRecord{}
+	for rows.Next() {
+		var playerId int
+		var since string
+		var hints, openSpectating, ranked sql.NullBool
+		var inviteCode sql.NullString
+		var queuedHints, queuedOpenSpectating, queuedRanked, rated sql.NullBool
+		var rating sql.NullInt64
+		var queuedSince sql.NullString
+		if err := rows.Scan(&playerId, &since, &hints, &openSpectating, &ranked, &inviteCode,
+			&queuedHints, &queuedOpenSpectating, &queuedRanked, &rating, &rated, &queuedSince); err != nil {
+			return nil, fmt.Errorf("Cannot read waiting player: %w", err)
+		}
+
+		record := waitingRecord{Player: players[playerId], InviteCode: inviteCode.String}
+		if record.Since, err = parseTime(since); err != nil {
+			return nil, err
+		}
+		if hints.Valid {
+			record.Rules = &engine.Rules{Hints: hints.Bool, OpenSpectating: openSpectating.Bool, Ranked: ranked.Bool}
+		}
+		if queuedSince.Valid {
+			ticket := Ticket{
+				PlayerId: playerId,
+				Rules:    engine.Rules{Hints: queuedHints.Bool, OpenSpectating: queuedOpenSpectating.Bool, Ranked: queuedRanked.Bool},
+				Rating:   int(rating.Int64),
+				Rated:    rated.Bool,
+			}
+			if ticket.Since, err = parseTime(queuedSince.String); err != nil {
+				return nil, err
+			}
+			record.Ticket = &ticket
+		}
+		waiting = append(waiting, record)
+	}
+	return waiting, rows.Err()
+}
+
+// loadGames reads the games the condition on the games table holds for, with their history
+func (sqliteStore *SQLiteStore) loadGames(players map[int]playerRecord, condition string, args ...any) ([]gameRecord, error) {
+	rows, err := sqliteStore.db.Query(`SELECT id, player_a_id, player_b_id, turn_player_id, winner_player_id,
+			hints, open_spectating, ranked, started_at, ended_at, version
+		FROM games WHERE `+condition, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read games: %w", err)
+	}
+	defer rows.Close()
+
+	var games []gameRecord
+	indexByGameId := map[int]int{}
+	for rows.Next() {
+		var record gameRecord
+		var playerAId, playerBId int
+		var turn, winner sql.NullInt64
+		var startedAt string
+		var endedAt sql.NullString
+		if err := rows.Scan(&record.Id, &playerAId, &playerBId, &turn, &winner,
+			&record.Rules.Hints, &record.Rules.OpenSpectating, &record.Rules.Ranked, &startedAt, &endedAt, &record.Version); err != nil {
+			return nil, fmt.Errorf("Cannot read game: %w", err)
+		}
+
+		record.PlayerA, record.PlayerB = players[playerAId], players[playerBId]
+		record.Turn, record.Winner = playerIdOf(turn), playerIdOf(winner)
+		record.History = []engine.Shot{}
+		if record.StartedAt, err = parseTime(startedAt); err != nil {
+			return nil, err
+		}
+		if endedAt.Valid {
+			if record.EndedAt, err = parseTime(endedAt.String); err != nil {
+				return nil, err
+			}
+		}
+		indexByGameId[record.Id] = len(games)
+		games = append(games, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Cannot read games: %w", err)
+	}
+
+	shots, err := sqliteStore.db.Query(`SELECT shots.game_id, shots.player_id, shots.x, shots.y, shots.hit, shots.sank
+		FROM shots JOIN games ON games.id = shots.game_id
+		WHERE `+condition+`
+		ORDER BY shots.game_id, shots.position`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read shots: %w", err)
+	}
+	defer shots.Close()
+
+	for shots.Next() {
+		var gameId int
+		var shot engine.Shot
+		if err := shots.Scan(&gameId, &shot.PlayerId, &shot.Cell.X, &shot.Cell.Y, &shot.Hit, &shot.Sank); err != nil {
+			return nil, fmt.Errorf("Cannot read shot: %w", err)
+		}
+		index := indexByGameId[gameId]
+		games[index].History = append(games[index].History, shot)
+	}
+	return games, shots.Err()
+}
+
+// saveWaiting saves the waiting player, with the rules they wait with and their place in the matchmaking queue
+func saveWaiting(tx *sql.Tx, record waitingRecord) error {
+	if err := savePlayer(tx, record.Player, nil); err != nil {
+		return err
+	}
+
+	var hints, openSpectating, ranked any
+	if record.Rules != nil {
+		hints, openSpectating, ranked = record.Rules.Hints, record.Rules.OpenSpectating, record.Rules.Ranked
+	}
+	var inviteCode any
+	if record.InviteCode != "" {
+		inviteCode = record.InviteCode
+	}
+	_, err := tx.Exec(`INSERT INTO waiting (player_id, since, hints, open_spectating, ranked, invite_code) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (player_id) DO UPDATE SET since = excluded.since, hints = excluded.hints, open_spectating = excluded.open_spectating,
+			ranked = excluded.ranked, invite_code = excluded.invite_code`,
+		record.Player.Id, formatTime(record.Since), hints, openSpectating, ranked, inviteCode)
+	if err != nil {
+		return fmt.Errorf("Cannot save waiting player %d: %w", record.Player.Id, err)
+	}
+
+	if record.Ticket == nil {
+		if _, err := tx.Exec(`DELETE FROM queue WHERE player_id = ?`, record.Player.Id); err != nil {
+			return fmt.Errorf("Cannot save waiting player %d: %w", record.Player.Id, err)
+		}
+		return nil
+	}
+	ticket := record.Ticket
+	_, err = tx.Exec(`INSERT INTO queue (player_id, hints, open_spectating, ranked, rating, rated, since) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (player_id) DO UPDATE SET hints = excluded.hints, open_spectating = excluded.open_spectating, ranked = excluded.ranked,
+			rating = excluded.rating, rated = excluded.rated, since = excluded.since`,
+		ticket.PlayerId, ticket.Rules.Hints, ticket.Rules.OpenSpectating, ticket.Rules.Ranked, ticket.Rating, ticket.Rated, formatTime(ticket.Since))
+	if err != nil {
+		return fmt.Errorf("Cannot save queued player %d: %w", record.Player.Id, err)
+	}
+	return nil
+}
+
+// saveGame saves the game, with both players and the whole history
+func saveGame(tx *sql.Tx, record gameRecord) error {
+	var endedAt any
+	if !record.EndedAt.IsZero() {
+		endedAt = formatTime(record.EndedAt)
+	}
+	_, err := tx.Exec(`INSERT INTO games (id, player_a_id, player_b_id, turn_player_id, winner_player_id, hints, open_spectating, ranked, started_at, ended_at, version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET player_a_id = excluded.player_a_id, player_b_id = excluded.player_b_id,
+			turn_player_id = excluded.turn_player_id, winner_player_id = excluded.winner_player_id, hints = excluded.hints,
+			open_spectating = excluded.open_spectating, ranked = excluded.ranked, started_at = excluded.started_at, ended_at = excluded.ended_at,
+			version = excluded.version`,
+		record.Id, record.PlayerA.Id, record.PlayerB.Id, record.Turn, record.Winner,
+		record.Rules.Hints, record.Rules.OpenSpectating, record.Rules.Ranked, formatTime(record.StartedAt), endedAt, record.Version)
+	if err != nil {
+		return fmt.Errorf("Cannot save game %d: %w", record.Id, err)
+	}
+
+	for _, player := range []playerRecord{record.PlayerA, record.PlayerB} {
+		if err := savePlayer(tx, player, &record.Id); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM shots WHERE game_id = ?`, record.Id); err != nil {
+		return fmt.Errorf("Cannot save history of game %d: %w", record.Id, err)
+	}
+	for position, shot := range record.History {
+		_, err := tx.Exec(`INSERT INTO shots (game_id, position, player_id, x, y, hit, sank) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			record.Id, position, shot.PlayerId, shot.Cell.X, shot.Cell.Y, shot.Hit, shot.Sank)
+		if err != nil {
+			return fmt.Errorf("Cannot save history of game %d: %w", record.Id, err)
+		}
+	}
+	return nil
+}
+
+// savePlayer saves the player, with their ships, in the game, or waiting if the game id is nil
+func savePlayer(tx *sql.Tx, record playerRecord, gameId *int) error {
+	hits, err := json.Marshal(record.Hits)
+	if err != nil {
+		return fmt.Errorf("Cannot encode hits of player %d: %w", record.Id, err)
+	}
+	misses, err := json.Marshal(record.Misses)
+	if err != nil {
+		return fmt.Errorf("Cannot encode misses of player %d: %w", record.Id, err)
+	}
+	var userId any
+	if record.UserId != 0 {
+		userId = record.UserId
+	}
+	_, err = tx.Exec(`INSERT INTO players (id, game_id, name, user_id, hits, misses, version) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET game_id = excluded.game_id, name = excluded.name, user_id = excluded.user_id,
+			hits = excluded.hits, misses = excluded.misses, version = excluded.version`,
+		record.Id, gameId, record.Name, userId, string(hits), string(misses), record.Version)
+	if err != nil {
+		return fmt.Errorf("Cannot save player %d: %w", record.Id, err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM ships WHERE player_id = ?`, record.Id); err != nil {
+		return fmt.Errorf("Cannot save ships of player %d: %w", record.Id, err)
+	}
+	for board, ships := range map[string][][]engine.Cell{fleetBoard: record.Ships, targetBoard: record.SankShips} {
+		for position, cells := range ships {
+			encoded, err := json.Marshal(cells)
+			if err != nil {
+				return fmt.Errorf("Cannot encode ship of player %d: %w", record.Id, err)
+			}
+			_, err = tx.Exec(`INSERT INTO ships (player_id, board, position, cells) VALUES (?, ?, ?, ?)`, record.Id, board, position, string(encoded))
+			if err != nil {
+				return fmt.Errorf("Cannot save ships of player %d: %w", record.Id, err)
+			}
+		}
+	}
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+func parseTime(value string) (time.Time, error) {
+	t, err := time.Parse(timeFormat, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Cannot parse time %v: %w", value, err)
+	}
+	return t, nil
+}
+
+// playerIdOf returns the pointer to the player id in the column, or nil if there's none
+func playerIdOf(column sql.NullInt64) *int {
+	if !column.Valid {
+		return nil
+	}
+	playerId := int(column.Int64)
+	return &playerId
+}
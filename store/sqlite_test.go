@@ -0,0 +1,176 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danilopavk/battleshipper/engine"
+	"github.com/danilopavk/battleshipper/users"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func Test_SQLiteStoreRestores(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "battleshipper.db")
+	sqliteStore := openSQLiteStore(t, path)
+
+	karsa, _ := sqliteStore.StartGameWithRules("Karsa Orlong", 0, engine.RankedRules())
+	game, _ := sqliteStore.JoinGame("Fiddler", 0, karsa.Id)
+	_ = game.PlayerA.PlaceRandomly()
+	_ = game.PlayerB.PlaceRandomly()
+	_, _, _, _ = game.Shoot(karsa.Id, engine.Cell{X: 3, Y: 4})
+	_ = sqliteStore.UpdateGame(game)
+	_ = sqliteStore.AssignUser(karsa.Id, 7)
+	game, _ = sqliteStore.GetGame(game.Id)
+	hedge, code, _ := sqliteStore.StartPrivateGame("Hedge", 0)
+	quickBen, _, _ := sqliteStore.FindMatch("Quick Ben", Ticket{Rules: engine.DefaultRules(), Rating: 1600, Rated: true})
+	kalam, _, _ := sqliteStore.FindMatch("Kalam", Ticket{Rules: engine.RankedRules()})
+	tavore, _ := sqliteStore.StartGameWithRules("Tavore", 0, engine.Rules{OpenSpectating: true})
+	sqliteStore.Close()
+
+	restored := openSQLiteStore(t, path)
+	defer restored.Close()
+
+	restoredGame, err := restored.GetGame(game.Id)
+	if err != nil {
+		t.Fatalf("Cannot find the game after restart: %v", err)
+	}
+	if diff := cmp.Diff(game, restoredGame, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected game after restart (-want +got):\n%s", diff)
+	}
+	if restoredCode, ok := restored.InviteCode(hedge.Id); !ok || restoredCode != code {
+		t.Errorf("Expected Hedge to wait with invite code %v, but got %v", code, restoredCode)
+	}
+	if !restored.Queued(quickBen.Id) || !restored.Queued(kalam.Id) {
+		t.Error("Expected Quick Ben and Kalam to wait in the matchmaking queue")
+	}
+	if rules, _ := restored.WaitingRules(tavore.Id); rules != (engine.Rules{OpenSpectating: true}) {
+		t.Errorf("Expected Tavore to wait with the chosen rules, but got %v", rules)
+	}
+	if players := restored.AllWaitingPlayers(); len(players) != 1 || players[0].Id != tavore.Id {
+		t.Errorf("Expected only Tavore to be listed, but got %v", players)
+	}
+
+	_, matched, _ := restored.FindMatch("Paran", Ticket{Rules: engine.DefaultRules()})
+	if matched.PlayerA.Id != quickBen.Id {
+		t.Errorf("Expected Paran to be matched with Quick Ben, but got %v", matched.PlayerA.Name)
+	}
+}
+
+func Test_SQLiteStoreTables(t *testing.T) {
+	sqliteStore := openSQLiteStore(t, filepath.Join(t.TempDir(), "battleshipper.db"))
+	defer sqliteStore.Close()
+
+	karsa, _ := sqliteStore.StartGame("Karsa Orlong", 0)
+	game, _ := sqliteStore.JoinGame("Fiddler", 0, karsa.Id)
+	_ = game.PlayerA.PlaceRandomly()
+	_ = game.PlayerB.PlaceRandomly()
+	_, _, _, _ = game.Shoot(karsa.Id, engine.Cell{X: 3, Y: 4})
+	_ = sqliteStore.UpdateGame(game)
+	quickBen, _, _ := sqliteStore.FindMatch("Quick Ben", Ticket{Rules: engine.DefaultRules()})
+	_ = sqliteStore.CancelMatch(quickBen.Id)
+
+	var shots, ships, waiting, players int
+	row := sqliteStore.db.QueryRow(`SELECT
+		(SELECT COUNT(*) FROM shots WHERE game_id = ? AND player_id = ? AND x = 3 AND y = 4),
+		(SELECT COUNT(*) FROM ships JOIN players ON players.id = ships.player_id WHERE players.game_id = ? AND board = 'fleet'),
+		(SELECT COUNT(*) FROM waiting),
+		(SELECT COUNT(*) FROM players)`, game.Id, karsa.Id, game.Id)
+	if err := row.Scan(&shots, &ships, &waiting, &players); err != nil {
+		t.Fatalf("Cannot query the tables: %v", err)
+	}
+	if shots != 1 || ships != 10 || waiting != 0 || players != 2 {
+		t.Errorf("Expected 1 shot, 10 ships, no waiting and 2 players, but got %d, %d, %d and %d", shots, ships, waiting, players)
+	}
+}
+
+func Test_SQLiteStoreUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "battleshipper.db")
+	sqliteStore := openSQLiteStore(t, path)
+	registry := users.InitializePersistentRegistry(nil, sqliteStore.SaveUser)
+	tehol, _ := registry.Register("tehol", "", "bugg's hammer")
+	_, _ = registry.Rename(tehol.Id, "Tehol the Only")
+	sqliteStore.Close()
+
+	restored := openSQLiteStore(t, path)
+	defer restored.Close()
+	saved, err := restored.Users()
+	if err != nil {
+		t.Fatalf("Cannot read users: %v", err)
+	}
+	if len(saved) != 1 || saved[0].DisplayName != "Tehol the Only" || !saved[0].CreatedAt.Equal(tehol.CreatedAt) {
+		t.Errorf("Expected renamed Tehol to be saved, but got %v", saved)
+	}
+}
+
+func Test_SQLiteStoreBots(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "battleshipper.db")
+	sqliteStore := openSQLiteStore(t, path)
+	kruppe := BotRecord{PlayerId: 1, Name: "Kruppe", CallbackURL: "https://kruppe.example.com/shot"}
+	baruk := BotRecord{PlayerId: 2, Name: "Baruk", CallbackURL: "https://baruk.example.com/shot"}
+	_ = sqliteStore.SaveBot(kruppe)
+	_ = sqliteStore.SaveBot(baruk)
+	_ = sqliteStore.RemoveBot(baruk.PlayerId)
+	sqliteStore.Close()
+
+	restored := openSQLiteStore(t, path)
+	defer restored.Close()
+	saved, err := restored.Bots()
+	if err != nil {
+		t.Fatalf("Cannot read bots: %v", err)
+	}
+	if diff := cmp.Diff([]BotRecord{kruppe}, saved); diff != "" {
+		t.Errorf("Expected only Kruppe to stay registered (-want +got):\n%s", diff)
+	}
+}
+
+func Test_SQLiteStoreMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "battleshipper.db")
+	openSQLiteStore(t, path).Close()
+
+	sqliteStore := openSQLiteStore(t, path)
+	var version int
+	if err := sqliteStore.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil || version != len(migrations) {
+		t.Errorf("Expected schema version %d, but got %d and error %v", len(migrations), version, err)
+	}
+	_, _ = sqliteStore.db.Exec(`PRAGMA user_version = 1000`)
+	sqliteStore.Close()
+
+	if _, err := InitializeSQLiteStore(path); err == nil {
+		t.Error("Expected error for the database newer than the store")
+	}
+}
+
+func Test_SQLiteStoreArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "battleshipper.db")
+	sqliteStore := openSQLiteStore(t, path)
+
+	karsa, _ := sqliteStore.StartGame("Karsa Orlong", 0)
+	game, _ := sqliteStore.JoinGame("Fiddler", 0, karsa.Id)
+	_ = game.Forfeit(karsa.Id)
+	_ = sqliteStore.UpdateGame(game)
+	archived := sqliteStore.ArchiveFinished(time.Now().Add(time.Minute))
+	sqliteStore.Close()
+
+	restored := openSQLiteStore(t, path)
+	defer restored.Close()
+	if _, err := restored.GetGame(game.Id); err == nil {
+		t.Error("Expected the archived game to stay out of the started games after restart")
+	}
+	finished := restored.FinishedGames()
+	if len(finished) != 1 {
+		t.Fatalf("Expected the archived game to be read, but got %v", finished)
+	}
+	if diff := cmp.Diff(archived[0], finished[0], cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected archived game (-want +got):\n%s", diff)
+	}
+}
+
+func openSQLiteStore(t *testing.T, path string) *SQLiteStore {
+	sqliteStore, err := InitializeSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("Cannot open SQLite store: %v", err)
+	}
+	return sqliteStore
+}
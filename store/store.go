@@ -1,34 +1,66 @@
 // Package that represents an in-memory store for battlershipper game.
 //
 // It tracks all the games in memory, and performs the basic management operations
-// for a game, like start game, join game, retrieve and update.
+// for a game, like start game, join game, retrieve and update. The operations are
+// described by the GameRepository interface, which the rest of the game depends on.
 package store
 
 import (
+	"errors"
 	"fmt"
+	"slices"
 	"sync"
+	"time"
 
+	"github.com/danilopavk/battleshipper/archive"
 	"github.com/danilopavk/battleshipper/engine"
 )
 
-// Store type stores all the data related to the game.
+// Store type stores all the data related to the game in memory.
 //
-// Has one lock for both pending and running games, so potentially we could
-// separate this into 2 structs if we see that locks are slowing
-// down the game, so at least one of the game phases are spared.
+// The maps are private, so they are never read or changed without holding
+// the lock. Players and games are handed out and taken in as deep copies,
+// so the callers can't change them behind the lock. The waiting players
+// share one lock, while the started games are spread across the shards by
+// their ids, each shard with its own lock, so the moves in different games
+// don't wait for each other. When both are needed, the lock of the waiting
+// players is always taken first.
 type Store struct {
+	mutex                  sync.RWMutex
+	waitingPlayers         map[int]engine.Player
+	waitingSinceByPlayerId map[int]time.Time
+	rulesByPlayerId        map[int]engine.Rules
+	inviteCodeByPlayerId   map[int]string
+	playerIdByInviteCode   map[string]int
+	queue                  []Ticket
+	archive                []engine.Game
+	shards                 [shardCount]shard
+}
+
+// shardCount is how many shards the started games are spread across
+const shardCount = 64
+
+// shard holds the started games whose ids fall into it, and the game ids of the players whose ids fall into it
+type shard struct {
 	mutex            sync.RWMutex
-	GamesByGameId    map[int]engine.Game
-	GameIdByPlayerId map[int]int
-	WaitingPlayers   map[int]engine.Player
+	gamesByGameId    map[int]engine.Game
+	gameIdByPlayerId map[int]int
 }
 
+// ErrNotWaiting is returned when joining a player that isn't waiting for the opponent
+var ErrNotWaiting = errors.New("Player is not waiting for the opponent")
+
+// ErrConflict is returned when saving the game or the player that was changed by someone else since it was read
+var ErrConflict = errors.New("Game was changed in the meantime")
+
 // InitializeStore builds the empty store
 func InitializeStore() Store {
 	return Store{
-		GamesByGameId:    map[int]engine.Game{},
-		GameIdByPlayerId: map[int]int{},
-		WaitingPlayers:   map[int]engine.Player{},
+		waitingPlayers:         map[int]engine.Player{},
+		waitingSinceByPlayerId: map[int]time.Time{},
+		rulesByPlayerId:        map[int]engine.Rules{},
+		inviteCodeByPlayerId:   map[int]string{},
+		playerIdByInviteCode:   map[string]int{},
 	}
 }
 
@@ -36,121 +68,523 @@ func InitializeStore() Store {
 //
 // Adds the player to waiting players map,
 // where they will wait for someone to join their game.
-func (store *Store) StartGame(playerName string) engine.Player {
+// The player plays for the user, or is a guest when the user id is 0.
+// Never fails in memory, the error is for the stores that save the player.
+func (store *Store) StartGame(playerName string, userId int) (engine.Player, error) {
+	return store.StartGameWithRules(playerName, userId, engine.DefaultRules())
+}
+
+// StartGameWithRules starts a new game, played by the given rules once someone joins
+func (store *Store) StartGameWithRules(playerName string, userId int, rules engine.Rules) (engine.Player, error) {
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
-	player := engine.InitializePlayer(playerName)
-	store.WaitingPlayers[player.Id] = player
+	player := initializePlayer(playerName, userId)
+	store.waitingPlayers[player.Id] = player
+	store.waitingSinceByPlayerId[player.Id] = time.Now()
+	if rules != engine.DefaultRules() {
+		store.rulesByPlayerId[player.Id] = rules
+	}
 
-	return player
+	return player.Clone(), nil
+}
+
+// StartGameAs starts a new game with the player already set up, like a bot with its ships placed.
+//
+// The player is listed only once it's ready, so nobody joins it halfway.
+// Returns ErrExists if the player is already in the store.
+func (store *Store) StartGameAs(player engine.Player) (engine.Player, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if err := store.checkPlayerAbsent(player.Id); err != nil {
+		return engine.Player{}, err
+	}
+	store.waitingPlayers[player.Id] = player.Clone()
+	store.waitingSinceByPlayerId[player.Id] = time.Now()
+
+	return player.Clone(), nil
+}
+
+// StartPrivateGame starts a new game that can only be joined with the invite code.
+//
+// The player waits like in any other game, but never shows up among
+// AllWaitingPlayers. Returns the player and the invite code.
+func (store *Store) StartPrivateGame(playerName string, userId int) (engine.Player, string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	code, err := store.newInviteCode()
+	if err != nil {
+		return engine.Player{}, "", err
+	}
+
+	player := initializePlayer(playerName, userId)
+	store.waitingPlayers[player.Id] = player
+	store.waitingSinceByPlayerId[player.Id] = time.Now()
+	store.inviteCodeByPlayerId[player.Id] = code
+	store.playerIdByInviteCode[code] = player.Id
+
+	return player.Clone(), code, nil
 }
 
 // AllWaitingPlayers method returns a list of all waiting players.
 //
 // Should be used to offer list of
-// potential players whom games one might join
+// potential players whom games one might join.
+// Players waiting in private games or in the matchmaking queue are left out.
 func (store *Store) AllWaitingPlayers() []engine.Player {
 	store.mutex.RLock()
 	defer store.mutex.RUnlock()
 
 	var players []engine.Player
-	for _, player := range store.WaitingPlayers {
-		players = append(players, player)
+	for _, player := range store.waitingPlayers {
+		if store.listed(player.Id) {
+			players = append(players, player.Clone())
+		}
 	}
 
 	return players
 }
 
+// WaitingSince returns the time when the player started waiting for the opponent.
+//
+// Returns false if the player isn't waiting.
+func (store *Store) WaitingSince(playerId int) (time.Time, bool) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	since, ok := store.waitingSinceByPlayerId[playerId]
+	return since, ok
+}
+
+// WaitingRules returns the rules of the game the player is waiting in.
+//
+// Returns false if the player isn't waiting.
+func (store *Store) WaitingRules(playerId int) (engine.Rules, bool) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	if _, ok := store.waitingPlayers[playerId]; !ok {
+		return engine.Rules{}, false
+	}
+	return store.rules(playerId), true
+}
+
 // GetPlayerAndGame method retrieves a player and the corresponding game by player id.
 
 // If player is in a waiting state,game will be nil.
 func (store *Store) GetPlayerAndGame(playerId int) (engine.Player, engine.Game, error) {
+	if player, game, ok := store.playerInGame(playerId); ok {
+		return player, game, nil
+	}
+
+	if waitingPlayer, ok := store.waitingPlayer(playerId); ok {
+		return waitingPlayer, engine.Game{}, nil
+	}
+
+	// the game could have started while the player was looked up among the waiting ones
+	if player, game, ok := store.playerInGame(playerId); ok {
+		return player, game, nil
+	}
+	return engine.Player{}, engine.Game{}, fmt.Errorf("Game not found for player %d", playerId)
+}
+
+// InviteCode returns the invite code of the player waiting in a private game.
+//
+// Returns false if the player isn't waiting in a private game.
+func (store *Store) InviteCode(playerId int) (string, bool) {
 	store.mutex.RLock()
 	defer store.mutex.RUnlock()
 
-	if waitingPlayer, ok := store.WaitingPlayers[playerId]; ok {
-		return waitingPlayer, engine.Game{}, nil
+	code, ok := store.inviteCodeByPlayerId[playerId]
+	return code, ok
+}
+
+// GetGame retrieves the game by its id
+func (store *Store) GetGame(gameId int) (engine.Game, error) {
+	game, ok := store.game(gameId)
+	if !ok {
+		return engine.Game{}, fmt.Errorf("Cannot find game with id %d", gameId)
+	}
+	return game, nil
+}
+
+// Games returns all the started games, the earliest started first
+func (store *Store) Games() []engine.Game {
+	games := store.allGames()
+	slices.SortFunc(games, func(a, b engine.Game) int {
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+	return games
+}
+
+// FinishedGames returns all the games that have a winner, archived or not, the earliest ended first
+func (store *Store) FinishedGames() []engine.Game {
+	games := store.activeFinishedGames()
+
+	store.mutex.RLock()
+	for _, game := range store.archive {
+		games = append(games, game.Clone())
 	}
+	store.mutex.RUnlock()
+
+	sortByEnd(games)
+	return games
+}
 
-	if gameId, ok := store.GameIdByPlayerId[playerId]; ok {
-		if game, ok := store.GamesByGameId[gameId]; ok {
-			switch playerId {
-			case game.PlayerA.Id:
-				return game.PlayerA, game, nil
-			case game.PlayerB.Id:
-				return game.PlayerB, game, nil
-			default:
-				return engine.Player{}, engine.Game{}, fmt.Errorf("Internal error, game found for player id %d, but it doesn't contain the same player id", playerId)
-			}
+// FinishedGame returns the game by its id, if it has a winner, archived or not
+func (store *Store) FinishedGame(gameId int) (engine.Game, error) {
+	if game, ok := store.game(gameId); ok && game.Winner != nil {
+		return game, nil
+	}
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	for _, game := range store.archive {
+		if game.Id == gameId {
+			return game.Clone(), nil
 		}
-		return engine.Player{}, engine.Game{}, fmt.Errorf("Internal error, game id found for player id %d, but can't find the game with that id: %d", playerId, gameId)
 	}
+	return engine.Game{}, fmt.Errorf("Cannot find finished game with id %d", gameId)
+}
 
-	return engine.Player{}, engine.Game{}, fmt.Errorf("Game not found for player %d", playerId)
+// SearchFinished finds the games that have a winner matching the query, archived or not
+func (store *Store) SearchFinished(query archive.Query) (archive.Page, error) {
+	return archive.Search(store.FinishedGames(), query)
+}
+
+// activeFinishedGames returns the games that have a winner, but aren't archived yet
+func (store *Store) activeFinishedGames() []engine.Game {
+	var games []engine.Game
+	for _, game := range store.allGames() {
+		if game.Winner != nil {
+			games = append(games, game)
+		}
+	}
+	return games
+}
+
+// sortByEnd sorts the finished games, the earliest ended first
+func sortByEnd(games []engine.Game) {
+	slices.SortFunc(games, func(a, b engine.Game) int {
+		return a.EndedAt.Compare(b.EndedAt)
+	})
 }
 
 // UpdatePlayer updates a player in the db.
 
-// It can be either a waiting player, or one in the active game.
+// It can be either a waiting player, or one in the active game. Returns
+// ErrConflict if the player's version isn't the stored one, because someone
+// else saved the player or their game since it was read.
 func (store *Store) UpdatePlayer(player engine.Player) error {
-	store.mutex.Lock()
-	defer store.mutex.Unlock()
+	_, _, err := store.changePlayer(player.Id, func(stored *engine.Player, game *engine.Game) error {
+		if player.Version != stored.Version {
+			return conflict("player", player.Id, player.Version, stored.Version)
+		}
+		*stored = player.Clone()
+		return nil
+	})
+	return err
+}
 
-	if _, ok := store.WaitingPlayers[player.Id]; ok {
-		store.WaitingPlayers[player.Id] = player
+// AssignUser links the player to the account of the user playing the game.
+//
+// It works both for the waiting players and for the ones already in the game.
+func (store *Store) AssignUser(playerId int, userId int) error {
+	_, _, err := store.changePlayer(playerId, func(player *engine.Player, game *engine.Game) error {
+		player.UserId = userId
 		return nil
-	}
+	})
+	return err
+}
 
-	if gameId, ok := store.GameIdByPlayerId[player.Id]; ok {
-		if game, ok := store.GamesByGameId[gameId]; ok {
-			switch player.Id {
-			case game.PlayerA.Id:
-				{
-					game.PlayerA = player
-					return nil
-				}
-			case game.PlayerB.Id:
-				{
-					game.PlayerB = player
-					return nil
-				}
-			}
-		}
+// JoinGame joins a game that the opponent already started.
+//
+// Returns ErrNotWaiting if the opponent isn't waiting, for example when
+// someone else joined their game first. Private games can't be joined
+// this way, they look like the opponent isn't waiting.
+func (store *Store) JoinGame(playerName string, userId int, opponentId int) (engine.Game, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	playerA, ok := store.waitingPlayers[opponentId]
+	if !ok || !store.listed(opponentId) {
+		return engine.Game{}, fmt.Errorf("Cannot join player %d: %w", opponentId, ErrNotWaiting)
 	}
-	return fmt.Errorf("Not found player with id %d to update", player.Id)
+	return store.join(playerA, initializePlayer(playerName, userId)), nil
 }
 
-// JoinGame joins a game that the opponent already started
-func (store *Store) JoinGame(playerName string, opponentId int) engine.Game {
+// JoinPrivateGame joins the private game with the invite code.
+//
+// The code is matched regardless of the case and the dashes or spaces in it.
+// Returns ErrNotWaiting if there's no game waiting with that code.
+func (store *Store) JoinPrivateGame(playerName string, userId int, code string) (engine.Game, error) {
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
-	playerA := store.WaitingPlayers[opponentId]
-	playerB := engine.InitializePlayer(playerName)
+	opponentId, ok := store.playerIdByInviteCode[NormalizeInviteCode(code)]
+	if !ok {
+		return engine.Game{}, fmt.Errorf("Cannot join game with invite code %v: %w", code, ErrNotWaiting)
+	}
+	return store.join(store.waitingPlayers[opponentId], initializePlayer(playerName, userId)), nil
+}
 
-	game := engine.InitializeGame(playerA, playerB, playerA.Id)
+// listed checks whether anyone can join the waiting player's game, and must be called with the lock held
+func (store *Store) listed(playerId int) bool {
+	if _, private := store.inviteCodeByPlayerId[playerId]; private {
+		return false
+	}
+	return store.queued(playerId) < 0
+}
 
-	delete(store.WaitingPlayers, playerA.Id)
-	store.GameIdByPlayerId[playerA.Id] = game.Id
-	store.GameIdByPlayerId[playerB.Id] = game.Id
-	store.GamesByGameId[game.Id] = game
+// rules returns the rules the waiting player chose, and must be called with the lock held
+func (store *Store) rules(playerId int) engine.Rules {
+	if index := store.queued(playerId); index >= 0 {
+		return store.queue[index].Rules
+	}
+	if rules, ok := store.rulesByPlayerId[playerId]; ok {
+		return rules
+	}
+	return engine.DefaultRules()
+}
 
-	return game
+// join starts the game of the waiting player a with the new player b, and must be called with the lock held
+func (store *Store) join(playerA engine.Player, playerB engine.Player) engine.Game {
+	return store.startBetween(playerA, playerB, store.rules(playerA.Id))
+}
+
+// initializePlayer creates the new player playing for the user, or a guest when the user id is 0
+func initializePlayer(playerName string, userId int) engine.Player {
+	player := engine.InitializePlayer(playerName)
+	player.UserId = userId
+	return player
+}
+
+// startBetween starts the game, with player a as the host, and must be called with the lock held.
+//
+// Both players stop waiting, if they were. The game starts at the version
+// after the waiting versions of both players, so a player read while still
+// waiting can't be saved into the game.
+func (store *Store) startBetween(playerA engine.Player, playerB engine.Player, rules engine.Rules) engine.Game {
+	game := versioned(engine.InitializeGame(playerA, playerB, playerA.Id), max(playerA.Version, playerB.Version)+1)
+	game.Rules = rules
+
+	store.forget(playerA.Id)
+	store.forget(playerB.Id)
+	store.putGame(game)
+
+	return game.Clone()
+}
+
+// forget removes the player from the waiting players, and must be called with the lock held
+func (store *Store) forget(playerId int) {
+	delete(store.waitingPlayers, playerId)
+	delete(store.waitingSinceByPlayerId, playerId)
+	delete(store.rulesByPlayerId, playerId)
+	delete(store.playerIdByInviteCode, store.inviteCodeByPlayerId[playerId])
+	delete(store.inviteCodeByPlayerId, playerId)
+	if index := store.queued(playerId); index >= 0 {
+		store.queue = append(store.queue[:index:index], store.queue[index+1:]...)
+	}
 }
 
 // UpdateGame updates a game.
 
-// It can update anything about the game - player data, or some game metadata
+// It can update anything about the game - player data, or some game metadata.
+// The game is only saved if its version is the stored one, and is stored with
+// the version increased by one. Otherwise, someone else saved the game since
+// it was read, and ErrConflict is returned, so the change can be made again on
+// the fresh game, like UpdateWithRetry does.
 func (store *Store) UpdateGame(game engine.Game) error {
+	_, err := store.changeGame(game.Id, func(stored *engine.Game) error {
+		if game.Version != stored.Version {
+			return conflict("game", game.Id, game.Version, stored.Version)
+		}
+		*stored = game.Clone()
+		return nil
+	})
+	return err
+}
+
+// changePlayer changes the player, and stores them with the next version.
+//
+// The waiting player is changed under the lock of the waiting players, and
+// the player in the game under the lock of the game's shard alone. The game
+// given to the change is nil while the player is waiting. Nothing is stored
+// if the change returns error. Returns the changed player, and the game if
+// the player isn't waiting anymore.
+func (store *Store) changePlayer(playerId int, change func(player *engine.Player, game *engine.Game) error) (engine.Player, engine.Game, error) {
+	if gameId, ok := store.gameIdOf(playerId); ok {
+		return store.changePlayerInGame(gameId, playerId, change)
+	}
+
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
-	if _, ok := store.GamesByGameId[game.Id]; !ok {
-		return fmt.Errorf("Cannot find game with id %d", game.Id)
+	player, ok := store.waitingPlayers[playerId]
+	if !ok {
+		// the game could have started while the lock was being taken
+		if gameId, ok := store.gameIdOf(playerId); ok {
+			return store.changePlayerInGame(gameId, playerId, change)
+		}
+		return engine.Player{}, engine.Game{}, fmt.Errorf("Game not found for player %d", playerId)
+	}
+	if err := change(&player, nil); err != nil {
+		return engine.Player{}, engine.Game{}, err
+	}
+	player.Version++
+	store.waitingPlayers[playerId] = player
+	return player.Clone(), engine.Game{}, nil
+}
+
+// changePlayerInGame changes the player in the game, and stores the game with the next version
+func (store *Store) changePlayerInGame(gameId int, playerId int, change func(player *engine.Player, game *engine.Game) error) (engine.Player, engine.Game, error) {
+	game, err := store.changeGame(gameId, func(game *engine.Game) error {
+		return change(playerIn(game, playerId), game)
+	})
+	if err != nil {
+		return engine.Player{}, engine.Game{}, err
+	}
+	return *playerIn(&game, playerId), game, nil
+}
+
+// changeGame changes the game under the lock of its shard, and stores it with the next version.
+//
+// Nothing is stored if the change returns error.
+func (store *Store) changeGame(gameId int, change func(game *engine.Game) error) (engine.Game, error) {
+	shard := store.shardOf(gameId)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	game, ok := shard.gamesByGameId[gameId]
+	if !ok {
+		return engine.Game{}, fmt.Errorf("Cannot find game with id %d", gameId)
+	}
+	if err := change(&game); err != nil {
+		return engine.Game{}, err
+	}
+	game = versioned(game, game.Version+1)
+	shard.gamesByGameId[gameId] = game
+	return game.Clone(), nil
+}
+
+// putGame stores the started game, and links its players to it
+func (store *Store) putGame(game engine.Game) {
+	gameShard := store.shardOf(game.Id)
+	gameShard.mutex.Lock()
+	if gameShard.gamesByGameId == nil {
+		gameShard.gamesByGameId = map[int]engine.Game{}
 	}
+	gameShard.gamesByGameId[game.Id] = game
+	gameShard.mutex.Unlock()
+
+	// the players are linked only once the game is stored, so their game is always found
+	for _, playerId := range []int{game.PlayerA.Id, game.PlayerB.Id} {
+		playerShard := store.shardOf(playerId)
+		playerShard.mutex.Lock()
+		if playerShard.gameIdByPlayerId == nil {
+			playerShard.gameIdByPlayerId = map[int]int{}
+		}
+		playerShard.gameIdByPlayerId[playerId] = game.Id
+		playerShard.mutex.Unlock()
+	}
+}
+
+// waitingPlayer returns the copy of the waiting player
+func (store *Store) waitingPlayer(playerId int) (engine.Player, bool) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	player, ok := store.waitingPlayers[playerId]
+	return player.Clone(), ok
+}
+
+// game returns the copy of the started game by its id
+func (store *Store) game(gameId int) (engine.Game, bool) {
+	shard := store.shardOf(gameId)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+
+	game, ok := shard.gamesByGameId[gameId]
+	return game.Clone(), ok
+}
+
+// gameIdOf returns the id of the player's game, and false if the player isn't in a game
+func (store *Store) gameIdOf(playerId int) (int, bool) {
+	shard := store.shardOf(playerId)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+
+	gameId, ok := shard.gameIdByPlayerId[playerId]
+	return gameId, ok
+}
 
-	store.GamesByGameId[game.Id] = game
+// playerInGame returns the player and their game, and false if the player isn't in a game
+func (store *Store) playerInGame(playerId int) (engine.Player, engine.Game, bool) {
+	gameId, ok := store.gameIdOf(playerId)
+	if !ok {
+		return engine.Player{}, engine.Game{}, false
+	}
+	game, ok := store.game(gameId)
+	if !ok {
+		return engine.Player{}, engine.Game{}, false
+	}
+	return *playerIn(&game, playerId), game, true
+}
+
+// allGames returns the copies of all the started games, in no particular order
+func (store *Store) allGames() []engine.Game {
+	var games []engine.Game
+	for index := range store.shards {
+		shard := &store.shards[index]
+		shard.mutex.RLock()
+		for _, game := range shard.gamesByGameId {
+			games = append(games, game.Clone())
+		}
+		shard.mutex.RUnlock()
+	}
+	return games
+}
+
+// gameRecords returns the records of all the started games.
+//
+// Records are made under the lock of the shard, since the moves change the
+// ships and the shots of the stored game in place.
+func (store *Store) gameRecords() []gameRecord {
+	records := []gameRecord{}
+	for index := range store.shards {
+		shard := &store.shards[index]
+		shard.mutex.RLock()
+		for _, game := range shard.gamesByGameId {
+			records = append(records, recordOfGame(game))
+		}
+		shard.mutex.RUnlock()
+	}
+	return records
+}
+
+// shardOf returns the shard the game or the player with the id falls into
+func (store *Store) shardOf(id int) *shard {
+	return &store.shards[uint(id)%shardCount]
+}
+
+// playerIn returns the player of the game with the id
+func playerIn(game *engine.Game, playerId int) *engine.Player {
+	if game.PlayerB.Id == playerId {
+		return &game.PlayerB
+	}
+	return &game.PlayerA
+}
+
+// versioned returns the game with the version, which its players share
+func versioned(game engine.Game, version int) engine.Game {
+	game.Version = version
+	game.PlayerA.Version = version
+	game.PlayerB.Version = version
+	return game
+}
 
-	return nil
+func conflict(kind string, id int, version int, storedVersion int) error {
+	return fmt.Errorf("Cannot update %v %d read at version %d, it's at version %d by now: %w", kind, id, version, storedVersion, ErrConflict)
 }
@@ -1,6 +1,11 @@
 package store
 
 import (
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/danilopavk/battleshipper/engine"
@@ -10,7 +15,7 @@ import (
 func Test_StartGame(t *testing.T) {
 	store := InitializeStore()
 
-	player := store.StartGame("Karsa Orlong")
+	player, _ := store.StartGame("Karsa Orlong", 0)
 
 	if player.Name != "Karsa Orlong" {
 		t.Error("Wrong player name!")
@@ -20,9 +25,9 @@ func Test_StartGame(t *testing.T) {
 func Test_AllWaitingPlayers(t *testing.T) {
 	store := InitializeStore()
 
-	store.StartGame("Karsa Orlong")
-	store.StartGame("Fiddler")
-	store.StartGame("Coltaine")
+	store.StartGame("Karsa Orlong", 0)
+	store.StartGame("Fiddler", 0)
+	store.StartGame("Coltaine", 0)
 
 	allPlayers := store.AllWaitingPlayers()
 
@@ -56,7 +61,7 @@ func Test_AllWaitingPlayers(t *testing.T) {
 func Test_GetWaitingPlayer(t *testing.T) {
 	store := InitializeStore()
 
-	player := store.StartGame("Karsa Orlong")
+	player, _ := store.StartGame("Karsa Orlong", 0)
 
 	savedPlayer, game, error := store.GetPlayerAndGame(player.Id)
 
@@ -76,8 +81,8 @@ func Test_GetWaitingPlayer(t *testing.T) {
 func Test_GetPlayerAndGame(t *testing.T) {
 	store := InitializeStore()
 
-	playerA := store.StartGame("Karsa Orlong")
-	startedGame := store.JoinGame("Fiddler", playerA.Id)
+	playerA, _ := store.StartGame("Karsa Orlong", 0)
+	startedGame, _ := store.JoinGame("Fiddler", 0, playerA.Id)
 
 	karsa, game, error := store.GetPlayerAndGame(playerA.Id)
 
@@ -115,7 +120,7 @@ func Test_GetUnknownPlayer(t *testing.T) {
 func Test_UpdatePendingPlayer(t *testing.T) {
 	store := InitializeStore()
 
-	player := store.StartGame("Karsa Orlong")
+	player, _ := store.StartGame("Karsa Orlong", 0)
 
 	ship := engine.Ship{
 		Cells: map[engine.Cell]bool{
@@ -135,6 +140,8 @@ func Test_UpdatePendingPlayer(t *testing.T) {
 	}
 
 	result, _, _ := store.GetPlayerAndGame(player.Id)
+	// the store saves the player at the next version
+	player.Version++
 
 	if diff := cmp.Diff(player, result); diff != "" {
 		t.Errorf("Unexpected diff on updated player: %v", diff)
@@ -144,8 +151,9 @@ func Test_UpdatePendingPlayer(t *testing.T) {
 func Test_UpdatePlayerInGame(t *testing.T) {
 	store := InitializeStore()
 
-	karsa := store.StartGame("Karsa Orlong")
-	game := store.JoinGame("Fiddler", karsa.Id)
+	karsa, _ := store.StartGame("Karsa Orlong", 0)
+	game, _ := store.JoinGame("Fiddler", 0, karsa.Id)
+	karsa = game.PlayerA
 	fiddler := game.PlayerB
 
 	ship := engine.Ship{
@@ -167,6 +175,9 @@ func Test_UpdatePlayerInGame(t *testing.T) {
 	}
 
 	updatedKarsa, _, _ := store.GetPlayerAndGame(karsa.Id)
+	// the game is saved at the next version, which both players share
+	karsa.Version++
+	fiddler.Version++
 
 	if diff := cmp.Diff(updatedKarsa, karsa); diff != "" {
 		t.Errorf("Unexpected diff %v", diff)
@@ -182,6 +193,10 @@ func Test_UpdatePlayerInGame(t *testing.T) {
 		},
 	}
 	_ = fiddler.AddShip(fiddlerShip)
+	if error := store.UpdatePlayer(fiddler); error != nil {
+		t.Errorf("Unexpected error: %v", error)
+	}
+	fiddler.Version++
 
 	updatedFiddler, _, _ := store.GetPlayerAndGame(fiddler.Id)
 
@@ -194,9 +209,12 @@ func Test_UpdatePlayerInGame(t *testing.T) {
 func Test_JoinGame(t *testing.T) {
 	store := InitializeStore()
 
-	karsa := store.StartGame("Karsa Orlong")
-	game := store.JoinGame("Fiddler", karsa.Id)
+	karsa, _ := store.StartGame("Karsa Orlong", 0)
+	game, err := store.JoinGame("Fiddler", 0, karsa.Id)
 
+	if err != nil {
+		t.Errorf("Expected to join the game, but got error %v", err)
+	}
 	if game.PlayerA.Id != karsa.Id {
 		t.Error("Created the game, but joined with the wrong player!")
 	}
@@ -204,24 +222,139 @@ func Test_JoinGame(t *testing.T) {
 		t.Error("Created the game, but player b is not fiddler!")
 	}
 
-	if _, exists := store.WaitingPlayers[karsa.Id]; exists {
+	if _, exists := store.waitingPlayers[karsa.Id]; exists {
 		t.Error("Expected to remove karsa id from the map, but it's still there")
 	}
-	if store.GameIdByPlayerId[karsa.Id] != game.Id {
+	if gameId, _ := store.gameIdOf(karsa.Id); gameId != game.Id {
 		t.Error("Cannot find game id by Karsa's id")
 	}
-	if store.GameIdByPlayerId[game.PlayerB.Id] != game.Id {
+	if gameId, _ := store.gameIdOf(game.PlayerB.Id); gameId != game.Id {
 		t.Error("Cannot find game id by Fiddler's id")
 	}
-	if _, exists := store.GamesByGameId[game.Id]; !exists {
+	if _, exists := store.game(game.Id); !exists {
 		t.Error("Cannot find game by its id")
 	}
+	if _, waiting := store.WaitingSince(karsa.Id); waiting {
+		t.Error("Expected Karsa to stop waiting after the game was joined")
+	}
+}
+
+func Test_JoinRankedGame(t *testing.T) {
+	store := InitializeStore()
+
+	karsa, _ := store.StartGameWithRules("Karsa Orlong", 0, engine.RankedRules())
+	if rules, waiting := store.WaitingRules(karsa.Id); !waiting || !rules.Ranked {
+		t.Errorf("Expected Karsa to wait in a ranked game, but got rules %v", rules)
+	}
+
+	game, err := store.JoinGame("Fiddler", 0, karsa.Id)
+	if err != nil {
+		t.Fatalf("Expected to join the game, but got error %v", err)
+	}
+	if diff := cmp.Diff(engine.RankedRules(), game.Rules); diff != "" {
+		t.Errorf("Expected the game to be played by the ranked rules (-want +got):\n%s", diff)
+	}
+	if _, waiting := store.WaitingRules(karsa.Id); waiting {
+		t.Error("Expected Karsa to stop waiting after the game was joined")
+	}
+}
+
+func Test_JoinMissingPlayer(t *testing.T) {
+	store := InitializeStore()
+
+	if _, err := store.JoinGame("Fiddler", 0, 42); !errors.Is(err, ErrNotWaiting) {
+		t.Errorf("Expected not waiting error, got %v", err)
+	}
+	if len(store.allGames()) != 0 {
+		t.Error("Expected no game to be created")
+	}
+}
+
+func Test_JoinGameConcurrently(t *testing.T) {
+	store := InitializeStore()
+	karsa, _ := store.StartGame("Karsa Orlong", 0)
+
+	var wait sync.WaitGroup
+	var joined atomic.Int32
+	for _, name := range []string{"Fiddler", "Hedge", "Kalam", "Quick Ben"} {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			if _, err := store.JoinGame(name, 0, karsa.Id); err == nil {
+				joined.Add(1)
+			}
+		}()
+	}
+	wait.Wait()
+
+	if joined.Load() != 1 {
+		t.Errorf("Expected exactly one player to join Karsa, but %d did", joined.Load())
+	}
+	if len(store.allGames()) != 1 {
+		t.Errorf("Expected a single game, got %d", len(store.allGames()))
+	}
+}
+
+func Test_AssignUser(t *testing.T) {
+	store := InitializeStore()
+	karsa, _ := store.StartGame("Karsa Orlong", 0)
+
+	if err := store.AssignUser(karsa.Id, 7); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	game, _ := store.JoinGame("Fiddler", 0, karsa.Id)
+	if game.PlayerA.UserId != 7 {
+		t.Error("Expected the waiting player to keep the user")
+	}
+
+	if err := store.AssignUser(game.PlayerB.Id, 8); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	fiddler, _, _ := store.GetPlayerAndGame(game.PlayerB.Id)
+	if fiddler.UserId != 8 {
+		t.Error("Expected the user to be assigned to the player in the game")
+	}
+	if err := store.AssignUser(42, 9); err == nil {
+		t.Error("Expected error for the unknown player")
+	}
+}
+
+func Test_PrivateGame(t *testing.T) {
+	store := InitializeStore()
+	karsa, code, err := store.StartPrivateGame("Karsa Orlong", 0)
+	if err != nil {
+		t.Fatalf("Cannot start private game: %v", err)
+	}
+
+	if len(store.AllWaitingPlayers()) != 0 {
+		t.Error("Expected the private game not to be listed")
+	}
+	if _, err := store.JoinGame("Fiddler", 0, karsa.Id); !errors.Is(err, ErrNotWaiting) {
+		t.Errorf("Expected not waiting error when joining without the code, got %v", err)
+	}
+	if _, err := store.JoinPrivateGame("Fiddler", 0, "WRONG1"); !errors.Is(err, ErrNotWaiting) {
+		t.Errorf("Expected not waiting error for the wrong code, got %v", err)
+	}
+
+	game, err := store.JoinPrivateGame("Fiddler", 0, strings.ToLower(FormatInviteCode(code)))
+	if err != nil {
+		t.Fatalf("Cannot join with the invite code: %v", err)
+	}
+	if game.PlayerA.Id != karsa.Id {
+		t.Error("Joined the wrong player's game")
+	}
+	if _, ok := store.InviteCode(karsa.Id); ok {
+		t.Error("Expected the invite code to be used up")
+	}
+	if _, err := store.JoinPrivateGame("Hedge", 0, code); !errors.Is(err, ErrNotWaiting) {
+		t.Errorf("Expected not waiting error when the code is reused, got %v", err)
+	}
 }
 
 func Test_UpdateGame(t *testing.T) {
 	store := InitializeStore()
-	karsa := store.StartGame("KarsaOrlong")
-	game := store.JoinGame("Fiddler", karsa.Id)
+	karsa, _ := store.StartGame("KarsaOrlong", 0)
+	game, _ := store.JoinGame("Fiddler", 0, karsa.Id)
 	fiddler := game.PlayerB
 
 	ship := engine.Ship{
@@ -243,6 +376,9 @@ func Test_UpdateGame(t *testing.T) {
 	}
 
 	updatedFiddler, updatedGame, _ := store.GetPlayerAndGame(fiddler.Id)
+	// the store saves the game, and the players in it, at the next version
+	game = versioned(game, game.Version+1)
+	fiddler.Version++
 
 	if diff := cmp.Diff(updatedFiddler, fiddler); diff != "" {
 		t.Errorf("Unexpected diff %v", diff)
@@ -251,3 +387,82 @@ func Test_UpdateGame(t *testing.T) {
 		t.Errorf("Unexpected diff %v", diff)
 	}
 }
+
+func Test_UpdateGameConflict(t *testing.T) {
+	store := InitializeStore()
+	karsa, _ := store.StartGame("Karsa Orlong", 0)
+	game, _ := store.JoinGame("Fiddler", 0, karsa.Id)
+	stale := game
+
+	if err := store.UpdateGame(game); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := store.UpdateGame(stale); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected conflict for the stale game, got %v", err)
+	}
+	if err := store.UpdatePlayer(stale.PlayerA); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected conflict for the player of the stale game, got %v", err)
+	}
+	if err := store.UpdatePlayer(karsa); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected conflict for the player read while waiting, got %v", err)
+	}
+
+	updated, _ := store.GetGame(game.Id)
+	if updated.Version != game.Version+1 {
+		t.Errorf("Expected version %d, got %d", game.Version+1, updated.Version)
+	}
+}
+
+func Benchmark_ShootInManyGames(b *testing.B) {
+	store := InitializeStore()
+
+	// every goroutine plays its own games, so hundreds of games are played at once
+	b.SetParallelism(100)
+	games := make(chan engine.Game, b.N/missesPerGame+100*runtime.GOMAXPROCS(0))
+	for range cap(games) {
+		games <- placedGame(b, &store)
+	}
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		game := <-games
+		shots := 0
+		for pb.Next() {
+			if shots == missesPerGame {
+				game, shots = <-games, 0
+			}
+			shooter := game.PlayerA.Id
+			if shots%2 == 1 {
+				shooter = game.PlayerB.Id
+			}
+			// the odd rows are always missed, so the game never ends
+			cell := engine.Cell{X: shots / 2 % 10, Y: shots/2/10*2 + 1}
+			if _, _, err := store.Shoot(shooter, cell); err != nil {
+				b.Fatalf("Cannot shoot: %v", err)
+			}
+			shots++
+		}
+	})
+}
+
+// missesPerGame is how many shots both players miss in the game placed by placedGame
+const missesPerGame = 100
+
+// placedGame starts the game with the fleets of both players placed on the even rows
+func placedGame(b testing.TB, store *Store) engine.Game {
+	karsa, _ := store.StartGame("Karsa Orlong", 0)
+	game, _ := store.JoinGame("Fiddler", 0, karsa.Id)
+	for _, playerId := range []int{game.PlayerA.Id, game.PlayerB.Id} {
+		for row, length := range []int{5, 4, 4, 3, 3} {
+			ship := engine.Ship{Cells: map[engine.Cell]bool{}}
+			for x := range length {
+				ship.Cells[engine.Cell{X: x, Y: row * 2}] = true
+			}
+			if _, _, err := store.PlaceShip(playerId, ship); err != nil {
+				b.Fatalf("Cannot place ship: %v", err)
+			}
+		}
+	}
+	game, _ = store.GetGame(game.Id)
+	return game
+}
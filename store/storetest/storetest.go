@@ -0,0 +1,1030 @@
+// Package storetest checks that the implementations of store.GameRepository behave the same.
+//
+// The in-memory store is the reference implementation, and every other
+// implementation runs the same suite in its own tests:
+//
+//	func Test_Conformance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) store.GameRepository {
+//			return openEmptyRepository(t)
+//		})
+//	}
+//
+// The suite only uses the methods of the interface, and always reads back what
+// it saved, so it doesn't depend on how the implementation keeps the data.
+package storetest
+
+import (
+	"errors"
+	"maps"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danilopavk/battleshipper/archive"
+	"github.com/danilopavk/battleshipper/engine"
+	"github.com/danilopavk/battleshipper/store"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// Run runs the whole suite, with a new empty repository from newRepository for every test
+func Run(t *testing.T, newRepository func(t *testing.T) store.GameRepository) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repository store.GameRepository)
+	}{
+		{"StartGame", testStartGame},
+		{"StartGameWithRules", testStartGameWithRules},
+		{"StartGameAs", testStartGameAs},
+		{"JoinGame", testJoinGame},
+		{"JoinMissingPlayer", testJoinMissingPlayer},
+		{"JoinGameConcurrently", testJoinGameConcurrently},
+		{"PrivateGame", testPrivateGame},
+		{"FindMatch", testFindMatch},
+		{"CancelMatch", testCancelMatch},
+		{"Matchmake", testMatchmake},
+		{"GetUnknown", testGetUnknown},
+		{"UpdateWaitingPlayer", testUpdateWaitingPlayer},
+		{"UpdatePlayerInGame", testUpdatePlayerInGame},
+		{"UpdateGame", testUpdateGame},
+		{"AssignUser", testAssignUser},
+		{"NewPlayersOfUsers", testNewPlayersOfUsers},
+		{"FinishedGames", testFinishedGames},
+		{"UpdateConflict", testUpdateConflict},
+		{"UpdateWithRetry", testUpdateWithRetry},
+		{"PlaceShip", testPlaceShip},
+		{"Shoot", testShoot},
+		{"ShootInManyGames", testShootInManyGames},
+		{"Snapshots", testSnapshots},
+		{"ReadsAndWritesConcurrently", testReadsAndWritesConcurrently},
+		{"RemoveWaiting", testRemoveWaiting},
+		{"ArchiveFinished", testArchiveFinished},
+		{"FinishedGame", testFinishedGame},
+		{"SearchFinished", testSearchFinished},
+		{"DumpAndLoad", testDumpAndLoad},
+		{"LoadExisting", testLoadExisting},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newRepository(t))
+		})
+	}
+}
+
+// RunRestart runs the suite for the repositories that keep the games over restarts.
+//
+// newRepository returns the new empty repository, and reopen, which closes
+// it and opens it again on the same data.
+func RunRestart(t *testing.T, newRepository func(t *testing.T) (repository store.GameRepository, reopen func() store.GameRepository)) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repository store.GameRepository, reopen func() store.GameRepository)
+	}{
+		{"RestoreSankShip", testRestoreSankShip},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repository, reopen := newRepository(t)
+			test.test(t, repository, reopen)
+		})
+	}
+}
+
+func testStartGame(t *testing.T, repository store.GameRepository) {
+	karsa, _ := repository.StartGame("Karsa Orlong", 0)
+
+	if karsa.Name != "Karsa Orlong" || karsa.Id == 0 {
+		t.Errorf("Unexpected player %v", karsa)
+	}
+	waiting := repository.AllWaitingPlayers()
+	if len(waiting) != 1 || waiting[0].Id != karsa.Id {
+		t.Errorf("Expected Karsa to be listed, but got %v", waiting)
+	}
+	if _, ok := repository.WaitingSince(karsa.Id); !ok {
+		t.Error("Expected Karsa to be waiting")
+	}
+	if rules, ok := repository.WaitingRules(karsa.Id); !ok || rules != engine.DefaultRules() {
+		t.Errorf("Expected Karsa to wait in a game with the default rules, but got %v", rules)
+	}
+
+	player, game, err := repository.GetPlayerAndGame(karsa.Id)
+	if err != nil {
+		t.Fatalf("Cannot get the waiting player: %v", err)
+	}
+	assertEqual(t, karsa, player)
+	if game.Id != 0 {
+		t.Errorf("Expected no game for the waiting player, but got %d", game.Id)
+	}
+}
+
+func testStartGameWithRules(t *testing.T, repository store.GameRepository) {
+	karsa, _ := repository.StartGameWithRules("Karsa Orlong", 0, engine.RankedRules())
+
+	game, err := repository.JoinGame("Fiddler", 0, karsa.Id)
+	if err != nil {
+		t.Fatalf("Cannot join the game: %v", err)
+	}
+	if game.Rules != engine.RankedRules() {
+		t.Errorf("Expected the game to be played by the ranked rules, but got %v", game.Rules)
+	}
+}
+
+func testStartGameAs(t *testing.T, repository store.GameRepository) {
+	icarium := engine.InitializePlayer("Icarium")
+	if err := icarium.PlaceRandomly(); err != nil {
+		t.Fatalf("Cannot place ships: %v", err)
+	}
+
+	started, err := repository.StartGameAs(icarium)
+	if err != nil {
+		t.Fatalf("Cannot start the game: %v", err)
+	}
+	assertEqual(t, icarium, started)
+	waiting := repository.AllWaitingPlayers()
+	if len(waiting) != 1 || len(*waiting[0].Ships) != 5 {
+		t.Errorf("Expected Icarium to be listed with the ships placed, but got %v", waiting)
+	}
+	game, err := repository.JoinGame("Mappo", 0, icarium.Id)
+	if err != nil {
+		t.Fatalf("Cannot join the game: %v", err)
+	}
+	assertEqual(t, icarium.Ships, game.PlayerA.Ships)
+
+	if _, err := repository.StartGameAs(icarium); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Expected the player in the game to exist, but got %v", err)
+	}
+}
+
+func testJoinGame(t *testing.T, repository store.GameRepository) {
+	karsa, _ := repository.StartGame("Karsa Orlong", 0)
+
+	game, err := repository.JoinGame("Fiddler", 0, karsa.Id)
+	if err != nil {
+		t.Fatalf("Cannot join the game: %v", err)
+	}
+	if game.PlayerA.Id != karsa.Id || game.PlayerB.Name != "Fiddler" || *game.Turn != karsa.Id {
+		t.Errorf("Unexpected game %v", game)
+	}
+	if _, waiting := repository.WaitingSince(karsa.Id); waiting {
+		t.Error("Expected Karsa to stop waiting")
+	}
+	if len(repository.AllWaitingPlayers()) != 0 {
+		t.Error("Expected nobody to be listed once the game started")
+	}
+
+	for _, playerId := range []int{karsa.Id, game.PlayerB.Id} {
+		_, found, err := repository.GetPlayerAndGame(playerId)
+		if err != nil {
+			t.Fatalf("Cannot get player %d: %v", playerId, err)
+		}
+		assertEqual(t, game, found)
+	}
+	found, err := repository.GetGame(game.Id)
+	if err != nil {
+		t.Fatalf("Cannot get the game: %v", err)
+	}
+	assertEqual(t, game, found)
+	if games := repository.Games(); len(games) != 1 || games[0].Id != game.Id {
+		t.Errorf("Expected a single game, but got %v", games)
+	}
+}
+
+func testJoinMissingPlayer(t *testing.T, repository store.GameRepository) {
+	if _, err := repository.JoinGame("Fiddler", 0, 42); !errors.Is(err, store.ErrNotWaiting) {
+		t.Errorf("Expected not waiting error, but got %v", err)
+	}
+	if len(repository.Games()) != 0 {
+		t.Error("Expected no game to start")
+	}
+}
+
+func testJoinGameConcurrently(t *testing.T, repository store.GameRepository) {
+	karsa, _ := repository.StartGame("Karsa Orlong", 0)
+
+	var joined atomic.Int32
+	var group sync.WaitGroup
+	for _, name := range []string{"Fiddler", "Hedge", "Kalam", "Quick Ben", "Whiskeyjack"} {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			if _, err := repository.JoinGame(name, 0, karsa.Id); err == nil {
+				joined.Add(1)
+			}
+		}()
+	}
+	group.Wait()
+
+	if joined.Load() != 1 {
+		t.Errorf("Expected exactly one player to join Karsa, but %d did", joined.Load())
+	}
+	if len(repository.Games()) != 1 {
+		t.Errorf("Expected a single game, but got %d", len(repository.Games()))
+	}
+}
+
+func testPrivateGame(t *testing.T, repository store.GameRepository) {
+	karsa, code, err := repository.StartPrivateGame("Karsa Orlong", 0)
+	if err != nil {
+		t.Fatalf("Cannot start the private game: %v", err)
+	}
+
+	if len(repository.AllWaitingPlayers()) != 0 {
+		t.Error("Expected the private game not to be listed")
+	}
+	if found, ok := repository.InviteCode(karsa.Id); !ok || found != code {
+		t.Errorf("Expected invite code %v, but got %v", code, found)
+	}
+	if _, err := repository.JoinGame("Fiddler", 0, karsa.Id); !errors.Is(err, store.ErrNotWaiting) {
+		t.Errorf("Expected not waiting error when joining without the code, but got %v", err)
+	}
+
+	game, err := repository.JoinPrivateGame("Fiddler", 0, strings.ToLower(store.FormatInviteCode(code)))
+	if err != nil {
+		t.Fatalf("Cannot join with the invite code: %v", err)
+	}
+	if game.PlayerA.Id != karsa.Id {
+		t.Error("Joined the wrong player's game")
+	}
+	if _, ok := repository.InviteCode(karsa.Id); ok {
+		t.Error("Expected the invite code to be used up")
+	}
+	if _, err := repository.JoinPrivateGame("Hedge", 0, code); !errors.Is(err, store.ErrNotWaiting) {
+		t.Errorf("Expected not waiting error when the code is reused, but got %v", err)
+	}
+}
+
+func testFindMatch(t *testing.T, repository store.GameRepository) {
+	karsa, game, _ := repository.FindMatch("Karsa Orlong", store.Ticket{Rules: engine.DefaultRules()})
+	if game.Id != 0 || !repository.Queued(karsa.Id) {
+		t.Fatal("Expected Karsa to wait in the queue")
+	}
+	if len(repository.AllWaitingPlayers()) != 0 {
+		t.Error("Expected the queued player not to be listed")
+	}
+
+	hedge, game, _ := repository.FindMatch("Hedge", store.Ticket{Rules: engine.RankedRules()})
+	if game.Id != 0 {
+		t.Error("Expected the players with different rules not to be matched")
+	}
+
+	fiddler, game, _ := repository.FindMatch("Fiddler", store.Ticket{Rules: engine.DefaultRules()})
+	if game.Id == 0 || game.PlayerA.Id != karsa.Id || game.PlayerB.Id != fiddler.Id {
+		t.Fatalf("Expected Fiddler to be matched with Karsa, but got %v", game)
+	}
+	if repository.Queued(karsa.Id) || !repository.Queued(hedge.Id) {
+		t.Error("Expected only Hedge to stay in the queue")
+	}
+}
+
+func testCancelMatch(t *testing.T, repository store.GameRepository) {
+	karsa, _, _ := repository.FindMatch("Karsa Orlong", store.Ticket{Rules: engine.DefaultRules()})
+
+	if err := repository.CancelMatch(karsa.Id); err != nil {
+		t.Fatalf("Cannot cancel the match: %v", err)
+	}
+	if repository.Queued(karsa.Id) {
+		t.Error("Expected Karsa to leave the queue")
+	}
+	if _, _, err := repository.GetPlayerAndGame(karsa.Id); err == nil {
+		t.Error("Expected Karsa to be gone")
+	}
+	if err := repository.CancelMatch(karsa.Id); !errors.Is(err, store.ErrNotQueued) {
+		t.Errorf("Expected not queued error, but got %v", err)
+	}
+}
+
+func testMatchmake(t *testing.T, repository store.GameRepository) {
+	karsa, _, _ := repository.FindMatch("Karsa Orlong", store.Ticket{Rules: engine.DefaultRules(), Rating: 1500, Rated: true})
+	fiddler, game, _ := repository.FindMatch("Fiddler", store.Ticket{Rules: engine.DefaultRules(), Rating: 1800, Rated: true})
+	if game.Id != 0 {
+		t.Fatal("Expected the players too far apart not to be matched right away")
+	}
+
+	if games := repository.Matchmake(time.Now()); len(games) != 0 {
+		t.Errorf("Expected no matches yet, but got %v", games)
+	}
+	games := repository.Matchmake(time.Now().Add(time.Hour))
+	if len(games) != 1 || games[0].PlayerA.Id != karsa.Id || games[0].PlayerB.Id != fiddler.Id {
+		t.Errorf("Expected Karsa and Fiddler to be matched once the windows widen, but got %v", games)
+	}
+}
+
+func testGetUnknown(t *testing.T, repository store.GameRepository) {
+	if _, _, err := repository.GetPlayerAndGame(42); err == nil {
+		t.Error("Expected error for the unknown player")
+	}
+	if _, err := repository.GetGame(42); err == nil {
+		t.Error("Expected error for the unknown game")
+	}
+	if err := repository.UpdateGame(engine.Game{Id: 42}); err == nil {
+		t.Error("Expected error when updating the unknown game")
+	}
+	if err := repository.UpdatePlayer(engine.InitializePlayer("Icarium")); err == nil {
+		t.Error("Expected error when updating the unknown player")
+	}
+}
+
+func testUpdateWaitingPlayer(t *testing.T, repository store.GameRepository) {
+	karsa, _ := repository.StartGame("Karsa Orlong", 0)
+	if err := karsa.PlaceRandomly(); err != nil {
+		t.Fatalf("Cannot place ships: %v", err)
+	}
+
+	if err := repository.UpdatePlayer(karsa); err != nil {
+		t.Fatalf("Cannot update the player: %v", err)
+	}
+	player, _, _ := repository.GetPlayerAndGame(karsa.Id)
+	karsa.Version++
+	assertEqual(t, karsa, player)
+
+	game, _ := repository.JoinGame("Fiddler", 0, karsa.Id)
+	if game.Version <= karsa.Version {
+		t.Errorf("Expected the game to start after the version %d of the waiting player, but got %d", karsa.Version, game.Version)
+	}
+	karsa.Version = game.Version
+	assertEqual(t, karsa, game.PlayerA)
+}
+
+func testUpdatePlayerInGame(t *testing.T, repository store.GameRepository) {
+	karsa, _ := repository.StartGame("Karsa Orlong", 0)
+	game, _ := repository.JoinGame("Fiddler", 0, karsa.Id)
+	fiddler := game.PlayerB
+	ships := []engine.Ship{}
+	fiddler.Ships = &ships
+	if err := fiddler.PlaceRandomly(); err != nil {
+		t.Fatalf("Cannot place ships: %v", err)
+	}
+	fiddler.UserId = 8
+
+	if err := repository.UpdatePlayer(fiddler); err != nil {
+		t.Fatalf("Cannot update the player: %v", err)
+	}
+	player, found, _ := repository.GetPlayerAndGame(fiddler.Id)
+	fiddler.Version++
+	assertEqual(t, fiddler, player)
+	assertEqual(t, fiddler, found.PlayerB)
+	if found.Version != fiddler.Version {
+		t.Errorf("Expected the game at version %d, but got %d", fiddler.Version, found.Version)
+	}
+}
+
+func testUpdateGame(t *testing.T, repository store.GameRepository) {
+	karsa, _ := repository.StartGame("Karsa Orlong", 0)
+	game, _ := repository.JoinGame("Fiddler", 0, karsa.Id)
+	if err := game.PlayerA.PlaceRandomly(); err != nil {
+		t.Fatalf("Cannot place ships: %v", err)
+	}
+	if err := game.PlayerB.PlaceRandomly(); err != nil {
+		t.Fatalf("Cannot place ships: %v", err)
+	}
+	if _, _, _, err := game.Shoot(karsa.Id, engine.Cell{X: 3, Y: 4}); err != nil {
+		t.Fatalf("Cannot shoot: %v", err)
+	}
+
+	if err := repository.UpdateGame(game); err != nil {
+		t.Fatalf("Cannot update the game: %v", err)
+	}
+	found, err := repository.GetGame(game.Id)
+	if err != nil {
+		t.Fatalf("Cannot get the game: %v", err)
+	}
+	game = nextVersion(game)
+	assertEqual(t, game, found)
+	fiddler, _, _ := repository.GetPlayerAndGame(game.PlayerB.Id)
+	assertEqual(t, game.PlayerB, fiddler)
+}
+
+func testAssignUser(t *testing.T, repository store.GameRepository) {
+	karsa, _ := repository.StartGame("Karsa Orlong", 0)
+
+	if err := repository.AssignUser(karsa.Id, 7); err != nil {
+		t.Fatalf("Cannot assign the waiting player: %v", err)
+	}
+	game, _ := repository.JoinGame("Fiddler", 0, karsa.Id)
+	if game.PlayerA.UserId != 7 {
+		t.Error("Expected the waiting player to keep the user")
+	}
+
+	if err := repository.AssignUser(game.PlayerB.Id, 8); err != nil {
+		t.Fatalf("Cannot assign the player in the game: %v", err)
+	}
+	fiddler, _, _ := repository.GetPlayerAndGame(game.PlayerB.Id)
+	if fiddler.UserId != 8 {
+		t.Error("Expected the user to be assigned to the player in the game")
+	}
+	if err := repository.AssignUser(42, 9); err == nil {
+		t.Error("Expected error for the unknown player")
+	}
+}
+
+func testNewPlayersOfUsers(t *testing.T, repository store.GameRepository) {
+	karsa, _ := repository.StartGame("Karsa Orlong", 7)
+	game, _ := repository.JoinGame("Fiddler", 8, karsa.Id)
+	if game.PlayerA.UserId != 7 || game.PlayerB.UserId != 8 {
+		t.Errorf("Expected the players of users 7 and 8, but got %v and %v", game.PlayerA.UserId, game.PlayerB.UserId)
+	}
+
+	hedge, code, _ := repository.StartPrivateGame("Hedge", 9)
+	game, _ = repository.JoinPrivateGame("Quick Ben", 10, code)
+	if game.PlayerA.Id != hedge.Id || game.PlayerA.UserId != 9 || game.PlayerB.UserId != 10 {
+		t.Errorf("Expected the private game of users 9 and 10, but got %v and %v", game.PlayerA.UserId, game.PlayerB.UserId)
+	}
+
+	tavore, _, _ := repository.FindMatch("Tavore", store.Ticket{UserId: 11, Rules: engine.DefaultRules()})
+	_, game, _ = repository.FindMatch("Felisin", store.Ticket{UserId: 12, Rules: engine.DefaultRules()})
+	if game.PlayerA.Id != tavore.Id || game.PlayerA.UserId != 11 || game.PlayerB.UserId != 12 {
+		t.Errorf("Expected the matched game of users 11 and 12, but got %v and %v", game.PlayerA.UserId, game.PlayerB.UserId)
+	}
+
+	guest, _ := repository.StartGameWithRules("Kalam", 0, engine.RankedRules())
+	if guest.UserId != 0 {
+		t.Errorf("Expected the guest, but got the player of user %d", guest.UserId)
+	}
+}
+
+func testFinishedGames(t *testing.T, repository store.GameRepository) {
+	karsa, _ := repository.StartGame("Karsa Orlong", 0)
+	finished, _ := repository.JoinGame("Fiddler", 0, karsa.Id)
+	hedge, _ := repository.StartGame("Hedge", 0)
+	repository.JoinGame("Quick Ben", 0, hedge.Id)
+
+	if err := finished.Forfeit(karsa.Id); err != nil {
+		t.Fatalf("Cannot forfeit: %v", err)
+	}
+	repository.UpdateGame(finished)
+
+	games := repository.FinishedGames()
+	if len(games) != 1 || games[0].Id != finished.Id || *games[0].Winner != finished.PlayerB.Id {
+		t.Errorf("Expected only the forfeited game to be finished, but got %v", games)
+	}
+}
+
+func testUpdateConflict(t *testing.T, repository store.GameRepository) {
+	karsa, _ := repository.StartGame("Karsa Orlong", 0)
+	stalePlayer := karsa
+	if err := repository.UpdatePlayer(karsa); err != nil {
+		t.Fatalf("Cannot update the player: %v", err)
+	}
+	if err := repository.UpdatePlayer(stalePlayer); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("Expected conflict for the stale waiting player, but got %v", err)
+	}
+
+	game, _ := repository.JoinGame("Fiddler", 0, karsa.Id)
+	if err := repository.UpdatePlayer(karsa); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("Expected conflict for the player read while waiting, but got %v", err)
+	}
+	staleGame := game
+	if err := repository.UpdateGame(game); err != nil {
+		t.Fatalf("Cannot update the game: %v", err)
+	}
+	if err := repository.UpdateGame(staleGame); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("Expected conflict for the stale game, but got %v", err)
+	}
+
+	game = nextVersion(game)
+	if err := repository.AssignUser(game.PlayerB.Id, 8); err != nil {
+		t.Fatalf("Cannot assign the user: %v", err)
+	}
+	if err := repository.UpdateGame(game); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("Expected conflict for the game read before the user was assigned, but got %v", err)
+	}
+	found, _ := repository.GetGame(game.Id)
+	if found.Version != game.Version+1 || found.PlayerB.UserId != 8 {
+		t.Errorf("Expected the game with the user at version %d, but got %v", game.Version+1, found)
+	}
+}
+
+func testUpdateWithRetry(t *testing.T, repository store.GameRepository) {
+	karsa, _ := repository.StartGame("Karsa Orlong", 0)
+	player, _, err := store.UpdateWithRetry(repository, karsa.Id, func(player *engine.Player, game *engine.Game) error {
+		return player.PlaceRandomly()
+	})
+	if err != nil {
+		t.Fatalf("Cannot place ships of the waiting player: %v", err)
+	}
+	saved, _, _ := repository.GetPlayerAndGame(karsa.Id)
+	assertEqual(t, saved, player)
+
+	game, _ := repository.JoinGame("Fiddler", 0, karsa.Id)
+	attempts := 0
+	_, updated, err := store.UpdateWithRetry(repository, game.PlayerB.Id, func(player *engine.Player, game *engine.Game) error {
+		attempts++
+		if attempts == 1 {
+			// someone else changes the game in the meantime
+			if err := repository.AssignUser(karsa.Id, 7); err != nil {
+				t.Fatalf("Cannot assign the user: %v", err)
+			}
+		}
+		return player.PlaceRandomly()
+	})
+	if err != nil {
+		t.Fatalf("Cannot place ships of the player in the game: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("Expected the update to be retried once, but it was made %d times", attempts)
+	}
+	found, _ := repository.GetGame(game.Id)
+	assertEqual(t, found, updated)
+	if found.PlayerA.UserId != 7 || len(*found.PlayerB.Ships) != 5 {
+		t.Errorf("Expected both the user and the ships to be saved, but got %v", found)
+	}
+
+	failure := errors.New("Ships don't fit")
+	if _, _, err := store.UpdateWithRetry(repository, karsa.Id, func(player *engine.Player, game *engine.Game) error {
+		return failure
+	}); err != failure {
+		t.Errorf("Expected the error of the update, but got %v", err)
+	}
+}
+
+func testPlaceShip(t *testing.T, repository store.GameRepository) {
+	carrier := engine.Ship{Cells: map[engine.Cell]bool{{X: 0, Y: 0}: true, {X: 0, Y: 1}: true, {X: 0, Y: 2}: true, {X: 0, Y: 3}: true, {X: 0, Y: 4}: true}}
+	battleship := engine.Ship{Cells: map[engine.Cell]bool{{X: 2, Y: 0}: true, {X: 2, Y: 1}: true, {X: 2, Y: 2}: true, {X: 2, Y: 3}: true}}
+
+	karsa, _ := repository.StartGame("Karsa Orlong", 0)
+	player, game, err := repository.PlaceShip(karsa.Id, carrier)
+	if err != nil {
+		t.Fatalf("Cannot place the ship of the waiting player: %v", err)
+	}
+	if game.Id != 0 || len(*player.Ships) != 1 || player.Version != karsa.Version+1 {
+		t.Errorf("Expected the waiting player with one ship at the next version, but got %v and %v", player, game)
+	}
+	saved, _, _ := repository.GetPlayerAndGame(karsa.Id)
+	assertEqual(t, player, saved)
+	if _, _, err := repository.PlaceShip(karsa.Id, carrier); !errors.Is(err, store.ErrIllegalMove) {
+		t.Errorf("Expected illegal move for the carrier placed twice, but got %v", err)
+	}
+
+	joined, _ := repository.JoinGame("Fiddler", 0, karsa.Id)
+	player, game, err = repository.PlaceShip(karsa.Id, battleship)
+	if err != nil {
+		t.Fatalf("Cannot place the ship of the player in the game: %v", err)
+	}
+	if game.Id != joined.Id || len(*player.Ships) != 2 || game.Version != joined.Version+1 {
+		t.Errorf("Expected the game with two ships of Karsa at the next version, but got %v", game)
+	}
+	_, found, _ := repository.GetPlayerAndGame(karsa.Id)
+	assertEqual(t, game, found)
+
+	if _, _, err := repository.PlaceShip(42, carrier); err == nil {
+		t.Error("Expected error for the unknown player")
+	}
+}
+
+func testShoot(t *testing.T, repository store.GameRepository) {
+	karsa, _ := repository.StartGame("Karsa Orlong", 0)
+	if _, _, err := repository.Shoot(karsa.Id, engine.Cell{X: 3, Y: 4}); !errors.Is(err, store.ErrNotStarted) {
+		t.Errorf("Expected error for the game nobody joined, but got %v", err)
+	}
+
+	game, _ := repository.JoinGame("Fiddler", 0, karsa.Id)
+	if _, _, err := repository.Shoot(karsa.Id, engine.Cell{X: 3, Y: 4}); !errors.Is(err, store.ErrIllegalMove) {
+		t.Errorf("Expected illegal move before the ships are placed, but got %v", err)
+	}
+	for _, player := range []engine.Player{game.PlayerA, game.PlayerB} {
+		if _, _, err := store.UpdateWithRetry(repository, player.Id, func(player *engine.Player, game *engine.Game) error {
+			return player.PlaceRandomly()
+		}); err != nil {
+			t.Fatalf("Cannot place ships: %v", err)
+		}
+	}
+	placed, _ := repository.GetGame(game.Id)
+
+	shot, shotAt, err := repository.Shoot(karsa.Id, engine.Cell{X: 3, Y: 4})
+	if err != nil {
+		t.Fatalf("Cannot shoot: %v", err)
+	}
+	if shot.PlayerId != karsa.Id || shot.Cell != (engine.Cell{X: 3, Y: 4}) || len(shotAt.History) != 1 || shotAt.Version != placed.Version+1 {
+		t.Errorf("Unexpected shot %v in the game %v", shot, shotAt)
+	}
+	found, _ := repository.GetGame(game.Id)
+	assertEqual(t, shotAt, found)
+
+	if _, _, err := repository.Shoot(karsa.Id, engine.Cell{X: 5, Y: 5}); !shot.Hit && !errors.Is(err, store.ErrIllegalMove) {
+		t.Errorf("Expected illegal move when shooting out of turn, but got %v", err)
+	}
+}
+
+func testShootInManyGames(t *testing.T, repository store.GameRepository) {
+	const games, shots = 20, 10
+
+	var group sync.WaitGroup
+	gameIds := make([]int, games)
+	for index := range games {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			// waiting players come and go while the games are played
+			repository.StartGame("Hedge", 0)
+
+			karsa, _ := repository.StartGame("Karsa Orlong", 0)
+			game, err := repository.JoinGame("Fiddler", 0, karsa.Id)
+			if err != nil {
+				t.Errorf("Cannot join the game: %v", err)
+				return
+			}
+			gameIds[index] = game.Id
+			for _, player := range []engine.Player{game.PlayerA, game.PlayerB} {
+				if _, _, err := store.UpdateWithRetry(repository, player.Id, func(player *engine.Player, game *engine.Game) error {
+					return player.PlaceRandomly()
+				}); err != nil {
+					t.Errorf("Cannot place ships: %v", err)
+					return
+				}
+			}
+			for shot := range shots {
+				shooter := game.PlayerA.Id
+				if shot%2 == 1 {
+					shooter = game.PlayerB.Id
+				}
+				if _, _, err := repository.Shoot(shooter, engine.Cell{X: shot / 2, Y: 0}); err != nil {
+					t.Errorf("Cannot shoot: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	group.Wait()
+
+	for _, gameId := range gameIds {
+		game, err := repository.GetGame(gameId)
+		if err != nil {
+			t.Fatalf("Cannot find the game: %v", err)
+		}
+		if len(game.History) != shots {
+			t.Errorf("Expected %d shots in game %d, but got %d", shots, gameId, len(game.History))
+		}
+	}
+}
+
+func testSnapshots(t *testing.T, repository store.GameRepository) {
+	karsa, _ := repository.StartGame("Karsa Orlong", 0)
+	_ = karsa.PlaceRandomly()
+	waiting, _, _ := repository.GetPlayerAndGame(karsa.Id)
+	if len(*waiting.Ships) != 0 {
+		t.Error("Expected the returned player not to change the waiting one")
+	}
+
+	game, _ := repository.JoinGame("Fiddler", 0, karsa.Id)
+	_ = game.PlayerA.PlaceRandomly()
+	_ = game.PlayerB.PlaceRandomly()
+	if err := repository.UpdateGame(game); err != nil {
+		t.Fatalf("Cannot update the game: %v", err)
+	}
+	_, _, _, _ = game.Shoot(karsa.Id, engine.Cell{X: 3, Y: 4})
+	_ = game.Forfeit(karsa.Id)
+
+	found, _ := repository.GetGame(game.Id)
+	if len(found.History) != 0 || found.Winner != nil || *found.Turn != karsa.Id {
+		t.Errorf("Expected the saved game not to change with the one that was saved, but got %v", found)
+	}
+	(*found.PlayerA.Ships)[0].Cells[engine.Cell{X: 9, Y: 9}] = true
+	found.PlayerB.Target.Misses[engine.Cell{X: 9, Y: 9}] = true
+	again, _ := repository.GetGame(game.Id)
+	if (*again.PlayerA.Ships)[0].Cells[engine.Cell{X: 9, Y: 9}] || len(again.PlayerB.Target.Misses) != 0 {
+		t.Error("Expected the returned game not to change the stored one")
+	}
+}
+
+func testReadsAndWritesConcurrently(t *testing.T, repository store.GameRepository) {
+	karsa, _ := repository.StartGame("Karsa Orlong", 0)
+	game, _ := repository.JoinGame("Fiddler", 0, karsa.Id)
+	for _, player := range []engine.Player{game.PlayerA, game.PlayerB} {
+		if _, _, err := repository.PlaceShip(player.Id, engine.Ship{Cells: map[engine.Cell]bool{{X: 0, Y: 0}: true, {X: 1, Y: 0}: true, {X: 2, Y: 0}: true, {X: 3, Y: 0}: true, {X: 4, Y: 0}: true}}); err != nil {
+			t.Fatalf("Cannot place the ship: %v", err)
+		}
+	}
+
+	var group sync.WaitGroup
+	for range 4 {
+		group.Add(2)
+		// readers change what they read, which must not reach the store or the other readers
+		go func() {
+			defer group.Done()
+			for range 50 {
+				player, game, _ := repository.GetPlayerAndGame(karsa.Id)
+				_ = player.PlaceRandomly()
+				_, _, _, _ = game.Shoot(karsa.Id, engine.Cell{X: 9, Y: 9})
+				_ = game.Forfeit(karsa.Id)
+				for _, game := range repository.Games() {
+					_ = game.PlayerB.PlaceRandomly()
+				}
+			}
+		}()
+		go func() {
+			defer group.Done()
+			for range 50 {
+				_, _, _ = store.UpdateWithRetry(repository, karsa.Id, func(player *engine.Player, game *engine.Game) error {
+					game.Rules.OpenSpectating = !game.Rules.OpenSpectating
+					return nil
+				})
+			}
+		}()
+	}
+	group.Wait()
+
+	found, _ := repository.GetGame(game.Id)
+	if len(*found.PlayerA.Ships) != 1 || len(*found.PlayerB.Ships) != 1 || found.Winner != nil || len(found.History) != 0 {
+		t.Errorf("Expected the game to change only by what was saved, but got %v", found)
+	}
+}
+
+func testRemoveWaiting(t *testing.T, repository store.GameRepository) {
+	karsa, _ := repository.StartGame("Karsa Orlong", 0)
+	hedge, _, _ := repository.StartPrivateGame("Hedge", 0)
+	quickBen, _, _ := repository.FindMatch("Quick Ben", store.Ticket{Rules: engine.DefaultRules()})
+
+	if removed := repository.RemoveWaiting(time.Now().Add(-time.Minute), nil); len(removed) != 0 {
+		t.Errorf("Expected nobody to wait long enough to be removed, but got %v", removed)
+	}
+	removed := repository.RemoveWaiting(time.Now().Add(time.Minute), func(playerId int) bool {
+		return playerId == hedge.Id
+	})
+
+	if len(removed) != 2 {
+		t.Errorf("Expected Karsa and Quick Ben to be removed, but got %v", removed)
+	}
+	if _, _, err := repository.GetPlayerAndGame(karsa.Id); err == nil {
+		t.Error("Expected Karsa not to be found after removal")
+	}
+	if repository.Queued(quickBen.Id) {
+		t.Error("Expected Quick Ben to leave the matchmaking queue")
+	}
+	if _, ok := repository.InviteCode(hedge.Id); !ok {
+		t.Error("Expected Hedge to keep waiting")
+	}
+}
+
+func testArchiveFinished(t *testing.T, repository store.GameRepository) {
+	karsa, _ := repository.StartGame("Karsa Orlong", 0)
+	finished, _ := repository.JoinGame("Fiddler", 0, karsa.Id)
+	_, finished, _ = store.UpdateWithRetry(repository, karsa.Id, func(player *engine.Player, game *engine.Game) error {
+		return game.Forfeit(player.Id)
+	})
+	hedge, _ := repository.StartGame("Hedge", 0)
+	running, _ := repository.JoinGame("Quick Ben", 0, hedge.Id)
+
+	if archived := repository.ArchiveFinished(time.Now().Add(-time.Minute)); len(archived) != 0 {
+		t.Errorf("Expected no game to end long enough ago to be archived, but got %v", archived)
+	}
+	archived := repository.ArchiveFinished(time.Now().Add(time.Minute))
+
+	if len(archived) != 1 {
+		t.Fatalf("Expected only the finished game to be archived, but got %v", archived)
+	}
+	assertEqual(t, finished, archived[0])
+	if games := repository.Games(); len(games) != 1 || games[0].Id != running.Id {
+		t.Errorf("Expected only the running game to be left, but got %v", games)
+	}
+	if _, err := repository.GetGame(finished.Id); err == nil {
+		t.Error("Expected the archived game not to be found among the started ones")
+	}
+	if games := repository.FinishedGames(); len(games) != 1 {
+		t.Errorf("Expected the archived game among the finished ones, but got %v", games)
+	} else {
+		assertEqual(t, finished, games[0])
+	}
+	if archived := repository.ArchiveFinished(time.Now().Add(time.Minute)); len(archived) != 0 {
+		t.Errorf("Expected the game to be archived only once, but got %v", archived)
+	}
+}
+
+func testFinishedGame(t *testing.T, repository store.GameRepository) {
+	karsa, _ := repository.StartGame("Karsa Orlong", 0)
+	finished, _ := repository.JoinGame("Fiddler", 0, karsa.Id)
+	_, finished, _ = store.UpdateWithRetry(repository, karsa.Id, func(player *engine.Player, game *engine.Game) error {
+		return game.Forfeit(player.Id)
+	})
+	hedge, _ := repository.StartGame("Hedge", 0)
+	running, _ := repository.JoinGame("Quick Ben", 0, hedge.Id)
+
+	if game, err := repository.FinishedGame(finished.Id); err != nil {
+		t.Errorf("Cannot find the finished game: %v", err)
+	} else {
+		assertEqual(t, finished, game)
+	}
+	repository.ArchiveFinished(time.Now().Add(time.Minute))
+	if game, err := repository.FinishedGame(finished.Id); err != nil {
+		t.Errorf("Cannot find the archived game: %v", err)
+	} else {
+		assertEqual(t, finished, game)
+	}
+	if _, err := repository.FinishedGame(running.Id); err == nil {
+		t.Error("Expected the running game not to be found among the finished ones")
+	}
+	if _, err := repository.FinishedGame(-1); err == nil {
+		t.Error("Expected the unknown game not to be found")
+	}
+}
+
+func testSearchFinished(t *testing.T, repository store.GameRepository) {
+	finish := func(nameA string, userA int, nameB string, userB int, rules engine.Rules, loserName string) engine.Game {
+		host, _ := repository.StartGameWithRules(nameA, userA, rules)
+		game, _ := repository.JoinGame(nameB, userB, host.Id)
+		loser := game.PlayerA.Id
+		if loserName == nameB {
+			loser = game.PlayerB.Id
+		}
+		_, game, _ = store.UpdateWithRetry(repository, loser, func(player *engine.Player, game *engine.Game) error {
+			return game.Forfeit(player.Id)
+		})
+		return game
+	}
+	lost := finish("Karsa Orlong", 1, "Fiddler", 2, engine.DefaultRules(), "Karsa Orlong")
+	won := finish("Karsa Orlong", 1, "Hedge", 0, engine.RankedRules(), "Hedge")
+	repository.ArchiveFinished(time.Now().Add(time.Minute))
+	running := finish("Kalam", 0, "Quick Ben", 0, engine.DefaultRules(), "Kalam")
+
+	ranked, casual := true, false
+	tests := []struct {
+		name  string
+		query archive.Query
+		want  []engine.Game
+		total int
+	}{
+		{"All", archive.Query{}, []engine.Game{running, won, lost}, 3},
+		{"User", archive.Query{UserId: 1}, []engine.Game{won, lost}, 2},
+		{"Won", archive.Query{UserId: 1, Result: archive.Won}, []engine.Game{won}, 1},
+		{"Lost", archive.Query{UserId: 1, Result: archive.Lost}, []engine.Game{lost}, 1},
+		{"OpponentName", archive.Query{Opponent: "hEDGE"}, []engine.Game{won}, 1},
+		{"OpponentUser", archive.Query{UserId: 1, OpponentId: 2}, []engine.Game{lost}, 1},
+		{"Ranked", archive.Query{Ranked: &ranked}, []engine.Game{won}, 1},
+		{"Casual", archive.Query{Ranked: &casual}, []engine.Game{running, lost}, 2},
+		{"From", archive.Query{From: time.Now().Add(time.Hour)}, []engine.Game{}, 0},
+		{"To", archive.Query{To: time.Now().Add(-time.Hour)}, []engine.Game{}, 0},
+		{"Page", archive.Query{Page: 2, PageSize: 1}, []engine.Game{won}, 3},
+		{"PastLastPage", archive.Query{Page: 4, PageSize: 1}, []engine.Game{}, 3},
+	}
+	for _, test := range tests {
+		page, err := repository.SearchFinished(test.query)
+		if err != nil {
+			t.Errorf("%v: cannot search: %v", test.name, err)
+			continue
+		}
+		if page.Total != test.total {
+			t.Errorf("%v: expected %d games found, but got %d", test.name, test.total, page.Total)
+		}
+		assertEqual(t, test.want, page.Games)
+	}
+	if _, err := repository.SearchFinished(archive.Query{Result: archive.Won}); err == nil {
+		t.Error("Expected error for the result without the user")
+	}
+}
+
+func testDumpAndLoad(t *testing.T, repository store.GameRepository) {
+	source := store.InitializeStore()
+	karsa, _ := source.StartGameWithRules("Karsa Orlong", 0, engine.RankedRules())
+	hedge, code, _ := source.StartPrivateGame("Hedge", 0)
+	queued, _, _ := source.FindMatch("Quick Ben", store.Ticket{Rules: engine.DefaultRules(), Rating: 1600, Rated: true})
+	running := placedGame(t, &source, "Fiddler", "Kalam")
+	_, running, _ = source.Shoot(running.PlayerA.Id, engine.Cell{X: 0, Y: 0})
+	archived := placedGame(t, &source, "Tavore", "Felisin")
+	_, archived, _ = store.UpdateWithRetry(&source, archived.PlayerA.Id, func(player *engine.Player, game *engine.Game) error {
+		return game.Forfeit(player.Id)
+	})
+	source.ArchiveFinished(time.Now().Add(time.Minute))
+	dump, _ := source.Dump()
+
+	if err := repository.Load(dump); err != nil {
+		t.Fatalf("Cannot load the dump: %v", err)
+	}
+
+	if rules, ok := repository.WaitingRules(karsa.Id); !ok || rules != engine.RankedRules() {
+		t.Errorf("Expected Karsa to wait for a ranked game, but got %v", rules)
+	}
+	if loaded, ok := repository.InviteCode(hedge.Id); !ok || loaded != code {
+		t.Errorf("Expected Hedge to wait with the invite code %v, but got %v", code, loaded)
+	}
+	if !repository.Queued(queued.Id) {
+		t.Error("Expected Quick Ben to wait in the matchmaking queue")
+	}
+	if game, err := repository.GetGame(running.Id); err != nil {
+		t.Errorf("Cannot find the running game: %v", err)
+	} else {
+		assertEqual(t, running, game)
+	}
+	if game, err := repository.FinishedGame(archived.Id); err != nil {
+		t.Errorf("Cannot find the archived game: %v", err)
+	} else {
+		assertEqual(t, archived, game)
+	}
+	if _, err := repository.GetGame(archived.Id); err == nil {
+		t.Error("Expected the archived game to stay archived")
+	}
+
+	reloaded := store.InitializeStore()
+	redump, err := repository.Dump()
+	if err != nil {
+		t.Fatalf("Cannot dump the repository: %v", err)
+	}
+	if err := reloaded.Load(redump); err != nil {
+		t.Fatalf("Cannot load the dump back: %v", err)
+	}
+	if waiting, games, archived := redump.Counts(); waiting != 3 || games != 1 || archived != 1 {
+		t.Errorf("Expected 3 waiting players, 1 started and 1 archived game, but got %d, %d and %d", waiting, games, archived)
+	}
+	if game, _ := reloaded.GetGame(running.Id); game.Version != running.Version {
+		t.Errorf("Expected the game to keep its version, but got %d", game.Version)
+	}
+}
+
+func testLoadExisting(t *testing.T, repository store.GameRepository) {
+	karsa, _ := repository.StartGame("Karsa Orlong", 0)
+	_, _ = repository.JoinGame("Fiddler", 0, karsa.Id)
+	hedge, _ := repository.StartGame("Hedge", 0)
+	dump, _ := repository.Dump()
+
+	if err := repository.Load(dump); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Expected the dump of the same repository to exist, but got %v", err)
+	}
+
+	source := store.InitializeStore()
+	_ = source.Load(dump)
+	felisin, _ := source.StartGame("Felisin", 0)
+	partial, _ := source.Dump()
+	if err := repository.Load(partial); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Expected the dump with some of the players to exist, but got %v", err)
+	}
+	if _, waiting := repository.WaitingSince(felisin.Id); waiting {
+		t.Error("Expected nothing to be loaded from the dump that exists in part")
+	}
+	if _, waiting := repository.WaitingSince(hedge.Id); !waiting {
+		t.Error("Expected Hedge to keep waiting")
+	}
+}
+
+func testRestoreSankShip(t *testing.T, repository store.GameRepository, reopen func() store.GameRepository) {
+	game := placedGame(t, repository, "Karsa Orlong", "Fiddler")
+	for x := range 3 {
+		if _, _, err := repository.Shoot(game.PlayerA.Id, engine.Cell{X: x, Y: 8}); err != nil {
+			t.Fatalf("Cannot shoot: %v", err)
+		}
+		if _, _, err := repository.Shoot(game.PlayerB.Id, engine.Cell{X: x, Y: 9}); err != nil {
+			t.Fatalf("Cannot shoot: %v", err)
+		}
+	}
+	if _, _, err := repository.Shoot(game.PlayerA.Id, engine.Cell{X: 4, Y: 4}); err != nil {
+		t.Fatalf("Cannot shoot: %v", err)
+	}
+	before, _ := repository.GetGame(game.Id)
+	if len(*before.PlayerA.Target.SankShips) != 1 {
+		t.Fatalf("Expected Karsa to sink the ship, but got %v", before.PlayerA.Target)
+	}
+
+	after, err := reopen().GetGame(game.Id)
+	if err != nil {
+		t.Fatalf("Cannot find the game after restart: %v", err)
+	}
+	// Sank ships stay in the hits as false, which is the same as missing
+	withoutSank := cmp.Transformer("withoutSank", func(cells map[engine.Cell]bool) map[engine.Cell]bool {
+		set := maps.Clone(cells)
+		maps.DeleteFunc(set, func(cell engine.Cell, present bool) bool { return !present })
+		return set
+	})
+	if diff := cmp.Diff(before.PlayerA.Target, after.PlayerA.Target, withoutSank, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected target after restart (-want +got):\n%s", diff)
+	}
+	wantHint, _, _ := before.HintFor(game.PlayerA.Id)
+	gotHint, _, _ := after.HintFor(game.PlayerA.Id)
+	if wantHint != gotHint {
+		t.Errorf("Expected the hint %v after restart, but got %v", wantHint, gotHint)
+	}
+}
+
+// placedGame starts the game between the two players, with both fleets placed on the even rows
+func placedGame(t *testing.T, repository store.GameRepository, nameA string, nameB string) engine.Game {
+	t.Helper()
+	host, _ := repository.StartGame(nameA, 0)
+	game, _ := repository.JoinGame(nameB, 0, host.Id)
+	for _, playerId := range []int{game.PlayerA.Id, game.PlayerB.Id} {
+		for row, length := range []int{5, 4, 4, 3, 3} {
+			ship := engine.Ship{Cells: map[engine.Cell]bool{}}
+			for x := range length {
+				ship.Cells[engine.Cell{X: x, Y: row * 2}] = true
+			}
+			if _, _, err := repository.PlaceShip(playerId, ship); err != nil {
+				t.Fatalf("Cannot place ship: %v", err)
+			}
+		}
+	}
+	game, _ = repository.GetGame(game.Id)
+	return game
+}
+
+// nextVersion returns the game as it's stored once saved, at the next version, which its players share
+func nextVersion(game engine.Game) engine.Game {
+	game.Version++
+	game.PlayerA.Version = game.Version
+	game.PlayerB.Version = game.Version
+	return game
+}
+
+// assertEqual compares the players or games, treating the empty and missing maps and slices the same
+func assertEqual[T any](t *testing.T, want T, got T) {
+	t.Helper()
+	if diff := cmp.Diff(want, got, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected difference (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,56 @@
+package store
+
+import "sync"
+
+// Watchers type keeps track of the spectators watching every game.
+//
+// Players aren't counted, only the third parties following the game
+// through its read-only view.
+type Watchers struct {
+	mutex          sync.Mutex
+	countsByGameId map[int]int
+}
+
+// InitializeWatchers builds the registry without any spectators
+func InitializeWatchers() Watchers {
+	return Watchers{countsByGameId: map[int]int{}}
+}
+
+// Watch registers a new spectator of the game.
+//
+// Returns the number of spectators including the new one, and the function
+// that must be called once the spectator stops watching. That function
+// returns the number of spectators left.
+func (watchers *Watchers) Watch(gameId int) (int, func() int) {
+	watchers.mutex.Lock()
+	defer watchers.mutex.Unlock()
+
+	watchers.countsByGameId[gameId]++
+	count := watchers.countsByGameId[gameId]
+
+	var once sync.Once
+	leave := func() int {
+		watchers.mutex.Lock()
+		defer watchers.mutex.Unlock()
+
+		once.Do(func() {
+			watchers.countsByGameId[gameId]--
+			if watchers.countsByGameId[gameId] == 0 {
+				delete(watchers.countsByGameId, gameId)
+			}
+		})
+		return watchers.countsByGameId[gameId]
+	}
+	return count, leave
+}
+
+// Count returns the number of spectators watching the game
+func (watchers *Watchers) Count(gameId int) int {
+	if watchers == nil {
+		return 0
+	}
+	watchers.mutex.Lock()
+	defer watchers.mutex.Unlock()
+
+	return watchers.countsByGameId[gameId]
+}
@@ -0,0 +1,28 @@
+package store
+
+import "testing"
+
+func Test_Watch(t *testing.T) {
+	watchers := InitializeWatchers()
+
+	count, leaveTool := watchers.Watch(7)
+	if count != 1 {
+		t.Errorf("Expected one spectator, got %d", count)
+	}
+	count, leaveTehol := watchers.Watch(7)
+	if count != 2 {
+		t.Errorf("Expected two spectators, got %d", count)
+	}
+
+	if left := leaveTool(); left != 1 {
+		t.Errorf("Expected one spectator to stay, got %d", left)
+	}
+	if left := leaveTool(); left != 1 {
+		t.Error("Expected leaving twice not to change the count")
+	}
+	leaveTehol()
+
+	if watchers.Count(7) != 0 {
+		t.Errorf("Expected no spectators, got %d", watchers.Count(7))
+	}
+}
@@ -0,0 +1,170 @@
+// Package users keeps the accounts of the people playing the game.
+//
+// Players only live as long as their game, while users stay across sessions,
+// so the history, ratings and stats of a person are attached to the user id.
+// A user can play any number of games, each time as a different player.
+package users
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// minPasswordLength is the shortest password accepted on registration
+const minPasswordLength = 8
+
+// ErrUsernameTaken is returned when registering a username that already exists
+var ErrUsernameTaken = errors.New("Username is already taken")
+
+// ErrInvalidCredentials is returned when the username or the password is wrong
+var ErrInvalidCredentials = errors.New("Invalid username or password")
+
+// ErrNotFound is returned when there's no user with the given id
+var ErrNotFound = errors.New("User not found")
+
+// User is an account of a person playing the game.
+//
+// Username is used to sign in, and is stored in lower case, while DisplayName
+// is shown to the other players. The password itself is never stored, only
+// its bcrypt hash.
+type User struct {
+	Id           int
+	Username     string
+	DisplayName  string
+	PasswordHash []byte
+	CreatedAt    time.Time
+}
+
+// Registry type holds all the users, indexed by id and username.
+//
+// If save is set, every registered or renamed user is saved with it before
+// the change is visible, so the users outlive the process.
+type Registry struct {
+	mutex        sync.RWMutex
+	usersById    map[int]User
+	idByUsername map[string]int
+	save         func(User) error
+}
+
+// InitializeRegistry builds the registry without any users
+func InitializeRegistry() Registry {
+	return Registry{usersById: map[int]User{}, idByUsername: map[string]int{}}
+}
+
+// InitializePersistentRegistry builds the registry with the users saved before, and saves the changed users with the save function
+func InitializePersistentRegistry(saved []User, save func(User) error) Registry {
+	usersById := map[int]User{}
+	idByUsername := map[string]int{}
+	for _, user := range saved {
+		usersById[user.Id] = user
+		idByUsername[user.Username] = user.Id
+	}
+	return Registry{usersById: usersById, idByUsername: idByUsername, save: save}
+}
+
+// Register creates the new user.
+//
+// Display name defaults to the username. Returns ErrUsernameTaken if someone
+// already registered the same username, regardless of the case.
+func (registry *Registry) Register(username string, displayName string, password string) (User, error) {
+	username = normalize(username)
+	if username == "" || strings.ContainsAny(username, " \t\n") {
+		return User{}, errors.New("Username must be a single word")
+	}
+	if len(password) < minPasswordLength {
+		return User{}, fmt.Errorf("Password must have at least %d characters", minPasswordLength)
+	}
+	if displayName == "" {
+		displayName = username
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, fmt.Errorf("Cannot hash password: %w", err)
+	}
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if _, ok := registry.idByUsername[username]; ok {
+		return User{}, fmt.Errorf("Cannot register %v: %w", username, ErrUsernameTaken)
+	}
+	user := User{rand.Int(), username, displayName, hash, time.Now()}
+	if err := registry.persist(user); err != nil {
+		return User{}, err
+	}
+	registry.usersById[user.Id] = user
+	registry.idByUsername[username] = user.Id
+
+	return user, nil
+}
+
+// Authenticate returns the user with the given username, if the password matches
+func (registry *Registry) Authenticate(username string, password string) (User, error) {
+	registry.mutex.RLock()
+	user, ok := registry.usersById[registry.idByUsername[normalize(username)]]
+	registry.mutex.RUnlock()
+
+	if !ok {
+		return User{}, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)); err != nil {
+		return User{}, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// Get returns the user with the given id
+func (registry *Registry) Get(userId int) (User, error) {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	user, ok := registry.usersById[userId]
+	if !ok {
+		return User{}, fmt.Errorf("Cannot find user %d: %w", userId, ErrNotFound)
+	}
+	return user, nil
+}
+
+// Rename changes the display name of the user
+func (registry *Registry) Rename(userId int, displayName string) (User, error) {
+	if displayName == "" {
+		return User{}, errors.New("Display name cannot be empty")
+	}
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	user, ok := registry.usersById[userId]
+	if !ok {
+		return User{}, fmt.Errorf("Cannot find user %d: %w", userId, ErrNotFound)
+	}
+	user.DisplayName = displayName
+	if err := registry.persist(user); err != nil {
+		return User{}, err
+	}
+	registry.usersById[userId] = user
+
+	return user, nil
+}
+
+// persist saves the user, if the registry is persistent, and must be called with the lock held
+func (registry *Registry) persist(user User) error {
+	if registry.save == nil {
+		return nil
+	}
+	if err := registry.save(user); err != nil {
+		return fmt.Errorf("Cannot save user %v: %w", user.Username, err)
+	}
+	return nil
+}
+
+func normalize(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
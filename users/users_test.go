@@ -0,0 +1,86 @@
+package users
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_Register(t *testing.T) {
+	registry := InitializeRegistry()
+
+	user, err := registry.Register("Tehol", "Tehol Beddict", "bugg's hammer")
+	if err != nil {
+		t.Fatalf("Cannot register: %v", err)
+	}
+	if user.Username != "tehol" || user.DisplayName != "Tehol Beddict" {
+		t.Errorf("Unexpected user %v", user)
+	}
+	if string(user.PasswordHash) == "bugg's hammer" {
+		t.Error("Expected the password to be hashed")
+	}
+
+	if _, err := registry.Register("TEHOL", "", "another password"); !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("Expected username taken error, got %v", err)
+	}
+	if _, err := registry.Register("bugg", "", "short"); err == nil {
+		t.Error("Expected error for the short password")
+	}
+}
+
+func Test_Authenticate(t *testing.T) {
+	registry := InitializeRegistry()
+	tehol, _ := registry.Register("tehol", "", "bugg's hammer")
+
+	user, err := registry.Authenticate("Tehol", "bugg's hammer")
+	if err != nil || user.Id != tehol.Id {
+		t.Errorf("Expected to sign in as Tehol, got %v and error %v", user, err)
+	}
+	if _, err := registry.Authenticate("tehol", "wrong password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected invalid credentials for the wrong password, got %v", err)
+	}
+	if _, err := registry.Authenticate("bugg", "bugg's hammer"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected invalid credentials for the unknown user, got %v", err)
+	}
+}
+
+func Test_Rename(t *testing.T) {
+	registry := InitializeRegistry()
+	tehol, _ := registry.Register("tehol", "", "bugg's hammer")
+
+	if _, err := registry.Rename(tehol.Id, "Tehol the Only"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	user, _ := registry.Get(tehol.Id)
+	if user.DisplayName != "Tehol the Only" {
+		t.Errorf("Expected the new display name, got %v", user.DisplayName)
+	}
+}
+
+func Test_PersistentRegistry(t *testing.T) {
+	var saved []User
+	registry := InitializePersistentRegistry(nil, func(user User) error {
+		saved = append(saved, user)
+		return nil
+	})
+	tehol, _ := registry.Register("tehol", "", "bugg's hammer")
+	_, _ = registry.Rename(tehol.Id, "Tehol the Only")
+
+	if len(saved) != 2 || saved[1].DisplayName != "Tehol the Only" {
+		t.Fatalf("Expected the registered and the renamed user to be saved, got %v", saved)
+	}
+
+	restored := InitializePersistentRegistry(saved[1:], nil)
+	if user, err := restored.Authenticate("tehol", "bugg's hammer"); err != nil || user.DisplayName != "Tehol the Only" {
+		t.Errorf("Expected to sign in as the restored Tehol, got %v and error %v", user, err)
+	}
+
+	failing := InitializePersistentRegistry(nil, func(user User) error {
+		return errors.New("Disk is full")
+	})
+	if _, err := failing.Register("bugg", "", "bugg's hammer"); err == nil {
+		t.Error("Expected error when the user cannot be saved")
+	}
+	if _, err := failing.Authenticate("bugg", "bugg's hammer"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected the unsaved user not to be registered, got %v", err)
+	}
+}